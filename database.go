@@ -1,25 +1,38 @@
 package go_notifier_core
 
 import (
+	"database/sql"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"log"
+	"strings"
 	"sync"
+	"time"
 )
 
 type DbConfig struct {
-	Username string
-	Password string
-	Driver   int
-	Host     string
-	Port     string
-	Name     string
-	DB       string
+	Username   string
+	Password   string
+	Driver     int
+	Host       string
+	Port       string
+	Name       string
+	DB         string // Database name, or the file path (or ":memory:") for the SQLite driver.
+	SslMode    string // Postgres only. Defaults to "disable" when empty.
+	SearchPath string // Postgres only. Comma separated list of schemas, uses server default when empty.
+
+	// Connection pool settings. Zero values keep the database/sql defaults.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
 const (
 	MysqlDriver    = iota + 1 // Constant representing the MySQL database driver.
 	PostgresDriver            // Constant representing the PostgresSQL database driver.
+	SqliteDriver              // Constant representing the SQLite database driver.
 )
 
 var (
@@ -35,36 +48,119 @@ func init() {
 // If the driver instance is already created for the given configuration, it returns the existing one.
 // If the driver instance does not exist, it calls the setDriver function to create a new one.
 // The function ensures that only one driver instance is created and reused per unique configuration.
-// If an invalid driver type is provided in the configuration, the function panics.
-func getDriver(config DbConfig) interface{} {
+// Failed connections aren't stored, so calling it again retries the connection.
+func getDriver(config DbConfig) (interface{}, error) {
 	driversMu.Lock()
 	defer driversMu.Unlock()
 
 	if val, ok := drivers[config.Name]; ok {
-		return val
+		return val, nil
 	}
 	return setDriver(config)
 }
 
 // setDriver creates and returns a new database driver instance based on the provided configuration.
-// The function supports MySQL driver. If the configuration contains an invalid driver type,
-// the function panics with an error message.
-func setDriver(config DbConfig) interface{} {
+// The function supports MySQL, Postgres and SQLite drivers. If the configuration contains an invalid driver type,
+// the function returns an InvalidDriverError.
+func setDriver(config DbConfig) (interface{}, error) {
+	var (
+		tmp *gorm.DB
+		err error
+	)
 	switch config.Driver {
 	case MysqlDriver:
-		tmp := mysqlDriver(config)
-		drivers[config.Name] = tmp
-		return tmp
+		tmp, err = mysqlDriver(config)
+	case PostgresDriver:
+		tmp, err = postgresDriver(config)
+	case SqliteDriver:
+		tmp, err = sqliteDriver(config)
+	default:
+		return nil, InvalidDriverError{Name: config.Name, Driver: config.Driver}
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = configurePool(tmp, config)
+	if err != nil {
+		return nil, ConnectionError{Name: config.Name, Driver: driverName(config.Driver), Err: err}
+	}
+	drivers[config.Name] = tmp
+	return tmp, nil
+}
+
+// configurePool applies the connection pool settings of the configuration to the database connection.
+// An in-memory SQLite database is kept on a single connection, whatever the settings are.
+func configurePool(db *gorm.DB, config DbConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	if config.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	}
+	if config.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	}
+	if config.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+	}
+	if config.Driver == SqliteDriver && isSqliteMemory(config.DB) {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return nil
+}
+
+// openSqlDB wraps a database/sql connection opened by the caller in a *gorm.DB of the driver type.
+// The pool of the connection is left as it is, so the caller keeps control of its limits. Foreign keys of SQLite,
+// which the cascades of the tables rely on, are enabled by PRAGMA; the pragma holds for one connection only, so
+// a pool of several SQLite connections must be opened with _foreign_keys=1 in its DSN.
+func openSqlDB(sqlDB *sql.DB, driver int) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case MysqlDriver:
+		dialector = mysql.New(mysql.Config{Conn: sqlDB})
 	case PostgresDriver:
-		panic("postgres driver doesn't implemented")
+		dialector = postgres.New(postgres.Config{Conn: sqlDB})
+	case SqliteDriver:
+		dialector = &sqlite.Dialector{Conn: sqlDB}
+	default:
+		return nil, InvalidDriverError{Driver: driver}
 	}
-	panic("invalid driver for config '" + config.Name + "'.")
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, ConnectionError{Driver: driverName(driver), Err: err}
+	}
+	if driver == SqliteDriver {
+		err = db.Exec("PRAGMA foreign_keys = ON").Error
+		if err != nil {
+			return nil, ConnectionError{Driver: driverName(driver), Err: err}
+		}
+	}
+	return db, nil
+}
+
+// driverName returns the name of the driver type used in errors.
+func driverName(driver int) string {
+	switch driver {
+	case MysqlDriver:
+		return "mysql"
+	case PostgresDriver:
+		return "postgres"
+	case SqliteDriver:
+		return "sqlite"
+	}
+	return "unknown"
 }
 
 // mysqlDriver establishes a connection to the MySQL database using the provided configuration.
 // It returns a *gorm.DB object, which represents the database connection.
-// If any error occurs during the connection, the function logs a fatal error and exits the application.
-func mysqlDriver(config DbConfig) *gorm.DB {
+// If any error occurs during the connection, the function returns a ConnectionError.
+func mysqlDriver(config DbConfig) (*gorm.DB, error) {
+	name := config.Name
 	if config.Password != "" {
 		config.Password = ":" + config.Password
 	}
@@ -74,7 +170,80 @@ func mysqlDriver(config DbConfig) *gorm.DB {
 
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
 	if err != nil {
-		log.Fatalf("Error during connecting db mysql driver : %s", err)
+		return nil, ConnectionError{Name: name, Driver: "mysql", Err: err}
+	}
+	return db, nil
+}
+
+// postgresDriver establishes a connection to the Postgres database using the provided configuration.
+// It returns a *gorm.DB object, which represents the database connection.
+// If any error occurs during the connection, the function returns a ConnectionError.
+func postgresDriver(config DbConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(postgresDsn(config)), &gorm.Config{})
+	if err != nil {
+		return nil, ConnectionError{Name: config.Name, Driver: "postgres", Err: err}
 	}
-	return db
+	return db, nil
+}
+
+// postgresDsn builds a key/value connection string for the Postgres driver from the provided configuration.
+// Empty optional values are skipped so the server or libpq defaults are used instead.
+func postgresDsn(config DbConfig) string {
+	sslMode := config.SslMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	params := [][2]string{
+		{"host", config.Host},
+		{"port", config.Port},
+		{"user", config.Username},
+		{"password", config.Password},
+		{"dbname", config.DB},
+		{"sslmode", sslMode},
+		{"search_path", config.SearchPath},
+	}
+
+	parts := make([]string, 0, len(params))
+	for _, param := range params {
+		if param[1] == "" {
+			continue
+		}
+		parts = append(parts, param[0]+"="+quoteDsnValue(param[1]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteDsnValue quotes a value of a key/value connection string when it contains spaces, quotes or backslashes.
+func quoteDsnValue(value string) string {
+	if !strings.ContainsAny(value, " '\\") {
+		return value
+	}
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "'", "\\'")
+	return "'" + value + "'"
+}
+
+// sqliteDriver opens the SQLite database file (or an in-memory database for ":memory:") set in config.DB.
+// Foreign keys are enabled to behave like the other drivers. An in-memory database lives only as long as
+// its connection, so configurePool limits its pool to a single connection.
+// If any error occurs during the connection, the function returns a ConnectionError.
+func sqliteDriver(config DbConfig) (*gorm.DB, error) {
+	dsn := config.DB
+	if strings.Contains(dsn, "?") {
+		dsn += "&_foreign_keys=1"
+	} else {
+		dsn += "?_foreign_keys=1"
+	}
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, ConnectionError{Name: config.Name, Driver: "sqlite", Err: err}
+	}
+	return db, nil
+}
+
+// isSqliteMemory reports whether the SQLite path points to an in-memory database.
+func isSqliteMemory(path string) bool {
+	return path == ":memory:" || strings.Contains(path, "mode=memory")
 }
@@ -1,24 +1,25 @@
 package go_notifier_core
 
 import (
+	"database/sql"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestGetDriver(t *testing.T) {
 	config := DbConfig{
-		Name:     "name",
-		Username: "roottest",
-		Password: "secret",
-		Driver:   MysqlDriver,
-		Host:     "localhost",
-		Port:     "3306",
-		DB:       "notifier_test",
+		Name:   "name",
+		Driver: SqliteDriver,
+		DB:     ":memory:",
 	}
 
 	// Test when the driver is already set
-	driver := getDriver(config)
+	driver, err := getDriver(config)
+	assert.Nil(t, err)
 	assert.NotNil(t, driver, "Driver should not be nil")
 
 	// Test invalid driver type
@@ -26,35 +27,22 @@ func TestGetDriver(t *testing.T) {
 		Name:   "invalidDB",
 		Driver: 999, // Invalid driver type
 	}
-	assert.Panics(t, func() {
-		getDriver(invalidConfig)
-	}, "Getting driver with an invalid driver type should panic")
-
-	// Test invalid driver type
-	postgresConfig := DbConfig{
-		Name:   "invalidDB",
-		Driver: PostgresDriver,
-	}
-
-	assert.Panics(t, func() {
-		getDriver(postgresConfig)
-	}, "Panic for postgres driver, not implemented")
+	driver, err = getDriver(invalidConfig)
+	assert.Nil(t, driver)
+	assert.ErrorAs(t, err, &InvalidDriverError{}, "Getting driver with an invalid driver type should return error")
 }
 
 func TestSetDriver(t *testing.T) {
 	// Create a sample DbConfig
 	config := DbConfig{
-		Name:     "name",
-		Username: "roottest",
-		Password: "secret",
-		Driver:   MysqlDriver,
-		Host:     "localhost",
-		Port:     "3306",
-		DB:       "notifier_test",
+		Name:   "name",
+		Driver: SqliteDriver,
+		DB:     ":memory:",
 	}
 
-	// Test setting the driver for MySQL
-	driver := setDriver(config)
+	// Test setting the driver for SQLite
+	driver, err := setDriver(config)
+	assert.Nil(t, err)
 	assert.NotNil(t, driver, "Driver should not be nil")
 
 	// Test setting the driver for an invalid driver type
@@ -62,22 +50,17 @@ func TestSetDriver(t *testing.T) {
 		Name:   "invalidDB",
 		Driver: 999, // Invalid driver type
 	}
-	assert.Panics(t, func() {
-		setDriver(invalidConfig)
-	}, "Setting driver with an invalid driver type should panic")
-
-	// Test invalid driver type
-	postgresConfig := DbConfig{
-		Name:   "invalidDB",
-		Driver: PostgresDriver,
-	}
-
-	assert.Panics(t, func() {
-		setDriver(postgresConfig)
-	}, "Panic for postgres driver, not implemented")
+	driver, err = setDriver(invalidConfig)
+	assert.Nil(t, driver)
+	assert.ErrorAs(t, err, &InvalidDriverError{}, "Setting driver with an invalid driver type should return error")
 }
 
 func TestMysqlDriver(t *testing.T) {
+	// This test needs a live MySQL server
+	if os.Getenv("NOTIFIER_TEST_MYSQL") == "" {
+		t.Skip("set NOTIFIER_TEST_MYSQL to run tests against a local MySQL server")
+	}
+
 	// Create a sample DbConfig
 	config := DbConfig{
 		Name:     "name",
@@ -90,52 +73,148 @@ func TestMysqlDriver(t *testing.T) {
 	}
 
 	// Test connecting to MySQL
-	db := mysqlDriver(config)
+	db, err := mysqlDriver(config)
+	assert.Nil(t, err)
 	assert.NotNil(t, db, "Database connection should not be nil")
+}
+
+func TestConnectionError(t *testing.T) {
+	// Nothing listens on port 1, so the connection is refused
+	config := DbConfig{
+		Name:     "unreachable",
+		Username: "test",
+		Password: "test",
+		Driver:   MysqlDriver,
+		Host:     "127.0.0.1",
+		Port:     "1",
+		DB:       "notifier_test",
+	}
 
-	//invalidConfig := DbConfig{
-	//	Name:     "name",
-	//	Username: "test",
-	//	Password: "test",
-	//	Driver:   MysqlDriver,
-	//	Host:     "localhost",
-	//	Port:     "3306",
-	//	DB:       "notifier_test",
-	//}
-	//
-	//var buf bytes.Buffer
-	//log.SetOutput(&buf)
-	//
-	//mysqlDriver(invalidConfig)
-	//
-	//actualLog := buf.String()
-	//if actualLog == "" {
-	//	t.Errorf("Expected log message not empty")
-	//}
+	db, err := mysqlDriver(config)
+	assert.Nil(t, db)
+	assert.ErrorAs(t, err, &ConnectionError{})
 
+	// Test failed connections aren't cached and Initialize returns the error
+	err = Initialize(config)
+	assert.ErrorAs(t, err, &ConnectionError{})
+	driversMu.Lock()
+	_, ok := drivers[config.Name]
+	driversMu.Unlock()
+	assert.False(t, ok, "Failed connection should not be stored")
+
+	err = Migrate(config)
+	assert.ErrorAs(t, err, &ConnectionError{})
+
+	err = MigrateRollback(config)
+	assert.ErrorAs(t, err, &ConnectionError{})
 }
 
-func TestConcurrentGetDriver(t *testing.T) {
-	// Create a sample DbConfig for MySQL driver
-	config1 := DbConfig{
-		Name:     "dbConfig1",
-		Driver:   MysqlDriver,
-		Host:     "localhost",
-		Port:     "3306",
+func TestPostgresDsn(t *testing.T) {
+	config := DbConfig{
+		Name:     "name",
 		Username: "roottest",
 		Password: "secret",
+		Driver:   PostgresDriver,
+		Host:     "localhost",
+		Port:     "5432",
 		DB:       "notifier_test",
 	}
 
-	// Create another sample DbConfig for MySQL driver
+	// Test default ssl mode and empty search path
+	assert.Equal(t,
+		"host=localhost port=5432 user=roottest password=secret dbname=notifier_test sslmode=disable",
+		postgresDsn(config),
+	)
+
+	// Test custom options
+	config.SslMode = "require"
+	config.SearchPath = "notifier,public"
+	assert.Equal(t,
+		"host=localhost port=5432 user=roottest password=secret dbname=notifier_test sslmode=require search_path=notifier,public",
+		postgresDsn(config),
+	)
+
+	// Test quoting values with spaces and quotes
+	config.Password = "it's secret"
+	assert.Contains(t, postgresDsn(config), `password='it\'s secret'`)
+}
+
+func TestSqliteDriver(t *testing.T) {
+	config := DbConfig{
+		Name:   "sqlite",
+		Driver: SqliteDriver,
+		DB:     ":memory:",
+	}
+
+	db, err := sqliteDriver(config)
+	assert.Nil(t, err)
+	assert.NotNil(t, db, "Database connection should not be nil")
+
+	var foreignKeys int
+	db.Raw("PRAGMA foreign_keys").Scan(&foreignKeys)
+	assert.Equal(t, 1, foreignKeys, "Foreign keys should be enabled")
+}
+
+func TestConfigurePool(t *testing.T) {
+	config := DbConfig{
+		Name:            "sqlite pool",
+		Driver:          SqliteDriver,
+		DB:              filepath.Join(t.TempDir(), "pool.db"),
+		MaxOpenConns:    5,
+		MaxIdleConns:    2,
+		ConnMaxLifetime: time.Minute,
+	}
+
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+	sqlDB, err := db.DB()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, sqlDB.Stats().MaxOpenConnections)
+
+	// Test in-memory databases stay on a single connection
+	config = DbConfig{
+		Name:         "sqlite memory pool",
+		Driver:       SqliteDriver,
+		DB:           ":memory:",
+		MaxOpenConns: 5,
+	}
+	db, err = dbFactory(config)
+	assert.Nil(t, err)
+	sqlDB, err = db.DB()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sqlDB.Stats().MaxOpenConnections)
+}
+
+func TestOpenSqlDB(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+
+	db, err := openSqlDB(sqlDB, SqliteDriver)
+	assert.Nil(t, err)
+	shared, err := db.DB()
+	assert.Nil(t, err)
+	assert.Same(t, sqlDB, shared, "The caller's connection pool should be shared")
+
+	// Test invalid driver type
+	_, err = openSqlDB(sqlDB, 999)
+	assert.ErrorAs(t, err, &InvalidDriverError{})
+}
+
+func TestConcurrentGetDriver(t *testing.T) {
+	// Create a sample DbConfig for SQLite driver
+	config1 := DbConfig{
+		Name:   "dbConfig1",
+		Driver: SqliteDriver,
+		DB:     ":memory:",
+	}
+
+	// Create another sample DbConfig for SQLite driver
 	config2 := DbConfig{
-		Name:     "dbConfig2",
-		Driver:   MysqlDriver,
-		Host:     "localhost",
-		Port:     "3306",
-		Username: "roottest",
-		Password: "secret",
-		DB:       "notifier_test",
+		Name:   "dbConfig2",
+		Driver: SqliteDriver,
+		DB:     ":memory:",
 	}
 
 	// Ensure that the drivers map is initially empty
@@ -153,12 +232,12 @@ func TestConcurrentGetDriver(t *testing.T) {
 	// Simultaneously get drivers for both configurations using concurrent goroutines
 	go func() {
 		defer wg.Done()
-		_ = getDriver(config1)
+		_, _ = getDriver(config1)
 	}()
 
 	go func() {
 		defer wg.Done()
-		_ = getDriver(config2)
+		_, _ = getDriver(config2)
 	}()
 
 	// Wait for both goroutines to finish
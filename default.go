@@ -0,0 +1,938 @@
+package go_notifier_core
+
+import "time"
+
+// The package level functions below call the same methods of the default Notifier created by Initialize.
+// They return NotInitializedError when Initialize isn't called yet.
+
+// Tag functions #start
+
+func CreateTag(name string) (*NotifierTag, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateTag(name)
+}
+
+func DeleteTagByName(name string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteTagByName(name)
+}
+
+func GetTagByName(name string) (*NotifierTag, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetTagByName(name)
+}
+
+func TagsList() ([]NotifierTag, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.TagsList()
+}
+
+// Tag functions #end
+
+// Email subscribe functions #start
+
+func SubscribeEmail(email, fName, lName string, tags []string, createTag bool) (*NotifierEmailSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.SubscribeEmail(email, fName, lName, tags, createTag)
+}
+
+func AssignTagsToEmail(email string, tags []string, createTag bool) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.AssignTagsToEmail(email, tags, createTag)
+}
+
+func RemoveTagsFromEmail(email string, tags []string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.RemoveTagsFromEmail(email, tags)
+}
+
+func UnSubscribeEmail(email string, unsubId uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UnSubscribeEmail(email, unsubId)
+}
+
+func EmailUnsubscribeEventsList() ([]NotifierEmailUnsubscribeEvent, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.EmailUnsubscribeEventsList()
+}
+
+func GetTagEmailSubscribers(tag string) ([]NotifierEmailSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetTagEmailSubscribers(tag)
+}
+
+func GetUnsubscribedEmails() ([]NotifierEmailSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetUnsubscribedEmails()
+}
+
+func GetEmailSubscribersWithTags(tags []NotifierTag) ([]NotifierEmailSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailSubscribersWithTags(tags)
+}
+
+// Email subscribe functions #end
+
+// Mobile subscribe functions #start
+
+func SubscribeMobile(countryCode, mobile, fName, lName string, tags []string, createTag bool) (*NotifierMobileSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.SubscribeMobile(countryCode, mobile, fName, lName, tags, createTag)
+}
+
+func AssignTagsToMobile(mobile string, tags []string, createTag bool) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.AssignTagsToMobile(mobile, tags, createTag)
+}
+
+func RemoveTagsFromMobile(mobile string, tags []string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.RemoveTagsFromMobile(mobile, tags)
+}
+
+func UnSubscribeMobile(mobile string, unsubId uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UnSubscribeMobile(mobile, unsubId)
+}
+
+func MobileUnsubscribeEventsList() ([]NotifierMobileUnsubscribeEvent, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.MobileUnsubscribeEventsList()
+}
+
+func GetTagMobileSubscribers(tag string) ([]NotifierMobileSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetTagMobileSubscribers(tag)
+}
+
+func GetUnsubscribedMobiles() ([]NotifierMobileSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetUnsubscribedMobiles()
+}
+
+// Mobile subscribe functions #end
+
+// Mobile campaign functions #start
+
+func CreateMobileDriver(name, driverType string, payload []byte) (*NotifierMobileDriver, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateMobileDriver(name, driverType, payload)
+}
+
+func GetMobileDrivers() ([]NotifierMobileDriver, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetMobileDrivers()
+}
+
+func GetMobileDriverById(driver uint64) (*NotifierMobileDriver, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetMobileDriverById(driver)
+}
+
+func AddMobileCampaign(data *MobileCampaignCreateData) (*NotifierMobileCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.AddMobileCampaign(data)
+}
+
+func UpdateMobileCampaignWithId(cmpId uint64, data *MobileCampaignUpdateData) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateMobileCampaignWithId(cmpId, data)
+}
+
+func DeleteMobileCampaign(campaign uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteMobileCampaign(campaign)
+}
+
+func GetLatestMobileCampaignForRun() (*NotifierMobileCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetLatestMobileCampaignForRun()
+}
+
+func UpdateMobileCampaign(campaign *NotifierMobileCampaign) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateMobileCampaign(campaign)
+}
+
+func GetMobileCampaignTags(cmpId uint64) []NotifierTag {
+	n, err := Default()
+	if err != nil {
+		return []NotifierTag{}
+	}
+	return n.GetMobileCampaignTags(cmpId)
+}
+
+func GetMobileSubscribersWithTags(tags []NotifierTag) ([]NotifierMobileSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetMobileSubscribersWithTags(tags)
+}
+
+func CheckMobileMessageExists(message *NotifierMobileMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.CheckMobileMessageExists(message)
+}
+
+func CreateMobileMessage(message *NotifierMobileMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.CreateMobileMessage(message)
+}
+
+func UpdateMobileMessage(message *NotifierMobileMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateMobileMessage(message)
+}
+
+func SendMobileMessage(message *NotifierMobileMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.SendMobileMessage(message)
+}
+
+func GetMobileMessageByProviderMessageId(providerMessageId string) (*NotifierMobileMessage, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetMobileMessageByProviderMessageId(providerMessageId)
+}
+
+// Mobile campaign functions #end
+
+// Notification subscribe functions #start
+
+func AddNewToken(token, fName, lName string, driverId uint64, tags []string, createTag bool) (*NotifierNotificationSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.AddNewToken(token, fName, lName, driverId, tags, createTag)
+}
+
+func AssignTagsToToken(token string, tags []string, createTag bool) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.AssignTagsToToken(token, tags, createTag)
+}
+
+func RemoveTagsFromToken(token string, tags []string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.RemoveTagsFromToken(token, tags)
+}
+
+func RemoveToken(token string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.RemoveToken(token)
+}
+
+func DisableToken(token, reason string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DisableToken(token, reason)
+}
+
+func RefreshToken(token string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.RefreshToken(token)
+}
+
+func PruneStaleTokens(days int) (int64, error) {
+	n, err := Default()
+	if err != nil {
+		return 0, err
+	}
+	return n.PruneStaleTokens(days)
+}
+
+func GetTagTokenSubscribers(tag string) ([]NotifierNotificationSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetTagTokenSubscribers(tag)
+}
+
+func GetTagAndDriverTokenSubscribers(tag string, driverId uint64) ([]NotifierNotificationSubscriber, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetTagAndDriverTokenSubscribers(tag, driverId)
+}
+
+func NotificationDriversList() ([]NotifierNotificationService, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.NotificationDriversList()
+}
+
+// Notification subscribe functions #end
+
+// Notification campaign functions #start
+
+func CreateNotificationDriver(name, driverType string, payload []byte) (*NotifierNotificationService, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateNotificationDriver(name, driverType, payload)
+}
+
+func GetNotificationDriverById(driver uint64) (*NotifierNotificationService, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetNotificationDriverById(driver)
+}
+
+func AddNotificationCampaign(data *NotificationCampaignCreateData) (*NotifierNotificationCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.AddNotificationCampaign(data)
+}
+
+func UpdateNotificationCampaignWithId(cmpId uint64, data *NotificationCampaignUpdateData) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateNotificationCampaignWithId(cmpId, data)
+}
+
+func DeleteNotificationCampaign(campaign uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteNotificationCampaign(campaign)
+}
+
+func GetLatestNotificationCampaignForRun() (*NotifierNotificationCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetLatestNotificationCampaignForRun()
+}
+
+func UpdateNotificationCampaign(campaign *NotifierNotificationCampaign) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateNotificationCampaign(campaign)
+}
+
+func GetNotificationCampaignTags(cmpId uint64) []NotifierTag {
+	n, err := Default()
+	if err != nil {
+		return []NotifierTag{}
+	}
+	return n.GetNotificationCampaignTags(cmpId)
+}
+
+func CheckNotificationMessageExists(message *NotifierNotificationMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.CheckNotificationMessageExists(message)
+}
+
+func CreateNotificationMessage(message *NotifierNotificationMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.CreateNotificationMessage(message)
+}
+
+func UpdateNotificationMessage(message *NotifierNotificationMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateNotificationMessage(message)
+}
+
+func SendNotificationMessage(message *NotifierNotificationMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.SendNotificationMessage(message)
+}
+
+func GetNotificationMessageByProviderMessageId(providerMessageId string) (*NotifierNotificationMessage, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetNotificationMessageByProviderMessageId(providerMessageId)
+}
+
+// Notification campaign functions #end
+
+// Campaign functions #start
+
+func AddCampaign(data *CampaignCreateData) (*NotifierCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.AddCampaign(data)
+}
+
+func GetCampaign(cmpId uint64) (*NotifierCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetCampaign(cmpId)
+}
+
+func GetCampaignChannels(cmpId uint64) (*CampaignChannels, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetCampaignChannels(cmpId)
+}
+
+func SetCampaignStatus(cmpId uint64, statusId uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.SetCampaignStatus(cmpId, statusId)
+}
+
+func DeleteCampaign(cmpId uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteCampaign(cmpId)
+}
+
+func CampaignList() ([]NotifierCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CampaignList()
+}
+
+// Campaign functions #end
+
+// Job functions #start
+
+func EnqueueJob(jobType string, payload any) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.EnqueueJob(jobType, payload)
+}
+
+func RunJobs(consumer string, limit int, lease time.Duration) (int, error) {
+	n, err := Default()
+	if err != nil {
+		return 0, err
+	}
+	return n.RunJobs(consumer, limit, lease)
+}
+
+func PruneFinishedJobs(days int) (int64, error) {
+	n, err := Default()
+	if err != nil {
+		return 0, err
+	}
+	return n.PruneFinishedJobs(days)
+}
+
+// Job functions #end
+
+// Email Template functions #start
+
+func CreateEmailTemplate(name, content string) (*NotifierEmailCampaignTemplate, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateEmailTemplate(name, content)
+}
+
+func UpdateEmailTemplate(id uint64, name, content string) (*NotifierEmailCampaignTemplate, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.UpdateEmailTemplate(id, name, content)
+}
+
+func DeleteEmailTemplate(id uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteEmailTemplate(id)
+}
+
+func EmailTemplateList() ([]NotifierEmailCampaignTemplate, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.EmailTemplateList()
+}
+
+func AddEmailCampaign(data *EmailCampaignCreateData) (*NotifierEmailCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.AddEmailCampaign(data)
+}
+
+func DeleteEmailCampaign(campaign uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteEmailCampaign(campaign)
+}
+
+func UpdateEmailCampaignWithId(cmpId uint64, data *EmailCampaignUpdateData) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateEmailCampaignWithId(cmpId, data)
+}
+
+func GetLatestCampaignForRun() (*NotifierEmailCampaign, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetLatestCampaignForRun()
+}
+
+func UpdateEmailCampaign(campaign *NotifierEmailCampaign) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateEmailCampaign(campaign)
+}
+
+func GetEmailCampaignTags(cmpId uint64) []NotifierTag {
+	n, err := Default()
+	if err != nil {
+		return []NotifierTag{}
+	}
+	return n.GetEmailCampaignTags(cmpId)
+}
+
+func CheckEmailMessageExists(message *NotifierEmailMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.CheckEmailMessageExists(message)
+}
+
+func CreateEmailMessage(message *NotifierEmailMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.CreateEmailMessage(message)
+}
+
+func CreateEmailService(name, serviceType string, payload []byte) (*NotifierEmailService, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateEmailService(name, serviceType, payload)
+}
+
+func SetEmailServiceRateLimit(serviceId uint64, perSecond, perDay uint) (*NotifierEmailService, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.SetEmailServiceRateLimit(serviceId, perSecond, perDay)
+}
+
+func GetEmailServices() ([]NotifierEmailService, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailServices()
+}
+
+func GetEmailServiceById(service uint64) (*NotifierEmailService, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailServiceById(service)
+}
+
+func UpdateEmailMessage(message *NotifierEmailMessage) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.UpdateEmailMessage(message)
+}
+
+func DetachTagsForCampaign(campaign uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DetachTagsForCampaign(campaign)
+}
+
+func AttachToEmailCampaign(campaignId uint64, attachment *NotifierEmailAttachment) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.AttachToEmailCampaign(campaignId, attachment)
+}
+
+func AttachToEmailTemplate(templateId uint64, attachment *NotifierEmailAttachment) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.AttachToEmailTemplate(templateId, attachment)
+}
+
+func GetEmailCampaignAttachments(campaignId uint64) ([]NotifierEmailAttachment, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailCampaignAttachments(campaignId)
+}
+
+func GetEmailTemplateAttachments(templateId uint64) ([]NotifierEmailAttachment, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailTemplateAttachments(templateId)
+}
+
+func DeleteEmailAttachment(id uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteEmailAttachment(id)
+}
+
+func SendEmailMessage(message *NotifierEmailMessage, attachments ...*NotifierEmailAttachment) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.SendEmailMessage(message, attachments...)
+}
+
+func SendEmailEnvelope(message *NotifierEmailMessage, envelope *EmailEnvelope, attachments ...*NotifierEmailAttachment) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.SendEmailEnvelope(message, envelope, attachments...)
+}
+
+func GetDeadEmailMessages() ([]NotifierEmailMessage, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetDeadEmailMessages()
+}
+
+func RequeueEmailMessage(id uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.RequeueEmailMessage(id)
+}
+
+func DiscardEmailMessage(id uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DiscardEmailMessage(id)
+}
+
+func GetEmailMessageByProviderMessageId(providerMessageId string) (*NotifierEmailMessage, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailMessageByProviderMessageId(providerMessageId)
+}
+
+func CreateEmailTemplateWithLayout(name, content string, layoutId uint64) (*NotifierEmailCampaignTemplate, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateEmailTemplateWithLayout(name, content, layoutId)
+}
+
+func UpdateEmailTemplateLayout(id uint64, layoutId *uint64) (*NotifierEmailCampaignTemplate, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.UpdateEmailTemplateLayout(id, layoutId)
+}
+
+func RollbackEmailTemplate(id uint64, version uint) (*NotifierEmailCampaignTemplate, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.RollbackEmailTemplate(id, version)
+}
+
+func GetEmailTemplateVersions(id uint64) ([]NotifierEmailTemplateVersion, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailTemplateVersions(id)
+}
+
+func GetEmailTemplateVersion(id uint64, version uint) (*NotifierEmailTemplateVersion, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.GetEmailTemplateVersion(id, version)
+}
+
+func DiffEmailTemplateVersions(id uint64, from, to uint) (TemplateDiff, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.DiffEmailTemplateVersions(id, from, to)
+}
+
+func CreateEmailLayout(name, content string) (*NotifierEmailLayout, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateEmailLayout(name, content)
+}
+
+func UpdateEmailLayout(id uint64, name, content string) (*NotifierEmailLayout, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.UpdateEmailLayout(id, name, content)
+}
+
+func DeleteEmailLayout(id uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteEmailLayout(id)
+}
+
+func EmailLayoutList() ([]NotifierEmailLayout, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.EmailLayoutList()
+}
+
+func CreateEmailPartial(name, content string) (*NotifierEmailPartial, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.CreateEmailPartial(name, content)
+}
+
+func UpdateEmailPartial(id uint64, name, content string) (*NotifierEmailPartial, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.UpdateEmailPartial(id, name, content)
+}
+
+func DeleteEmailPartial(id uint64) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.DeleteEmailPartial(id)
+}
+
+func EmailPartialList() ([]NotifierEmailPartial, error) {
+	n, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return n.EmailPartialList()
+}
+
+func PreviewEmailTemplate(templateId uint64, subscriberEmail string) (string, error) {
+	n, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return n.PreviewEmailTemplate(templateId, subscriberEmail)
+}
+
+func PreviewEmailTemplateWithData(templateId uint64, data EmailTemplateData) (string, error) {
+	n, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return n.PreviewEmailTemplateWithData(templateId, data)
+}
+
+func SendTestEmailCampaign(campaignId uint64, recipients []string) error {
+	n, err := Default()
+	if err != nil {
+		return err
+	}
+	return n.SendTestEmailCampaign(campaignId, recipients)
+}
+
+// Email Template functions #end
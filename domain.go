@@ -1,19 +1,86 @@
 package go_notifier_core
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 //Campaign Models
 
+// NotifierEmailCampaignTemplate is the latest version of a template. Every change is kept as
+// a NotifierEmailTemplateVersion, and Version is the number of the latest one.
 type NotifierEmailCampaignTemplate struct {
+	LayoutId  *uint64
 	UpdatedAt time.Time
 	CreatedAt time.Time
 	Content   string `gorm:"type=longtext"`
 	Name      string
+	Version   uint
 	ID        uint64
 }
 
 func NewNotifierEmailCampaignTemplate(content string, name string) *NotifierEmailCampaignTemplate {
 	return &NotifierEmailCampaignTemplate{
+		Content:   content,
+		Name:      name,
+		Version:   1,
+		UpdatedAt: time.Now(),
+		CreatedAt: time.Now(),
+	}
+}
+
+// NotifierEmailTemplateVersion is an immutable revision of a template. Campaigns are pinned to a version.
+type NotifierEmailTemplateVersion struct {
+	LayoutId   *uint64
+	CreatedAt  time.Time
+	Content    string `gorm:"type=longtext"`
+	Name       string
+	TemplateId uint64
+	Version    uint
+	ID         uint64
+}
+
+func NewNotifierEmailTemplateVersion(template *NotifierEmailCampaignTemplate) *NotifierEmailTemplateVersion {
+	return &NotifierEmailTemplateVersion{
+		LayoutId:   template.LayoutId,
+		Content:    template.Content,
+		Name:       template.Name,
+		TemplateId: template.ID,
+		Version:    template.Version,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// NotifierEmailLayout is a shared frame of templates, e.g. the header and the footer. The content renders
+// the template by {{template "body" .}}.
+type NotifierEmailLayout struct {
+	UpdatedAt time.Time
+	CreatedAt time.Time
+	Content   string `gorm:"type=longtext"`
+	Name      string
+	ID        uint64
+}
+
+func NewNotifierEmailLayout(name, content string) *NotifierEmailLayout {
+	return &NotifierEmailLayout{
+		Content:   content,
+		Name:      name,
+		UpdatedAt: time.Now(),
+		CreatedAt: time.Now(),
+	}
+}
+
+// NotifierEmailPartial is a reusable block of templates and layouts, rendered by {{template "<Name>" .}}.
+type NotifierEmailPartial struct {
+	UpdatedAt time.Time
+	CreatedAt time.Time
+	Content   string `gorm:"type=longtext"`
+	Name      string
+	ID        uint64
+}
+
+func NewNotifierEmailPartial(name, content string) *NotifierEmailPartial {
+	return &NotifierEmailPartial{
 		Content:   content,
 		Name:      name,
 		UpdatedAt: time.Now(),
@@ -39,11 +106,16 @@ const (
 	NotifierEmailServiceSMTPType     = "SMPT"
 )
 
+// NotifierEmailService is a provider account that sends mails by the mailer of its type. RateLimitPerSecond and
+// RateLimitPerDay limit its sends, zero is unlimited, see SetEmailServiceRateLimit. RateLimitPerSecond is a limit
+// of every process, RateLimitPerDay is shared by the processes of the database.
 type NotifierEmailService struct {
-	Payload string `gorm:"type=longtext"`
-	Type    string
-	Name    string
-	ID      uint64
+	Payload            string `gorm:"type=longtext"`
+	Type               string
+	Name               string
+	RateLimitPerSecond uint
+	RateLimitPerDay    uint
+	ID                 uint64
 }
 
 func NewNotifierEmailService(payload string, Type string, name string) *NotifierEmailService {
@@ -59,6 +131,54 @@ const (
 	NotifierEmailStatusFailed
 )
 
+// NotifierCampaign is a campaign sent on several channels from one definition. Every channel has its own
+// campaign linked by CampaignId, e.g. a NotifierEmailCampaign, with the content and the provider of the channel.
+// Its status follows the statuses of the channel campaigns, see CampaignStatus.
+type NotifierCampaign struct {
+	ScheduledAt *time.Time
+	UpdatedAt   time.Time
+	CreatedAt   time.Time
+	StatusId    uint64
+	Name        string
+	ID          uint64
+}
+
+func NewNotifierCampaign(scheduledAt *time.Time, statusId uint64, name string) *NotifierCampaign {
+	return &NotifierCampaign{
+		ScheduledAt: scheduledAt,
+		StatusId:    statusId,
+		Name:        name,
+		UpdatedAt:   time.Now(),
+		CreatedAt:   time.Now(),
+	}
+}
+
+// CampaignStatus returns the status of a multi-channel campaign of the statuses of its channel campaigns.
+// The campaign is sending while a channel is sending, then waiting while a channel is a draft or queued.
+// When every channel is done, it's failed if a channel failed, canceled if every channel is canceled and
+// sent otherwise.
+func CampaignStatus(channelStatuses []uint64) uint64 {
+	has := map[uint64]bool{}
+	for _, status := range channelStatuses {
+		has[status] = true
+	}
+	switch {
+	case len(channelStatuses) == 0:
+		return NotifierEmailStatusDraft
+	case has[NotifierEmailStatusSending]:
+		return NotifierEmailStatusSending
+	case has[NotifierEmailStatusDraft]:
+		return NotifierEmailStatusDraft
+	case has[NotifierEmailStatusQueued]:
+		return NotifierEmailStatusQueued
+	case has[NotifierEmailStatusFailed]:
+		return NotifierEmailStatusFailed
+	case len(has) == 1 && has[NotifierEmailStatusCanceled]:
+		return NotifierEmailStatusCanceled
+	}
+	return NotifierEmailStatusSent
+}
+
 type NotifierEmailCampaignStatus struct {
 	Name string
 	ID   uint64
@@ -80,7 +200,13 @@ type NotifierEmailCampaign struct {
 	Subject        string
 	Content        string `gorm:"type=longtext"`
 	Name           string
-	ID             uint64
+	// Variables are rendered in the subject and the content as {{.Vars.name}}.
+	Variables map[string]string `gorm:"serializer:json"`
+	// TemplateVersion is the version of the template the Content is built from.
+	TemplateVersion uint
+	// CampaignId is the multi-channel campaign of the email campaign, if any.
+	CampaignId *uint64
+	ID         uint64
 }
 
 func NewNotifierEmailCampaign(emailServiceId uint64, scheduledAt *time.Time, templateId uint64, statusId uint64, fromEmail string, fromName string, subject string, content string, name string) *NotifierEmailCampaign {
@@ -178,7 +304,17 @@ type NotifierEmailMessage struct {
 	FailedAt       *time.Time
 	Subject        string
 	SentAt         *time.Time
-	ID             uint64
+	// ProviderMessageId is the message ID the provider returned for the sent mail, to correlate its bounces.
+	ProviderMessageId string
+	// Attempts counts the failed sends, and LastError is the error of the last one. A message that failed for good
+	// has FailedAt and is dead until it's requeued or discarded, see GetDeadEmailMessages.
+	Attempts    uint
+	LastError   string
+	DiscardedAt *time.Time
+	// Envelope keeps the Cc, Bcc, Reply-To, headers and tags of a transactional mail, so it's sent with them again
+	// when it's requeued.
+	Envelope *EmailEnvelope `gorm:"serializer:json"`
+	ID       uint64
 }
 
 func NewNotifierEmailMessage(recipientEmail string, subscriberId uint64, sourceType string, fromEmail string, sourceId uint64, fromName string, subject string, emailServiceId uint64, message string) *NotifierEmailMessage {
@@ -199,6 +335,61 @@ func NewNotifierEmailMessage(recipientEmail string, subscriberId uint64, sourceT
 	}
 }
 
+const (
+	NotifierEmailMessageSourceCampaign      = "campaign"
+	NotifierEmailMessageSourceTransactional = "transactional"
+)
+
+// NotifierEmailAttachment is a file sent with the mails of a campaign, of a template or of a single message.
+// The file is stored in Content, or read from Path (a file path or an http(s) URL) when the mail is sent.
+// Inline attachments are referenced from the HTML body as "cid:<ContentId>".
+type NotifierEmailAttachment struct {
+	CampaignId  *uint64
+	TemplateId  *uint64
+	MessageId   *uint64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Name        string
+	ContentType string
+	Content     []byte
+	Path        string
+	ContentId   string
+	Inline      bool
+	ID          uint64
+}
+
+// NewNotifierEmailAttachment returns an attachment stored in the database.
+// The content type is detected from the name or the content when it's empty.
+func NewNotifierEmailAttachment(name, contentType string, content []byte) *NotifierEmailAttachment {
+	return &NotifierEmailAttachment{
+		Name:        name,
+		ContentType: contentType,
+		Content:     content,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+// NewNotifierEmailAttachmentReference returns an attachment read from a file path or an http(s) URL when it's sent.
+func NewNotifierEmailAttachmentReference(name, contentType, path string) *NotifierEmailAttachment {
+	return &NotifierEmailAttachment{
+		Name:        name,
+		ContentType: contentType,
+		Path:        path,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+// NewNotifierEmailInlineAttachment returns an image stored in the database and embedded in the HTML body
+// by "cid:<contentId>".
+func NewNotifierEmailInlineAttachment(name, contentType, contentId string, content []byte) *NotifierEmailAttachment {
+	attachment := NewNotifierEmailAttachment(name, contentType, content)
+	attachment.Inline = true
+	attachment.ContentId = contentId
+	return attachment
+}
+
 //Mobile Subscriber models
 
 const (
@@ -227,6 +418,7 @@ func NewNotifierMobileUnsubscribeEvent(reason string, ID uint64) *NotifierMobile
 
 type NotifierMobileSubscriber struct {
 	UnsubscribedEventId *uint64
+	Tags                []NotifierTag `gorm:"many2many:notifier_mobile_sub_tags;ForeignKey:id;References:id;JoinForeignKey:MobileSubscriberId;joinReferences:TagId"`
 	UnsubscribedAt      *time.Time
 	CountryCode         string
 	CreatedAt           time.Time
@@ -241,6 +433,17 @@ func (mobile *NotifierMobileSubscriber) Unsubscribable() bool {
 	return mobile.UnsubscribedAt == nil || mobile.UnsubscribedEventId == nil
 }
 
+// Receptor returns the number SMS are sent to: the country code without "+" and the national mobile number
+// without its trunk prefix, e.g. "989121234567", or the mobile as is when there's no country code.
+func (mobile *NotifierMobileSubscriber) Receptor() string {
+	countryCode := strings.TrimLeft(strings.TrimSpace(mobile.CountryCode), "+0")
+	number := strings.TrimSpace(mobile.Mobile)
+	if countryCode == "" {
+		return number
+	}
+	return countryCode + strings.TrimLeft(number, "0")
+}
+
 func NewNotifierMobileSubscriber(countryCode, mobile, firstName, lastName string) *NotifierMobileSubscriber {
 	return &NotifierMobileSubscriber{
 		FirstName:   firstName,
@@ -264,10 +467,92 @@ func NewNotifierMobileSubTag(mobileSubscriberId uint64, tagId uint64) *NotifierM
 	}
 }
 
+// NotifierMobileCampaign is an SMS campaign sent to the subscribers of its tags by the mobile driver.
+// Its statuses are the ones of email campaigns.
+type NotifierMobileCampaign struct {
+	DriverId    uint64
+	ScheduledAt *time.Time
+	UpdatedAt   time.Time
+	CreatedAt   time.Time
+	StatusId    uint64
+	Sender      string // The line number messages are sent from, or empty for the default line of the driver.
+	Message     string
+	Name        string
+	// Variables are rendered in the message as {{.Vars.name}}.
+	Variables map[string]string `gorm:"serializer:json"`
+	// CampaignId is the multi-channel campaign of the SMS campaign, if any.
+	CampaignId *uint64
+	ID         uint64
+}
+
+func NewNotifierMobileCampaign(driverId uint64, scheduledAt *time.Time, statusId uint64, sender, message, name string) *NotifierMobileCampaign {
+	return &NotifierMobileCampaign{
+		DriverId:    driverId,
+		ScheduledAt: scheduledAt,
+		StatusId:    statusId,
+		Sender:      sender,
+		Message:     message,
+		Name:        name,
+		UpdatedAt:   time.Now(),
+		CreatedAt:   time.Now(),
+	}
+}
+
+type NotifierMobileCampaignTag struct {
+	CampaignId uint64
+	TagId      uint64
+}
+
+func NewNotifierMobileCampaignTag(campaignId uint64, tagId uint64) *NotifierMobileCampaignTag {
+	return &NotifierMobileCampaignTag{CampaignId: campaignId, TagId: tagId}
+}
+
+// NotifierMobileMessage is the log of an SMS sent to a subscriber.
+type NotifierMobileMessage struct {
+	Receptor     string
+	DriverId     uint64
+	SubscriberId uint64
+	SourceType   string
+	SourceId     uint64
+	Sender       string
+	Message      string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	QueuedAt     *time.Time
+	FailedAt     *time.Time
+	SentAt       *time.Time
+	// ProviderMessageId is the message ID the driver returned for the sent SMS, to correlate its delivery reports.
+	ProviderMessageId string
+	ID                uint64
+}
+
+func NewNotifierMobileMessage(receptor string, subscriberId uint64, sourceType string, sourceId uint64, sender string, driverId uint64, message string) *NotifierMobileMessage {
+	queuedAt := time.Now()
+	return &NotifierMobileMessage{
+		Receptor:     receptor,
+		DriverId:     driverId,
+		SubscriberId: subscriberId,
+		SourceType:   sourceType,
+		SourceId:     sourceId,
+		Sender:       sender,
+		Message:      message,
+		QueuedAt:     &queuedAt,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+}
+
+const (
+	NotifierMobileMessageSourceCampaign      = "campaign"
+	NotifierMobileMessageSourceTransactional = "transactional"
+)
+
 // Notification subscriber models
 
 const (
 	NotifierNotificationServiceFirebaseType = "Firebase"
+	NotifierNotificationServiceAPNsType     = "APNs"
+	NotifierNotificationServiceWebPushType  = "WebPush"
 )
 
 type NotifierNotificationService struct {
@@ -277,18 +562,27 @@ type NotifierNotificationService struct {
 	ID      uint64
 }
 
+// TableName keeps the table name of the notification drivers, which is older than this model.
+func (NotifierNotificationService) TableName() string {
+	return "notifier_notification_drivers"
+}
+
 func NewNotifierNotificationService(payload string, Type string, name string) *NotifierNotificationService {
 	return &NotifierNotificationService{Payload: payload, Type: Type, Name: name}
 }
 
+// NotifierNotificationSubscriber is a push token of a device or a browser. UpdatedAt is when the token was
+// last refreshed by the app, and DisabledAt is set when the provider reported the token as invalid.
 type NotifierNotificationSubscriber struct {
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	FirstName string
-	LastName  string
-	DriverId  uint64
-	Token     string
-	ID        uint64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	FirstName      string
+	LastName       string
+	DriverId       uint64
+	Token          string
+	DisabledAt     *time.Time
+	DisabledReason string
+	ID             uint64
 }
 
 func NewNotifierNotificationSubscriber(token, firstName, lastName string, driverId uint64) *NotifierNotificationSubscriber {
@@ -302,6 +596,11 @@ func NewNotifierNotificationSubscriber(token, firstName, lastName string, driver
 	}
 }
 
+// Active reports whether notifications are sent to the token.
+func (s *NotifierNotificationSubscriber) Active() bool {
+	return s.DisabledAt == nil
+}
+
 type NotifierNotificationSubTag struct {
 	NotificationSubscriberId uint64
 	TagId                    uint64
@@ -314,6 +613,97 @@ func NewNotifierNotificationSubTag(notificationSubscriberId uint64, tagId uint64
 	}
 }
 
+// NotifierNotificationCampaign is a push campaign sent to the tokens of the subscribers of its tags that
+// belong to the notification driver. Its statuses are the ones of email campaigns.
+type NotifierNotificationCampaign struct {
+	DriverId    uint64
+	ScheduledAt *time.Time
+	UpdatedAt   time.Time
+	CreatedAt   time.Time
+	StatusId    uint64
+	Title       string
+	Body        string
+	Image       string // The URL of an image shown by the clients that support it.
+	// Data is delivered to the app with the notification.
+	Data map[string]string `gorm:"serializer:json"`
+	Name string
+	// CampaignId is the multi-channel campaign of the push campaign, if any.
+	CampaignId *uint64
+	ID         uint64
+}
+
+func NewNotifierNotificationCampaign(driverId uint64, scheduledAt *time.Time, statusId uint64, title, body, image, name string) *NotifierNotificationCampaign {
+	return &NotifierNotificationCampaign{
+		DriverId:    driverId,
+		ScheduledAt: scheduledAt,
+		StatusId:    statusId,
+		Title:       title,
+		Body:        body,
+		Image:       image,
+		Name:        name,
+		UpdatedAt:   time.Now(),
+		CreatedAt:   time.Now(),
+	}
+}
+
+type NotifierNotificationCampaignTag struct {
+	CampaignId uint64
+	TagId      uint64
+}
+
+func NewNotifierNotificationCampaignTag(campaignId uint64, tagId uint64) *NotifierNotificationCampaignTag {
+	return &NotifierNotificationCampaignTag{CampaignId: campaignId, TagId: tagId}
+}
+
+// NotifierNotificationMessage is the log of a push notification sent to a token of a subscriber.
+type NotifierNotificationMessage struct {
+	Token        string
+	DriverId     uint64
+	SubscriberId uint64
+	SourceType   string
+	SourceId     uint64
+	Title        string
+	Body         string
+	Image        string
+	Data         map[string]string `gorm:"serializer:json"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	QueuedAt     *time.Time
+	FailedAt     *time.Time
+	SentAt       *time.Time
+	// ProviderMessageId is the message ID the driver returned for the sent notification.
+	ProviderMessageId string
+	ID                uint64
+}
+
+func NewNotifierNotificationMessage(token string, subscriberId uint64, sourceType string, sourceId uint64, driverId uint64, notification *PushNotification) *NotifierNotificationMessage {
+	queuedAt := time.Now()
+	return &NotifierNotificationMessage{
+		Token:        token,
+		DriverId:     driverId,
+		SubscriberId: subscriberId,
+		SourceType:   sourceType,
+		SourceId:     sourceId,
+		Title:        notification.Title,
+		Body:         notification.Body,
+		Image:        notification.Image,
+		Data:         notification.Data,
+		QueuedAt:     &queuedAt,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+}
+
+// Notification returns the push notification of the message.
+func (m *NotifierNotificationMessage) Notification() *PushNotification {
+	return &PushNotification{Title: m.Title, Body: m.Body, Image: m.Image, Data: m.Data}
+}
+
+const (
+	NotifierNotificationMessageSourceCampaign      = "campaign"
+	NotifierNotificationMessageSourceTransactional = "transactional"
+)
+
 //Tag models
 
 type NotifierTag struct {
@@ -330,3 +720,49 @@ func NewNotifierTag(name string) *NotifierTag {
 		UpdatedAt: time.Now(),
 	}
 }
+
+// Job models
+
+const (
+	NotifierJobStatePending = "pending"
+	NotifierJobStateRunning = "running"
+	NotifierJobStateDone    = "done"
+	NotifierJobStateFailed  = "failed"
+	NotifierJobStateDead    = "dead"
+)
+
+// NotifierJob is a row of the outbox of workers, e.g. the send of a campaign message. A pending job is claimed
+// by a worker from AvailableAt and leased to it until LockedUntil; a running job of an expired lease is claimed
+// again, so jobs of a stopped worker are run by the others. A job claimed MaxAttempts times isn't claimed again but
+// set as dead, so a job that keeps crashing or timing out its worker is given up. LastError is why a failed or dead
+// job is finished. Batch groups the jobs of a campaign, and UniqueKey skips a job that's already enqueued.
+type NotifierJob struct {
+	AvailableAt time.Time
+	LockedUntil *time.Time
+	FinishedAt  *time.Time
+	UpdatedAt   time.Time
+	CreatedAt   time.Time
+	UniqueKey   *string
+	Type        string
+	Payload     string
+	Batch       string
+	State       string
+	LockedBy    string
+	LastError   string
+	Attempts    uint
+	MaxAttempts uint
+	ID          uint64
+}
+
+func NewNotifierJob(jobType string, payload string, batch string) *NotifierJob {
+	return &NotifierJob{
+		Type:        jobType,
+		Payload:     payload,
+		Batch:       batch,
+		State:       NotifierJobStatePending,
+		MaxAttempts: defaultJobMaxAttempts,
+		AvailableAt: time.Now(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
@@ -547,3 +547,25 @@ func TestNewNotifierTag(t *testing.T) {
 		t.Error("Expected UpdatedAt to be close to the current time")
 	}
 }
+
+//Campaign test
+
+func TestCampaignStatus(t *testing.T) {
+	tests := []struct {
+		statuses []uint64
+		expected uint64
+	}{
+		{nil, NotifierEmailStatusDraft},
+		{[]uint64{NotifierEmailStatusSent, NotifierEmailStatusSending, NotifierEmailStatusDraft}, NotifierEmailStatusSending},
+		{[]uint64{NotifierEmailStatusSent, NotifierEmailStatusDraft}, NotifierEmailStatusDraft},
+		{[]uint64{NotifierEmailStatusQueued, NotifierEmailStatusFailed}, NotifierEmailStatusQueued},
+		{[]uint64{NotifierEmailStatusSent, NotifierEmailStatusFailed}, NotifierEmailStatusFailed},
+		{[]uint64{NotifierEmailStatusCanceled, NotifierEmailStatusCanceled}, NotifierEmailStatusCanceled},
+		{[]uint64{NotifierEmailStatusSent, NotifierEmailStatusCanceled}, NotifierEmailStatusSent},
+	}
+	for _, test := range tests {
+		if status := CampaignStatus(test.statuses); status != test.expected {
+			t.Errorf("Expected status of %v to be '%d', but got '%d'", test.statuses, test.expected, status)
+		}
+	}
+}
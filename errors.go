@@ -0,0 +1,174 @@
+package go_notifier_core
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrMessageExists is returned by CheckEmailMessageExists, CheckMobileMessageExists and
+// CheckNotificationMessageExists when the message is already stored, and the message is filled with it.
+var ErrMessageExists = errors.New("record found")
+
+type (
+	// InvalidDriverError is returned when DbConfig.Driver isn't one of the supported drivers.
+	InvalidDriverError struct {
+		Name   string
+		Driver int
+	}
+
+	// ConnectionError is returned when the database connection can't be opened.
+	ConnectionError struct {
+		Name   string
+		Driver string
+		Err    error
+	}
+
+	// MigrationError is returned when running or rolling back migrations fails.
+	// Migration is the id of the failed migration, empty when the history table can't be used.
+	MigrationError struct {
+		Action    string
+		Migration string
+		Err       error
+	}
+
+	// SeedError is returned when a seeder can't store its records.
+	SeedError struct {
+		Seeder string
+		Err    error
+	}
+
+	// NotInitializedError is returned when a function needs Initialize to be called first.
+	NotInitializedError struct {
+	}
+
+	// MailerError is returned when the API of an email provider rejects a mail.
+	// Body is the response of the provider, which usually explains the reason.
+	MailerError struct {
+		Mailer     string
+		StatusCode int
+		Body       string
+	}
+
+	// SmsSenderError is returned when the API of an SMS provider rejects a message.
+	// Body is the response of the provider, which usually explains the reason.
+	SmsSenderError struct {
+		Sender     string
+		StatusCode int
+		Body       string
+	}
+
+	// PushSenderError is returned when the API of a push provider rejects a notification.
+	// Body is the response of the provider, which usually explains the reason.
+	PushSenderError struct {
+		Sender     string
+		StatusCode int
+		Body       string
+	}
+
+	// InvalidPushTokenError is returned by push senders when the provider reports the token as unregistered
+	// or invalid, so it won't ever be delivered to. Reason is the reason the provider gave, e.g. "UNREGISTERED".
+	InvalidPushTokenError struct {
+		Sender string
+		Reason string
+		Err    error
+	}
+
+	// RateLimitedError is returned when a send would exceed a rate limit of its service. The message isn't sent
+	// nor failed, and its job is delayed for RetryAfter.
+	RateLimitedError struct {
+		Service    string
+		Limit      string
+		RetryAfter time.Duration
+	}
+
+	// RetryError is returned when a send failed by a transient error and is retried after RetryAfter.
+	// Attempt is the count of the failed sends.
+	RetryError struct {
+		Attempt    uint
+		RetryAfter time.Duration
+		Err        error
+	}
+
+	// TemplateError is returned when a template, e.g. the content of an email template or the subject of
+	// a campaign, can't be parsed or rendered.
+	TemplateError struct {
+		Template string
+		Err      error
+	}
+)
+
+func (i InvalidDriverError) Error() string {
+	return "invalid driver " + strconv.Itoa(i.Driver) + " for config '" + i.Name + "'"
+}
+
+func (c ConnectionError) Error() string {
+	return "error during connecting db " + c.Driver + " driver for config '" + c.Name + "' : " + c.Err.Error()
+}
+
+func (c ConnectionError) Unwrap() error {
+	return c.Err
+}
+
+func (m MigrationError) Error() string {
+	if m.Migration != "" {
+		return "error during " + m.Action + " of " + m.Migration + " : " + m.Err.Error()
+	}
+	return "error during " + m.Action + " : " + m.Err.Error()
+}
+
+func (m MigrationError) Unwrap() error {
+	return m.Err
+}
+
+func (s SeedError) Error() string {
+	return "error during " + s.Seeder + " seeder : " + s.Err.Error()
+}
+
+func (s SeedError) Unwrap() error {
+	return s.Err
+}
+
+func (n NotInitializedError) Error() string {
+	return "dependencies not initialized"
+}
+
+func (m MailerError) Error() string {
+	return m.Mailer + " mailer failed with status " + strconv.Itoa(m.StatusCode) + " : " + m.Body
+}
+
+func (s SmsSenderError) Error() string {
+	return s.Sender + " sms sender failed with status " + strconv.Itoa(s.StatusCode) + " : " + s.Body
+}
+
+func (p PushSenderError) Error() string {
+	return p.Sender + " push sender failed with status " + strconv.Itoa(p.StatusCode) + " : " + p.Body
+}
+
+func (i InvalidPushTokenError) Error() string {
+	return i.Sender + " push token is invalid (" + i.Reason + ") : " + i.Err.Error()
+}
+
+func (i InvalidPushTokenError) Unwrap() error {
+	return i.Err
+}
+
+func (r RateLimitedError) Error() string {
+	return r.Service + " reached its " + r.Limit + " rate limit, retry after " + r.RetryAfter.String()
+}
+
+func (r RetryError) Error() string {
+	return "attempt " + strconv.FormatUint(uint64(r.Attempt), 10) + " failed, retry after " + r.RetryAfter.String() + " : " + r.Err.Error()
+}
+
+func (r RetryError) Unwrap() error {
+	return r.Err
+}
+
+func (t TemplateError) Error() string {
+	return "invalid template " + t.Template + " : " + t.Err.Error()
+}
+
+func (t TemplateError) Unwrap() error {
+	return t.Err
+}
@@ -20,12 +20,14 @@ func main() {
 	}
 
 	//For using package you should first initialize it.
-	go_notifier_core.Initialize(c)
+	if err := go_notifier_core.Initialize(c); err != nil {
+		log.Fatalf("Error during initialize : %s", err)
+	}
 
 	//Create a tag called all. all tag is a default tag for subscribers. All subscribers have this tag.
 	tag, err := go_notifier_core.CreateTag("all")
-	if err != nil {
-		log.Printf("Error during create a tag : %s", err)
+	if err != nil && tag == nil {
+		log.Fatalf("Error during create a tag : %s", err)
 	}
 
 	//subscribe 1000 emails
@@ -47,7 +49,7 @@ func main() {
 		Port:       "2525",
 		Username:   "username",
 		Password:   "password",
-		Encryption: "tls",
+		Encryption: go_notifier_core.SmtpEncryptionStartTLS, // Or go_notifier_core.SmtpEncryptionSSL on port 465.
 	}
 
 	bt, err := json.Marshal(&config)
@@ -56,9 +58,15 @@ func main() {
 	}
 
 	emailService, err := go_notifier_core.CreateEmailService("smtp test", go_notifier_core.NotifierEmailServiceSMTPType, bt)
+	if err != nil {
+		log.Fatalf("Error during create email service : %s", err)
+	}
 
 	//Create a email template
-	template, error := go_notifier_core.CreateEmailTemplate("Test Template", "<h1> Hello, Good morning</h1></br><p>This is a test</p>")
+	template, err := go_notifier_core.CreateEmailTemplate("Test Template", "<h1> Hello, Good morning</h1></br><p>This is a test</p>")
+	if err != nil {
+		log.Fatalf("Error during create email template : %s", err)
+	}
 
 	//Create a campaign
 	//To send a scheduled email for subscribers, you should create a campaign
@@ -73,7 +81,10 @@ func main() {
 		FromName:       "Go Notifier",
 		Subject:        "This is a test",
 		Name:           "New Campaign",
-		Tags:           []uint64{1},
+		Tags:           []uint64{tag.ID},
 	})
-
+	if err != nil {
+		log.Fatalf("Error during create campaign : %s", err)
+	}
+	log.Printf("Campaign %d created", campaign.ID)
 }
@@ -1,6 +1,9 @@
 package main
 
-import "github.com/milito-78/go-notifier-core"
+import (
+	"github.com/milito-78/go-notifier-core"
+	"log"
+)
 
 func main() {
 	//For connecting app to db you don't need to create env.
@@ -8,22 +11,31 @@ func main() {
 		Name:     "connection name",
 		Username: "root",
 		Password: "secret",
-		Driver:   go_notifier_core.MysqlDriver, // Postgres doesn't implemented
+		Driver:   go_notifier_core.MysqlDriver, // or go_notifier_core.PostgresDriver, go_notifier_core.SqliteDriver
 		Host:     "127.0.0.1",
 		Port:     "3306",
 		DB:       "notifier",
 	}
 
 	//To migrate and create tables use this function.
-	go_notifier_core.Migrate(c)
+	//Applied migrations are stored in `notifier_migrations` table, so it only runs pending migrations
+	//and it's safe to call it after every upgrade of the package.
+	//It returns an error instead of stopping your application, so you can retry or report it.
+	if err := go_notifier_core.Migrate(c); err != nil {
+		log.Fatalf("Error during migrate : %s", err)
+	}
 
 	//##########################################
 
 	//You need to init and seed your tables.
 	// BUT! Before you seed your tables you should initialize your application.
 	//So you should use Initialize function first.
-	go_notifier_core.Initialize(c)
-	go_notifier_core.Seed()
+	if err := go_notifier_core.Initialize(c); err != nil {
+		log.Fatalf("Error during initialize : %s", err)
+	}
+	if err := go_notifier_core.Seed(); err != nil {
+		log.Fatalf("Error during seed : %s", err)
+	}
 
 	/*
 		 * HINT: If you used Initialize() you can use handlers and Seed function and Migrate() function too.
@@ -32,6 +44,14 @@ func main() {
 	*/
 	//##########################################
 
-	//To rollback your migrations and remove your db use this function
-	go_notifier_core.MigrateRollback(c)
+	//To rollback the last batch of your migrations use this function.
+	//Use MigrateRollbackSteps(c, n) to rollback the last n migrations.
+	if err := go_notifier_core.MigrateRollback(c); err != nil {
+		log.Fatalf("Error during rollback : %s", err)
+	}
+
+	//To rollback all of your migrations and remove your tables use this function
+	if err := go_notifier_core.MigrateReset(c); err != nil {
+		log.Fatalf("Error during reset : %s", err)
+	}
 }
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"github.com/milito-78/go-notifier-core"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -10,13 +15,16 @@ func main() {
 		Name:     "connection name",
 		Username: "root",
 		Password: "secret",
-		Driver:   go_notifier_core.MysqlDriver, // Postgres doesn't implemented
+		Driver:   go_notifier_core.MysqlDriver, // or go_notifier_core.PostgresDriver, go_notifier_core.SqliteDriver
 		Host:     "127.0.0.1",
 		Port:     "3306",
 		DB:       "notifier",
 	}
 	//For use worker you need to Initialize it first.
-	go_notifier_core.Initialize(c)
+	//Initialize returns an error when the database isn't reachable, so you can retry it.
+	if err := go_notifier_core.Initialize(c); err != nil {
+		log.Fatalf("Error during initialize : %s", err)
+	}
 
 	//You need to create a list of workers that you need.
 	list := go_notifier_core.WorkersList{
@@ -25,12 +33,45 @@ func main() {
 			Worker:   go_notifier_core.EmailWorker{}, // You can customize your worker. It must be implemented from IWorker interface.
 			Name:     "Email worker",
 		},
+		go_notifier_core.WorkerConfig{
+			Duration: time.Second * 10,
+			Worker:   go_notifier_core.MobileWorker{},
+			Name:     "Mobile worker",
+		},
+		go_notifier_core.WorkerConfig{
+			Duration: time.Second * 10,
+			Worker:   go_notifier_core.NotificationWorker{},
+			Name:     "Notification worker",
+		},
+		go_notifier_core.WorkerConfig{
+			Duration: time.Second * 5,
+			Worker:   go_notifier_core.JobWorker{}, // Sends the messages the workers above enqueue. Run it on as many hosts as you need.
+			Name:     "Job worker",
+		},
+		go_notifier_core.WorkerConfig{
+			Duration: time.Hour * 24,
+			Worker:   go_notifier_core.PushTokenPruneWorker{Days: 60},
+			Name:     "Push token prune worker",
+		},
+		go_notifier_core.WorkerConfig{
+			Duration: time.Hour * 24,
+			Worker:   go_notifier_core.JobPruneWorker{Days: 7},
+			Name:     "Job prune worker",
+		},
 	}
 
 	//After create list, you should pass list to start it.
-	go_notifier_core.WorkerStart(list)
+	workers := go_notifier_core.WorkerStart(context.Background(), list)
+
+	//Keep your app running until it's stopped, e.g. by Kubernetes during a rollout.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
 
-	//To keep your app running you can use chanel
-	var infinity chan interface{}
-	<-infinity
+	//Stop waits for the sends in flight. The jobs of the sends it gives up on are run again by another worker.
+	shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := workers.Stop(shutdown); err != nil {
+		log.Printf("Error during stop workers : %s", err)
+	}
 }
@@ -1,120 +1,18 @@
 package go_notifier_core
 
 import (
+	"context"
 	"errors"
-	"github.com/golobby/container/v3"
+	"fmt"
 	"gorm.io/gorm"
-	"log"
 	"strings"
 	"time"
 )
 
-var initialized = false
-
-func dbFactory(config DbConfig) *gorm.DB {
-	return getDriver(config).(*gorm.DB)
-}
-
-func initRepositories() {
-	_ = container.Singleton(func(db *gorm.DB) ITagRepository {
-		return NewGormTagRepository(db)
-	})
-
-	//Email repositories #start
-	_ = container.Singleton(func(db *gorm.DB) IEmailUnSubEventRepository {
-		return NewGormEmailUnSubEventRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IEmailSubTagRepository {
-		return NewGormEmailSubTagRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IEmailSubscriberRepository {
-		return NewGormEmailSubscriberRepository(db)
-	})
-	//Email repositories #end
-
-	//Mobile repositories #start
-	_ = container.Singleton(func(db *gorm.DB) IMobileUnSubEventRepository {
-		return NewGormMobileUnSubEventRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IMobileSubTagRepository {
-		return NewGormMobileSubTagRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IMobileSubscriberRepository {
-		return NewGormMobileSubscriberRepository(db)
-	})
-	//Mobile repositories #end
-
-	//Notification repositories #start
-	_ = container.Singleton(func(db *gorm.DB) INotifierNotificationDriverRepository {
-		return NewGormNotifierNotificationDriverRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) INotificationSubTagRepository {
-		return NewGormNotificationSubTagRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) INotificationSubscriberRepository {
-		return NewGormNotificationSubscriberRepository(db)
-	})
-	//Notification repositories #end
-
-	//Campaign repositories #start
-	_ = container.Singleton(func(db *gorm.DB) IEmailTemplateRepository {
-		return NewGormEmailTemplateRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IEmailServiceRepository {
-		return NewGormEmailServiceRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IEmailStatusRepository {
-		return NewGormEmailStatusRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IEmailCampaignRepository {
-		return NewGormEmailCampaignRepository(db)
-	})
-
-	_ = container.Singleton(func(db *gorm.DB) IEmailMessageRepository {
-		return NewGormEmailMessageRepository(db)
-	})
-	//Campaign repositories #end
-}
-
-func initMailers() {
-	_ = container.NamedSingleton(NotifierEmailServiceSMTPType, func() Mailer {
-		return new(SmtpMailer)
-	})
-}
-
-func Initialize(config DbConfig) {
-	defer func() {
-		initialized = true
-	}()
-
-	err := container.Singleton(func() *gorm.DB {
-		return dbFactory(config)
-	})
-	if err != nil {
-		log.Fatalf("Error during generate singleton : %s", err)
-	}
-
-	initRepositories()
-	initMailers()
-}
-
 // Tag functions #start
 
-func CreateTag(name string) (*NotifierTag, error) {
-	var tgRepo ITagRepository
-	err := container.Resolve(&tgRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) CreateTag(name string) (*NotifierTag, error) {
+	tgRepo := n.tagRepo
 	nLower := strings.ToLower(name)
 
 	res, err := tgRepo.GetByName(nLower)
@@ -130,16 +28,12 @@ func CreateTag(name string) (*NotifierTag, error) {
 	return tmp, nil
 }
 
-func DeleteTagByName(name string) error {
+func (n *Notifier) DeleteTagByName(name string) error {
 	if strings.ToLower(name) == "all" {
 		return errors.New("can't remove all tag")
 	}
 
-	var tgRepo ITagRepository
-	err := container.Resolve(&tgRepo)
-	if err != nil {
-		return err
-	}
+	tgRepo := n.tagRepo
 
 	exists, err := tgRepo.GetByName(name)
 	if err != nil {
@@ -153,12 +47,8 @@ func DeleteTagByName(name string) error {
 	return nil
 }
 
-func GetTagByName(name string) (*NotifierTag, error) {
-	var tgRepo ITagRepository
-	err := container.Resolve(&tgRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) GetTagByName(name string) (*NotifierTag, error) {
+	tgRepo := n.tagRepo
 
 	nLower := strings.ToLower(name)
 	res, err := tgRepo.GetByName(nLower)
@@ -168,22 +58,18 @@ func GetTagByName(name string) (*NotifierTag, error) {
 	return res, nil
 }
 
-func TagsList() ([]NotifierTag, error) {
-	var tgRepo ITagRepository
-	err := container.Resolve(&tgRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) TagsList() ([]NotifierTag, error) {
+	tgRepo := n.tagRepo
 	var data []NotifierTag
 	tgRepo.All(&data)
 	return data, nil
 }
 
-func fetchTags(tags []string, createTag bool) ([]uint64, error) {
+func (n *Notifier) fetchTags(tags []string, createTag bool) ([]uint64, error) {
 	var tagsEntity []uint64
 
 	if len(tags) == 0 {
-		tmp, err := CreateTag("all")
+		tmp, err := n.CreateTag("all")
 		if err != nil && tmp.ID == 0 {
 			return nil, err
 		}
@@ -193,7 +79,7 @@ func fetchTags(tags []string, createTag bool) ([]uint64, error) {
 
 	if createTag {
 		for _, tag := range tags {
-			tmp, err := CreateTag(tag)
+			tmp, err := n.CreateTag(tag)
 			if err != nil && tmp.ID == 0 {
 				return nil, err
 			}
@@ -201,7 +87,7 @@ func fetchTags(tags []string, createTag bool) ([]uint64, error) {
 		}
 	} else {
 		for _, tag := range tags {
-			tmp, err := GetTagByName(tag)
+			tmp, err := n.GetTagByName(tag)
 			if err != nil {
 				return nil, err
 			}
@@ -224,17 +110,13 @@ func checkAllTagExists(tags []string) bool {
 
 // Email subscribe functions #start
 
-func SubscribeEmail(email, fName, lName string, tags []string, createTag bool) (*NotifierEmailSubscriber, error) {
-	tagsEntity, err := fetchTags(tags, createTag)
+func (n *Notifier) SubscribeEmail(email, fName, lName string, tags []string, createTag bool) (*NotifierEmailSubscriber, error) {
+	tagsEntity, err := n.fetchTags(tags, createTag)
 	if err != nil {
 		return nil, err
 	}
 
-	var subRepo IEmailSubscriberRepository
-	err = container.Resolve(&subRepo)
-	if err != nil {
-		return nil, err
-	}
+	subRepo := n.emailSubscriberRepo
 
 	tmp, err := subRepo.GetByEmail(email)
 	if err == nil && tmp.ID != 0 {
@@ -255,20 +137,16 @@ func SubscribeEmail(email, fName, lName string, tags []string, createTag bool) (
 	return subscriber, nil
 }
 
-func AssignTagsToEmail(email string, tags []string, createTag bool) error {
+func (n *Notifier) AssignTagsToEmail(email string, tags []string, createTag bool) error {
 	if len(tags) == 0 {
 		return errors.New("tags is empty")
 	}
-	tagsEntity, err := fetchTags(tags, createTag)
+	tagsEntity, err := n.fetchTags(tags, createTag)
 	if err != nil {
 		return err
 	}
 
-	var subRepo IEmailSubscriberRepository
-	err = container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+	subRepo := n.emailSubscriberRepo
 	subscriber, err := subRepo.GetByEmailWithTags(email)
 	if err != nil {
 		return err
@@ -297,7 +175,7 @@ func AssignTagsToEmail(email string, tags []string, createTag bool) error {
 	return nil
 }
 
-func RemoveTagsFromEmail(email string, tags []string) error {
+func (n *Notifier) RemoveTagsFromEmail(email string, tags []string) error {
 	if len(tags) == 0 {
 		return errors.New("tags is empty")
 	}
@@ -307,17 +185,13 @@ func RemoveTagsFromEmail(email string, tags []string) error {
 
 	var tagsEntity []uint64
 	for _, tag := range tags {
-		tmp, err := GetTagByName(tag)
+		tmp, err := n.GetTagByName(tag)
 		if err == nil {
 			tagsEntity = append(tagsEntity, tmp.ID)
 		}
 	}
 
-	var subRepo IEmailSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+	subRepo := n.emailSubscriberRepo
 	subscriber, err := subRepo.GetByEmail(email)
 	if err != nil {
 		return err
@@ -331,12 +205,8 @@ func RemoveTagsFromEmail(email string, tags []string) error {
 	return nil
 }
 
-func UnSubscribeEmail(email string, unsubId uint64) error {
-	var subRepo IEmailSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+func (n *Notifier) UnSubscribeEmail(email string, unsubId uint64) error {
+	subRepo := n.emailSubscriberRepo
 
 	subscriber, err := subRepo.GetByEmail(email)
 	if err != nil {
@@ -347,11 +217,7 @@ func UnSubscribeEmail(email string, unsubId uint64) error {
 		return nil
 	}
 
-	var eventRepo IEmailUnSubEventRepository
-	err = container.Resolve(&eventRepo)
-	if err != nil {
-		return err
-	}
+	eventRepo := n.emailUnSubEventRepo
 
 	_, err = eventRepo.Get(unsubId)
 	if err != nil {
@@ -359,8 +225,8 @@ func UnSubscribeEmail(email string, unsubId uint64) error {
 	}
 
 	subscriber.UnsubscribedEventId = &unsubId
-	n := time.Now()
-	subscriber.UnsubscribedAt = &n
+	now := time.Now()
+	subscriber.UnsubscribedAt = &now
 
 	err = subRepo.Update(subscriber)
 	if err != nil {
@@ -370,51 +236,35 @@ func UnSubscribeEmail(email string, unsubId uint64) error {
 	return nil
 }
 
-func EmailUnsubscribeEventsList() ([]NotifierEmailUnsubscribeEvent, error) {
-	var eventRepo IEmailUnSubEventRepository
-	err := container.Resolve(&eventRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) EmailUnsubscribeEventsList() ([]NotifierEmailUnsubscribeEvent, error) {
+	eventRepo := n.emailUnSubEventRepo
 	var data []NotifierEmailUnsubscribeEvent
 	eventRepo.All(&data)
 	return data, nil
 }
 
-func GetTagEmailSubscribers(tag string) ([]NotifierEmailSubscriber, error) {
-	tmp, err := GetTagByName(tag)
+func (n *Notifier) GetTagEmailSubscribers(tag string) ([]NotifierEmailSubscriber, error) {
+	tmp, err := n.GetTagByName(tag)
 	if err != nil {
 		return nil, err
 	}
 
-	var subRepo IEmailSubscriberRepository
-	err = container.Resolve(&subRepo)
-	if err != nil {
-		return nil, err
-	}
+	subRepo := n.emailSubscriberRepo
 	var data []NotifierEmailSubscriber
 	subRepo.GetSubscribersForTag(tmp.ID, &data)
 	return data, nil
 }
 
-func GetUnsubscribedEmails() ([]NotifierEmailSubscriber, error) {
-	var subRepo IEmailSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) GetUnsubscribedEmails() ([]NotifierEmailSubscriber, error) {
+	subRepo := n.emailSubscriberRepo
 
 	var data []NotifierEmailSubscriber
 	subRepo.GetUnSubscribed(&data)
 	return data, nil
 }
 
-func GetEmailSubscribersWithTags(tags []NotifierTag) ([]NotifierEmailSubscriber, error) {
-	var subRepo IEmailSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) GetEmailSubscribersWithTags(tags []NotifierTag) ([]NotifierEmailSubscriber, error) {
+	subRepo := n.emailSubscriberRepo
 	var data []NotifierEmailSubscriber
 	subRepo.GetUsersByTagId(tags, &data)
 	return data, nil
@@ -424,17 +274,13 @@ func GetEmailSubscribersWithTags(tags []NotifierTag) ([]NotifierEmailSubscriber,
 
 // Mobile subscribe functions #start
 
-func SubscribeMobile(countryCode, mobile, fName, lName string, tags []string, createTag bool) (*NotifierMobileSubscriber, error) {
-	tagsEntity, err := fetchTags(tags, createTag)
+func (n *Notifier) SubscribeMobile(countryCode, mobile, fName, lName string, tags []string, createTag bool) (*NotifierMobileSubscriber, error) {
+	tagsEntity, err := n.fetchTags(tags, createTag)
 	if err != nil {
 		return nil, err
 	}
 
-	var subRepo IMobileSubscriberRepository
-	err = container.Resolve(&subRepo)
-	if err != nil {
-		return nil, err
-	}
+	subRepo := n.mobileSubscriberRepo
 	subscriber := NewNotifierMobileSubscriber(countryCode, mobile, fName, lName)
 	err = subRepo.Create(subscriber)
 	if err != nil {
@@ -448,21 +294,17 @@ func SubscribeMobile(countryCode, mobile, fName, lName string, tags []string, cr
 	return subscriber, nil
 }
 
-func AssignTagsToMobile(mobile string, tags []string, createTag bool) error {
+func (n *Notifier) AssignTagsToMobile(mobile string, tags []string, createTag bool) error {
 	if len(tags) == 0 {
 		return errors.New("tags is empty")
 	}
 
-	tagsEntity, err := fetchTags(tags, createTag)
+	tagsEntity, err := n.fetchTags(tags, createTag)
 	if err != nil {
 		return err
 	}
 
-	var subRepo IMobileSubscriberRepository
-	err = container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+	subRepo := n.mobileSubscriberRepo
 	subscriber, err := subRepo.GetByMobile(mobile)
 	if err != nil {
 		return err
@@ -476,7 +318,7 @@ func AssignTagsToMobile(mobile string, tags []string, createTag bool) error {
 	return nil
 }
 
-func RemoveTagsFromMobile(mobile string, tags []string) error {
+func (n *Notifier) RemoveTagsFromMobile(mobile string, tags []string) error {
 	if len(tags) == 0 {
 		return errors.New("tags is empty")
 	}
@@ -486,17 +328,13 @@ func RemoveTagsFromMobile(mobile string, tags []string) error {
 	}
 	var tagsEntity []uint64
 	for _, tag := range tags {
-		tmp, err := GetTagByName(tag)
+		tmp, err := n.GetTagByName(tag)
 		if err == nil {
 			tagsEntity = append(tagsEntity, tmp.ID)
 		}
 	}
 
-	var subRepo IMobileSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+	subRepo := n.mobileSubscriberRepo
 	subscriber, err := subRepo.GetByMobile(mobile)
 	if err != nil {
 		return err
@@ -510,23 +348,15 @@ func RemoveTagsFromMobile(mobile string, tags []string) error {
 	return nil
 }
 
-func UnSubscribeMobile(mobile string, unsubId uint64) error {
-	var subRepo IMobileSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+func (n *Notifier) UnSubscribeMobile(mobile string, unsubId uint64) error {
+	subRepo := n.mobileSubscriberRepo
 
 	subscriber, err := subRepo.GetByMobile(mobile)
 	if err != nil {
 		return err
 	}
 
-	var eventRepo IMobileUnSubEventRepository
-	err = container.Resolve(&eventRepo)
-	if err != nil {
-		return err
-	}
+	eventRepo := n.mobileUnSubEventRepo
 
 	_, err = eventRepo.Get(unsubId)
 	if err != nil {
@@ -534,8 +364,8 @@ func UnSubscribeMobile(mobile string, unsubId uint64) error {
 	}
 
 	subscriber.UnsubscribedEventId = &unsubId
-	n := time.Now()
-	subscriber.UnsubscribedAt = &n
+	now := time.Now()
+	subscriber.UnsubscribedAt = &now
 
 	err = subRepo.Update(subscriber)
 	if err != nil {
@@ -545,53 +375,229 @@ func UnSubscribeMobile(mobile string, unsubId uint64) error {
 	return nil
 }
 
-func MobileUnsubscribeEventsList() ([]NotifierMobileUnsubscribeEvent, error) {
-	var eventRepo IMobileUnSubEventRepository
-	err := container.Resolve(&eventRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) MobileUnsubscribeEventsList() ([]NotifierMobileUnsubscribeEvent, error) {
+	eventRepo := n.mobileUnSubEventRepo
 	var data []NotifierMobileUnsubscribeEvent
 	eventRepo.All(&data)
 	return data, nil
 }
 
-func GetTagMobileSubscribers(tag string) ([]NotifierMobileSubscriber, error) {
-	tmp, err := GetTagByName(tag)
+func (n *Notifier) GetTagMobileSubscribers(tag string) ([]NotifierMobileSubscriber, error) {
+	tmp, err := n.GetTagByName(tag)
 	if err != nil {
 		return nil, err
 	}
 
-	var subRepo IMobileSubscriberRepository
-	err = container.Resolve(&subRepo)
+	subRepo := n.mobileSubscriberRepo
+	var data []NotifierMobileSubscriber
+	subRepo.GetSubscribersForTag(tmp.ID, data)
+	return data, nil
+}
+
+func (n *Notifier) GetUnsubscribedMobiles() ([]NotifierMobileSubscriber, error) {
+	subRepo := n.mobileSubscriberRepo
+
+	var data []NotifierMobileSubscriber
+	subRepo.GetUnSubscribed(data)
+	return data, nil
+}
+
+// Mobile subscribe functions #end
+
+// Mobile campaign functions #start
+
+func (n *Notifier) CreateMobileDriver(name, driverType string, payload []byte) (*NotifierMobileDriver, error) {
+	driver := NewNotifierMobileDriver(string(payload), driverType, name)
+	err := n.mobileDriverRepo.Create(driver)
 	if err != nil {
 		return nil, err
 	}
-	var data []NotifierMobileSubscriber
-	subRepo.GetSubscribersForTag(tmp.ID, data)
+	return driver, nil
+}
+
+func (n *Notifier) GetMobileDrivers() ([]NotifierMobileDriver, error) {
+	var data []NotifierMobileDriver
+	n.mobileDriverRepo.All(&data)
 	return data, nil
 }
 
-func GetUnsubscribedMobiles() ([]NotifierMobileSubscriber, error) {
-	var subRepo IMobileSubscriberRepository
-	err := container.Resolve(&subRepo)
+func (n *Notifier) GetMobileDriverById(driver uint64) (*NotifierMobileDriver, error) {
+	return n.mobileDriverRepo.Get(driver)
+}
+
+type MobileCampaignCreateData struct {
+	DriverId    uint64
+	ScheduledAt *time.Time
+	StatusId    uint64
+	Sender      string
+	Message     string
+	Name        string
+	Tags        []uint64
+	Variables   map[string]string
+	CampaignId  *uint64 // The multi-channel campaign of the SMS campaign, if any.
+}
+
+// AddMobileCampaign stores an SMS campaign for the subscribers of the tags. The message is a Go template rendered
+// for every subscriber, see SmsTemplateData, and a TemplateError is returned when it's invalid.
+func (n *Notifier) AddMobileCampaign(data *MobileCampaignCreateData) (*NotifierMobileCampaign, error) {
+	err := ValidateSmsMessage(data.Message)
+	if err != nil {
+		return nil, err
+	}
+	_, err = n.mobileDriverRepo.Get(data.DriverId)
+	if err != nil {
+		return nil, err
+	}
+
+	cmRepo := n.mobileCampaignRepo
+	tmp := NewNotifierMobileCampaign(data.DriverId, data.ScheduledAt, data.StatusId, data.Sender, data.Message, data.Name)
+	tmp.Variables = data.Variables
+	tmp.CampaignId = data.CampaignId
+	err = cmRepo.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmRepo.AssignTagsToCampaign(tmp.ID, data.Tags)
 	if err != nil {
 		return nil, err
 	}
+	return tmp, nil
+}
+
+type MobileCampaignUpdateData struct {
+	DriverId    uint64
+	ScheduledAt *time.Time
+	StatusId    uint64
+	Sender      string
+	Message     string
+	Name        string
+	Tags        []uint64
+	Variables   map[string]string
+}
+
+func (n *Notifier) UpdateMobileCampaignWithId(cmpId uint64, data *MobileCampaignUpdateData) error {
+	err := ValidateSmsMessage(data.Message)
+	if err != nil {
+		return err
+	}
+	_, err = n.mobileDriverRepo.Get(data.DriverId)
+	if err != nil {
+		return err
+	}
+
+	cmRepo := n.mobileCampaignRepo
+	campaign, err := cmRepo.Get(cmpId)
+	if err != nil {
+		return err
+	}
+
+	campaign.DriverId = data.DriverId
+	campaign.ScheduledAt = data.ScheduledAt
+	campaign.StatusId = data.StatusId
+	campaign.Sender = data.Sender
+	campaign.Message = data.Message
+	campaign.Name = data.Name
+	campaign.Variables = data.Variables
+	campaign.UpdatedAt = time.Now()
+	err = cmRepo.Update(campaign)
+	if err != nil {
+		return err
+	}
+	err = cmRepo.DeleteAllTagsForCampaign(cmpId)
+	if err != nil {
+		return err
+	}
+	return cmRepo.AssignTagsToCampaign(campaign.ID, data.Tags)
+}
+
+func (n *Notifier) DeleteMobileCampaign(campaign uint64) error {
+	cmRepo := n.mobileCampaignRepo
+
+	tmp, err := cmRepo.Get(campaign)
+	if err != nil {
+		return err
+	}
+	err = cmRepo.DeleteAllTagsForCampaign(tmp.ID)
+	if err != nil {
+		return err
+	}
+	return cmRepo.Delete(tmp)
+}
+
+func (n *Notifier) GetLatestMobileCampaignForRun() (*NotifierMobileCampaign, error) {
+	return n.mobileCampaignRepo.GetLatestCampaign()
+}
+
+// UpdateMobileCampaign stores the campaign and updates the status of its multi-channel campaign.
+func (n *Notifier) UpdateMobileCampaign(campaign *NotifierMobileCampaign) error {
+	err := n.mobileCampaignRepo.Update(campaign)
+	if err != nil {
+		return err
+	}
+	return n.syncCampaignStatus(campaign.CampaignId)
+}
+
+func (n *Notifier) GetMobileCampaignTags(cmpId uint64) []NotifierTag {
+	return n.mobileCampaignRepo.GetCampaignTags(cmpId)
+}
 
+func (n *Notifier) GetMobileSubscribersWithTags(tags []NotifierTag) ([]NotifierMobileSubscriber, error) {
 	var data []NotifierMobileSubscriber
-	subRepo.GetUnSubscribed(data)
+	n.mobileSubscriberRepo.GetUsersByTagId(tags, &data)
 	return data, nil
 }
 
-// Mobile subscribe functions #end
+func (n *Notifier) CheckMobileMessageExists(message *NotifierMobileMessage) error {
+	err := n.mobileMessageRepo.CheckMessageExists(message)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if message.ID != 0 {
+		return ErrMessageExists
+	}
+
+	return nil
+}
+
+func (n *Notifier) CreateMobileMessage(message *NotifierMobileMessage) error {
+	return n.mobileMessageRepo.Create(message)
+}
+
+func (n *Notifier) UpdateMobileMessage(message *NotifierMobileMessage) error {
+	return n.mobileMessageRepo.Update(message)
+}
+
+// SendMobileMessage sends a transactional SMS now. Unlike campaign messages, the message is sent even if
+// the subscriber got a message of the same source before.
+func (n *Notifier) SendMobileMessage(message *NotifierMobileMessage) error {
+	if message.SourceType == "" {
+		message.SourceType = NotifierMobileMessageSourceTransactional
+	}
+	err := n.CreateMobileMessage(message)
+	if err != nil {
+		return err
+	}
+	return n.deliverSms(message)
+}
+
+// GetMobileMessageByProviderMessageId returns the message the driver sent by the message ID, e.g. for
+// a delivery report.
+func (n *Notifier) GetMobileMessageByProviderMessageId(providerMessageId string) (*NotifierMobileMessage, error) {
+	return n.mobileMessageRepo.GetByProviderMessageId(providerMessageId)
+}
+
+// Mobile campaign functions #end
 
 // Notification subscribe functions #start
 
-func AddNewToken(token, fName, lName string, driverId uint64, tags []string, createTag bool) (*NotifierNotificationSubscriber, error) {
+func (n *Notifier) AddNewToken(token, fName, lName string, driverId uint64, tags []string, createTag bool) (*NotifierNotificationSubscriber, error) {
 	var tagsEntity []uint64
 	if len(tags) == 0 {
-		tmp, err := CreateTag("all")
+		tmp, err := n.CreateTag("all")
 		if err != nil && tmp.ID == 0 {
 			return nil, err
 		}
@@ -600,7 +606,7 @@ func AddNewToken(token, fName, lName string, driverId uint64, tags []string, cre
 	} else {
 		if createTag {
 			for _, tag := range tags {
-				tmp, err := CreateTag(tag)
+				tmp, err := n.CreateTag(tag)
 				if err != nil && tmp.ID == 0 {
 					return nil, err
 				}
@@ -608,7 +614,7 @@ func AddNewToken(token, fName, lName string, driverId uint64, tags []string, cre
 			}
 		} else {
 			for _, tag := range tags {
-				tmp, err := GetTagByName(tag)
+				tmp, err := n.GetTagByName(tag)
 				if err != nil {
 					return nil, err
 				}
@@ -617,22 +623,14 @@ func AddNewToken(token, fName, lName string, driverId uint64, tags []string, cre
 		}
 	}
 
-	var driverRepo INotifierNotificationDriverRepository
-	err := container.Resolve(&driverRepo)
-	if err != nil {
-		return nil, err
-	}
+	driverRepo := n.notificationDriverRepo
 
-	_, err = driverRepo.Get(driverId)
+	_, err := driverRepo.Get(driverId)
 	if err != nil {
 		return nil, err
 	}
 
-	var subRepo INotificationSubscriberRepository
-	err = container.Resolve(&subRepo)
-	if err != nil {
-		return nil, err
-	}
+	subRepo := n.notificationSubscriberRepo
 	subscriber := NewNotifierNotificationSubscriber(token, fName, lName, driverId)
 	err = subRepo.Create(subscriber)
 	if err != nil {
@@ -646,7 +644,7 @@ func AddNewToken(token, fName, lName string, driverId uint64, tags []string, cre
 	return subscriber, nil
 }
 
-func AssignTagsToToken(token string, tags []string, createTag bool) error {
+func (n *Notifier) AssignTagsToToken(token string, tags []string, createTag bool) error {
 	if len(tags) == 0 {
 		return errors.New("tags is empty")
 	}
@@ -654,7 +652,7 @@ func AssignTagsToToken(token string, tags []string, createTag bool) error {
 	var tagsEntity []uint64
 	if createTag {
 		for _, tag := range tags {
-			tmp, err := CreateTag(tag)
+			tmp, err := n.CreateTag(tag)
 			if err != nil && tmp.ID == 0 {
 				return err
 			}
@@ -662,7 +660,7 @@ func AssignTagsToToken(token string, tags []string, createTag bool) error {
 		}
 	} else {
 		for _, tag := range tags {
-			tmp, err := GetTagByName(tag)
+			tmp, err := n.GetTagByName(tag)
 			if err != nil {
 				return err
 			}
@@ -670,11 +668,7 @@ func AssignTagsToToken(token string, tags []string, createTag bool) error {
 		}
 	}
 
-	var subRepo INotificationSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+	subRepo := n.notificationSubscriberRepo
 	subscriber, err := subRepo.GetByNotification(token)
 	if err != nil {
 		return err
@@ -688,7 +682,7 @@ func AssignTagsToToken(token string, tags []string, createTag bool) error {
 	return nil
 }
 
-func RemoveTagsFromToken(token string, tags []string) error {
+func (n *Notifier) RemoveTagsFromToken(token string, tags []string) error {
 	if len(tags) == 0 {
 		return errors.New("tags is empty")
 	}
@@ -699,17 +693,13 @@ func RemoveTagsFromToken(token string, tags []string) error {
 
 	var tagsEntity []uint64
 	for _, tag := range tags {
-		tmp, err := GetTagByName(tag)
+		tmp, err := n.GetTagByName(tag)
 		if err == nil {
 			tagsEntity = append(tagsEntity, tmp.ID)
 		}
 	}
 
-	var subRepo INotificationSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+	subRepo := n.notificationSubscriberRepo
 	subscriber, err := subRepo.GetByNotification(token)
 	if err != nil {
 		return err
@@ -723,12 +713,9 @@ func RemoveTagsFromToken(token string, tags []string) error {
 	return nil
 }
 
-func RemoveToken(token string) error {
-	var subRepo INotificationSubscriberRepository
-	err := container.Resolve(&subRepo)
-	if err != nil {
-		return err
-	}
+// RemoveToken removes the token with its tags and message log.
+func (n *Notifier) RemoveToken(token string) error {
+	subRepo := n.notificationSubscriberRepo
 
 	subscriber, err := subRepo.GetByNotification(token)
 	if err != nil {
@@ -742,44 +729,73 @@ func RemoveToken(token string) error {
 	return nil
 }
 
-func GetTagTokenSubscribers(tag string) ([]NotifierNotificationSubscriber, error) {
-	tmp, err := GetTagByName(tag)
+// DisableToken stops sending notifications to the token, e.g. when the provider reported it as invalid.
+// The reason and the time are stored on the subscriber.
+func (n *Notifier) DisableToken(token, reason string) error {
+	subRepo := n.notificationSubscriberRepo
+
+	subscriber, err := subRepo.GetByNotification(token)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var subRepo INotificationSubscriberRepository
-	err = container.Resolve(&subRepo)
+	now := time.Now()
+	subscriber.DisabledAt = &now
+	subscriber.DisabledReason = reason
+	return subRepo.Update(subscriber)
+}
+
+// RefreshToken records the app registered the token again, and enables it when it was disabled.
+// Apps should refresh their tokens periodically, as tokens that aren't refreshed are pruned by PruneStaleTokens.
+func (n *Notifier) RefreshToken(token string) error {
+	subRepo := n.notificationSubscriberRepo
+
+	subscriber, err := subRepo.GetByNotification(token)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var data []NotifierNotificationSubscriber
-	subRepo.GetSubscribersForTag(tmp.ID, data)
-	return data, nil
+
+	subscriber.DisabledAt = nil
+	subscriber.DisabledReason = ""
+	subscriber.UpdatedAt = time.Now()
+	return subRepo.Update(subscriber)
 }
 
-func GetTagAndDriverTokenSubscribers(tag string, driverId uint64) ([]NotifierNotificationSubscriber, error) {
-	tmp, err := GetTagByName(tag)
-	if err != nil {
-		return nil, err
+// PruneStaleTokens removes the tokens that aren't refreshed or changed in the days, with their message logs,
+// and returns how many tokens are removed.
+func (n *Notifier) PruneStaleTokens(days int) (int64, error) {
+	if days <= 0 {
+		return 0, errors.New("days must be positive")
 	}
+	return n.notificationSubscriberRepo.DeleteNotRefreshedSince(time.Now().AddDate(0, 0, -days))
+}
 
-	var subRepo INotificationSubscriberRepository
-	err = container.Resolve(&subRepo)
+func (n *Notifier) GetTagTokenSubscribers(tag string) ([]NotifierNotificationSubscriber, error) {
+	tmp, err := n.GetTagByName(tag)
 	if err != nil {
 		return nil, err
 	}
+
+	subRepo := n.notificationSubscriberRepo
 	var data []NotifierNotificationSubscriber
-	subRepo.GetSubscribersForTagAndDriver(tmp.ID, driverId, data)
+	subRepo.GetSubscribersForTag(tmp.ID, &data)
 	return data, nil
 }
 
-func NotificationDriversList() ([]NotifierNotificationService, error) {
-	var tgRepo INotifierNotificationDriverRepository
-	err := container.Resolve(&tgRepo)
+func (n *Notifier) GetTagAndDriverTokenSubscribers(tag string, driverId uint64) ([]NotifierNotificationSubscriber, error) {
+	tmp, err := n.GetTagByName(tag)
 	if err != nil {
 		return nil, err
 	}
+
+	subRepo := n.notificationSubscriberRepo
+	var data []NotifierNotificationSubscriber
+	subRepo.GetSubscribersForTagAndDriver(tmp.ID, driverId, &data)
+	return data, nil
+}
+
+func (n *Notifier) NotificationDriversList() ([]NotifierNotificationService, error) {
+	tgRepo := n.notificationDriverRepo
 	var data []NotifierNotificationService
 	tgRepo.All(&data)
 	return data, nil
@@ -787,91 +803,696 @@ func NotificationDriversList() ([]NotifierNotificationService, error) {
 
 // Notification subscribe functions #end
 
-// Email Template functions #start
+// Notification campaign functions #start
 
-func CreateEmailTemplate(name, content string) (*NotifierEmailCampaignTemplate, error) {
-	var tmRepo IEmailTemplateRepository
-	err := container.Resolve(&tmRepo)
+func (n *Notifier) CreateNotificationDriver(name, driverType string, payload []byte) (*NotifierNotificationService, error) {
+	driver := NewNotifierNotificationService(string(payload), driverType, name)
+	err := n.notificationDriverRepo.Create(driver)
 	if err != nil {
 		return nil, err
 	}
-	tmp := NewNotifierEmailCampaignTemplate(content, name)
-	err = tmRepo.Create(tmp)
-	if err != nil {
-		return nil, err
-	}
-	return tmp, nil
+	return driver, nil
+}
+
+func (n *Notifier) GetNotificationDriverById(driver uint64) (*NotifierNotificationService, error) {
+	return n.notificationDriverRepo.Get(driver)
 }
 
-func UpdateEmailTemplate(id uint64, name, content string) (*NotifierEmailCampaignTemplate, error) {
-	var tmRepo IEmailTemplateRepository
-	err := container.Resolve(&tmRepo)
+type NotificationCampaignCreateData struct {
+	DriverId    uint64
+	ScheduledAt *time.Time
+	StatusId    uint64
+	Title       string
+	Body        string
+	Image       string
+	Data        map[string]string
+	Name        string
+	Tags        []uint64
+	CampaignId  *uint64 // The multi-channel campaign of the push campaign, if any.
+}
+
+// AddNotificationCampaign stores a push campaign for the tokens of the subscribers of the tags that belong to
+// the notification driver.
+func (n *Notifier) AddNotificationCampaign(data *NotificationCampaignCreateData) (*NotifierNotificationCampaign, error) {
+	_, err := n.notificationDriverRepo.Get(data.DriverId)
 	if err != nil {
 		return nil, err
 	}
-	tmp, err := tmRepo.Get(id)
+
+	cmRepo := n.notificationCampaignRepo
+	tmp := NewNotifierNotificationCampaign(data.DriverId, data.ScheduledAt, data.StatusId, data.Title, data.Body, data.Image, data.Name)
+	tmp.Data = data.Data
+	tmp.CampaignId = data.CampaignId
+	err = cmRepo.Create(tmp)
 	if err != nil {
 		return nil, err
 	}
 
-	tmp.Name = name
-	tmp.Content = content
-	err = tmRepo.Update(tmp)
+	err = cmRepo.AssignTagsToCampaign(tmp.ID, data.Tags)
 	if err != nil {
 		return nil, err
 	}
 	return tmp, nil
 }
 
-func DeleteEmailTemplate(id uint64) error {
-	var tmRepo IEmailTemplateRepository
-	err := container.Resolve(&tmRepo)
+type NotificationCampaignUpdateData struct {
+	DriverId    uint64
+	ScheduledAt *time.Time
+	StatusId    uint64
+	Title       string
+	Body        string
+	Image       string
+	Data        map[string]string
+	Name        string
+	Tags        []uint64
+}
+
+func (n *Notifier) UpdateNotificationCampaignWithId(cmpId uint64, data *NotificationCampaignUpdateData) error {
+	_, err := n.notificationDriverRepo.Get(data.DriverId)
 	if err != nil {
 		return err
 	}
-	err = tmRepo.Delete(&NotifierEmailCampaignTemplate{ID: id})
-	return err
-}
 
-func EmailTemplateList() ([]NotifierEmailCampaignTemplate, error) {
-	var tgRepo IEmailTemplateRepository
-	err := container.Resolve(&tgRepo)
+	cmRepo := n.notificationCampaignRepo
+	campaign, err := cmRepo.Get(cmpId)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var data []NotifierEmailCampaignTemplate
+
+	campaign.DriverId = data.DriverId
+	campaign.ScheduledAt = data.ScheduledAt
+	campaign.StatusId = data.StatusId
+	campaign.Title = data.Title
+	campaign.Body = data.Body
+	campaign.Image = data.Image
+	campaign.Data = data.Data
+	campaign.Name = data.Name
+	campaign.UpdatedAt = time.Now()
+	err = cmRepo.Update(campaign)
+	if err != nil {
+		return err
+	}
+	err = cmRepo.DeleteAllTagsForCampaign(cmpId)
+	if err != nil {
+		return err
+	}
+	return cmRepo.AssignTagsToCampaign(campaign.ID, data.Tags)
+}
+
+func (n *Notifier) DeleteNotificationCampaign(campaign uint64) error {
+	cmRepo := n.notificationCampaignRepo
+
+	tmp, err := cmRepo.Get(campaign)
+	if err != nil {
+		return err
+	}
+	err = cmRepo.DeleteAllTagsForCampaign(tmp.ID)
+	if err != nil {
+		return err
+	}
+	return cmRepo.Delete(tmp)
+}
+
+func (n *Notifier) GetLatestNotificationCampaignForRun() (*NotifierNotificationCampaign, error) {
+	return n.notificationCampaignRepo.GetLatestCampaign()
+}
+
+// UpdateNotificationCampaign stores the campaign and updates the status of its multi-channel campaign.
+func (n *Notifier) UpdateNotificationCampaign(campaign *NotifierNotificationCampaign) error {
+	err := n.notificationCampaignRepo.Update(campaign)
+	if err != nil {
+		return err
+	}
+	return n.syncCampaignStatus(campaign.CampaignId)
+}
+
+func (n *Notifier) GetNotificationCampaignTags(cmpId uint64) []NotifierTag {
+	return n.notificationCampaignRepo.GetCampaignTags(cmpId)
+}
+
+func (n *Notifier) CheckNotificationMessageExists(message *NotifierNotificationMessage) error {
+	err := n.notificationMessageRepo.CheckMessageExists(message)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if message.ID != 0 {
+		return ErrMessageExists
+	}
+
+	return nil
+}
+
+func (n *Notifier) CreateNotificationMessage(message *NotifierNotificationMessage) error {
+	return n.notificationMessageRepo.Create(message)
+}
+
+func (n *Notifier) UpdateNotificationMessage(message *NotifierNotificationMessage) error {
+	return n.notificationMessageRepo.Update(message)
+}
+
+// SendNotificationMessage sends a transactional push notification now. Unlike campaign messages, the message
+// is sent even if the token got a message of the same source before.
+func (n *Notifier) SendNotificationMessage(message *NotifierNotificationMessage) error {
+	if message.SourceType == "" {
+		message.SourceType = NotifierNotificationMessageSourceTransactional
+	}
+	err := n.CreateNotificationMessage(message)
+	if err != nil {
+		return err
+	}
+	return n.deliverPush(message)
+}
+
+// GetNotificationMessageByProviderMessageId returns the message the driver sent by the message ID.
+func (n *Notifier) GetNotificationMessageByProviderMessageId(providerMessageId string) (*NotifierNotificationMessage, error) {
+	return n.notificationMessageRepo.GetByProviderMessageId(providerMessageId)
+}
+
+// Notification campaign functions #end
+
+// Campaign functions #start
+
+// CampaignChannels are the campaigns of the channels of a multi-channel campaign.
+type CampaignChannels struct {
+	Email []NotifierEmailCampaign
+	Sms   []NotifierMobileCampaign
+	Push  []NotifierNotificationCampaign
+}
+
+// CampaignEmailData is the content and the email service of the email channel of a campaign.
+type CampaignEmailData struct {
+	EmailServiceId  uint64
+	TemplateId      uint64
+	TemplateVersion uint // The version of the template, or 0 for the latest one.
+	FromEmail       string
+	FromName        string
+	Subject         string
+	Variables       map[string]string
+}
+
+// CampaignSmsData is the message and the mobile driver of the SMS channel of a campaign.
+type CampaignSmsData struct {
+	DriverId  uint64
+	Sender    string
+	Message   string
+	Variables map[string]string
+}
+
+// CampaignPushData is the notification and the notification driver of the push channel of a campaign.
+type CampaignPushData struct {
+	DriverId uint64
+	Title    string
+	Body     string
+	Image    string
+	Data     map[string]string
+}
+
+// CampaignCreateData defines a campaign for the subscribers of the tags on every channel that isn't nil.
+type CampaignCreateData struct {
+	ScheduledAt *time.Time
+	StatusId    uint64 // Defaults to NotifierEmailStatusDraft.
+	Name        string
+	Tags        []uint64
+	Email       *CampaignEmailData
+	Sms         *CampaignSmsData
+	Push        *CampaignPushData
+}
+
+// AddCampaign stores a multi-channel campaign with a campaign of every channel of the data, which share the
+// name, the schedule and the tags. The channel campaigns are sent by the worker of their channel.
+// Channels are stored as queued and get the status of the data together, so workers don't send a channel
+// while another one can still fail, and nothing is stored when a channel is invalid.
+func (n *Notifier) AddCampaign(data *CampaignCreateData) (*NotifierCampaign, error) {
+	if data.Email == nil && data.Sms == nil && data.Push == nil {
+		return nil, errors.New("campaign has no channel")
+	}
+	if len(data.Tags) == 0 {
+		return nil, errors.New("tags id is empty")
+	}
+
+	campaign := NewNotifierCampaign(data.ScheduledAt, NotifierEmailStatusQueued, data.Name)
+	err := n.campaignRepo.Create(campaign)
+	if err != nil {
+		return nil, err
+	}
+	err = n.addCampaignChannels(campaign, data)
+	if err != nil {
+		_ = n.DeleteCampaign(campaign.ID)
+		return nil, err
+	}
+
+	status := data.StatusId
+	if status == 0 {
+		status = NotifierEmailStatusDraft
+	}
+	err = n.SetCampaignStatus(campaign.ID, status)
+	if err != nil {
+		return nil, err
+	}
+	return n.campaignRepo.Get(campaign.ID)
+}
+
+func (n *Notifier) addCampaignChannels(campaign *NotifierCampaign, data *CampaignCreateData) error {
+	if data.Email != nil {
+		_, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+			EmailServiceId:  data.Email.EmailServiceId,
+			ScheduledAt:     data.ScheduledAt,
+			TemplateId:      data.Email.TemplateId,
+			TemplateVersion: data.Email.TemplateVersion,
+			StatusId:        NotifierEmailStatusQueued,
+			FromEmail:       data.Email.FromEmail,
+			FromName:        data.Email.FromName,
+			Subject:         data.Email.Subject,
+			Name:            data.Name,
+			Tags:            data.Tags,
+			Variables:       data.Email.Variables,
+			CampaignId:      &campaign.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if data.Sms != nil {
+		_, err := n.AddMobileCampaign(&MobileCampaignCreateData{
+			DriverId:    data.Sms.DriverId,
+			ScheduledAt: data.ScheduledAt,
+			StatusId:    NotifierEmailStatusQueued,
+			Sender:      data.Sms.Sender,
+			Message:     data.Sms.Message,
+			Name:        data.Name,
+			Tags:        data.Tags,
+			Variables:   data.Sms.Variables,
+			CampaignId:  &campaign.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if data.Push != nil {
+		_, err := n.AddNotificationCampaign(&NotificationCampaignCreateData{
+			DriverId:    data.Push.DriverId,
+			ScheduledAt: data.ScheduledAt,
+			StatusId:    NotifierEmailStatusQueued,
+			Title:       data.Push.Title,
+			Body:        data.Push.Body,
+			Image:       data.Push.Image,
+			Data:        data.Push.Data,
+			Name:        data.Name,
+			Tags:        data.Tags,
+			CampaignId:  &campaign.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) GetCampaign(cmpId uint64) (*NotifierCampaign, error) {
+	return n.campaignRepo.Get(cmpId)
+}
+
+func (n *Notifier) GetCampaignChannels(cmpId uint64) (*CampaignChannels, error) {
+	_, err := n.campaignRepo.Get(cmpId)
+	if err != nil {
+		return nil, err
+	}
+	channels := &CampaignChannels{}
+	err = n.campaignRepo.GetChannels(cmpId, channels)
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// SetCampaignStatus sets the status of every channel that isn't sending or done yet, e.g.
+// NotifierEmailStatusCanceled to cancel a scheduled campaign, and updates the status of the campaign.
+func (n *Notifier) SetCampaignStatus(cmpId uint64, statusId uint64) error {
+	channels, err := n.GetCampaignChannels(cmpId)
+	if err != nil {
+		return err
+	}
+	pending := func(status uint64) bool {
+		return status == NotifierEmailStatusDraft || status == NotifierEmailStatusQueued
+	}
+	for i := range channels.Email {
+		if pending(channels.Email[i].StatusId) {
+			channels.Email[i].StatusId = statusId
+			err = n.emailCampaignRepo.Update(&channels.Email[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i := range channels.Sms {
+		if pending(channels.Sms[i].StatusId) {
+			channels.Sms[i].StatusId = statusId
+			err = n.mobileCampaignRepo.Update(&channels.Sms[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i := range channels.Push {
+		if pending(channels.Push[i].StatusId) {
+			channels.Push[i].StatusId = statusId
+			err = n.notificationCampaignRepo.Update(&channels.Push[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return n.syncCampaignStatus(&cmpId)
+}
+
+// DeleteCampaign deletes the campaign with the campaigns of its channels.
+func (n *Notifier) DeleteCampaign(cmpId uint64) error {
+	campaign, err := n.campaignRepo.Get(cmpId)
+	if err != nil {
+		return err
+	}
+	channels := &CampaignChannels{}
+	err = n.campaignRepo.GetChannels(cmpId, channels)
+	if err != nil {
+		return err
+	}
+	for _, email := range channels.Email {
+		err = n.DeleteEmailCampaign(email.ID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, sms := range channels.Sms {
+		err = n.DeleteMobileCampaign(sms.ID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, push := range channels.Push {
+		err = n.DeleteNotificationCampaign(push.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return n.campaignRepo.Delete(campaign)
+}
+
+func (n *Notifier) CampaignList() ([]NotifierCampaign, error) {
+	var data []NotifierCampaign
+	n.campaignRepo.All(&data)
+	return data, nil
+}
+
+// syncCampaignStatus sets the status of the multi-channel campaign of the statuses of its channels.
+func (n *Notifier) syncCampaignStatus(cmpId *uint64) error {
+	if cmpId == nil {
+		return nil
+	}
+	campaign, err := n.campaignRepo.Get(*cmpId)
+	if err != nil {
+		return err
+	}
+	statuses, err := n.campaignRepo.GetChannelStatuses(campaign.ID)
+	if err != nil {
+		return err
+	}
+	status := CampaignStatus(statuses)
+	if status == campaign.StatusId {
+		return nil
+	}
+	campaign.StatusId = status
+	return n.campaignRepo.Update(campaign)
+}
+
+// Campaign functions #end
+
+// Job functions #start
+
+// EnqueueJob enqueues a job of the type with the JSON of payload, run by the JobHandler of the type.
+// See RegisterJobHandler.
+func (n *Notifier) EnqueueJob(jobType string, payload any) error {
+	message, err := NewQueueMessage(jobType, payload)
+	if err != nil {
+		return err
+	}
+	return n.jobQueue().Enqueue(*message)
+}
+
+// RunJobs reserves at most limit available jobs for consumer, leased for lease, and runs them until no job is
+// available or the context of n is done, see WithContext. It returns the count of the run jobs. See JobWorker.
+func (n *Notifier) RunJobs(consumer string, limit int, lease time.Duration) (int, error) {
+	return n.runJobs(n.Context(), consumer, limit, 1, lease)
+}
+
+// PruneFinishedJobs removes the done, failed and dead jobs of the jobs table finished more than days ago, and
+// returns how many jobs are removed. Their unique keys can be enqueued again.
+func (n *Notifier) PruneFinishedJobs(days int) (int64, error) {
+	if days <= 0 {
+		return 0, errors.New("days must be positive")
+	}
+	return n.jobRepo.DeleteFinishedBefore(time.Now().AddDate(0, 0, -days))
+}
+
+// Job functions #end
+
+// Email Template functions #start
+
+// CreateEmailTemplate stores a template as its version 1. The content is a Go template rendered for every
+// subscriber, see EmailTemplateData, and a TemplateError is returned when it's invalid.
+func (n *Notifier) CreateEmailTemplate(name, content string) (*NotifierEmailCampaignTemplate, error) {
+	return n.createEmailTemplate(name, content, nil)
+}
+
+// CreateEmailTemplateWithLayout is CreateEmailTemplate for a template rendered in a layout.
+func (n *Notifier) CreateEmailTemplateWithLayout(name, content string, layoutId uint64) (*NotifierEmailCampaignTemplate, error) {
+	return n.createEmailTemplate(name, content, &layoutId)
+}
+
+func (n *Notifier) createEmailTemplate(name, content string, layoutId *uint64) (*NotifierEmailCampaignTemplate, error) {
+	err := n.validateEmailTemplate(content, layoutId)
+	if err != nil {
+		return nil, err
+	}
+	tmRepo := n.emailTemplateRepo
+	tmp := NewNotifierEmailCampaignTemplate(content, name)
+	tmp.LayoutId = layoutId
+	err = tmRepo.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = n.emailTemplateVersionRepo.Create(NewNotifierEmailTemplateVersion(tmp))
+	if err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// UpdateEmailTemplate stores the name and the content as a new version of the template.
+// Campaigns keep the version they were created with.
+func (n *Notifier) UpdateEmailTemplate(id uint64, name, content string) (*NotifierEmailCampaignTemplate, error) {
+	tmRepo := n.emailTemplateRepo
+	tmp, err := tmRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	err = n.validateEmailTemplate(content, tmp.LayoutId)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp.Name = name
+	tmp.Content = content
+	return tmp, n.saveEmailTemplateVersion(tmp)
+}
+
+// UpdateEmailTemplateLayout stores a new version of the template in the layout, or without a layout when
+// layoutId is nil.
+func (n *Notifier) UpdateEmailTemplateLayout(id uint64, layoutId *uint64) (*NotifierEmailCampaignTemplate, error) {
+	tmRepo := n.emailTemplateRepo
+	tmp, err := tmRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	err = n.validateEmailTemplate(tmp.Content, layoutId)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp.LayoutId = layoutId
+	return tmp, n.saveEmailTemplateVersion(tmp)
+}
+
+// RollbackEmailTemplate stores a copy of an old version as a new version of the template.
+func (n *Notifier) RollbackEmailTemplate(id uint64, version uint) (*NotifierEmailCampaignTemplate, error) {
+	tmRepo := n.emailTemplateRepo
+	tmp, err := tmRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	old, err := n.emailTemplateVersionRepo.GetVersion(id, version)
+	if err != nil {
+		return nil, err
+	}
+	err = n.validateEmailTemplate(old.Content, old.LayoutId)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp.Name = old.Name
+	tmp.Content = old.Content
+	tmp.LayoutId = old.LayoutId
+	return tmp, n.saveEmailTemplateVersion(tmp)
+}
+
+// GetEmailTemplateVersions returns the versions of a template from the first one.
+func (n *Notifier) GetEmailTemplateVersions(id uint64) ([]NotifierEmailTemplateVersion, error) {
+	_, err := n.emailTemplateRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return n.emailTemplateVersionRepo.GetVersions(id), nil
+}
+
+func (n *Notifier) GetEmailTemplateVersion(id uint64, version uint) (*NotifierEmailTemplateVersion, error) {
+	return n.emailTemplateVersionRepo.GetVersion(id, version)
+}
+
+// DiffEmailTemplateVersions returns the line diff of the contents of two versions of a template.
+func (n *Notifier) DiffEmailTemplateVersions(id uint64, from, to uint) (TemplateDiff, error) {
+	versionRepo := n.emailTemplateVersionRepo
+	fromVersion, err := versionRepo.GetVersion(id, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := versionRepo.GetVersion(id, to)
+	if err != nil {
+		return nil, err
+	}
+	return DiffTemplates(fromVersion.Content, toVersion.Content), nil
+}
+
+// PreviewEmailTemplate renders the latest version of a template, in its layout with the partials, for the subscriber
+// of the email.
+func (n *Notifier) PreviewEmailTemplate(templateId uint64, subscriberEmail string) (string, error) {
+	subscriber, err := n.emailSubscriberRepo.GetByEmailWithTags(subscriberEmail)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", NotFoundError{}
+	}
+	if err != nil {
+		return "", err
+	}
+	return n.PreviewEmailTemplateWithData(templateId, NewEmailTemplateData(subscriber, nil))
+}
+
+// PreviewEmailTemplateWithData is PreviewEmailTemplate for sample data instead of a subscriber.
+func (n *Notifier) PreviewEmailTemplateWithData(templateId uint64, data EmailTemplateData) (string, error) {
+	tmp, err := n.emailTemplateRepo.Get(templateId)
+	if err != nil {
+		return "", err
+	}
+	content, err := n.composeEmailTemplate(tmp.Content, tmp.LayoutId)
+	if err != nil {
+		return "", err
+	}
+	return RenderEmailContent(content, data)
+}
+
+// saveEmailTemplateVersion bumps the version of the template and stores it with its new version.
+func (n *Notifier) saveEmailTemplateVersion(tmp *NotifierEmailCampaignTemplate) error {
+	tmp.Version++
+	tmp.UpdatedAt = time.Now()
+	err := n.emailTemplateRepo.Update(tmp)
+	if err != nil {
+		return err
+	}
+	return n.emailTemplateVersionRepo.Create(NewNotifierEmailTemplateVersion(tmp))
+}
+
+// validateEmailTemplate validates the content in the layout with the partials.
+func (n *Notifier) validateEmailTemplate(content string, layoutId *uint64) error {
+	composed, err := n.composeEmailTemplate(content, layoutId)
+	if err != nil {
+		return err
+	}
+	return ValidateEmailContent(composed)
+}
+
+// composeEmailTemplate returns the content in the layout with the current partials.
+// A campaign stores the result, so later changes of the layout and the partials don't change it.
+func (n *Notifier) composeEmailTemplate(content string, layoutId *uint64) (string, error) {
+	var layout *NotifierEmailLayout
+	if layoutId != nil {
+		var err error
+		layout, err = n.emailLayoutRepo.Get(*layoutId)
+		if err != nil {
+			return "", err
+		}
+	}
+	var partials []NotifierEmailPartial
+	n.emailPartialRepo.All(&partials)
+	return ComposeEmailTemplate(content, layout, partials), nil
+}
+
+// emailTemplateVersion returns a version of the template, or its latest version when version is 0.
+func (n *Notifier) emailTemplateVersion(tmp *NotifierEmailCampaignTemplate, version uint) (*NotifierEmailTemplateVersion, error) {
+	if version == 0 {
+		version = tmp.Version
+	}
+	v, err := n.emailTemplateVersionRepo.GetVersion(tmp.ID, version)
+	if errors.As(err, &NotFoundError{}) && version == tmp.Version {
+		// A template stored without the handlers has no version rows
+		return NewNotifierEmailTemplateVersion(tmp), nil
+	}
+	return v, err
+}
+
+func (n *Notifier) DeleteEmailTemplate(id uint64) error {
+	tmRepo := n.emailTemplateRepo
+	err := tmRepo.Delete(&NotifierEmailCampaignTemplate{ID: id})
+	return err
+}
+
+func (n *Notifier) EmailTemplateList() ([]NotifierEmailCampaignTemplate, error) {
+	tgRepo := n.emailTemplateRepo
+	var data []NotifierEmailCampaignTemplate
 	tgRepo.All(&data)
 	return data, nil
 }
 
 type EmailCampaignCreateData struct {
-	EmailServiceId uint64
-	ScheduledAt    *time.Time
-	TemplateId     uint64
-	StatusId       uint64
-	FromEmail      string
-	FromName       string
-	Subject        string
-	Name           string
-	Tags           []uint64
+	EmailServiceId  uint64
+	ScheduledAt     *time.Time
+	TemplateId      uint64
+	TemplateVersion uint // The version of the template, or 0 for the latest one.
+	StatusId        uint64
+	FromEmail       string
+	FromName        string
+	Subject         string
+	Name            string
+	Tags            []uint64
+	Variables       map[string]string
+	CampaignId      *uint64 // The multi-channel campaign of the email campaign, if any.
 }
 
-func AddEmailCampaign(data *EmailCampaignCreateData) (*NotifierEmailCampaign, error) {
-	var tmRepo IEmailTemplateRepository
-	err := container.Resolve(&tmRepo)
+func (n *Notifier) AddEmailCampaign(data *EmailCampaignCreateData) (*NotifierEmailCampaign, error) {
+	err := ValidateEmailSubject(data.Subject)
 	if err != nil {
 		return nil, err
 	}
+	tmRepo := n.emailTemplateRepo
 	temp, err := tmRepo.Get(data.TemplateId)
 	if err != nil {
 		return nil, err
 	}
-
-	var cmRepo IEmailCampaignRepository
-	err = container.Resolve(&cmRepo)
+	version, content, err := n.emailCampaignContent(temp, data.TemplateVersion)
 	if err != nil {
 		return nil, err
 	}
+
+	cmRepo := n.emailCampaignRepo
 	tmp := NewNotifierEmailCampaign(
 		data.EmailServiceId,
 		data.ScheduledAt,
@@ -880,9 +1501,12 @@ func AddEmailCampaign(data *EmailCampaignCreateData) (*NotifierEmailCampaign, er
 		data.FromEmail,
 		data.FromName,
 		data.Subject,
-		temp.Content,
+		content,
 		data.Name,
 	)
+	tmp.Variables = data.Variables
+	tmp.TemplateVersion = version
+	tmp.CampaignId = data.CampaignId
 	err = cmRepo.Create(tmp)
 	if err != nil {
 		return nil, err
@@ -895,18 +1519,32 @@ func AddEmailCampaign(data *EmailCampaignCreateData) (*NotifierEmailCampaign, er
 	return tmp, nil
 }
 
-func DeleteEmailCampaign(campaign uint64) error {
-	var cmRepo IEmailCampaignRepository
-	err := container.Resolve(&cmRepo)
+// emailCampaignContent returns the number and the composed content of a version of the template,
+// or of its latest version when version is 0.
+func (n *Notifier) emailCampaignContent(temp *NotifierEmailCampaignTemplate, version uint) (uint, string, error) {
+	v, err := n.emailTemplateVersion(temp, version)
 	if err != nil {
-		return err
+		return 0, "", err
+	}
+	content, err := n.composeEmailTemplate(v.Content, v.LayoutId)
+	if err != nil {
+		return 0, "", err
+	}
+	err = ValidateEmailContent(content)
+	if err != nil {
+		return 0, "", err
 	}
+	return v.Version, content, nil
+}
+
+func (n *Notifier) DeleteEmailCampaign(campaign uint64) error {
+	cmRepo := n.emailCampaignRepo
 
 	tmp, err := cmRepo.Get(campaign)
 	if err != nil {
 		return err
 	}
-	_ = DetachTagsForCampaign(tmp.ID)
+	_ = n.DetachTagsForCampaign(tmp.ID)
 	err = cmRepo.Delete(tmp)
 	if err != nil {
 		return err
@@ -915,33 +1553,35 @@ func DeleteEmailCampaign(campaign uint64) error {
 }
 
 type EmailCampaignUpdateData struct {
-	EmailServiceId uint64
-	ScheduledAt    *time.Time
-	TemplateId     uint64
-	StatusId       uint64
-	FromEmail      string
-	FromName       string
-	Subject        string
-	Name           string
-	Tags           []uint64
+	EmailServiceId  uint64
+	ScheduledAt     *time.Time
+	TemplateId      uint64
+	TemplateVersion uint // The version of the template, or 0 for the latest one.
+	StatusId        uint64
+	FromEmail       string
+	FromName        string
+	Subject         string
+	Name            string
+	Tags            []uint64
+	Variables       map[string]string
 }
 
-func UpdateEmailCampaignWithId(cmpId uint64, data *EmailCampaignUpdateData) error {
-	var tmRepo IEmailTemplateRepository
-	err := container.Resolve(&tmRepo)
+func (n *Notifier) UpdateEmailCampaignWithId(cmpId uint64, data *EmailCampaignUpdateData) error {
+	err := ValidateEmailSubject(data.Subject)
 	if err != nil {
 		return err
 	}
+	tmRepo := n.emailTemplateRepo
 	temp, err := tmRepo.Get(data.TemplateId)
 	if err != nil {
 		return err
 	}
-
-	var cmRepo IEmailCampaignRepository
-	err = container.Resolve(&cmRepo)
+	version, content, err := n.emailCampaignContent(temp, data.TemplateVersion)
 	if err != nil {
 		return err
 	}
+
+	cmRepo := n.emailCampaignRepo
 	campaign, err := cmRepo.Get(cmpId)
 	if err != nil {
 		return err
@@ -954,8 +1594,10 @@ func UpdateEmailCampaignWithId(cmpId uint64, data *EmailCampaignUpdateData) erro
 	campaign.TemplateId = temp.ID
 	campaign.EmailServiceId = data.EmailServiceId
 	campaign.Subject = data.Subject
-	campaign.Content = temp.Content
+	campaign.Content = content
+	campaign.TemplateVersion = version
 	campaign.Name = data.Name
+	campaign.Variables = data.Variables
 	campaign.UpdatedAt = time.Now()
 	err = cmRepo.Update(campaign)
 	if err != nil {
@@ -972,128 +1614,429 @@ func UpdateEmailCampaignWithId(cmpId uint64, data *EmailCampaignUpdateData) erro
 	return err
 }
 
-func GetLatestCampaignForRun() (*NotifierEmailCampaign, error) {
-	var campaignRepo IEmailCampaignRepository
-	err := container.Resolve(&campaignRepo)
+// SendTestEmailCampaign sends the campaign, with its attachments, to the recipients by its email service.
+// A recipient that is a subscriber gets the campaign rendered for them, any other one gets it rendered for
+// its email only. No message is stored and the campaign status isn't changed. Recipients that fail don't stop
+// the others, and their errors are returned joined.
+func (n *Notifier) SendTestEmailCampaign(campaignId uint64, recipients []string) error {
+	if len(recipients) == 0 {
+		return errors.New("no recipients for the test email")
+	}
+	campaign, err := n.emailCampaignRepo.Get(campaignId)
 	if err != nil {
-		log.Fatalf("Error during resolve : %s", err)
+		return err
 	}
-	campaign, err := campaignRepo.GetLatestCampaign()
+	service, err := n.GetEmailServiceById(campaign.EmailServiceId)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return campaign, err
+
+	var errs []error
+	for _, recipient := range recipients {
+		err = n.sendTestEmail(campaign, service, recipient)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("send test email to %s : %w", recipient, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func UpdateEmailCampaign(campaign *NotifierEmailCampaign) error {
-	var campaignRepo IEmailCampaignRepository
-	err := container.Resolve(&campaignRepo)
+func (n *Notifier) sendTestEmail(campaign *NotifierEmailCampaign, service *NotifierEmailService, recipient string) error {
+	subscriber, err := n.emailSubscriberRepo.GetByEmailWithTags(recipient)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		subscriber, err = &NotifierEmailSubscriber{Email: recipient}, nil
+	}
+	if err != nil {
+		return err
+	}
+	data := NewEmailTemplateData(subscriber, campaign.Variables)
+	subject, err := RenderEmailSubject(campaign.Subject, data)
+	if err != nil {
+		return err
+	}
+	content, err := RenderEmailContent(campaign.Content, data)
 	if err != nil {
-		log.Fatalf("Error during resolve : %s", err)
+		return err
 	}
-	return campaignRepo.Update(campaign)
+	message := NewNotifierEmailMessage(
+		recipient,
+		subscriber.ID,
+		NotifierEmailMessageSourceCampaign,
+		campaign.FromEmail,
+		campaign.ID,
+		campaign.FromName,
+		subject,
+		campaign.EmailServiceId,
+		content,
+	)
+	_, err = n.handleMail(service, message, nil)
+	return err
 }
 
-func GetEmailCampaignTags(cmpId uint64) []NotifierTag {
-	var campaignRepo IEmailCampaignRepository
-	err := container.Resolve(&campaignRepo)
+func (n *Notifier) GetLatestCampaignForRun() (*NotifierEmailCampaign, error) {
+	campaignRepo := n.emailCampaignRepo
+	campaign, err := campaignRepo.GetLatestCampaign()
 	if err != nil {
-		log.Fatalf("Error during resolve : %s", err)
+		return nil, err
 	}
-	return campaignRepo.GetCampaignTags(cmpId)
+	return campaign, err
 }
 
-func CheckEmailMessageExists(message *NotifierEmailMessage) error {
-	var messageRepo IEmailMessageRepository
-	err := container.Resolve(&messageRepo)
+// UpdateEmailCampaign stores the campaign and updates the status of its multi-channel campaign.
+func (n *Notifier) UpdateEmailCampaign(campaign *NotifierEmailCampaign) error {
+	campaignRepo := n.emailCampaignRepo
+	err := campaignRepo.Update(campaign)
 	if err != nil {
 		return err
 	}
-	err = messageRepo.CheckMessageExists(message)
-	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+	return n.syncCampaignStatus(campaign.CampaignId)
+}
+
+func (n *Notifier) GetEmailCampaignTags(cmpId uint64) []NotifierTag {
+	campaignRepo := n.emailCampaignRepo
+	return campaignRepo.GetCampaignTags(cmpId)
+}
+
+func (n *Notifier) CheckEmailMessageExists(message *NotifierEmailMessage) error {
+	messageRepo := n.emailMessageRepo
+	err := messageRepo.CheckMessageExists(message)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil
 	}
-	if err != nil || message.ID != 0 {
-		return errors.New("record found")
+	if err != nil {
+		return err
+	}
+	if message.ID != 0 {
+		return ErrMessageExists
 	}
 
 	return nil
 }
 
-func CreateEmailMessage(message *NotifierEmailMessage) error {
-	var messageRepo IEmailMessageRepository
-	err := container.Resolve(&messageRepo)
-	if err != nil {
-		return err
-	}
-	err = messageRepo.Create(message)
+func (n *Notifier) CreateEmailMessage(message *NotifierEmailMessage) error {
+	messageRepo := n.emailMessageRepo
+	err := messageRepo.Create(message)
 	return err
 }
 
-func CreateEmailService(name, serviceType string, payload []byte) (*NotifierEmailService, error) {
-	var emailServiceRepo IEmailServiceRepository
-	err := container.Resolve(&emailServiceRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) CreateEmailService(name, serviceType string, payload []byte) (*NotifierEmailService, error) {
+	emailServiceRepo := n.emailServiceRepo
 	service := &NotifierEmailService{
 		Payload: string(payload),
 		Type:    serviceType,
 		Name:    name,
 	}
 
-	err = emailServiceRepo.Create(service)
+	err := emailServiceRepo.Create(service)
 	if err != nil {
 		return nil, err
 	}
 	return service, nil
 }
 
-func GetEmailServices() ([]NotifierEmailService, error) {
-	var emailServiceRepo IEmailServiceRepository
-	err := container.Resolve(&emailServiceRepo)
+// SetEmailServiceRateLimit sets how many mails the service sends per second and per day, zero is unlimited.
+// Sends wait for the per second limit; once the daily limit is reached, campaigns of the service wait and
+// their jobs are delayed. The per second limit is kept by every process, so a limit shared by several
+// processes is divided between them.
+func (n *Notifier) SetEmailServiceRateLimit(serviceId uint64, perSecond, perDay uint) (*NotifierEmailService, error) {
+	service, err := n.emailServiceRepo.Get(serviceId)
 	if err != nil {
 		return nil, err
 	}
+	service.RateLimitPerSecond = perSecond
+	service.RateLimitPerDay = perDay
+	err = n.emailServiceRepo.Update(service)
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func (n *Notifier) GetEmailServices() ([]NotifierEmailService, error) {
+	emailServiceRepo := n.emailServiceRepo
 	var data []NotifierEmailService
 	emailServiceRepo.All(&data)
 	return data, nil
 }
 
-func GetEmailServiceById(service uint64) (*NotifierEmailService, error) {
-	var emailServiceRepo IEmailServiceRepository
-	err := container.Resolve(&emailServiceRepo)
-	if err != nil {
-		return nil, err
-	}
+func (n *Notifier) GetEmailServiceById(service uint64) (*NotifierEmailService, error) {
+	emailServiceRepo := n.emailServiceRepo
 
 	tmp, err := emailServiceRepo.Get(service)
 	return tmp, err
 }
 
-func UpdateEmailMessage(message *NotifierEmailMessage) error {
-	var messageRepo IEmailMessageRepository
-	err := container.Resolve(&messageRepo)
+func (n *Notifier) UpdateEmailMessage(message *NotifierEmailMessage) error {
+	messageRepo := n.emailMessageRepo
+	return messageRepo.Update(message)
+}
+
+func (n *Notifier) DetachTagsForCampaign(campaign uint64) error {
+	cmRepo := n.emailCampaignRepo
+
+	err := cmRepo.DeleteAllTagsForCampaign(campaign)
 	if err != nil {
 		return err
 	}
-	err = messageRepo.Update(message)
-
 	return nil
 }
 
-func DetachTagsForCampaign(campaign uint64) error {
-	var cmRepo IEmailCampaignRepository
-	err := container.Resolve(&cmRepo)
+func (n *Notifier) AttachToEmailCampaign(campaignId uint64, attachment *NotifierEmailAttachment) error {
+	_, err := n.emailCampaignRepo.Get(campaignId)
 	if err != nil {
 		return err
 	}
+	attachment.CampaignId = &campaignId
+	return n.emailAttachmentRepo.Create(attachment)
+}
 
-	err = cmRepo.DeleteAllTagsForCampaign(campaign)
+func (n *Notifier) AttachToEmailTemplate(templateId uint64, attachment *NotifierEmailAttachment) error {
+	_, err := n.emailTemplateRepo.Get(templateId)
 	if err != nil {
 		return err
 	}
-	return nil
+	attachment.TemplateId = &templateId
+	return n.emailAttachmentRepo.Create(attachment)
+}
+
+func (n *Notifier) GetEmailCampaignAttachments(campaignId uint64) ([]NotifierEmailAttachment, error) {
+	return n.emailAttachmentRepo.GetByCampaignId(campaignId), nil
+}
+
+func (n *Notifier) GetEmailTemplateAttachments(templateId uint64) ([]NotifierEmailAttachment, error) {
+	return n.emailAttachmentRepo.GetByTemplateId(templateId), nil
+}
+
+func (n *Notifier) DeleteEmailAttachment(id uint64) error {
+	return n.emailAttachmentRepo.Delete(&NotifierEmailAttachment{ID: id})
+}
+
+// SendEmailMessage sends a transactional mail now, with its own attachments. Unlike campaign mails, the message
+// is sent even if the subscriber got a message of the same source before.
+func (n *Notifier) SendEmailMessage(message *NotifierEmailMessage, attachments ...*NotifierEmailAttachment) error {
+	return n.SendEmailEnvelope(message, nil, attachments...)
+}
+
+// SendEmailEnvelope sends a transactional mail like SendEmailMessage, with the Cc, Bcc, Reply-To, headers and tags
+// of the envelope. The addresses, the subject and the message of the envelope are taken from the message.
+func (n *Notifier) SendEmailEnvelope(message *NotifierEmailMessage, envelope *EmailEnvelope, attachments ...*NotifierEmailAttachment) error {
+	if message.SourceType == "" {
+		message.SourceType = NotifierEmailMessageSourceTransactional
+	}
+	message.Envelope = envelope.stored()
+	err := n.CreateEmailMessage(message)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		attachment.MessageId = &message.ID
+		err = n.emailAttachmentRepo.Create(attachment)
+		if err != nil {
+			return err
+		}
+	}
+	err = n.deliverEmail(message, envelope)
+	retryAfter, ok := sendRetryAfter(err)
+	if ok {
+		// The error is still returned, so the caller knows the mail isn't sent yet. A send stopped by the
+		// context of n is enqueued without it, as the context is done.
+		queue := n
+		if n.Context().Err() != nil {
+			queue = n.WithContext(context.Background())
+		}
+		er := queue.enqueueEmailDelivery(message, envelope, time.Now().Add(retryAfter))
+		if er != nil {
+			return er
+		}
+	}
+	return err
+}
+
+// sendRetryAfter returns when a send or a job that failed by err is run again: after the delay of a retried or
+// rate limited send, or right away for one stopped by its context. It reports false for the other errors.
+func sendRetryAfter(err error) (time.Duration, bool) {
+	var retry RetryError
+	var limited RateLimitedError
+	switch {
+	case errors.As(err, &retry):
+		return retry.RetryAfter, true
+	case errors.As(err, &limited):
+		return limited.RetryAfter, true
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return 0, true
+	}
+	return 0, false
+}
+
+// GetDeadEmailMessages returns the messages that failed for good, by a permanent error or out of attempts,
+// and aren't discarded.
+func (n *Notifier) GetDeadEmailMessages() ([]NotifierEmailMessage, error) {
+	return n.emailMessageRepo.GetDeadMessages()
+}
+
+// RequeueEmailMessage sends a dead message again by the job queue, with the attempts of the retry policy.
+// A transactional message is sent with the Cc, Bcc, Reply-To, headers and tags of its envelope.
+func (n *Notifier) RequeueEmailMessage(id uint64) error {
+	message, err := n.deadEmailMessage(id)
+	if err != nil {
+		return err
+	}
+	message.FailedAt = nil
+	message.Attempts = 0
+	err = n.UpdateEmailMessage(message)
+	if err != nil {
+		return err
+	}
+	return n.enqueueEmailDelivery(message, message.Envelope, time.Now())
+}
+
+// DiscardEmailMessage removes a dead message from GetDeadEmailMessages, it's kept failed.
+func (n *Notifier) DiscardEmailMessage(id uint64) error {
+	message, err := n.deadEmailMessage(id)
+	if err != nil {
+		return err
+	}
+	t := time.Now()
+	message.DiscardedAt = &t
+	return n.UpdateEmailMessage(message)
+}
+
+// deadEmailMessage returns the message of id, or NotFoundError when it isn't dead.
+func (n *Notifier) deadEmailMessage(id uint64) (*NotifierEmailMessage, error) {
+	message, err := n.emailMessageRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if message.FailedAt == nil || message.DiscardedAt != nil {
+		return nil, NotFoundError{}
+	}
+	return message, nil
+}
+
+// GetEmailMessageByProviderMessageId returns the message the provider sent by the message ID, e.g. for a bounce.
+func (n *Notifier) GetEmailMessageByProviderMessageId(providerMessageId string) (*NotifierEmailMessage, error) {
+	return n.emailMessageRepo.GetByProviderMessageId(providerMessageId)
+}
+
+// CreateEmailLayout stores a layout. The content renders the template by {{template "body" .}}.
+func (n *Notifier) CreateEmailLayout(name, content string) (*NotifierEmailLayout, error) {
+	err := n.validateEmailLayout(content)
+	if err != nil {
+		return nil, err
+	}
+	tmp := NewNotifierEmailLayout(name, content)
+	err = n.emailLayoutRepo.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// UpdateEmailLayout changes a layout. Templates use the change from their next campaign, campaigns
+// already created keep the layout they were created with.
+func (n *Notifier) UpdateEmailLayout(id uint64, name, content string) (*NotifierEmailLayout, error) {
+	err := n.validateEmailLayout(content)
+	if err != nil {
+		return nil, err
+	}
+	layoutRepo := n.emailLayoutRepo
+	tmp, err := layoutRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp.Name = name
+	tmp.Content = content
+	tmp.UpdatedAt = time.Now()
+	err = layoutRepo.Update(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// DeleteEmailLayout deletes a layout. Templates and versions in the layout are left without a layout.
+func (n *Notifier) DeleteEmailLayout(id uint64) error {
+	return n.emailLayoutRepo.Delete(&NotifierEmailLayout{ID: id})
+}
+
+func (n *Notifier) EmailLayoutList() ([]NotifierEmailLayout, error) {
+	var data []NotifierEmailLayout
+	n.emailLayoutRepo.All(&data)
+	return data, nil
+}
+
+func (n *Notifier) validateEmailLayout(content string) error {
+	err := ValidateEmailLayout(content)
+	if err != nil {
+		return err
+	}
+	var partials []NotifierEmailPartial
+	n.emailPartialRepo.All(&partials)
+	return ValidateEmailContent(ComposeEmailTemplate("", &NotifierEmailLayout{Content: content}, partials))
+}
+
+// CreateEmailPartial stores a partial that templates and layouts render by {{template "<name>" .}}.
+// The name has letters, digits, ".", "_" and "-" only.
+func (n *Notifier) CreateEmailPartial(name, content string) (*NotifierEmailPartial, error) {
+	tmp := NewNotifierEmailPartial(name, content)
+	err := n.validateEmailPartial(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = n.emailPartialRepo.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+func (n *Notifier) UpdateEmailPartial(id uint64, name, content string) (*NotifierEmailPartial, error) {
+	partialRepo := n.emailPartialRepo
+	tmp, err := partialRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp.Name = name
+	tmp.Content = content
+	err = n.validateEmailPartial(tmp)
+	if err != nil {
+		return nil, err
+	}
+	tmp.UpdatedAt = time.Now()
+	err = partialRepo.Update(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+func (n *Notifier) DeleteEmailPartial(id uint64) error {
+	return n.emailPartialRepo.Delete(&NotifierEmailPartial{ID: id})
+}
+
+func (n *Notifier) EmailPartialList() ([]NotifierEmailPartial, error) {
+	var data []NotifierEmailPartial
+	n.emailPartialRepo.All(&data)
+	return data, nil
+}
+
+// validateEmailPartial validates the partial with the other partials, which it may render.
+func (n *Notifier) validateEmailPartial(partial *NotifierEmailPartial) error {
+	err := ValidateEmailPartialName(partial.Name)
+	if err != nil {
+		return err
+	}
+	var stored []NotifierEmailPartial
+	n.emailPartialRepo.All(&stored)
+	partials := []NotifierEmailPartial{*partial}
+	for _, p := range stored {
+		if p.ID != partial.ID && p.Name != partial.Name {
+			partials = append(partials, p)
+		}
+	}
+	return ValidateEmailContent(ComposeEmailTemplate(`{{template "`+partial.Name+`" .}}`, nil, partials))
 }
 
 // Email Template functions #end
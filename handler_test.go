@@ -0,0 +1,225 @@
+package go_notifier_core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+var (
+	sqliteTestConfig = DbConfig{
+		Name:   "sqlite handler test",
+		Driver: SqliteDriver,
+		DB:     ":memory:",
+	}
+	sqliteTestOnce sync.Once
+)
+
+// initSqliteTest migrates, initializes and seeds an in-memory SQLite database shared by the package tests.
+func initSqliteTest(t *testing.T) {
+	t.Helper()
+	sqliteTestOnce.Do(func() {
+		if err := Migrate(sqliteTestConfig); err != nil {
+			t.Fatalf("Error during migrate : %s", err)
+		}
+		if err := Initialize(sqliteTestConfig); err != nil {
+			t.Fatalf("Error during initialize : %s", err)
+		}
+		if err := Seed(); err != nil {
+			t.Fatalf("Error during seed : %s", err)
+		}
+	})
+}
+
+func TestCreateTag(t *testing.T) {
+	initSqliteTest(t)
+
+	tag, err := CreateTag("Handler Tag")
+	assert.Nil(t, err)
+	assert.Equal(t, "handler tag", tag.Name)
+	assert.NotZero(t, tag.ID)
+
+	// Test creating an existing tag
+	exists, err := CreateTag("handler tag")
+	assert.NotNil(t, err, "Creating an existing tag should return error")
+	assert.Equal(t, tag.ID, exists.ID)
+
+	found, err := GetTagByName("HANDLER TAG")
+	assert.Nil(t, err)
+	assert.Equal(t, tag.ID, found.ID)
+}
+
+func TestSubscribeEmail(t *testing.T) {
+	initSqliteTest(t)
+
+	subscriber, err := SubscribeEmail("subscribe@test.com", "first", "last", []string{"subscribe tag"}, true)
+	assert.Nil(t, err)
+	assert.NotZero(t, subscriber.ID)
+
+	// Test subscribing an existing email returns the stored subscriber
+	again, err := SubscribeEmail("subscribe@test.com", "first", "last", []string{"subscribe tag"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, subscriber.ID, again.ID)
+
+	subscribers, err := GetTagEmailSubscribers("subscribe tag")
+	assert.Nil(t, err)
+	assert.Len(t, subscribers, 1)
+
+	// Test tags that don't exist without creating them
+	_, err = SubscribeEmail("subscribe2@test.com", "first", "last", []string{"unknown tag"}, false)
+	assert.NotNil(t, err, "Subscribing with unknown tags should return error")
+
+	err = UnSubscribeEmail("subscribe@test.com", NotifierEmailUnsubManualBySubscriber)
+	assert.Nil(t, err)
+
+	subscribers, err = GetTagEmailSubscribers("subscribe tag")
+	assert.Nil(t, err)
+	assert.Len(t, subscribers, 0)
+}
+
+func TestAddEmailCampaign(t *testing.T) {
+	initSqliteTest(t)
+
+	tag, err := CreateTag("campaign tag")
+	assert.Nil(t, err)
+
+	service, err := CreateEmailService("campaign service", NotifierEmailServiceSMTPType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	template, err := CreateEmailTemplate("campaign template", "<h1>Hello</h1>")
+	assert.Nil(t, err)
+
+	campaign, err := AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusCanceled,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "subject",
+		Name:           "campaign",
+		Tags:           []uint64{tag.ID},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, template.Content, campaign.Content)
+
+	tags := GetEmailCampaignTags(campaign.ID)
+	assert.Len(t, tags, 1)
+	assert.Equal(t, tag.ID, tags[0].ID)
+
+	// Test a template that doesn't exist
+	_, err = AddEmailCampaign(&EmailCampaignCreateData{TemplateId: 999999})
+	assert.NotNil(t, err)
+
+	// Test invalid templates are rejected
+	_, err = CreateEmailTemplate("invalid template", "<h1>Hello {{.FirstName</h1>")
+	assert.ErrorAs(t, err, &TemplateError{})
+	_, err = UpdateEmailTemplate(template.ID, "campaign template", "<h1>Hello {{.Phone}}</h1>")
+	assert.ErrorAs(t, err, &TemplateError{})
+	_, err = AddEmailCampaign(&EmailCampaignCreateData{TemplateId: template.ID, Subject: "Hello {{.FirstName"})
+	assert.ErrorAs(t, err, &TemplateError{})
+
+	assert.Nil(t, DeleteEmailCampaign(campaign.ID))
+	assert.Len(t, GetEmailCampaignTags(campaign.ID), 0)
+}
+
+func TestEmailTemplateVersions(t *testing.T) {
+	n := newSqliteTestNotifier(t, "sqlite template versions test")
+
+	tag, err := n.CreateTag("versions tag")
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("versions service", NotifierEmailServiceSMTPType, []byte(`{}`))
+	assert.Nil(t, err)
+	layout, err := n.CreateEmailLayout("main", "<html>{{template \"header\" .}}\n{{template \"body\" .}}\n</html>")
+	assert.ErrorAs(t, err, &TemplateError{}, "A layout rendering a missing partial should be invalid")
+	_, err = n.CreateEmailLayout("main", "<html></html>")
+	assert.ErrorAs(t, err, &TemplateError{}, "A layout without the body should be invalid")
+
+	_, err = n.CreateEmailPartial("header", "<h1>Hi {{.FirstName | default \"friend\"}}</h1>")
+	assert.Nil(t, err)
+	_, err = n.CreateEmailPartial("content", "<p></p>")
+	assert.ErrorAs(t, err, &TemplateError{})
+	layout, err = n.CreateEmailLayout("main", "<html>{{template \"header\" .}}\n{{template \"body\" .}}\n</html>")
+	assert.Nil(t, err)
+
+	template, err := n.CreateEmailTemplateWithLayout("news", "<p>First</p>", layout.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), template.Version)
+
+	data := &EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusCanceled,
+		FromEmail:      "from@test.com",
+		Subject:        "subject",
+		Name:           "first campaign",
+		Tags:           []uint64{tag.ID},
+	}
+	first, err := n.AddEmailCampaign(data)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), first.TemplateVersion)
+	html, err := RenderEmailContent(first.Content, EmailTemplateData{FirstName: "Ali"})
+	assert.Nil(t, err)
+	assert.Equal(t, "<html><h1>Hi Ali</h1>\n<p>First</p>\n</html>", html)
+
+	// Test an update stores a new version and campaigns keep theirs
+	template, err = n.UpdateEmailTemplate(template.ID, "news", "<p>Second</p>")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), template.Version)
+	_, err = n.UpdateEmailTemplate(template.ID, "news", "<p>{{.Phone}}</p>")
+	assert.ErrorAs(t, err, &TemplateError{})
+
+	stored, err := n.emailCampaignRepo.Get(first.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, first.Content, stored.Content)
+
+	second, err := n.AddEmailCampaign(data)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), second.TemplateVersion)
+	assert.Contains(t, second.Content, "<p>Second</p>")
+
+	// Test a campaign pinned to an old version
+	data.TemplateVersion = 1
+	pinned, err := n.AddEmailCampaign(data)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), pinned.TemplateVersion)
+	assert.Contains(t, pinned.Content, "<p>First</p>")
+	data.TemplateVersion = 9
+	_, err = n.AddEmailCampaign(data)
+	assert.ErrorAs(t, err, &NotFoundError{})
+
+	versions, err := n.GetEmailTemplateVersions(template.ID)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "<p>First</p>", versions[0].Content)
+	assert.Equal(t, layout.ID, *versions[0].LayoutId)
+
+	diff, err := n.DiffEmailTemplateVersions(template.ID, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "-<p>First</p>\n+<p>Second</p>\n", diff.String())
+
+	// Test a rollback stores the old version as a new one
+	template, err = n.RollbackEmailTemplate(template.ID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3), template.Version)
+	assert.Equal(t, "<p>First</p>", template.Content)
+	version, err := n.GetEmailTemplateVersion(template.ID, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "<p>First</p>", version.Content)
+	_, err = n.RollbackEmailTemplate(template.ID, 9)
+	assert.ErrorAs(t, err, &NotFoundError{})
+
+	// Test a template without a layout
+	template, err = n.UpdateEmailTemplateLayout(template.ID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(4), template.Version)
+	data.TemplateVersion = 0
+	plain, err := n.AddEmailCampaign(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "<p>First</p>{{define \"header\"}}<h1>Hi {{.FirstName | default \"friend\"}}</h1>{{end}}", plain.Content)
+
+	// Test deleting the layout leaves versions without a layout
+	assert.Nil(t, n.DeleteEmailLayout(layout.ID))
+	version, err = n.GetEmailTemplateVersion(template.ID, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, version.LayoutId)
+}
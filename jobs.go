@@ -0,0 +1,401 @@
+package go_notifier_core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Types of the jobs enqueued by workers. A campaign job enqueues a message job for every subscriber of
+// the campaign, and a message job sends the campaign to a subscriber.
+const (
+	emailCampaignJob        = "email_campaign"
+	emailMessageJob         = "email_message"
+	mobileCampaignJob       = "mobile_campaign"
+	mobileMessageJob        = "mobile_message"
+	notificationCampaignJob = "notification_campaign"
+	notificationMessageJob  = "notification_message"
+	// emailDeliveryJob sends a created transactional message again, after a transient error or a requeue.
+	emailDeliveryJob = "email_delivery"
+)
+
+const (
+	defaultJobBatch = 100
+	defaultJobLease = 5 * time.Minute
+	// defaultJobMaxAttempts is how many times a job is reserved before it's dead. Retries of RetryPolicy and delays
+	// of rate limits reserve the job again, so it's well above the default MaxAttempts of RetryPolicy.
+	defaultJobMaxAttempts = 25
+)
+
+// errJobOutOfAttempts is the LastError of a job that's dead because it's claimed MaxAttempts times.
+var errJobOutOfAttempts = errors.New("job is out of attempts")
+
+// JobHandler runs a job of its type by the JSON payload of the job. A failed job is nacked.
+type JobHandler func(n *Notifier, payload []byte) error
+
+var (
+	jobHandlersMu sync.RWMutex
+	jobHandlers   = map[string]JobHandler{
+		emailCampaignJob:        runEmailCampaignJob,
+		emailMessageJob:         runEmailMessageJob,
+		mobileCampaignJob:       runMobileCampaignJob,
+		mobileMessageJob:        runMobileMessageJob,
+		notificationCampaignJob: runNotificationCampaignJob,
+		notificationMessageJob:  runNotificationMessageJob,
+		emailDeliveryJob:        runEmailDeliveryJob,
+	}
+)
+
+// RegisterJobHandler registers the handler of a job type for every notifier of the process. It replaces the
+// handler of a built-in type.
+func RegisterJobHandler(jobType string, handler JobHandler) {
+	jobHandlersMu.Lock()
+	jobHandlers[jobType] = handler
+	jobHandlersMu.Unlock()
+}
+
+func registeredJobHandler(jobType string) (JobHandler, bool) {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+	handler, ok := jobHandlers[jobType]
+	return handler, ok
+}
+
+// campaignJobPayload is the payload of campaign jobs, and of message jobs with the subscriber.
+type campaignJobPayload struct {
+	CampaignId   uint64 `json:"campaign_id"`
+	SubscriberId uint64 `json:"subscriber_id,omitempty"`
+}
+
+// campaignBatch returns the batch of the jobs of a campaign, e.g. "email_campaign:12".
+func campaignBatch(jobType string, cmpId uint64) string {
+	return fmt.Sprintf("%s:%d", jobType, cmpId)
+}
+
+// enqueueCampaign enqueues the job that enqueues the messages of the campaign. Enqueuing it twice is harmless,
+// as a message job is enqueued once for every subscriber.
+func (n *Notifier) enqueueCampaign(jobType string, cmpId uint64) error {
+	message, err := NewQueueMessage(jobType, campaignJobPayload{CampaignId: cmpId})
+	if err != nil {
+		return err
+	}
+	message.Batch = campaignBatch(jobType, cmpId)
+	return n.jobQueue().Enqueue(*message)
+}
+
+// enqueueCampaignMessages enqueues a message job of messageType for every subscriber, in the batch of the campaign.
+func (n *Notifier) enqueueCampaignMessages(campaignType, messageType string, cmpId uint64, subscribersId []uint64) error {
+	messages := make([]QueueMessage, 0, len(subscribersId))
+	for _, subscriberId := range subscribersId {
+		message, err := NewQueueMessage(messageType, campaignJobPayload{CampaignId: cmpId, SubscriberId: subscriberId})
+		if err != nil {
+			return err
+		}
+		message.Batch = campaignBatch(campaignType, cmpId)
+		message.UniqueKey = fmt.Sprintf("%s:%d:%d", messageType, cmpId, subscriberId)
+		messages = append(messages, *message)
+	}
+	return n.jobQueue().Enqueue(messages...)
+}
+
+// campaignJobsFinished reports whether every job of the campaign is done or failed.
+func (n *Notifier) campaignJobsFinished(jobType string, cmpId uint64) bool {
+	count, err := n.jobQueue().Pending(campaignBatch(jobType, cmpId))
+	if err != nil {
+		log.Printf("Error during count jobs of %s : %s", campaignBatch(jobType, cmpId), err)
+		return false
+	}
+	return count == 0
+}
+
+// runJobs reserves at most limit available jobs for consumer, leased for lease, and runs them by a pool of
+// concurrency goroutines until no job is available or ctx is done. The reserved jobs that aren't started when
+// ctx is done are released. It returns the count of the run jobs.
+func (n *Notifier) runJobs(ctx context.Context, consumer string, limit, concurrency int, lease time.Duration) (int, error) {
+	// The jobs are run by the context of sends, and finished by the context of n, so the jobs stopped by
+	// a canceled send are still released.
+	queue := n.jobQueue()
+	sender := n.WithContext(sendContext(ctx))
+	count := 0
+	for ctx.Err() == nil {
+		messages, err := queue.Reserve(consumer, limit, lease)
+		if err != nil || len(messages) == 0 {
+			return count, err
+		}
+
+		work := make(chan QueueMessage)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency && i < len(messages); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for message := range work {
+					n.runJob(sender, queue, message)
+				}
+			}()
+		}
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				n.releaseJob(queue, message)
+				continue
+			}
+			work <- message
+			count++
+		}
+		close(work)
+		wg.Wait()
+	}
+	return count, nil
+}
+
+// releaseJob releases a reserved message to be reserved again right away.
+func (n *Notifier) releaseJob(queue QueueBackend, message QueueMessage) {
+	err := queue.Delay(message, 0)
+	if err != nil {
+		log.Printf("Error during release job %s : %s", message.ID, err)
+	}
+}
+
+// runJob runs a reserved message by the handler of its type with sender, then acks it, or nacks it on error.
+// A rate limited message is delayed until the limit allows it, a retried one by its retry delay, and a message
+// stopped by the context of sender is released.
+func (n *Notifier) runJob(sender *Notifier, queue QueueBackend, message QueueMessage) {
+	handler, err := n.jobHandler(message.Type)
+	if err == nil {
+		err = handler(sender, message.Payload)
+	}
+
+	retryAfter, retried := sendRetryAfter(err)
+	switch {
+	case retried:
+		err = queue.Delay(message, retryAfter)
+	case err != nil:
+		log.Printf("Error during run job %s (%s) : %s", message.ID, message.Type, err)
+		err = queue.Nack(message, err)
+	default:
+		err = queue.Ack(message)
+	}
+	if err != nil {
+		log.Printf("Error during finish job %s : %s", message.ID, err)
+	}
+}
+
+// emailDeliveryJobPayload is the payload of email delivery jobs.
+type emailDeliveryJobPayload struct {
+	MessageId uint64         `json:"message_id"`
+	Envelope  *EmailEnvelope `json:"envelope,omitempty"`
+}
+
+// enqueueEmailDelivery enqueues the job that sends the message again at availableAt.
+func (n *Notifier) enqueueEmailDelivery(message *NotifierEmailMessage, envelope *EmailEnvelope, availableAt time.Time) error {
+	job, err := NewQueueMessage(emailDeliveryJob, emailDeliveryJobPayload{MessageId: message.ID, Envelope: envelope})
+	if err != nil {
+		return err
+	}
+	job.AvailableAt = availableAt
+	return n.jobQueue().Enqueue(*job)
+}
+
+// defaultJobOwner returns the host name and the process ID, which identify the workers of the process in jobs.
+func defaultJobOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// newJobClaim returns a unique lock of owner for a claim, so jobs claimed together are told apart from the
+// ones of another claim of the same owner.
+func newJobClaim(owner string) string {
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+	return owner + "/" + hex.EncodeToString(id)
+}
+
+func runEmailCampaignJob(n *Notifier, payload []byte) error {
+	var data campaignJobPayload
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+	subscribers, err := n.GetEmailSubscribersWithTags(n.GetEmailCampaignTags(data.CampaignId))
+	if err != nil {
+		return err
+	}
+
+	subscribersId := make([]uint64, len(subscribers))
+	for i, subscriber := range subscribers {
+		subscribersId[i] = subscriber.ID
+	}
+	return n.enqueueCampaignMessages(emailCampaignJob, emailMessageJob, data.CampaignId, subscribersId)
+}
+
+func runEmailMessageJob(n *Notifier, payload []byte) error {
+	var data campaignJobPayload
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+	campaign, err := n.emailCampaignRepo.Get(data.CampaignId)
+	if err != nil {
+		return err
+	}
+	subscriber, err := n.emailSubscriberRepo.GetWithTags(data.SubscriberId)
+	if err != nil {
+		return err
+	}
+
+	templateData := NewEmailTemplateData(subscriber, campaign.Variables)
+	subject, err := RenderEmailSubject(campaign.Subject, templateData)
+	if err != nil {
+		return fmt.Errorf("render subject for subscriber %d: %w", subscriber.ID, err)
+	}
+	content, err := RenderEmailContent(campaign.Content, templateData)
+	if err != nil {
+		return fmt.Errorf("render content for subscriber %d: %w", subscriber.ID, err)
+	}
+	return n.sendEmail(NewNotifierEmailMessage(
+		subscriber.Email,
+		subscriber.ID,
+		NotifierEmailMessageSourceCampaign,
+		campaign.FromEmail,
+		campaign.ID,
+		campaign.FromName,
+		subject,
+		campaign.EmailServiceId,
+		content,
+	))
+}
+
+func runMobileCampaignJob(n *Notifier, payload []byte) error {
+	var data campaignJobPayload
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+	subscribers, err := n.GetMobileSubscribersWithTags(n.GetMobileCampaignTags(data.CampaignId))
+	if err != nil {
+		return err
+	}
+
+	subscribersId := make([]uint64, len(subscribers))
+	for i, subscriber := range subscribers {
+		subscribersId[i] = subscriber.ID
+	}
+	return n.enqueueCampaignMessages(mobileCampaignJob, mobileMessageJob, data.CampaignId, subscribersId)
+}
+
+func runMobileMessageJob(n *Notifier, payload []byte) error {
+	var data campaignJobPayload
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+	campaign, err := n.mobileCampaignRepo.Get(data.CampaignId)
+	if err != nil {
+		return err
+	}
+	subscriber, err := n.mobileSubscriberRepo.GetWithTags(data.SubscriberId)
+	if err != nil {
+		return err
+	}
+
+	message, err := RenderSmsMessage(campaign.Message, NewSmsTemplateData(subscriber, campaign.Variables))
+	if err != nil {
+		return fmt.Errorf("render message for mobile subscriber %d: %w", subscriber.ID, err)
+	}
+	return n.sendSms(NewNotifierMobileMessage(
+		subscriber.Receptor(),
+		subscriber.ID,
+		NotifierMobileMessageSourceCampaign,
+		campaign.ID,
+		campaign.Sender,
+		campaign.DriverId,
+		message,
+	))
+}
+
+// runNotificationCampaignJob enqueues a message job for every token of the driver of the campaign that belongs
+// to a subscriber of its tags. A subscriber with several of the tags gets the notification once.
+func runNotificationCampaignJob(n *Notifier, payload []byte) error {
+	var data campaignJobPayload
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+	campaign, err := n.notificationCampaignRepo.Get(data.CampaignId)
+	if err != nil {
+		return err
+	}
+
+	var subscribersId []uint64
+	added := map[uint64]bool{}
+	for _, tag := range n.GetNotificationCampaignTags(campaign.ID) {
+		subscribers, err := n.GetTagAndDriverTokenSubscribers(tag.Name, campaign.DriverId)
+		if err != nil {
+			return err
+		}
+		for _, subscriber := range subscribers {
+			if added[subscriber.ID] {
+				continue
+			}
+			added[subscriber.ID] = true
+			subscribersId = append(subscribersId, subscriber.ID)
+		}
+	}
+	return n.enqueueCampaignMessages(notificationCampaignJob, notificationMessageJob, campaign.ID, subscribersId)
+}
+
+// runNotificationMessageJob sends the campaign to a token, unless the token is disabled after it's enqueued.
+func runNotificationMessageJob(n *Notifier, payload []byte) error {
+	var data campaignJobPayload
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+	campaign, err := n.notificationCampaignRepo.Get(data.CampaignId)
+	if err != nil {
+		return err
+	}
+	subscriber, err := n.notificationSubscriberRepo.Get(data.SubscriberId)
+	if err != nil {
+		return err
+	}
+	if !subscriber.Active() {
+		return nil
+	}
+
+	return n.sendPush(NewNotifierNotificationMessage(
+		subscriber.Token,
+		subscriber.ID,
+		NotifierNotificationMessageSourceCampaign,
+		campaign.ID,
+		campaign.DriverId,
+		&PushNotification{Title: campaign.Title, Body: campaign.Body, Image: campaign.Image, Data: campaign.Data},
+	))
+}
+
+// runEmailDeliveryJob sends a message again, unless it's sent or failed for good after it's enqueued.
+func runEmailDeliveryJob(n *Notifier, payload []byte) error {
+	var data emailDeliveryJobPayload
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+	message, err := n.emailMessageRepo.Get(data.MessageId)
+	if err != nil {
+		return err
+	}
+	if message.SentAt != nil || message.FailedAt != nil {
+		return nil
+	}
+	return n.deliverEmail(message, data.Envelope)
+}
@@ -1,8 +1,20 @@
 package go_notifier_core
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
 	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 type (
@@ -11,12 +23,50 @@ type (
 		SetConfig(config []byte)
 	}
 
+	// AttachmentMailer is a Mailer that can send attachments. The built-in mailers implement it, and a mail with
+	// attachments fails on a registered mailer that doesn't.
+	AttachmentMailer interface {
+		Mailer
+		SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error
+	}
+
+	// MailAttachment is a file sent with a mail. An inline attachment is shown in the HTML body by
+	// "cid:<ContentID>" instead of being listed as a file.
+	MailAttachment struct {
+		Name        string
+		ContentType string // Detected from Name or Content when empty.
+		Content     []byte
+		Inline      bool
+		ContentID   string
+	}
+
+	// SmtpConfig is the payload of an SMTP email service.
 	SmtpConfig struct {
-		Host       string
-		Port       string
-		Username   string
-		Password   string
+		Host     string
+		Port     string
+		Username string
+		Password string
+
+		// Encryption is one of SmtpEncryptionNone, SmtpEncryptionStartTLS, SmtpEncryptionTLS or SmtpEncryptionSSL
+		// (implicit TLS, usually on port 465), in any case. When empty or SmtpEncryptionTLS, STARTTLS is used if
+		// the server supports it, and implicit TLS when Port is 465.
 		Encryption string
+
+		// Auth is one of SmtpAuthPlain, SmtpAuthLogin, SmtpAuthCramMD5 or SmtpAuthNone.
+		// When empty, PLAIN is used if Username is set, otherwise no authentication.
+		Auth string
+
+		// Timeouts as Go durations, e.g. "10s". ConnectTimeout limits dialing the server and SendTimeout
+		// the whole conversation after it. Defaults to 10s and 1m.
+		ConnectTimeout string
+		SendTimeout    string
+
+		// TLS settings. ServerName defaults to Host. CAFile is the path and CACert the content of a PEM bundle
+		// of the certificate authorities to trust instead of the system ones.
+		ServerName         string
+		CAFile             string
+		CACert             string
+		InsecureSkipVerify bool
 	}
 
 	SmtpMailer struct {
@@ -24,25 +74,313 @@ type (
 	}
 )
 
+const (
+	SmtpEncryptionNone     = "none"
+	SmtpEncryptionStartTLS = "starttls"
+	// SmtpEncryptionTLS uses STARTTLS when the server supports it, like an empty Encryption. It's kept for the
+	// services stored when STARTTLS was the only encryption.
+	SmtpEncryptionTLS = "tls"
+	SmtpEncryptionSSL = "ssl"
+
+	SmtpAuthNone    = "none"
+	SmtpAuthPlain   = "plain"
+	SmtpAuthLogin   = "login"
+	SmtpAuthCramMD5 = "cram-md5"
+
+	defaultSmtpConnectTimeout = 10 * time.Second
+	defaultSmtpSendTimeout    = time.Minute
+)
+
+var (
+	mailerFactoriesMu sync.RWMutex
+	mailerFactories   = map[string]func() Mailer{
+		NotifierEmailServiceSMTPType:     func() Mailer { return new(SmtpMailer) },
+		NotifierEmailServiceSESType:      func() Mailer { return new(SesMailer) },
+		NotifierEmailServiceSendGridType: func() Mailer { return new(SendGridMailer) },
+		NotifierEmailServiceMailgunType:  func() Mailer { return new(MailgunMailer) },
+		NotifierEmailServicePostmarkType: func() Mailer { return new(PostmarkMailer) },
+		NotifierEmailServiceMailjetType:  func() Mailer { return new(MailjetMailer) },
+		NotifierEmailServicePostalType:   func() Mailer { return new(PostalMailer) },
+	}
+)
+
+// RegisterMailer registers a mailer factory for an email service type, for every notifier of the process.
+// It replaces the mailer of a built-in type, so it can be used for an in-house relay too.
+// The Payload of the email service is passed to SetConfig of a new mailer for every mail.
+func RegisterMailer(serviceType string, factory func() Mailer) {
+	mailerFactoriesMu.Lock()
+	mailerFactories[serviceType] = factory
+	mailerFactoriesMu.Unlock()
+}
+
+func registeredMailer(serviceType string) (func() Mailer, bool) {
+	mailerFactoriesMu.RLock()
+	defer mailerFactoriesMu.RUnlock()
+	factory, ok := mailerFactories[serviceType]
+	return factory, ok
+}
+
+// contentType returns the ContentType of the attachment, or detects it from the extension of the name
+// or from the content.
+func (a MailAttachment) contentType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(a.Name)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(a.Content)
+}
+
 func (s *SmtpMailer) Send(fromName, fromMail, to, subject, message string) error {
-	auth := smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
-	err := smtp.SendMail(
-		s.config.Host+":"+s.config.Port,
-		auth,
-		fromMail,
-		[]string{to},
-		[]byte("From: "+fromName+" <"+fromMail+">\r\n"+
-			"To: "+to+"\r\n"+
-			"Subject: "+subject+"\r\n"+
-			"\r\n"+
-			message+"\r\n"),
-	)
+	_, err := s.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, nil))
+	return err
+}
+
+func (s *SmtpMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := s.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
 	return err
 }
 
+func (s *SmtpMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	return s.SendEnvelopeContext(context.Background(), envelope)
+}
+
+// SendEnvelopeContext sends the mail to the To, Cc and Bcc addresses. The message ID is the Message-ID header of the mail.
+func (s *SmtpMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	if s.config == nil {
+		return nil, errMailerNotConfigured
+	}
+	err := envelope.validate()
+	if err != nil {
+		return nil, err
+	}
+	mail := envelope.mailMessage()
+	mail.MessageID = newMessageID(envelope.FromEmail)
+	data, err := mail.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.deliver(ctx, envelope.FromEmail, envelope.recipients(), data)
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: trimMessageID(mail.MessageID)}, nil
+}
+
+// deliver sends the MIME message to the recipients in one SMTP session, which is stopped when ctx is done.
+func (s *SmtpMailer) deliver(ctx context.Context, from string, recipients []string, data []byte) error {
+	connectTimeout, err := parseTimeout(s.config.ConnectTimeout, defaultSmtpConnectTimeout)
+	if err != nil {
+		return err
+	}
+	sendTimeout, err := parseTimeout(s.config.SendTimeout, defaultSmtpSendTimeout)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	auth, err := s.auth()
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	encryption, err := s.encryption()
+	if err != nil {
+		return err
+	}
+	var conn net.Conn
+	if encryption == SmtpEncryptionSSL {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(sendTimeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if encryption == SmtpEncryptionTLS || encryption == SmtpEncryptionStartTLS {
+		ok, _ := c.Extension("STARTTLS")
+		if ok {
+			err = c.StartTLS(tlsConfig)
+			if err != nil {
+				return err
+			}
+		} else if encryption == SmtpEncryptionStartTLS {
+			return errors.New("smtp server doesn't support STARTTLS")
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server doesn't support AUTH")
+		}
+		err = c.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(from)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		err = c.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// tlsConfig returns the TLS settings used for implicit TLS and STARTTLS.
+func (s *SmtpMailer) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         s.config.ServerName,
+		InsecureSkipVerify: s.config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if config.ServerName == "" {
+		config.ServerName = s.config.Host
+	}
+
+	pem := []byte(s.config.CACert)
+	if s.config.CAFile != "" {
+		data, err := os.ReadFile(s.config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pem = append(pem, data...)
+	}
+	if len(pem) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in the smtp CA bundle")
+		}
+	}
+	return config, nil
+}
+
+// encryption returns the lowercased encryption of the config. An empty one is SmtpEncryptionSSL on port 465,
+// otherwise SmtpEncryptionTLS.
+func (s *SmtpMailer) encryption() (string, error) {
+	encryption := strings.ToLower(s.config.Encryption)
+	switch encryption {
+	case "":
+		if s.config.Port == "465" {
+			return SmtpEncryptionSSL, nil
+		}
+		return SmtpEncryptionTLS, nil
+	case SmtpEncryptionNone, SmtpEncryptionStartTLS, SmtpEncryptionTLS, SmtpEncryptionSSL:
+		return encryption, nil
+	}
+	return "", errors.New("invalid smtp encryption '" + s.config.Encryption + "'")
+}
+
+// auth returns the authentication mechanism of the config, nil for no authentication.
+func (s *SmtpMailer) auth() (smtp.Auth, error) {
+	mechanism := strings.ToLower(s.config.Auth)
+	if mechanism == "" {
+		mechanism = SmtpAuthNone
+		if s.config.Username != "" {
+			mechanism = SmtpAuthPlain
+		}
+	}
+
+	switch mechanism {
+	case SmtpAuthNone:
+		return nil, nil
+	case SmtpAuthPlain:
+		return smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host), nil
+	case SmtpAuthLogin:
+		return &loginAuth{username: s.config.Username, password: s.config.Password, host: s.config.Host}, nil
+	case SmtpAuthCramMD5:
+		return smtp.CRAMMD5Auth(s.config.Username, s.config.Password), nil
+	}
+	return nil, errors.New("invalid smtp auth '" + s.config.Auth + "'")
+}
+
 func (s *SmtpMailer) SetConfig(config []byte) {
 	err := json.Unmarshal(config, &s.config)
 	if err != nil {
 		return
 	}
 }
+
+// loginAuth implements the LOGIN authentication mechanism, which isn't in net/smtp but is still
+// the only one of some servers. Like smtp.PlainAuth, it sends the credentials only over TLS or to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, errors.New("unexpected server challenge '" + string(fromServer) + "'")
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// parseTimeout parses a duration of a config, the fallback is used when it's empty.
+func parseTimeout(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,165 @@
+package go_notifier_core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+type (
+	// EmailEnvelope is a mail with everything a mailer can send: the addresses, custom headers such as
+	// List-Unsubscribe, tracking tags of the provider and attachments.
+	EmailEnvelope struct {
+		FromName    string
+		FromEmail   string
+		To          string
+		Cc          []string
+		Bcc         []string
+		ReplyTo     string
+		Subject     string
+		Message     string
+		Headers     map[string]string // Custom headers, e.g. List-Unsubscribe or X-Campaign.
+		Tags        []string          // Tags of providers that support them, SMTP ignores them.
+		Attachments []MailAttachment
+	}
+
+	// SendResult is the answer of the provider to a sent mail. MessageID is the ID the provider reports in
+	// its bounce and event webhooks, so it correlates them with the NotifierEmailMessage.
+	SendResult struct {
+		MessageID string
+	}
+
+	// EnvelopeMailer is a Mailer that sends a whole EmailEnvelope. The built-in mailers implement it,
+	// other mailers are adapted by AdaptMailer.
+	EnvelopeMailer interface {
+		SetConfig(config []byte)
+		SendEnvelope(envelope *EmailEnvelope) (*SendResult, error)
+	}
+
+	// ContextMailer is an EnvelopeMailer that stops a send when its context is done, e.g. when a worker is
+	// stopped. The built-in mailers implement it, the sends of other mailers aren't stopped.
+	ContextMailer interface {
+		EnvelopeMailer
+		SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error)
+	}
+
+	// mailerAdapter sends envelopes by a Mailer that doesn't implement EnvelopeMailer.
+	mailerAdapter struct {
+		Mailer
+	}
+)
+
+var (
+	errMailerEnvelopeNotSupported    = errors.New("mailer can't send CC, BCC, Reply-To or custom headers, implement EnvelopeMailer")
+	errMailerAttachmentsNotSupported = errors.New("mailer can't send attachments, implement AttachmentMailer or EnvelopeMailer")
+)
+
+// reservedMailHeaders are set from the fields of the envelope, so they can't be custom headers.
+var reservedMailHeaders = map[string]bool{
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+}
+
+// AdaptMailer returns the mailer as an EnvelopeMailer. A mailer that only implements Mailer sends the addresses,
+// the subject, the message and, by AttachmentMailer, the attachments of an envelope, and returns an error for
+// an envelope with CC, BCC, Reply-To or custom headers. Tags are dropped, and the result has no message ID.
+func AdaptMailer(mailer Mailer) EnvelopeMailer {
+	if envelopeMailer, ok := mailer.(EnvelopeMailer); ok {
+		return envelopeMailer
+	}
+	return mailerAdapter{mailer}
+}
+
+func (m mailerAdapter) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	if len(envelope.Cc) > 0 || len(envelope.Bcc) > 0 || envelope.ReplyTo != "" || len(envelope.Headers) > 0 {
+		return nil, errMailerEnvelopeNotSupported
+	}
+	if len(envelope.Attachments) == 0 {
+		err := m.Send(envelope.FromName, envelope.FromEmail, envelope.To, envelope.Subject, envelope.Message)
+		return &SendResult{}, err
+	}
+	attachmentMailer, ok := m.Mailer.(AttachmentMailer)
+	if !ok {
+		return nil, errMailerAttachmentsNotSupported
+	}
+	err := attachmentMailer.SendWithAttachments(envelope.FromName, envelope.FromEmail, envelope.To, envelope.Subject, envelope.Message, envelope.Attachments)
+	return &SendResult{}, err
+}
+
+// sendEnvelope sends the envelope by ctx when the mailer is a ContextMailer.
+func sendEnvelope(ctx context.Context, mailer EnvelopeMailer, envelope *EmailEnvelope) (*SendResult, error) {
+	if contextMailer, ok := mailer.(ContextMailer); ok {
+		return contextMailer.SendEnvelopeContext(ctx, envelope)
+	}
+	return mailer.SendEnvelope(envelope)
+}
+
+// newEnvelope returns the envelope of a Send or SendWithAttachments call.
+func newEnvelope(fromName, fromMail, to, subject, message string, attachments []MailAttachment) *EmailEnvelope {
+	return &EmailEnvelope{
+		FromName:    fromName,
+		FromEmail:   fromMail,
+		To:          to,
+		Subject:     subject,
+		Message:     message,
+		Attachments: attachments,
+	}
+}
+
+// stored returns a copy of the envelope without its message and attachments, which are kept by the email
+// message and its attachments, to store it with the message.
+func (e *EmailEnvelope) stored() *EmailEnvelope {
+	if e == nil {
+		return nil
+	}
+	envelope := *e
+	envelope.Message = ""
+	envelope.Attachments = nil
+	return &envelope
+}
+
+// validate returns an error for an invalid custom header name, or a custom header set from the fields
+// of the envelope.
+func (e *EmailEnvelope) validate() error {
+	for name := range e.Headers {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' || r == ':' }) >= 0 {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if reservedMailHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			return fmt.Errorf("header %s is set by the envelope fields, it can't be a custom header", name)
+		}
+	}
+	return nil
+}
+
+// mailMessage returns the MIME message of the envelope. Bcc addresses aren't part of the message.
+func (e *EmailEnvelope) mailMessage() *MailMessage {
+	m := NewMailMessage(e.FromName, e.FromEmail, e.To, e.Subject, e.Message)
+	m.Cc = e.Cc
+	m.ReplyTo = e.ReplyTo
+	m.Headers = e.Headers
+	m.Attachments = e.Attachments
+	return m
+}
+
+// recipients returns the To, Cc and Bcc addresses.
+func (e *EmailEnvelope) recipients() []string {
+	recipients := append([]string{e.To}, e.Cc...)
+	return append(recipients, e.Bcc...)
+}
+
+// trimMessageID removes the angle brackets of a Message-ID header.
+func trimMessageID(id string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">")
+}
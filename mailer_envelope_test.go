@@ -0,0 +1,49 @@
+package go_notifier_core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAdaptMailer(t *testing.T) {
+	smtp := &SmtpMailer{}
+	assert.Same(t, smtp, AdaptMailer(smtp))
+
+	// Test a Mailer of older versions, without attachments or envelopes
+	mailer := &fakeMailer{}
+	adapted := AdaptMailer(struct{ Mailer }{mailer})
+	result, err := adapted.SendEnvelope(&EmailEnvelope{
+		FromName:  "from",
+		FromEmail: "from@example.com",
+		To:        "to@example.com",
+		Subject:   "Subject",
+		Message:   "Hello",
+		Tags:      []string{"dropped"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", result.MessageID)
+	if sent := mailer.Sent(); assert.Len(t, sent, 1) {
+		assert.Equal(t, "to@example.com", sent[0].to)
+		assert.Equal(t, "Hello", sent[0].message)
+	}
+
+	// Test fields an old mailer can't send fail the mail instead of being dropped
+	for _, envelope := range []*EmailEnvelope{
+		{To: "to@example.com", Cc: []string{"cc@example.com"}},
+		{To: "to@example.com", Bcc: []string{"bcc@example.com"}},
+		{To: "to@example.com", ReplyTo: "support@example.com"},
+		{To: "to@example.com", Headers: map[string]string{"X-Campaign": "42"}},
+		{To: "to@example.com", Attachments: []MailAttachment{{Name: "file.txt"}}},
+	} {
+		_, err = adapted.SendEnvelope(envelope)
+		assert.NotNil(t, err)
+	}
+	assert.Len(t, mailer.Sent(), 1)
+}
+
+func TestEmailEnvelopeValidate(t *testing.T) {
+	assert.Nil(t, (&EmailEnvelope{Headers: map[string]string{"List-Unsubscribe": "<mailto:unsubscribe@example.com>"}}).validate())
+	assert.NotNil(t, (&EmailEnvelope{Headers: map[string]string{"message-id": "<id@example.com>"}}).validate())
+	assert.NotNil(t, (&EmailEnvelope{Headers: map[string]string{"X-Bad\r\nBcc": "evil@example.com"}}).validate())
+	assert.NotNil(t, (&EmailEnvelope{Headers: map[string]string{"": "empty"}}).validate())
+}
@@ -0,0 +1,69 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// httpMailerClient sends the requests of the mailers of HTTP API providers.
+var httpMailerClient = &http.Client{Timeout: 30 * time.Second}
+
+// errMailerNotConfigured is returned by Send when SetConfig got an invalid payload.
+var errMailerNotConfigured = errors.New("mailer isn't configured, check the payload of the email service")
+
+// newJSONRequest returns a POST request with the body encoded as JSON.
+func newJSONRequest(ctx context.Context, url string, body interface{}) (*http.Request, []byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return req, data, nil
+}
+
+// doMailerRequest sends the request by ctx and returns the body and the headers of a 2xx response.
+// Any other status is returned as a MailerError with the body of the response.
+func doMailerRequest(ctx context.Context, mailer string, req *http.Request) ([]byte, http.Header, error) {
+	res, err := httpMailerClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, nil, MailerError{Mailer: mailer, StatusCode: res.StatusCode, Body: string(body)}
+	}
+	return body, res.Header, nil
+}
+
+// mailerURL joins the base URL of a provider, or its default when the base URL is empty, with the path.
+func mailerURL(baseURL, defaultURL, path string) string {
+	if baseURL == "" {
+		baseURL = defaultURL
+	}
+	return strings.TrimRight(baseURL, "/") + path
+}
+
+// formatAddress returns the address as "name <email>", encoding the name when it isn't ASCII.
+func formatAddress(name, email string) string {
+	if name == "" {
+		return email
+	}
+	return (&mail.Address{Name: name, Address: email}).String()
+}
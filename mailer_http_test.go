@@ -0,0 +1,396 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// providerStub is an httptest stand-in of an email provider API, recording the last request.
+type providerStub struct {
+	server *httptest.Server
+	req    *http.Request
+	body   []byte
+	header http.Header // Headers of the response.
+}
+
+func newProviderStub(t *testing.T, status int, response string) *providerStub {
+	stub := &providerStub{header: http.Header{}}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.req = r
+		stub.body, _ = io.ReadAll(r.Body)
+		for name, values := range stub.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (p *providerStub) config(t *testing.T, config map[string]string) []byte {
+	config["BaseURL"] = p.server.URL
+	data, err := json.Marshal(config)
+	assert.Nil(t, err)
+	return data
+}
+
+func (p *providerStub) json(t *testing.T) map[string]interface{} {
+	var body map[string]interface{}
+	assert.Nil(t, json.Unmarshal(p.body, &body))
+	return body
+}
+
+func TestSendGridMailerSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusAccepted, "")
+	mailer := &SendGridMailer{}
+	mailer.SetConfig(stub.config(t, map[string]string{"APIKey": "key"}))
+
+	err := mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.Nil(t, err)
+	assert.Equal(t, "/v3/mail/send", stub.req.URL.Path)
+	assert.Equal(t, "Bearer key", stub.req.Header.Get("Authorization"))
+	body := stub.json(t)
+	assert.Equal(t, "Subject", body["subject"])
+	assert.Equal(t, map[string]interface{}{"email": "from@example.com", "name": "Test User"}, body["from"])
+	assert.Contains(t, string(stub.body), `"to":[{"email":"to@example.com"}]`)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"type": "text/plain", "value": "Hello"},
+		map[string]interface{}{"type": "text/html", "value": "<p>Hello</p>"},
+	}, body["content"])
+}
+
+func TestMailgunMailerSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusOK, `{"id":"<id@example.com>","message":"Queued. Thank you."}`)
+	mailer := &MailgunMailer{}
+	mailer.SetConfig(stub.config(t, map[string]string{"Domain": "mg.example.com", "APIKey": "key"}))
+
+	err := mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.Nil(t, err)
+	assert.Equal(t, "/v3/mg.example.com/messages", stub.req.URL.Path)
+	user, password, ok := stub.req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "api", user)
+	assert.Equal(t, "key", password)
+	form, err := url.ParseQuery(string(stub.body))
+	assert.Nil(t, err)
+	assert.Equal(t, `"Test User" <from@example.com>`, form.Get("from"))
+	assert.Equal(t, "to@example.com", form.Get("to"))
+	assert.Equal(t, "<p>Hello</p>", form.Get("html"))
+	assert.Equal(t, "Hello", form.Get("text"))
+}
+
+func TestPostmarkMailerSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusOK, `{"ErrorCode":0,"Message":"OK","MessageID":"id"}`)
+	mailer := &PostmarkMailer{}
+	mailer.SetConfig(stub.config(t, map[string]string{"ServerToken": "token", "MessageStream": "broadcast"}))
+
+	err := mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.Nil(t, err)
+	assert.Equal(t, "/email", stub.req.URL.Path)
+	assert.Equal(t, "token", stub.req.Header.Get("X-Postmark-Server-Token"))
+	body := stub.json(t)
+	assert.Equal(t, `"Test User" <from@example.com>`, body["From"])
+	assert.Equal(t, "to@example.com", body["To"])
+	assert.Equal(t, "<p>Hello</p>", body["HtmlBody"])
+	assert.Equal(t, "broadcast", body["MessageStream"])
+}
+
+func TestMailjetMailerSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusOK, `{"Messages":[{"Status":"success"}]}`)
+	mailer := &MailjetMailer{}
+	mailer.SetConfig(stub.config(t, map[string]string{"APIKey": "key", "SecretKey": "secret"}))
+
+	err := mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.Nil(t, err)
+	assert.Equal(t, "/v3.1/send", stub.req.URL.Path)
+	user, password, ok := stub.req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "key", user)
+	assert.Equal(t, "secret", password)
+	assert.Contains(t, string(stub.body), `"From":{"Email":"from@example.com","Name":"Test User"}`)
+	assert.Contains(t, string(stub.body), `"To":[{"Email":"to@example.com"}]`)
+}
+
+func TestPostalMailerSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusOK, `{"status":"success","data":{"message_id":"id"}}`)
+	mailer := &PostalMailer{}
+	mailer.SetConfig(stub.config(t, map[string]string{"ServerKey": "key"}))
+
+	err := mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.Nil(t, err)
+	assert.Equal(t, "/api/v1/send/message", stub.req.URL.Path)
+	assert.Equal(t, "key", stub.req.Header.Get("X-Server-API-Key"))
+	body := stub.json(t)
+	assert.Equal(t, []interface{}{"to@example.com"}, body["to"])
+	assert.Equal(t, "<p>Hello</p>", body["html_body"])
+
+	// Test errors answered with a 200 status
+	stub = newProviderStub(t, http.StatusOK, `{"status":"parameter-error","data":{"message":"Invalid server key"}}`)
+	mailer.SetConfig(stub.config(t, map[string]string{"ServerKey": "key"}))
+	err = mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.ErrorAs(t, err, &MailerError{})
+
+	// Test the base URL is required
+	mailer.SetConfig([]byte(`{"ServerKey": "key"}`))
+	err = mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.NotNil(t, err)
+}
+
+func TestSesMailerSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusOK, `{"MessageId":"id"}`)
+	mailer := &SesMailer{}
+	mailer.SetConfig(stub.config(t, map[string]string{"Region": "eu-west-1", "AccessKeyId": "AKID", "SecretAccessKey": "secret"}))
+
+	err := mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "<p>Hello</p>")
+	assert.Nil(t, err)
+	assert.Equal(t, "/v2/email/outbound-emails", stub.req.URL.Path)
+	assert.Contains(t, stub.req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+	assert.Contains(t, stub.req.Header.Get("Authorization"), "/eu-west-1/ses/aws4_request")
+	assert.Contains(t, string(stub.body), `"ToAddresses":["to@example.com"]`)
+	assert.Contains(t, string(stub.body), `"Subject":{"Data":"Subject","Charset":"UTF-8"}`)
+}
+
+func TestHTTPMailerAttachments(t *testing.T) {
+	attachments := []MailAttachment{
+		{Name: "logo.png", ContentType: "image/png", Content: []byte("png"), Inline: true, ContentID: "logo"},
+		{Name: "report.pdf", Content: []byte("%PDF-1.4")},
+	}
+	message := `<p><img src="cid:logo"></p>`
+
+	stub := newProviderStub(t, http.StatusAccepted, "")
+	sendGrid := &SendGridMailer{}
+	sendGrid.SetConfig(stub.config(t, map[string]string{"APIKey": "key"}))
+	assert.Nil(t, sendGrid.SendWithAttachments("Test User", "from@example.com", "to@example.com", "Subject", message, attachments))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"content": "cG5n", "filename": "logo.png", "type": "image/png", "disposition": "inline", "content_id": "logo"},
+		map[string]interface{}{"content": "JVBERi0xLjQ=", "filename": "report.pdf", "type": "application/pdf", "disposition": "attachment"},
+	}, stub.json(t)["attachments"])
+
+	stub = newProviderStub(t, http.StatusOK, `{"id":"<id@example.com>"}`)
+	mailgun := &MailgunMailer{}
+	mailgun.SetConfig(stub.config(t, map[string]string{"Domain": "mg.example.com", "APIKey": "key"}))
+	assert.Nil(t, mailgun.SendWithAttachments("Test User", "from@example.com", "to@example.com", "Subject", message, attachments))
+	mediaType, params, err := mime.ParseMediaType(stub.req.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	form, err := multipart.NewReader(bytes.NewReader(stub.body), params["boundary"]).ReadForm(1 << 20)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"to@example.com"}, form.Value["to"])
+	assert.Equal(t, "logo", form.File["inline"][0].Filename)
+	assert.Equal(t, "report.pdf", form.File["attachment"][0].Filename)
+
+	stub = newProviderStub(t, http.StatusOK, `{"MessageID":"id"}`)
+	postmark := &PostmarkMailer{}
+	postmark.SetConfig(stub.config(t, map[string]string{"ServerToken": "token"}))
+	assert.Nil(t, postmark.SendWithAttachments("Test User", "from@example.com", "to@example.com", "Subject", message, attachments))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Name": "logo.png", "Content": "cG5n", "ContentType": "image/png", "ContentID": "cid:logo"},
+		map[string]interface{}{"Name": "report.pdf", "Content": "JVBERi0xLjQ=", "ContentType": "application/pdf"},
+	}, stub.json(t)["Attachments"])
+
+	stub = newProviderStub(t, http.StatusOK, `{"Messages":[{"Status":"success"}]}`)
+	mailjet := &MailjetMailer{}
+	mailjet.SetConfig(stub.config(t, map[string]string{"APIKey": "key", "SecretKey": "secret"}))
+	assert.Nil(t, mailjet.SendWithAttachments("Test User", "from@example.com", "to@example.com", "Subject", message, attachments))
+	assert.Contains(t, string(stub.body), `"Attachments":[{"ContentType":"application/pdf","Filename":"report.pdf","Base64Content":"JVBERi0xLjQ="}]`)
+	assert.Contains(t, string(stub.body), `"InlinedAttachments":[{"ContentType":"image/png","Filename":"logo.png","Base64Content":"cG5n","ContentID":"logo"}]`)
+
+	// Test Postal and SES send raw MIME messages
+	stub = newProviderStub(t, http.StatusOK, `{"status":"success"}`)
+	postal := &PostalMailer{}
+	postal.SetConfig(stub.config(t, map[string]string{"ServerKey": "key"}))
+	assert.Nil(t, postal.SendWithAttachments("Test User", "from@example.com", "to@example.com", "Subject", message, attachments))
+	assert.Equal(t, "/api/v1/send/raw", stub.req.URL.Path)
+	var raw postalRawMessage
+	assert.Nil(t, json.Unmarshal(stub.body, &raw))
+	assert.Equal(t, []string{"to@example.com"}, raw.RcptTo)
+	assert.Contains(t, string(raw.Data), "Content-Type: multipart/mixed")
+
+	stub = newProviderStub(t, http.StatusOK, `{"MessageId":"id"}`)
+	ses := &SesMailer{}
+	ses.SetConfig(stub.config(t, map[string]string{"Region": "eu-west-1", "AccessKeyId": "AKID", "SecretAccessKey": "secret"}))
+	assert.Nil(t, ses.SendWithAttachments("Test User", "from@example.com", "to@example.com", "Subject", message, attachments))
+	var sesBody sesMessage
+	assert.Nil(t, json.Unmarshal(stub.body, &sesBody))
+	assert.Nil(t, sesBody.Content.Simple)
+	if assert.NotNil(t, sesBody.Content.Raw) {
+		assert.Contains(t, string(sesBody.Content.Raw.Data), "Content-ID: <logo>")
+	}
+}
+
+func TestHTTPMailerSendEnvelope(t *testing.T) {
+	envelope := &EmailEnvelope{
+		FromName:  "Test User",
+		FromEmail: "from@example.com",
+		To:        "to@example.com",
+		Cc:        []string{"cc@example.com"},
+		Bcc:       []string{"bcc@example.com"},
+		ReplyTo:   "support@example.com",
+		Subject:   "Subject",
+		Message:   "<p>Hello</p>",
+		Headers:   map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>"},
+		Tags:      []string{"weekly"},
+	}
+
+	stub := newProviderStub(t, http.StatusAccepted, "")
+	stub.header.Set("X-Message-Id", "sendgrid-id")
+	sendGrid := &SendGridMailer{}
+	sendGrid.SetConfig(stub.config(t, map[string]string{"APIKey": "key"}))
+	result, err := sendGrid.SendEnvelope(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "sendgrid-id", result.MessageID)
+	body := stub.json(t)
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"to":  []interface{}{map[string]interface{}{"email": "to@example.com"}},
+		"cc":  []interface{}{map[string]interface{}{"email": "cc@example.com"}},
+		"bcc": []interface{}{map[string]interface{}{"email": "bcc@example.com"}},
+	}}, body["personalizations"])
+	assert.Equal(t, map[string]interface{}{"email": "support@example.com"}, body["reply_to"])
+	assert.Equal(t, map[string]interface{}{"List-Unsubscribe": "<https://example.com/unsubscribe>"}, body["headers"])
+	assert.Equal(t, []interface{}{"weekly"}, body["categories"])
+
+	stub = newProviderStub(t, http.StatusOK, `{"id":"<mailgun-id@example.com>","message":"Queued. Thank you."}`)
+	mailgun := &MailgunMailer{}
+	mailgun.SetConfig(stub.config(t, map[string]string{"Domain": "mg.example.com", "APIKey": "key"}))
+	result, err = mailgun.SendEnvelope(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "mailgun-id@example.com", result.MessageID)
+	form, err := url.ParseQuery(string(stub.body))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"cc@example.com"}, form["cc"])
+	assert.Equal(t, []string{"bcc@example.com"}, form["bcc"])
+	assert.Equal(t, "support@example.com", form.Get("h:Reply-To"))
+	assert.Equal(t, "<https://example.com/unsubscribe>", form.Get("h:List-Unsubscribe"))
+	assert.Equal(t, []string{"weekly"}, form["o:tag"])
+
+	stub = newProviderStub(t, http.StatusOK, `{"ErrorCode":0,"MessageID":"postmark-id"}`)
+	postmark := &PostmarkMailer{}
+	postmark.SetConfig(stub.config(t, map[string]string{"ServerToken": "token"}))
+	result, err = postmark.SendEnvelope(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "postmark-id", result.MessageID)
+	body = stub.json(t)
+	assert.Equal(t, "cc@example.com", body["Cc"])
+	assert.Equal(t, "bcc@example.com", body["Bcc"])
+	assert.Equal(t, "support@example.com", body["ReplyTo"])
+	assert.Equal(t, "weekly", body["Tag"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"Name": "List-Unsubscribe", "Value": "<https://example.com/unsubscribe>"}}, body["Headers"])
+
+	stub = newProviderStub(t, http.StatusOK, `{"Messages":[{"Status":"success","To":[{"Email":"to@example.com","MessageUUID":"uuid","MessageID":1152921504606846976}]}]}`)
+	mailjet := &MailjetMailer{}
+	mailjet.SetConfig(stub.config(t, map[string]string{"APIKey": "key", "SecretKey": "secret"}))
+	result, err = mailjet.SendEnvelope(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "1152921504606846976", result.MessageID)
+	assert.Contains(t, string(stub.body), `"Cc":[{"Email":"cc@example.com"}],"Bcc":[{"Email":"bcc@example.com"}],"ReplyTo":{"Email":"support@example.com"}`)
+	assert.Contains(t, string(stub.body), `"CustomCampaign":"weekly"`)
+
+	stub = newProviderStub(t, http.StatusOK, `{"status":"success","data":{"message_id":"postal-id@example.com","messages":{}}}`)
+	postal := &PostalMailer{}
+	postal.SetConfig(stub.config(t, map[string]string{"ServerKey": "key"}))
+	result, err = postal.SendEnvelope(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "postal-id@example.com", result.MessageID)
+	body = stub.json(t)
+	assert.Equal(t, []interface{}{"cc@example.com"}, body["cc"])
+	assert.Equal(t, "support@example.com", body["reply_to"])
+	assert.Equal(t, "weekly", body["tag"])
+
+	stub = newProviderStub(t, http.StatusOK, `{"MessageId":"ses-id"}`)
+	ses := &SesMailer{}
+	ses.SetConfig(stub.config(t, map[string]string{"Region": "eu-west-1", "AccessKeyId": "AKID", "SecretAccessKey": "secret"}))
+	result, err = ses.SendEnvelope(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "ses-id", result.MessageID)
+	assert.Contains(t, string(stub.body), `"CcAddresses":["cc@example.com"],"BccAddresses":["bcc@example.com"]},"ReplyToAddresses":["support@example.com"]`)
+	assert.Contains(t, string(stub.body), `"EmailTags":[{"Name":"weekly","Value":"true"}]`)
+	assert.Contains(t, string(stub.body), `"Headers":[{"Name":"List-Unsubscribe","Value":"\u003chttps://example.com/unsubscribe\u003e"}]`)
+}
+
+func TestSignAwsV4(t *testing.T) {
+	// get-vanilla case of the AWS signature version 4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	assert.Nil(t, err)
+	now, err := time.Parse("20060102T150405Z", "20150830T123600Z")
+	assert.Nil(t, err)
+
+	signAwsV4(req, nil, "us-east-1", "service", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", now)
+	assert.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"),
+	)
+}
+
+func TestHTTPMailerErrors(t *testing.T) {
+	stub := newProviderStub(t, http.StatusUnauthorized, `{"errors":[{"message":"invalid api key"}]}`)
+	mailer := &SendGridMailer{}
+	mailer.SetConfig(stub.config(t, map[string]string{"APIKey": "wrong"}))
+
+	err := mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "Hello")
+	var mailerErr MailerError
+	assert.ErrorAs(t, err, &mailerErr)
+	assert.Equal(t, http.StatusUnauthorized, mailerErr.StatusCode)
+	assert.Contains(t, mailerErr.Body, "invalid api key")
+
+	// Test a canceled send isn't requested
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = mailer.SendEnvelopeContext(ctx, newEnvelope("Test User", "from@example.com", "to@example.com", "Subject", "Hello", nil))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Test an invalid payload
+	mailer = &SendGridMailer{}
+	mailer.SetConfig([]byte("this is not valid JSON"))
+	err = mailer.Send("Test User", "from@example.com", "to@example.com", "Subject", "Hello")
+	assert.ErrorIs(t, err, errMailerNotConfigured)
+}
+
+func TestRegisterMailer(t *testing.T) {
+	n := newNotifier(nil)
+	for _, serviceType := range []string{
+		NotifierEmailServiceSMTPType,
+		NotifierEmailServiceSESType,
+		NotifierEmailServiceSendGridType,
+		NotifierEmailServiceMailgunType,
+		NotifierEmailServicePostmarkType,
+		NotifierEmailServiceMailjetType,
+		NotifierEmailServicePostalType,
+	} {
+		mailer, err := n.mailer(serviceType)
+		assert.Nil(t, err, serviceType)
+		assert.NotNil(t, mailer, serviceType)
+	}
+
+	_, err := n.mailer("Relay")
+	assert.NotNil(t, err)
+
+	relay := &fakeMailer{}
+	RegisterMailer("Relay", func() Mailer { return relay })
+	defer func() {
+		mailerFactoriesMu.Lock()
+		delete(mailerFactories, "Relay")
+		mailerFactoriesMu.Unlock()
+	}()
+	mailer, err := n.mailer("Relay")
+	assert.Nil(t, err)
+	assert.Same(t, relay, mailer)
+
+	// Test mailers of the notifier take precedence
+	own := &fakeMailer{}
+	n = newNotifier(nil, WithMailer("Relay", func() Mailer { return own }))
+	mailer, err = n.mailer("Relay")
+	assert.Nil(t, err)
+	assert.Same(t, own, mailer)
+}
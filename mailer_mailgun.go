@@ -0,0 +1,160 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+)
+
+type (
+	// MailgunConfig is the payload of a Mailgun email service.
+	MailgunConfig struct {
+		Domain  string
+		APIKey  string
+		BaseURL string // Defaults to https://api.mailgun.net, use https://api.eu.mailgun.net for EU domains.
+	}
+
+	// MailgunMailer sends mails by the messages API of Mailgun.
+	MailgunMailer struct {
+		config *MailgunConfig
+	}
+
+	mailgunResponse struct {
+		ID string `json:"id"`
+	}
+)
+
+func (m *MailgunMailer) Send(fromName, fromMail, to, subject, message string) error {
+	_, err := m.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, nil))
+	return err
+}
+
+func (m *MailgunMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := m.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
+	return err
+}
+
+func (m *MailgunMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	return m.SendEnvelopeContext(context.Background(), envelope)
+}
+
+// SendEnvelopeContext posts the mail as a form, or as multipart/form-data when it has attachments.
+// Mailgun uses the file name of an inline attachment as its Content-ID, so inline files are named by ContentID.
+// Headers are sent as "h:" fields and tags as "o:tag" fields.
+func (m *MailgunMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	if m.config == nil {
+		return nil, errMailerNotConfigured
+	}
+	err := envelope.validate()
+	if err != nil {
+		return nil, err
+	}
+	msg := envelope.mailMessage()
+	form := url.Values{}
+	form.Set("from", formatAddress(envelope.FromName, envelope.FromEmail))
+	form.Set("to", envelope.To)
+	for _, cc := range envelope.Cc {
+		form.Add("cc", cc)
+	}
+	for _, bcc := range envelope.Bcc {
+		form.Add("bcc", bcc)
+	}
+	form.Set("subject", envelope.Subject)
+	form.Set("text", msg.Text)
+	if msg.HTML != "" {
+		form.Set("html", msg.HTML)
+	}
+	if envelope.ReplyTo != "" {
+		form.Set("h:Reply-To", envelope.ReplyTo)
+	}
+	for name, value := range envelope.Headers {
+		form.Set("h:"+name, value)
+	}
+	for _, tag := range envelope.Tags {
+		form.Add("o:tag", tag)
+	}
+
+	contentType := "application/x-www-form-urlencoded"
+	body := []byte(form.Encode())
+	if len(envelope.Attachments) > 0 {
+		contentType, body, err = mailgunMultipartForm(form, envelope.Attachments)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		mailerURL(m.config.BaseURL, "https://api.mailgun.net", "/v3/"+url.PathEscape(m.config.Domain)+"/messages"),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("api", m.config.APIKey)
+	data, _, err := doMailerRequest(ctx, NotifierEmailServiceMailgunType, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var res mailgunResponse
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: trimMessageID(res.ID)}, nil
+}
+
+// mailgunMultipartForm returns the content type and the body of a multipart form of the fields and the attachments.
+func mailgunMultipartForm(form url.Values, attachments []MailAttachment) (string, []byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	names := make([]string, 0, len(form))
+	for name := range form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range form[name] {
+			err := mw.WriteField(name, value)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	for _, attachment := range attachments {
+		field, filename := "attachment", attachment.Name
+		if attachment.Inline {
+			field, filename = "inline", attachment.ContentID
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {mime.FormatMediaType("form-data", map[string]string{"name": field, "filename": filename})},
+			"Content-Type":        {attachment.contentType()},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		_, err = w.Write(attachment.Content)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	err := mw.Close()
+	return mw.FormDataContentType(), body.Bytes(), err
+}
+
+func (m *MailgunMailer) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &m.config)
+	if err != nil {
+		return
+	}
+}
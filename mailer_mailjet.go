@@ -0,0 +1,156 @@
+package go_notifier_core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+)
+
+type (
+	// MailjetConfig is the payload of a Mailjet email service.
+	MailjetConfig struct {
+		APIKey    string
+		SecretKey string
+		BaseURL   string // Defaults to https://api.mailjet.com
+	}
+
+	// MailjetMailer sends mails by the v3.1 send API of Mailjet.
+	MailjetMailer struct {
+		config *MailjetConfig
+	}
+
+	mailjetAddress struct {
+		Email string
+		Name  string `json:",omitempty"`
+	}
+
+	mailjetAttachment struct {
+		ContentType   string
+		Filename      string
+		Base64Content string
+		ContentID     string `json:",omitempty"`
+	}
+
+	mailjetMessage struct {
+		From               mailjetAddress
+		To                 []mailjetAddress
+		Cc                 []mailjetAddress `json:",omitempty"`
+		Bcc                []mailjetAddress `json:",omitempty"`
+		ReplyTo            *mailjetAddress  `json:",omitempty"`
+		Subject            string
+		TextPart           string
+		HTMLPart           string              `json:",omitempty"`
+		Headers            map[string]string   `json:",omitempty"`
+		CustomCampaign     string              `json:",omitempty"`
+		Attachments        []mailjetAttachment `json:",omitempty"`
+		InlinedAttachments []mailjetAttachment `json:",omitempty"`
+	}
+
+	mailjetRequest struct {
+		Messages []mailjetMessage
+	}
+
+	mailjetResponse struct {
+		Messages []struct {
+			To []struct {
+				MessageID json.Number
+			}
+		}
+	}
+)
+
+func (m *MailjetMailer) Send(fromName, fromMail, to, subject, message string) error {
+	_, err := m.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, nil))
+	return err
+}
+
+func (m *MailjetMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := m.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
+	return err
+}
+
+func (m *MailjetMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	return m.SendEnvelopeContext(context.Background(), envelope)
+}
+
+// SendEnvelopeContext sends the mail with the first tag as its custom campaign. The message ID is the MessageID of
+// the To address, which Mailjet events report.
+func (m *MailjetMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	if m.config == nil {
+		return nil, errMailerNotConfigured
+	}
+	err := envelope.validate()
+	if err != nil {
+		return nil, err
+	}
+	msg := envelope.mailMessage()
+	mail := mailjetMessage{
+		From:     mailjetAddress{Email: envelope.FromEmail, Name: envelope.FromName},
+		To:       []mailjetAddress{{Email: envelope.To}},
+		Cc:       mailjetAddresses(envelope.Cc),
+		Bcc:      mailjetAddresses(envelope.Bcc),
+		Subject:  envelope.Subject,
+		TextPart: msg.Text,
+		HTMLPart: msg.HTML,
+		Headers:  envelope.Headers,
+	}
+	if envelope.ReplyTo != "" {
+		mail.ReplyTo = &mailjetAddress{Email: envelope.ReplyTo}
+	}
+	if len(envelope.Tags) > 0 {
+		mail.CustomCampaign = envelope.Tags[0]
+	}
+	for _, attachment := range envelope.Attachments {
+		file := mailjetAttachment{
+			ContentType:   attachment.contentType(),
+			Filename:      attachment.Name,
+			Base64Content: base64.StdEncoding.EncodeToString(attachment.Content),
+		}
+		if attachment.Inline {
+			file.ContentID = attachment.ContentID
+			mail.InlinedAttachments = append(mail.InlinedAttachments, file)
+		} else {
+			mail.Attachments = append(mail.Attachments, file)
+		}
+	}
+
+	req, _, err := newJSONRequest(
+		ctx,
+		mailerURL(m.config.BaseURL, "https://api.mailjet.com", "/v3.1/send"),
+		mailjetRequest{Messages: []mailjetMessage{mail}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(m.config.APIKey, m.config.SecretKey)
+	data, _, err := doMailerRequest(ctx, NotifierEmailServiceMailjetType, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var res mailjetResponse
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, err
+	}
+	result := &SendResult{}
+	if len(res.Messages) > 0 && len(res.Messages[0].To) > 0 {
+		result.MessageID = res.Messages[0].To[0].MessageID.String()
+	}
+	return result, nil
+}
+
+func (m *MailjetMailer) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &m.config)
+	if err != nil {
+		return
+	}
+}
+
+func mailjetAddresses(emails []string) []mailjetAddress {
+	var addresses []mailjetAddress
+	for _, email := range emails {
+		addresses = append(addresses, mailjetAddress{Email: email})
+	}
+	return addresses
+}
@@ -0,0 +1,266 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MailMessage is an email built for sending. Bytes returns it as a MIME message for SMTP, and mailers of HTTP APIs
+// use its HTML and Text bodies. When both bodies are set, the message is a multipart/alternative of both.
+// Inline attachments are wrapped with the bodies in a multipart/related part and other attachments in
+// a multipart/mixed message.
+type MailMessage struct {
+	FromName    string
+	FromEmail   string
+	To          string
+	Cc          []string
+	ReplyTo     string
+	Subject     string
+	HTML        string
+	Text        string
+	Date        time.Time         // Defaults to the time Bytes is called.
+	MessageID   string            // Generated from the domain of FromEmail when empty.
+	Headers     map[string]string // Custom headers, written after the standard ones in the order of their names.
+	Attachments []MailAttachment
+}
+
+// mailPart is a MIME entity: its headers and its encoded body.
+type mailPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// NewMailMessage returns a message of the body. An HTML body gets a plain text alternative generated by HTMLToText,
+// any other body is sent as plain text.
+func NewMailMessage(fromName, fromEmail, to, subject, body string) *MailMessage {
+	m := &MailMessage{FromName: fromName, FromEmail: fromEmail, To: to, Subject: subject}
+	if looksLikeHTML(body) {
+		m.HTML = body
+		m.Text = HTMLToText(body)
+	} else {
+		m.Text = body
+	}
+	return m
+}
+
+// Bytes returns the message in the MIME format. Headers are encoded as RFC 2047 encoded-words when they aren't ASCII
+// and bodies are quoted-printable, so any UTF-8 text is delivered as it is. Attachments are base64 encoded.
+func (m *MailMessage) Bytes() ([]byte, error) {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := m.MessageID
+	if messageID == "" {
+		messageID = newMessageID(m.FromEmail)
+	}
+
+	part, err := m.bodyPart()
+	if err != nil {
+		return nil, err
+	}
+	var inline, attached []mailPart
+	for _, attachment := range m.Attachments {
+		if attachment.Inline {
+			inline = append(inline, attachment.part())
+		} else {
+			attached = append(attached, attachment.part())
+		}
+	}
+	if len(inline) > 0 {
+		part, err = multipartMailPart("multipart/related", append([]mailPart{part}, inline...))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(attached) > 0 {
+		part, err = multipartMailPart("multipart/mixed", append([]mailPart{part}, attached...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	writeMailHeader(&buf, "From", formatAddress(m.FromName, m.FromEmail))
+	writeMailHeader(&buf, "To", m.To)
+	if len(m.Cc) > 0 {
+		writeMailHeader(&buf, "Cc", strings.Join(m.Cc, ", "))
+	}
+	if m.ReplyTo != "" {
+		writeMailHeader(&buf, "Reply-To", m.ReplyTo)
+	}
+	writeMailHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeMailHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeMailHeader(&buf, "Message-ID", messageID)
+	writeMailHeader(&buf, "MIME-Version", "1.0")
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeMailHeader(&buf, name, mime.QEncoding.Encode("utf-8", m.Headers[name]))
+	}
+	for _, name := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if value := part.header.Get(name); value != "" {
+			writeMailHeader(&buf, name, value)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(part.body)
+	return buf.Bytes(), nil
+}
+
+// bodyPart returns the text body, or the multipart/alternative of the text and the HTML bodies.
+func (m *MailMessage) bodyPart() (mailPart, error) {
+	text, err := textMailPart("text/plain; charset=utf-8", m.Text)
+	if err != nil || m.HTML == "" {
+		return text, err
+	}
+	html, err := textMailPart("text/html; charset=utf-8", m.HTML)
+	if err != nil {
+		return mailPart{}, err
+	}
+	return multipartMailPart("multipart/alternative", []mailPart{text, html})
+}
+
+func textMailPart(contentType, content string) (mailPart, error) {
+	var body bytes.Buffer
+	err := writeQuotedPrintable(&body, content)
+	return mailPart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: body.Bytes(),
+	}, err
+}
+
+func multipartMailPart(contentType string, parts []mailPart) (mailPart, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range parts {
+		w, err := mw.CreatePart(part.header)
+		if err != nil {
+			return mailPart{}, err
+		}
+		_, err = w.Write(part.body)
+		if err != nil {
+			return mailPart{}, err
+		}
+	}
+	err := mw.Close()
+	return mailPart{
+		header: textproto.MIMEHeader{"Content-Type": {contentType + "; boundary=" + mw.Boundary()}},
+		body:   body.Bytes(),
+	}, err
+}
+
+// part returns the attachment as a base64 encoded MIME entity. Inline attachments get a Content-ID,
+// so the HTML body can show them by "cid:<ContentID>".
+func (a MailAttachment) part() mailPart {
+	disposition := "attachment"
+	if a.Inline {
+		disposition = "inline"
+	}
+	header := textproto.MIMEHeader{
+		"Content-Type":              {a.contentType()},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": a.Name})},
+	}
+	if a.ContentID != "" {
+		header["Content-ID"] = []string{"<" + strings.NewReplacer("\r", "", "\n", "", "<", "", ">", "").Replace(a.ContentID) + ">"}
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(a.Content)
+	var body bytes.Buffer
+	for len(encoded) > 76 {
+		body.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	body.WriteString(encoded)
+	return mailPart{header: header, body: body.Bytes()}
+}
+
+// writeMailHeader writes a header line. Line breaks are removed from the value, so it can't inject headers.
+func writeMailHeader(buf *bytes.Buffer, name, value string) {
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(content))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// newMessageID returns a unique Message-ID on the domain of the email address.
+func newMessageID(email string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(email, "@"); i >= 0 && i < len(email)-1 {
+		domain = email[i+1:]
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
+
+var (
+	htmlTagPattern       = regexp.MustCompile(`<[a-zA-Z][^>]*>`)
+	htmlIgnoredPattern   = regexp.MustCompile(`(?is)<(head|script|style)\b[^>]*>.*?</(head|script|style)>|<!--.*?-->`)
+	htmlLinkPattern      = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	htmlParagraphPattern = regexp.MustCompile(`(?i)</(p|h[1-6]|ul|ol|table|blockquote|header|footer|section|article)>`)
+	htmlLineBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</(div|li|tr)>`)
+	htmlListItemPattern  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlAnyTagPattern    = regexp.MustCompile(`<[^>]*>`)
+	spacesPattern        = regexp.MustCompile(`[ \t\r\f\v\x{00a0}]+`)
+	blankLinesPattern    = regexp.MustCompile(`\n{3,}`)
+)
+
+// looksLikeHTML reports whether the body has HTML tags.
+func looksLikeHTML(body string) bool {
+	return htmlTagPattern.MatchString(body)
+}
+
+// HTMLToText returns a plain text version of an HTML body, keeping its paragraphs, list items and link targets.
+func HTMLToText(body string) string {
+	text := htmlIgnoredPattern.ReplaceAllString(body, "")
+	text = htmlLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		match := htmlLinkPattern.FindStringSubmatch(link)
+		label := strings.TrimSpace(htmlAnyTagPattern.ReplaceAllString(match[2], ""))
+		if label == "" || label == match[1] {
+			return match[1]
+		}
+		return label + " (" + match[1] + ")"
+	})
+	text = strings.ReplaceAll(text, "\n", " ")
+	text = htmlParagraphPattern.ReplaceAllString(text, "\n\n")
+	text = htmlLineBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlListItemPattern.ReplaceAllString(text, "- ")
+	text = htmlAnyTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = spacesPattern.ReplaceAllString(text, " ")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
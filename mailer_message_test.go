@@ -0,0 +1,147 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailMessageBytes(t *testing.T) {
+	m := NewMailMessage("علی رضایی", "from@example.com", "to@example.com", "خبرنامه هفتگی", "<h1>سلام</h1><p>Hello <b>world</b></p>")
+	m.Date = time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	data, err := m.Bytes()
+	assert.Nil(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+	assert.Equal(t, "Sat, 01 Jul 2023 10:00:00 +0000", msg.Header.Get("Date"))
+	assert.True(t, strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>"))
+
+	// Test non-ASCII headers are encoded and decode back
+	assert.True(t, strings.HasPrefix(msg.Header.Get("Subject"), "=?utf-8?q?"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.Nil(t, err)
+	assert.Equal(t, "خبرنامه هفتگی", subject)
+	from, err := msg.Header.AddressList("From")
+	assert.Nil(t, err)
+	assert.Equal(t, "علی رضایی", from[0].Name)
+
+	// Test the body is a multipart/alternative of text and HTML
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+
+	part, err := reader.NextRawPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+	assert.Equal(t, "quoted-printable", part.Header.Get("Content-Transfer-Encoding"))
+	text, err := io.ReadAll(quotedprintable.NewReader(part))
+	assert.Nil(t, err)
+	assert.Equal(t, "سلام\r\n\r\nHello world", string(text))
+
+	part, err = reader.NextRawPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", part.Header.Get("Content-Type"))
+	html, err := io.ReadAll(quotedprintable.NewReader(part))
+	assert.Nil(t, err)
+	assert.Equal(t, "<h1>سلام</h1><p>Hello <b>world</b></p>", string(html))
+}
+
+func TestMailMessagePlainText(t *testing.T) {
+	m := NewMailMessage("Test User", "from@example.com", "to@example.com", "Subject\r\nBcc: evil@example.com", "Hello, this is a test email.")
+	assert.Equal(t, "", m.HTML)
+	data, err := m.Bytes()
+	assert.Nil(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", msg.Header.Get("Content-Type"))
+	assert.Equal(t, "", msg.Header.Get("Bcc"), "Line breaks in headers should not inject headers")
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, this is a test email.", string(body))
+}
+
+func TestHTMLToText(t *testing.T) {
+	html := `<html><head><title>Title</title><style>p { color: red; }</style></head>
+<body>
+	<header>Weekly&nbsp;news</header>
+	<p>First line<br>second   line</p>
+	<ul><li>One</li><li>Two &amp; three</li></ul>
+	<p>Read <a href="https://example.com/post">the post</a> or visit <a href="https://example.com">https://example.com</a></p>
+	<script>alert("hidden")</script>
+</body></html>`
+
+	assert.Equal(t, "Weekly news\n\nFirst line\nsecond line\n\n- One\n- Two & three\n\n"+
+		"Read the post (https://example.com/post) or visit https://example.com", HTMLToText(html))
+}
+
+func TestMailMessageAttachments(t *testing.T) {
+	m := NewMailMessage("Test User", "from@example.com", "to@example.com", "Subject", `<p><img src="cid:logo@example.com"></p>`)
+	content := bytes.Repeat([]byte("report "), 20)
+	m.Attachments = []MailAttachment{
+		{Name: "logo.png", ContentType: "image/png", Content: []byte("png"), Inline: true, ContentID: "logo@example.com"},
+		{Name: "گزارش.txt", Content: content},
+	}
+	data, err := m.Bytes()
+	assert.Nil(t, err)
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	// Test the structure is mixed(related(alternative, inline), attachment)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	mixed := multipart.NewReader(msg.Body, params["boundary"])
+
+	part, err := mixed.NextRawPart()
+	assert.Nil(t, err)
+	mediaType, params, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/related", mediaType)
+	related := multipart.NewReader(part, params["boundary"])
+
+	body, err := related.NextRawPart()
+	assert.Nil(t, err)
+	mediaType, _, err = mime.ParseMediaType(body.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	inline, err := related.NextRawPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "image/png", inline.Header.Get("Content-Type"))
+	assert.Equal(t, "<logo@example.com>", inline.Header.Get("Content-ID"))
+	assert.Equal(t, `inline; filename=logo.png`, inline.Header.Get("Content-Disposition"))
+	decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, inline))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("png"), decoded)
+
+	part, err = mixed.NextRawPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+	disposition, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	assert.Nil(t, err)
+	assert.Equal(t, "attachment", disposition)
+	assert.Equal(t, "گزارش.txt", params["filename"])
+	raw, err := io.ReadAll(part)
+	assert.Nil(t, err)
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		assert.LessOrEqual(t, len(line), 76)
+	}
+	decoded, err = io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(raw)))
+	assert.Nil(t, err)
+	assert.Equal(t, content, decoded)
+
+	_, err = mixed.NextRawPart()
+	assert.Equal(t, io.EOF, err)
+}
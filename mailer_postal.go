@@ -0,0 +1,137 @@
+package go_notifier_core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type (
+	// PostalConfig is the payload of a Postal email service. Postal is self-hosted, so BaseURL is required.
+	PostalConfig struct {
+		ServerKey string
+		BaseURL   string
+	}
+
+	// PostalMailer sends mails by the send message API of a Postal server.
+	PostalMailer struct {
+		config *PostalConfig
+	}
+
+	postalMessage struct {
+		To       []string          `json:"to"`
+		Cc       []string          `json:"cc,omitempty"`
+		Bcc      []string          `json:"bcc,omitempty"`
+		From     string            `json:"from"`
+		ReplyTo  string            `json:"reply_to,omitempty"`
+		Subject  string            `json:"subject"`
+		Tag      string            `json:"tag,omitempty"`
+		Text     string            `json:"plain_body"`
+		HtmlBody string            `json:"html_body,omitempty"`
+		Headers  map[string]string `json:"headers,omitempty"`
+	}
+
+	postalRawMessage struct {
+		MailFrom string   `json:"mail_from"`
+		RcptTo   []string `json:"rcpt_to"`
+		Data     []byte   `json:"data"`
+	}
+
+	postalResponse struct {
+		Status string `json:"status"`
+		Data   struct {
+			MessageID string `json:"message_id"`
+		} `json:"data"`
+	}
+)
+
+func (p *PostalMailer) Send(fromName, fromMail, to, subject, message string) error {
+	_, err := p.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, nil))
+	return err
+}
+
+func (p *PostalMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := p.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
+	return err
+}
+
+func (p *PostalMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	return p.SendEnvelopeContext(context.Background(), envelope)
+}
+
+// SendEnvelopeContext sends the mail with its first tag. A mail with attachments is sent as a raw MIME message,
+// so inline images keep their Content-ID, and has no tag.
+func (p *PostalMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	if p.config == nil {
+		return nil, errMailerNotConfigured
+	}
+	if p.config.BaseURL == "" {
+		return nil, errors.New("postal mailer needs the BaseURL of the server")
+	}
+	err := envelope.validate()
+	if err != nil {
+		return nil, err
+	}
+	msg := envelope.mailMessage()
+	var req *http.Request
+	if len(envelope.Attachments) == 0 {
+		var tag string
+		if len(envelope.Tags) > 0 {
+			tag = envelope.Tags[0]
+		}
+		req, _, err = newJSONRequest(
+			ctx,
+			mailerURL(p.config.BaseURL, "", "/api/v1/send/message"),
+			postalMessage{
+				To:       []string{envelope.To},
+				Cc:       envelope.Cc,
+				Bcc:      envelope.Bcc,
+				From:     formatAddress(envelope.FromName, envelope.FromEmail),
+				ReplyTo:  envelope.ReplyTo,
+				Subject:  envelope.Subject,
+				Tag:      tag,
+				Text:     msg.Text,
+				HtmlBody: msg.HTML,
+				Headers:  envelope.Headers,
+			},
+		)
+	} else {
+		var data []byte
+		data, err = msg.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		req, _, err = newJSONRequest(
+			ctx,
+			mailerURL(p.config.BaseURL, "", "/api/v1/send/raw"),
+			postalRawMessage{MailFrom: envelope.FromEmail, RcptTo: envelope.recipients(), Data: data},
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Server-API-Key", p.config.ServerKey)
+	body, _, err := doMailerRequest(ctx, NotifierEmailServicePostalType, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Postal answers errors with a 200 status and an error status in the body
+	var res postalResponse
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != "success" {
+		return nil, MailerError{Mailer: NotifierEmailServicePostalType, StatusCode: http.StatusOK, Body: string(body)}
+	}
+	return &SendResult{MessageID: res.Data.MessageID}, nil
+}
+
+func (p *PostalMailer) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &p.config)
+	if err != nil {
+		return
+	}
+}
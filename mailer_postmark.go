@@ -0,0 +1,142 @@
+package go_notifier_core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+type (
+	// PostmarkConfig is the payload of a Postmark email service.
+	PostmarkConfig struct {
+		ServerToken   string
+		MessageStream string // Defaults to the "outbound" stream of the server.
+		BaseURL       string // Defaults to https://api.postmarkapp.com
+	}
+
+	// PostmarkMailer sends mails by the email API of Postmark.
+	PostmarkMailer struct {
+		config *PostmarkConfig
+	}
+
+	postmarkAttachment struct {
+		Name        string
+		Content     string
+		ContentType string
+		ContentID   string `json:",omitempty"`
+	}
+
+	postmarkHeader struct {
+		Name  string
+		Value string
+	}
+
+	postmarkMessage struct {
+		From          string
+		To            string
+		Cc            string `json:",omitempty"`
+		Bcc           string `json:",omitempty"`
+		ReplyTo       string `json:",omitempty"`
+		Subject       string
+		Tag           string `json:",omitempty"`
+		TextBody      string
+		HtmlBody      string               `json:",omitempty"`
+		Headers       []postmarkHeader     `json:",omitempty"`
+		MessageStream string               `json:",omitempty"`
+		Attachments   []postmarkAttachment `json:",omitempty"`
+	}
+
+	postmarkResponse struct {
+		MessageID string
+	}
+)
+
+func (p *PostmarkMailer) Send(fromName, fromMail, to, subject, message string) error {
+	_, err := p.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, nil))
+	return err
+}
+
+func (p *PostmarkMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := p.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
+	return err
+}
+
+func (p *PostmarkMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	return p.SendEnvelopeContext(context.Background(), envelope)
+}
+
+// SendEnvelopeContext sends the mail. Postmark has one tag per message, so only the first tag is sent.
+func (p *PostmarkMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	if p.config == nil {
+		return nil, errMailerNotConfigured
+	}
+	err := envelope.validate()
+	if err != nil {
+		return nil, err
+	}
+	msg := envelope.mailMessage()
+	var files []postmarkAttachment
+	for _, attachment := range envelope.Attachments {
+		file := postmarkAttachment{
+			Name:        attachment.Name,
+			Content:     base64.StdEncoding.EncodeToString(attachment.Content),
+			ContentType: attachment.contentType(),
+		}
+		if attachment.Inline {
+			file.ContentID = "cid:" + attachment.ContentID
+		}
+		files = append(files, file)
+	}
+	var headers []postmarkHeader
+	for name, value := range envelope.Headers {
+		headers = append(headers, postmarkHeader{Name: name, Value: value})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	var tag string
+	if len(envelope.Tags) > 0 {
+		tag = envelope.Tags[0]
+	}
+
+	req, _, err := newJSONRequest(
+		ctx,
+		mailerURL(p.config.BaseURL, "https://api.postmarkapp.com", "/email"),
+		postmarkMessage{
+			From:          formatAddress(envelope.FromName, envelope.FromEmail),
+			To:            envelope.To,
+			Cc:            strings.Join(envelope.Cc, ","),
+			Bcc:           strings.Join(envelope.Bcc, ","),
+			ReplyTo:       envelope.ReplyTo,
+			Subject:       envelope.Subject,
+			Tag:           tag,
+			TextBody:      msg.Text,
+			HtmlBody:      msg.HTML,
+			Headers:       headers,
+			MessageStream: p.config.MessageStream,
+			Attachments:   files,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Postmark-Server-Token", p.config.ServerToken)
+	data, _, err := doMailerRequest(ctx, NotifierEmailServicePostmarkType, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var res postmarkResponse
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: res.MessageID}, nil
+}
+
+func (p *PostmarkMailer) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &p.config)
+	if err != nil {
+		return
+	}
+}
@@ -0,0 +1,146 @@
+package go_notifier_core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+)
+
+type (
+	// SendGridConfig is the payload of a SendGrid email service.
+	SendGridConfig struct {
+		APIKey  string
+		BaseURL string // Defaults to https://api.sendgrid.com
+	}
+
+	// SendGridMailer sends mails by the v3 mail send API of SendGrid.
+	SendGridMailer struct {
+		config *SendGridConfig
+	}
+
+	sendGridAddress struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+
+	sendGridContent struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+
+	sendGridPersonalization struct {
+		To  []sendGridAddress `json:"to"`
+		Cc  []sendGridAddress `json:"cc,omitempty"`
+		Bcc []sendGridAddress `json:"bcc,omitempty"`
+	}
+
+	sendGridAttachment struct {
+		Content     string `json:"content"`
+		Filename    string `json:"filename"`
+		Type        string `json:"type,omitempty"`
+		Disposition string `json:"disposition,omitempty"`
+		ContentID   string `json:"content_id,omitempty"`
+	}
+
+	sendGridMessage struct {
+		Personalizations []sendGridPersonalization `json:"personalizations"`
+		From             sendGridAddress           `json:"from"`
+		ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+		Subject          string                    `json:"subject"`
+		Content          []sendGridContent         `json:"content"`
+		Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+		Headers          map[string]string         `json:"headers,omitempty"`
+		Categories       []string                  `json:"categories,omitempty"`
+	}
+)
+
+func (s *SendGridMailer) Send(fromName, fromMail, to, subject, message string) error {
+	_, err := s.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, nil))
+	return err
+}
+
+func (s *SendGridMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := s.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
+	return err
+}
+
+func (s *SendGridMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	return s.SendEnvelopeContext(context.Background(), envelope)
+}
+
+// SendEnvelopeContext sends the mail with the tags as categories. The message ID is the X-Message-Id of the response.
+func (s *SendGridMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	if s.config == nil {
+		return nil, errMailerNotConfigured
+	}
+	err := envelope.validate()
+	if err != nil {
+		return nil, err
+	}
+	msg := envelope.mailMessage()
+	content := []sendGridContent{{Type: "text/plain", Value: msg.Text}}
+	if msg.HTML != "" {
+		content = append(content, sendGridContent{Type: "text/html", Value: msg.HTML})
+	}
+	var files []sendGridAttachment
+	for _, attachment := range envelope.Attachments {
+		file := sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(attachment.Content),
+			Filename:    attachment.Name,
+			Type:        attachment.contentType(),
+			Disposition: "attachment",
+		}
+		if attachment.Inline {
+			file.Disposition = "inline"
+			file.ContentID = attachment.ContentID
+		}
+		files = append(files, file)
+	}
+	var replyTo *sendGridAddress
+	if envelope.ReplyTo != "" {
+		replyTo = &sendGridAddress{Email: envelope.ReplyTo}
+	}
+
+	req, _, err := newJSONRequest(
+		ctx,
+		mailerURL(s.config.BaseURL, "https://api.sendgrid.com", "/v3/mail/send"),
+		sendGridMessage{
+			Personalizations: []sendGridPersonalization{{
+				To:  []sendGridAddress{{Email: envelope.To}},
+				Cc:  sendGridAddresses(envelope.Cc),
+				Bcc: sendGridAddresses(envelope.Bcc),
+			}},
+			From:        sendGridAddress{Email: envelope.FromEmail, Name: envelope.FromName},
+			ReplyTo:     replyTo,
+			Subject:     envelope.Subject,
+			Content:     content,
+			Attachments: files,
+			Headers:     envelope.Headers,
+			Categories:  envelope.Tags,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
+	_, header, err := doMailerRequest(ctx, NotifierEmailServiceSendGridType, req)
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: header.Get("X-Message-Id")}, nil
+}
+
+func (s *SendGridMailer) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &s.config)
+	if err != nil {
+		return
+	}
+}
+
+func sendGridAddresses(emails []string) []sendGridAddress {
+	var addresses []sendGridAddress
+	for _, email := range emails {
+		addresses = append(addresses, sendGridAddress{Email: email})
+	}
+	return addresses
+}
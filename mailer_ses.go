@@ -0,0 +1,226 @@
+package go_notifier_core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// SesConfig is the payload of an Amazon SES email service.
+	SesConfig struct {
+		Region           string
+		AccessKeyId      string
+		SecretAccessKey  string
+		SessionToken     string // Only for temporary credentials.
+		ConfigurationSet string
+		BaseURL          string // Defaults to https://email.<Region>.amazonaws.com
+	}
+
+	// SesMailer sends mails by the v2 API of Amazon SES. Requests are signed with AWS signature version 4.
+	SesMailer struct {
+		config *SesConfig
+	}
+
+	sesContent struct {
+		Data    string
+		Charset string
+	}
+
+	sesHeader struct {
+		Name  string
+		Value string
+	}
+
+	sesSimpleContent struct {
+		Subject sesContent
+		Body    struct {
+			Text *sesContent `json:",omitempty"`
+			Html *sesContent `json:",omitempty"`
+		}
+		Headers []sesHeader `json:",omitempty"`
+	}
+
+	sesRawContent struct {
+		Data []byte
+	}
+
+	sesTag struct {
+		Name  string
+		Value string
+	}
+
+	sesMessage struct {
+		FromEmailAddress string
+		Destination      struct {
+			ToAddresses  []string
+			CcAddresses  []string `json:",omitempty"`
+			BccAddresses []string `json:",omitempty"`
+		}
+		ReplyToAddresses []string `json:",omitempty"`
+		Content          struct {
+			Simple *sesSimpleContent `json:",omitempty"`
+			Raw    *sesRawContent    `json:",omitempty"`
+		}
+		EmailTags            []sesTag `json:",omitempty"`
+		ConfigurationSetName string   `json:",omitempty"`
+	}
+
+	sesResponse struct {
+		MessageId string
+	}
+)
+
+func (s *SesMailer) Send(fromName, fromMail, to, subject, message string) error {
+	_, err := s.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, nil))
+	return err
+}
+
+func (s *SesMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := s.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
+	return err
+}
+
+func (s *SesMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	return s.SendEnvelopeContext(context.Background(), envelope)
+}
+
+// SendEnvelopeContext sends a mail with attachments as raw content, because simple content of SES can't have them.
+// Tags are sent as message tags with the "true" value, for the event destinations of the configuration set.
+func (s *SesMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	if s.config == nil {
+		return nil, errMailerNotConfigured
+	}
+	err := envelope.validate()
+	if err != nil {
+		return nil, err
+	}
+	mail := envelope.mailMessage()
+	var msg sesMessage
+	msg.FromEmailAddress = formatAddress(envelope.FromName, envelope.FromEmail)
+	msg.Destination.ToAddresses = []string{envelope.To}
+	msg.Destination.CcAddresses = envelope.Cc
+	msg.Destination.BccAddresses = envelope.Bcc
+	if envelope.ReplyTo != "" {
+		msg.ReplyToAddresses = []string{envelope.ReplyTo}
+	}
+	if len(envelope.Attachments) == 0 {
+		simple := &sesSimpleContent{Subject: sesContent{Data: envelope.Subject, Charset: "UTF-8"}}
+		simple.Body.Text = &sesContent{Data: mail.Text, Charset: "UTF-8"}
+		if mail.HTML != "" {
+			simple.Body.Html = &sesContent{Data: mail.HTML, Charset: "UTF-8"}
+		}
+		for name, value := range envelope.Headers {
+			simple.Headers = append(simple.Headers, sesHeader{Name: name, Value: value})
+		}
+		sort.Slice(simple.Headers, func(i, j int) bool { return simple.Headers[i].Name < simple.Headers[j].Name })
+		msg.Content.Simple = simple
+	} else {
+		data, err := mail.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		msg.Content.Raw = &sesRawContent{Data: data}
+	}
+	for _, tag := range envelope.Tags {
+		msg.EmailTags = append(msg.EmailTags, sesTag{Name: tag, Value: "true"})
+	}
+	msg.ConfigurationSetName = s.config.ConfigurationSet
+
+	req, body, err := newJSONRequest(
+		ctx,
+		mailerURL(s.config.BaseURL, "https://email."+s.config.Region+".amazonaws.com", "/v2/email/outbound-emails"),
+		msg,
+	)
+	if err != nil {
+		return nil, err
+	}
+	signAwsV4(req, body, s.config.Region, "ses", s.config.AccessKeyId, s.config.SecretAccessKey, s.config.SessionToken, time.Now())
+	data, _, err := doMailerRequest(ctx, NotifierEmailServiceSESType, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var res sesResponse
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: res.MessageId}, nil
+}
+
+func (s *SesMailer) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &s.config)
+	if err != nil {
+		return
+	}
+}
+
+// signAwsV4 adds the AWS signature version 4 headers to the request.
+// The host, the content type and the x-amz-* headers are signed.
+func signAwsV4(req *http.Request, body []byte, region, service, accessKey, secretKey, sessionToken string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSha256([]byte("AWS4"+secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
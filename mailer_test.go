@@ -1,7 +1,27 @@
 package go_notifier_core
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Mock SMTP server configuration for testing
@@ -14,27 +34,6 @@ var mockSmtpConfig = []byte(`
 		"Encryption": "tls"
 	}`)
 
-func TestSmtpMailerSend(t *testing.T) {
-	// Create an instance of SmtpMailer
-	mailer := &SmtpMailer{}
-
-	// Set the configuration
-	mailer.SetConfig(mockSmtpConfig)
-
-	// Test email details
-	fromName := "Test User"
-	fromMail := "testuser@example.com"
-	to := "recipient@example.com"
-	subject := "Test Subject"
-	message := "Hello, this is a test email."
-
-	// Send an email
-	err := mailer.Send(fromName, fromMail, to, subject, message)
-	if err != nil {
-		t.Fatalf("Error sending email: %v", err)
-	}
-}
-
 func TestSmtpMailerSetConfig(t *testing.T) {
 	// Create an instance of SmtpMailer
 	mailer := &SmtpMailer{}
@@ -64,3 +63,369 @@ func TestSmtpMailerSetConfigInvalidJSON(t *testing.T) {
 		t.Fatalf("SmtpMailer configuration is not nil after setting invalid configuration")
 	}
 }
+
+// fakeSmtpServer is a local SMTP server for the mailer tests. It offers STARTTLS when tlsConfig is set
+// and requires authentication when username is set.
+type fakeSmtpServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+
+	mu   sync.Mutex
+	mail fakeSmtpMail
+}
+
+// fakeSmtpMail is what the fake server received for the last mail.
+type fakeSmtpMail struct {
+	auth string
+	tls  bool
+	from string
+	to   string
+	rcpt []string
+	data string
+}
+
+// newFakeSmtpServer starts a server, with implicit TLS when implicitTLS is set.
+func newFakeSmtpServer(t *testing.T, tlsConfig *tls.Config, implicitTLS bool, username, password string) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error during listen : %s", err)
+	}
+	if implicitTLS {
+		listener = tls.NewListener(listener, tlsConfig)
+		tlsConfig = nil
+	}
+	f := &fakeSmtpServer{listener: listener, tlsConfig: tlsConfig, username: username, password: password}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// config returns the payload of an email service for the server.
+func (f *fakeSmtpServer) config(t *testing.T, config SmtpConfig) []byte {
+	host, port, _ := net.SplitHostPort(f.listener.Addr().String())
+	config.Host = host
+	config.Port = port
+	data, err := json.Marshal(config)
+	assert.Nil(t, err)
+	return data
+}
+
+func (f *fakeSmtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	_, isTLS := conn.(*tls.Conn)
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"localhost"}
+			if f.tlsConfig != nil && !isTLS {
+				lines = append(lines, "STARTTLS")
+			}
+			if f.username != "" {
+				lines = append(lines, "AUTH PLAIN LOGIN CRAM-MD5")
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				_ = tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, f.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			if !f.authenticate(tp, arg) {
+				_ = tp.PrintfLine("535 Authentication credentials invalid")
+				continue
+			}
+			_ = tp.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			f.mu.Lock()
+			f.mail.from, f.mail.tls = arg, isTLS
+			f.mu.Unlock()
+			_ = tp.PrintfLine("250 OK")
+		case "RCPT":
+			f.mu.Lock()
+			f.mail.to = arg
+			f.mail.rcpt = append(f.mail.rcpt, arg)
+			f.mu.Unlock()
+			_ = tp.PrintfLine("250 OK")
+		case "DATA":
+			_ = tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.mail.data = string(data)
+			f.mu.Unlock()
+			_ = tp.PrintfLine("250 Queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (f *fakeSmtpServer) authenticate(tp *textproto.Conn, arg string) bool {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		decoded, _ := base64.StdEncoding.DecodeString(initial)
+		parts := strings.Split(string(decoded), "\x00")
+		if len(parts) != 3 {
+			return false
+		}
+		username, password = parts[1], parts[2]
+	case "LOGIN":
+		username = f.challenge(tp, "Username:")
+		password = f.challenge(tp, "Password:")
+	case "CRAM-MD5":
+		challenge := "<1896.697170952@localhost>"
+		username, digest, _ := strings.Cut(f.challenge(tp, challenge), " ")
+		h := hmac.New(md5.New, []byte(f.password))
+		h.Write([]byte(challenge))
+		f.setAuth("CRAM-MD5")
+		return username == f.username && digest == hex.EncodeToString(h.Sum(nil))
+	default:
+		return false
+	}
+	f.setAuth(strings.ToUpper(mechanism))
+	return username == f.username && password == f.password
+}
+
+func (f *fakeSmtpServer) challenge(tp *textproto.Conn, challenge string) string {
+	_ = tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+	line, _ := tp.ReadLine()
+	decoded, _ := base64.StdEncoding.DecodeString(line)
+	return string(decoded)
+}
+
+func (f *fakeSmtpServer) setAuth(mechanism string) {
+	f.mu.Lock()
+	f.mail.auth = mechanism
+	f.mu.Unlock()
+}
+
+// received returns the last mail and forgets it.
+func (f *fakeSmtpServer) received() fakeSmtpMail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mail := f.mail
+	f.mail = fakeSmtpMail{}
+	return mail
+}
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1 and localhost, and its PEM.
+func newTestCertificate(t *testing.T) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.Nil(t, err)
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, string(certPem)
+}
+
+func sendTestSmtpMail(config []byte) error {
+	mailer := &SmtpMailer{}
+	mailer.SetConfig(config)
+	return mailer.Send("Test User", "testuser@example.com", "recipient@example.com", "Test Subject", "Hello, this is a test email.")
+}
+
+func TestSmtpMailerSend(t *testing.T) {
+	server := newFakeSmtpServer(t, nil, false, "", "")
+
+	err := sendTestSmtpMail(server.config(t, SmtpConfig{Encryption: SmtpEncryptionNone}))
+	assert.Nil(t, err)
+	mail := server.received()
+	assert.Equal(t, "FROM:<testuser@example.com>", mail.from)
+	assert.Equal(t, "TO:<recipient@example.com>", mail.to)
+	assert.Contains(t, mail.data, "Subject: Test Subject")
+	assert.Contains(t, mail.data, "Hello, this is a test email.")
+	assert.Equal(t, "", mail.auth)
+}
+
+func TestSmtpMailerSendEnvelope(t *testing.T) {
+	server := newFakeSmtpServer(t, nil, false, "", "")
+	mailer := &SmtpMailer{}
+	mailer.SetConfig(server.config(t, SmtpConfig{Encryption: SmtpEncryptionNone}))
+
+	result, err := mailer.SendEnvelope(&EmailEnvelope{
+		FromName:  "Test User",
+		FromEmail: "testuser@example.com",
+		To:        "recipient@example.com",
+		Cc:        []string{"cc@example.com"},
+		Bcc:       []string{"bcc@example.com"},
+		ReplyTo:   "support@example.com",
+		Subject:   "Test Subject",
+		Message:   "Hello",
+		Headers:   map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>", "X-Campaign": "42"},
+		Tags:      []string{"weekly"},
+	})
+	assert.Nil(t, err)
+	mail := server.received()
+	assert.Equal(t, []string{"TO:<recipient@example.com>", "TO:<cc@example.com>", "TO:<bcc@example.com>"}, mail.rcpt)
+	assert.Contains(t, mail.data, "Cc: cc@example.com\n")
+	assert.Contains(t, mail.data, "Reply-To: support@example.com\n")
+	assert.Contains(t, mail.data, "List-Unsubscribe: <https://example.com/unsubscribe>\nX-Campaign: 42\n")
+	assert.NotContains(t, mail.data, "bcc@example.com")
+	assert.NotEmpty(t, result.MessageID)
+	assert.Contains(t, mail.data, "Message-ID: <"+result.MessageID+">\n")
+
+	// Test headers set by the envelope fields can't be custom headers
+	_, err = mailer.SendEnvelope(&EmailEnvelope{
+		FromEmail: "testuser@example.com",
+		To:        "recipient@example.com",
+		Headers:   map[string]string{"bcc": "hidden@example.com"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestSmtpMailerStartTLS(t *testing.T) {
+	serverTLS, ca := newTestCertificate(t)
+	server := newFakeSmtpServer(t, serverTLS, false, "username", "password")
+
+	config := SmtpConfig{Encryption: SmtpEncryptionStartTLS, Username: "username", Password: "password", CACert: ca}
+	err := sendTestSmtpMail(server.config(t, config))
+	assert.Nil(t, err)
+	mail := server.received()
+	assert.True(t, mail.tls, "Mail should be sent after STARTTLS")
+	assert.Equal(t, "PLAIN", mail.auth)
+
+	// Test the certificate is verified
+	config.CACert = ""
+	err = sendTestSmtpMail(server.config(t, config))
+	assert.NotNil(t, err)
+	config.InsecureSkipVerify = true
+	err = sendTestSmtpMail(server.config(t, config))
+	assert.Nil(t, err)
+
+	// Test "tls" of stored services is STARTTLS, in any case
+	config.Encryption = "TLS"
+	err = sendTestSmtpMail(server.config(t, config))
+	assert.Nil(t, err)
+	assert.True(t, server.received().tls)
+
+	// Test STARTTLS is required when it's set, and optional by "tls"
+	plain := newFakeSmtpServer(t, nil, false, "", "")
+	err = sendTestSmtpMail(plain.config(t, SmtpConfig{Encryption: SmtpEncryptionStartTLS}))
+	assert.NotNil(t, err)
+	err = sendTestSmtpMail(plain.config(t, SmtpConfig{Encryption: SmtpEncryptionTLS}))
+	assert.Nil(t, err)
+	assert.False(t, plain.received().tls)
+}
+
+func TestSmtpMailerImplicitTLS(t *testing.T) {
+	serverTLS, ca := newTestCertificate(t)
+	server := newFakeSmtpServer(t, serverTLS, true, "username", "password")
+
+	config := SmtpConfig{Encryption: SmtpEncryptionSSL, Auth: SmtpAuthLogin, Username: "username", Password: "password", CACert: ca}
+	err := sendTestSmtpMail(server.config(t, config))
+	assert.Nil(t, err)
+	mail := server.received()
+	assert.True(t, mail.tls)
+	assert.Equal(t, "LOGIN", mail.auth)
+
+	// Test wrong credentials
+	config.Password = "wrong"
+	err = sendTestSmtpMail(server.config(t, config))
+	assert.NotNil(t, err)
+}
+
+func TestSmtpMailerAuth(t *testing.T) {
+	server := newFakeSmtpServer(t, nil, false, "username", "password")
+
+	config := SmtpConfig{Encryption: SmtpEncryptionNone, Auth: SmtpAuthCramMD5, Username: "username", Password: "password"}
+	err := sendTestSmtpMail(server.config(t, config))
+	assert.Nil(t, err)
+	assert.Equal(t, "CRAM-MD5", server.received().auth)
+
+	// Test no auth for internal relays
+	err = sendTestSmtpMail(server.config(t, SmtpConfig{Encryption: SmtpEncryptionNone, Auth: SmtpAuthNone, Username: "username"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "", server.received().auth)
+
+	// Test invalid settings
+	err = sendTestSmtpMail(server.config(t, SmtpConfig{Auth: "unknown"}))
+	assert.NotNil(t, err)
+	err = sendTestSmtpMail(server.config(t, SmtpConfig{Encryption: "unknown"}))
+	assert.NotNil(t, err)
+}
+
+func TestSmtpMailerTimeout(t *testing.T) {
+	// A server that never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_, _ = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+	}()
+	server := &fakeSmtpServer{listener: listener}
+
+	start := time.Now()
+	err = sendTestSmtpMail(server.config(t, SmtpConfig{SendTimeout: "100ms"}))
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	err = sendTestSmtpMail(server.config(t, SmtpConfig{SendTimeout: "soon"}))
+	assert.NotNil(t, err)
+
+	// Test a canceled send stops before the send timeout
+	mailer := &SmtpMailer{}
+	mailer.SetConfig(server.config(t, SmtpConfig{SendTimeout: "1m"}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = mailer.SendEnvelopeContext(ctx, newEnvelope("Test User", "testuser@example.com", "recipient@example.com", "Test Subject", "Hello", nil))
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
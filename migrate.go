@@ -1,9 +1,16 @@
 package go_notifier_core
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"github.com/milito-78/go-notifier-core/migrations"
 	"gorm.io/gorm"
+	"io"
 	"log"
+	"time"
 )
 
 type migrator interface {
@@ -11,62 +18,439 @@ type migrator interface {
 }
 
 type migratorRollback interface {
-	rollback() error
+	rollback(steps int) error
+	reset() error
 }
 
+// gormMigrator applies the migrations list and records every applied migration in the history table
+// with a batch number. Each call of migrate is a new batch.
+// In dry-run mode (out is set) the statements changing the schema or the history table are written to out
+// instead of being executed, while the statements reading the schema still run on the database.
+// list returns the migrations list on a database, so a migration runs in the transaction of its history row.
 type gormMigrator struct {
-	db gorm.Migrator
+	db         *gorm.DB
+	migrations []migrations.Migration
+	list       func(db *gorm.DB) []migrations.Migration
+	out        io.Writer
 }
 
-func (g gormMigrator) rollback() error {
-	mgs := migrations.GetMigrationsList(g.db)
-	for i := len(mgs) - 1; i >= 0; i-- {
-		migration := mgs[i]
-		err := migration.Down()
+// MigrateOption customizes a migrate, rollback or reset run.
+type MigrateOption func(g *gormMigrator)
+
+// DryRun writes the SQL of the run to w instead of executing it, so it can be reviewed before
+// it's applied to the database. Nothing is changed in the database, including the history table.
+func DryRun(w io.Writer) MigrateOption {
+	return func(g *gormMigrator) {
+		g.out = w
+	}
+}
+
+func newGormMigrator(db *gorm.DB, opts ...MigrateOption) gormMigrator {
+	g := gormMigrator{db: db, list: migrations.GetMigrationsList}
+	for _, opt := range opts {
+		opt(&g)
+	}
+	if g.out != nil {
+		g.db = dryRunSession(db, g.out)
+	}
+	g.migrations = g.list(g.db)
+	return g
+}
+
+// dryRunConnPool writes the executed statements to out and passes the queries through to the connection pool.
+type dryRunConnPool struct {
+	gorm.ConnPool
+	dialector gorm.Dialector
+	out       io.Writer
+}
+
+func (p *dryRunConnPool) ExecContext(_ context.Context, query string, args ...interface{}) (sql.Result, error) {
+	_, err := fmt.Fprintf(p.out, "%s;\n", p.dialector.Explain(query, args...))
+	return driver.RowsAffected(0), err
+}
+
+// BeginTx writes the start of a transaction, some drivers change the schema in a transaction (e.g. SQLite drops
+// a column by rebuilding the table).
+func (p *dryRunConnPool) BeginTx(ctx context.Context, _ *sql.TxOptions) (gorm.ConnPool, error) {
+	_, err := p.ExecContext(ctx, "BEGIN")
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunTx{p}, nil
+}
+
+// dryRunTx is a transaction started on a dryRunConnPool.
+type dryRunTx struct {
+	*dryRunConnPool
+}
+
+func (t *dryRunTx) Commit() error {
+	_, err := t.ExecContext(context.Background(), "COMMIT")
+	return err
+}
+
+func (t *dryRunTx) Rollback() error {
+	_, err := t.ExecContext(context.Background(), "ROLLBACK")
+	return err
+}
+
+// dryRunSession returns a session of db that writes its statements to out instead of executing them.
+func dryRunSession(db *gorm.DB, out io.Writer) *gorm.DB {
+	tx := db.Session(&gorm.Session{Context: context.Background()})
+	tx.Statement.ConnPool = &dryRunConnPool{ConnPool: tx.Statement.ConnPool, dialector: tx.Dialector, out: out}
+	return tx
+}
+
+// history returns the applied migrations ordered by the time they were applied.
+// It returns no migrations when the history table doesn't exist yet.
+func (g gormMigrator) history() ([]migrations.NotifierMigration, error) {
+	if !g.db.Migrator().HasTable(&migrations.NotifierMigration{}) {
+		return nil, nil
+	}
+	var applied []migrations.NotifierMigration
+	err := g.db.Order("id asc").Find(&applied).Error
+	return applied, err
+}
+
+// save inserts the record into the history table.
+func (g gormMigrator) save(record *migrations.NotifierMigration) error {
+	if g.out == nil {
+		return g.db.Create(record).Error
+	}
+	stmt := g.db.Session(&gorm.Session{DryRun: true, SkipDefaultTransaction: true}).Create(record).Statement
+	return g.write(stmt)
+}
+
+// forget removes the record from the history table.
+func (g gormMigrator) forget(record *migrations.NotifierMigration) error {
+	if g.out == nil {
+		return g.db.Delete(record).Error
+	}
+	stmt := g.db.Session(&gorm.Session{DryRun: true, SkipDefaultTransaction: true}).Delete(record).Statement
+	return g.write(stmt)
+}
+
+// write writes the SQL of a dry-run statement to out.
+func (g gormMigrator) write(stmt *gorm.Statement) error {
+	_, err := fmt.Fprintf(g.out, "%s;\n", g.db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...))
+	return err
+}
+
+// comment writes an SQL comment to out in dry-run mode, to separate the statements of migrations.
+func (g gormMigrator) comment(format string, args ...interface{}) error {
+	if g.out == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(g.out, "-- "+format+"\n", args...)
+	return err
+}
+
+// transactional reports whether the schema changes of the database are transactional, as on PostgreSQL and
+// SQLite. MySQL commits every DDL statement, so its migrations can't be rolled back with their history row.
+func (g gormMigrator) transactional() bool {
+	name := g.db.Dialector.Name()
+	return g.list != nil && (name == "postgres" || name == "sqlite")
+}
+
+// run runs fn with the migration of id. On a transactional database fn runs in a transaction with a migrator
+// and a migration bound to it, so a migration and the change of its history row are committed together: a run
+// that fails or crashes between them doesn't leave a migration applied but not recorded, or the reverse.
+func (g gormMigrator) run(id string, fn func(m gormMigrator, migration migrations.Migration) error) error {
+	if !g.transactional() {
+		return fn(g, g.find(id))
+	}
+	return g.connection(func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			m := g
+			m.db = tx.Session(&gorm.Session{NewDB: true})
+			m.migrations = g.list(m.db)
+			return fn(m, m.find(id))
+		})
+	})
+}
+
+// connection runs fn on a single connection of the database. SQLite rebuilds a table to alter or drop its
+// columns, and its migrator turns off foreign keys meanwhile so the rows referencing the table are kept; that
+// pragma has no effect in a transaction, so foreign keys are turned off on the connection before it starts.
+func (g gormMigrator) connection(fn func(db *gorm.DB) error) error {
+	if g.db.Dialector.Name() != "sqlite" || g.out != nil {
+		return fn(g.db)
+	}
+	return g.db.Connection(func(conn *gorm.DB) error {
+		conn = conn.Session(&gorm.Session{NewDB: true})
+		var enabled int
+		err := conn.Raw("PRAGMA foreign_keys").Scan(&enabled).Error
+		if err != nil {
+			return err
+		}
+		if enabled == 0 {
+			return fn(conn)
+		}
+		err = conn.Exec("PRAGMA foreign_keys = OFF").Error
 		if err != nil {
 			return err
 		}
+		err = fn(conn)
+		er := conn.Exec("PRAGMA foreign_keys = ON").Error
+		if err == nil {
+			err = er
+		}
+		return err
+	})
+}
+
+// status returns the state of every migration of the migrations list, in the order they are applied.
+func (g gormMigrator) status() ([]MigrationState, error) {
+	applied, err := g.history()
+	if err != nil {
+		return nil, MigrationError{Action: "status", Err: err}
 	}
-	return nil
+	records := make(map[string]migrations.NotifierMigration, len(applied))
+	for _, record := range applied {
+		records[record.Migration] = record
+	}
+
+	states := make([]MigrationState, len(g.migrations))
+	for i, migration := range g.migrations {
+		states[i] = MigrationState{Migration: migration.ID()}
+		if record, ok := records[migration.ID()]; ok {
+			appliedAt := record.AppliedAt
+			states[i].Applied = true
+			states[i].Batch = record.Batch
+			states[i].AppliedAt = &appliedAt
+		}
+	}
+	return states, nil
 }
 
 func (g gormMigrator) migrate() error {
-	mgs := migrations.GetMigrationsList(g.db)
-	for _, migration := range mgs {
-		err := migration.Up()
+	applied, err := g.history()
+	if err != nil {
+		return MigrationError{Action: "migrate", Err: err}
+	}
+	err = migrations.CreateHistoryTable(g.db.Migrator())
+	if err != nil {
+		return MigrationError{Action: "migrate", Err: err}
+	}
+
+	batch := 1
+	done := make(map[string]bool, len(applied))
+	for _, record := range applied {
+		done[record.Migration] = true
+		if record.Batch >= batch {
+			batch = record.Batch + 1
+		}
+	}
+
+	for _, migration := range g.migrations {
+		if done[migration.ID()] {
+			continue
+		}
+		err := g.comment("migrate: %s", migration.ID())
 		if err != nil {
-			return err
+			return MigrationError{Action: "migrate", Migration: migration.ID(), Err: err}
+		}
+		err = g.run(migration.ID(), func(m gormMigrator, migration migrations.Migration) error {
+			err := migration.Up()
+			if err != nil {
+				return err
+			}
+			return m.save(&migrations.NotifierMigration{
+				Migration: migration.ID(),
+				Batch:     batch,
+				AppliedAt: time.Now(),
+			})
+		})
+		if err != nil {
+			return MigrationError{Action: "migrate", Migration: migration.ID(), Err: err}
+		}
+		if g.out == nil {
+			log.Printf("Migrated : %s\n", migration.ID())
 		}
 	}
 	return nil
 }
 
-func Migrate(config DbConfig) {
-	m := driverFactory(config)
-	err := m.migrate()
+// rollback reverts the last batch when steps is zero, otherwise the last steps applied migrations.
+func (g gormMigrator) rollback(steps int) error {
+	applied, err := g.history()
 	if err != nil {
-		log.Fatalf("Error during migrate : %s\n", err)
+		return MigrationError{Action: "rollback", Err: err}
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+
+	var revert []migrations.NotifierMigration
+	if steps <= 0 {
+		lastBatch := applied[len(applied)-1].Batch
+		for _, record := range applied {
+			if record.Batch == lastBatch {
+				revert = append(revert, record)
+			}
+		}
+	} else if steps < len(applied) {
+		revert = applied[len(applied)-steps:]
 	} else {
-		log.Println("Migration runs successfully")
+		revert = applied
 	}
+	return g.revert(revert)
 }
 
-func MigrateRollback(config DbConfig) {
-	m := rollbackDriverFactory(config)
-	err := m.rollback()
+// reset reverts every applied migration.
+func (g gormMigrator) reset() error {
+	applied, err := g.history()
 	if err != nil {
-		log.Fatalf("Error during rollback : %s\n", err)
-	} else {
-		log.Println("Migration rollback runs successfully")
+		return MigrationError{Action: "rollback", Err: err}
+	}
+	return g.revert(applied)
+}
+
+// revert runs Down of the records in reverse order and removes them from the history table.
+func (g gormMigrator) revert(records []migrations.NotifierMigration) error {
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		migration := g.find(record.Migration)
+		if migration == nil {
+			return MigrationError{Action: "rollback", Migration: record.Migration, Err: errors.New("migration not found in migrations list")}
+		}
+		err := g.comment("rollback: %s", record.Migration)
+		if err != nil {
+			return MigrationError{Action: "rollback", Migration: record.Migration, Err: err}
+		}
+		err = g.run(record.Migration, func(m gormMigrator, migration migrations.Migration) error {
+			err := migration.Down()
+			if err != nil {
+				return err
+			}
+			return m.forget(&record)
+		})
+		if err != nil {
+			return MigrationError{Action: "rollback", Migration: record.Migration, Err: err}
+		}
+		if g.out == nil {
+			log.Printf("Rolled back : %s\n", record.Migration)
+		}
+	}
+	return nil
+}
+
+func (g gormMigrator) find(id string) migrations.Migration {
+	for _, migration := range g.migrations {
+		if migration.ID() == id {
+			return migration
+		}
+	}
+	return nil
+}
+
+// MigrationState is the state of a migration of the migrations list. Batch and AppliedAt are set only
+// when the migration is applied.
+type MigrationState struct {
+	Migration string
+	Applied   bool
+	Batch     int
+	AppliedAt *time.Time
+}
+
+// MigrationStatus returns the applied or pending state of every migration on the database of the config,
+// in the order they are applied.
+func MigrationStatus(config DbConfig) ([]MigrationState, error) {
+	db, err := dbFactory(config)
+	if err != nil {
+		return nil, err
+	}
+	return newGormMigrator(db).status()
+}
+
+// Migrate applies the pending migrations on the database of the config as a new batch.
+// Pass DryRun to get the SQL of the pending migrations without applying them.
+// It returns a ConnectionError when the database isn't reachable and a MigrationError when a migration fails.
+func Migrate(config DbConfig, opts ...MigrateOption) error {
+	m, err := driverFactory(config, opts...)
+	if err != nil {
+		return err
 	}
+	err = m.migrate()
+	if err != nil {
+		return err
+	}
+	log.Println("Migration runs successfully")
+	return nil
+}
+
+// MigrateRollback reverts the last batch of migrations on the database of the config.
+// It returns a ConnectionError when the database isn't reachable and a MigrationError when a rollback fails.
+func MigrateRollback(config DbConfig, opts ...MigrateOption) error {
+	return MigrateRollbackSteps(config, 0, opts...)
 }
 
-func driverFactory(config DbConfig) migrator {
-	db := getDriver(config)
-	return gormMigrator{db: db.(*gorm.DB).Migrator()}
+// MigrateRollbackSteps reverts the last steps applied migrations on the database of the config.
+// Zero steps reverts the last batch.
+func MigrateRollbackSteps(config DbConfig, steps int, opts ...MigrateOption) error {
+	m, err := rollbackDriverFactory(config, opts...)
+	if err != nil {
+		return err
+	}
+	err = m.rollback(steps)
+	if err != nil {
+		return err
+	}
+	log.Println("Migration rollback runs successfully")
+	return nil
+}
+
+// MigrateReset reverts every applied migration on the database of the config.
+func MigrateReset(config DbConfig, opts ...MigrateOption) error {
+	m, err := rollbackDriverFactory(config, opts...)
+	if err != nil {
+		return err
+	}
+	err = m.reset()
+	if err != nil {
+		return err
+	}
+	log.Println("Migration reset runs successfully")
+	return nil
 }
 
-func rollbackDriverFactory(config DbConfig) migratorRollback {
-	db := getDriver(config)
-	return gormMigrator{db: db.(*gorm.DB).Migrator()}
+// MigrationStatus returns the applied or pending state of every migration on the database of the notifier.
+func (n *Notifier) MigrationStatus() ([]MigrationState, error) {
+	return newGormMigrator(n.db).status()
+}
+
+// Migrate applies the pending migrations on the database of the notifier as a new batch.
+func (n *Notifier) Migrate(opts ...MigrateOption) error {
+	return newGormMigrator(n.db, opts...).migrate()
+}
+
+// MigrateRollback reverts the last batch of migrations on the database of the notifier.
+func (n *Notifier) MigrateRollback(opts ...MigrateOption) error {
+	return newGormMigrator(n.db, opts...).rollback(0)
+}
+
+// MigrateRollbackSteps reverts the last steps applied migrations on the database of the notifier.
+func (n *Notifier) MigrateRollbackSteps(steps int, opts ...MigrateOption) error {
+	return newGormMigrator(n.db, opts...).rollback(steps)
+}
+
+// MigrateReset reverts every applied migration on the database of the notifier.
+func (n *Notifier) MigrateReset(opts ...MigrateOption) error {
+	return newGormMigrator(n.db, opts...).reset()
+}
+
+func driverFactory(config DbConfig, opts ...MigrateOption) (migrator, error) {
+	db, err := dbFactory(config)
+	if err != nil {
+		return nil, err
+	}
+	return newGormMigrator(db, opts...), nil
+}
+
+func rollbackDriverFactory(config DbConfig, opts ...MigrateOption) (migratorRollback, error) {
+	db, err := dbFactory(config)
+	if err != nil {
+		return nil, err
+	}
+	return newGormMigrator(db, opts...), nil
 }
@@ -1,15 +1,24 @@
 package go_notifier_core
 
 import (
+	"bytes"
+	"github.com/milito-78/go-notifier-core/migrations"
+	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
 	"testing"
+	"time"
 )
 
 type mockMigration struct {
+	id         string
 	upCalled   bool
 	downCalled bool
 }
 
+func (m *mockMigration) ID() string {
+	return m.id
+}
+
 func (m *mockMigration) Up() error {
 	m.upCalled = true
 	return nil
@@ -85,3 +94,373 @@ func TestMockRollback(t *testing.T) {
 		t.Error("Expected Down() to be called on the second migration")
 	}
 }
+
+func newHistoryTestMigrator(t *testing.T, name string, mgs ...*mockMigration) gormMigrator {
+	db, err := dbFactory(DbConfig{Name: name, Driver: SqliteDriver, DB: ":memory:"})
+	if err != nil {
+		t.Fatalf("Error during connect : %s", err)
+	}
+	list := make([]migrations.Migration, len(mgs))
+	for i, migration := range mgs {
+		list[i] = migration
+	}
+	return gormMigrator{db: db, migrations: list}
+}
+
+// rollbackStepsTo returns the steps which roll back the migrations down to and including id.
+func rollbackStepsTo(t *testing.T, db *gorm.DB, id string) int {
+	list := migrations.GetMigrationsList(db)
+	for i, migration := range list {
+		if migration.ID() == id {
+			return len(list) - i
+		}
+	}
+	t.Fatalf("Unknown migration %s", id)
+	return 0
+}
+
+func TestGormMigratorHistory(t *testing.T) {
+	first := &mockMigration{id: "0001_first"}
+	second := &mockMigration{id: "0002_second"}
+	m := newHistoryTestMigrator(t, "sqlite history", first, second)
+
+	assert.Nil(t, m.migrate())
+	assert.True(t, first.upCalled)
+	assert.True(t, second.upCalled)
+
+	// Test applied migrations are skipped and new ones get a new batch
+	first.upCalled, second.upCalled = false, false
+	third := &mockMigration{id: "0003_third"}
+	m.migrations = append(m.migrations, third)
+	assert.Nil(t, m.migrate())
+	assert.False(t, first.upCalled, "Applied migration should not run again")
+	assert.True(t, third.upCalled)
+
+	applied, err := m.history()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 3)
+	assert.Equal(t, 1, applied[0].Batch)
+	assert.Equal(t, 1, applied[1].Batch)
+	assert.Equal(t, 2, applied[2].Batch)
+
+	// Test rollback reverts only the last batch
+	assert.Nil(t, m.rollback(0))
+	assert.True(t, third.downCalled)
+	assert.False(t, second.downCalled)
+
+	// Test rollback by steps
+	assert.Nil(t, m.rollback(1))
+	assert.True(t, second.downCalled)
+	assert.False(t, first.downCalled)
+
+	applied, err = m.history()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 1)
+	assert.Equal(t, "0001_first", applied[0].Migration)
+}
+
+func TestGormMigratorReset(t *testing.T) {
+	first := &mockMigration{id: "0001_first"}
+	second := &mockMigration{id: "0002_second"}
+	m := newHistoryTestMigrator(t, "sqlite reset", first, second)
+
+	assert.Nil(t, m.migrate())
+	assert.Nil(t, m.reset())
+	assert.True(t, first.downCalled)
+	assert.True(t, second.downCalled)
+
+	applied, err := m.history()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 0)
+
+	// Test unknown migrations in the history table
+	assert.Nil(t, m.migrate())
+	m.migrations = m.migrations[:1]
+	err = m.rollback(0)
+	assert.ErrorAs(t, err, &MigrationError{})
+}
+
+// txMigration creates a table on its database. A failing txMigration breaks the change of its history row
+// after its schema change.
+type txMigration struct {
+	id   string
+	db   *gorm.DB
+	fail bool
+}
+
+func (m txMigration) ID() string {
+	return m.id
+}
+
+func (m txMigration) Up() error {
+	err := m.db.Exec("CREATE TABLE notifier_transaction_tests (id integer)").Error
+	if err != nil || !m.fail {
+		return err
+	}
+	return m.db.Create(&migrations.NotifierMigration{Migration: m.id, Batch: 1, AppliedAt: time.Now()}).Error
+}
+
+func (m txMigration) Down() error {
+	err := m.db.Exec("DROP TABLE notifier_transaction_tests").Error
+	if err != nil || !m.fail {
+		return err
+	}
+	return m.db.Migrator().DropTable(&migrations.NotifierMigration{})
+}
+
+func TestGormMigratorTransaction(t *testing.T) {
+	db, err := dbFactory(DbConfig{Name: "sqlite migration transaction", Driver: SqliteDriver, DB: ":memory:"})
+	assert.Nil(t, err)
+	fail := true
+	m := gormMigrator{db: db, list: func(db *gorm.DB) []migrations.Migration {
+		return []migrations.Migration{txMigration{id: "0001_transaction", db: db, fail: fail}}
+	}}
+	m.migrations = m.list(db)
+
+	// Test a failed history insert rolls back the migration
+	err = m.migrate()
+	assert.ErrorAs(t, err, &MigrationError{})
+	assert.False(t, db.Migrator().HasTable("notifier_transaction_tests"))
+	applied, err := m.history()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 0)
+
+	fail = false
+	assert.Nil(t, m.migrate())
+	assert.True(t, db.Migrator().HasTable("notifier_transaction_tests"))
+
+	// Test a failed history delete rolls back the rollback of the migration
+	fail = true
+	err = m.rollback(0)
+	assert.ErrorAs(t, err, &MigrationError{})
+	assert.True(t, db.Migrator().HasTable("notifier_transaction_tests"))
+	applied, err = m.history()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 1)
+}
+
+func TestMigrateRealMigrations(t *testing.T) {
+	config := DbConfig{Name: "sqlite real migrations", Driver: SqliteDriver, DB: ":memory:"}
+	assert.Nil(t, Migrate(config))
+	// Test running again is a no-op
+	assert.Nil(t, Migrate(config))
+
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+	assert.True(t, db.Migrator().HasTable("notifier_email_campaigns"))
+
+	assert.Nil(t, MigrateRollbackSteps(config, rollbackStepsTo(t, db, "000017_create_notifier_notification_sub_tags_table")))
+	assert.False(t, db.Migrator().HasTable("notifier_notification_sub_tags"))
+	assert.True(t, db.Migrator().HasTable("notifier_notification_subscribers"))
+
+	assert.Nil(t, MigrateReset(config))
+	assert.False(t, db.Migrator().HasTable("notifier_tags"))
+}
+
+func TestMigrationStatus(t *testing.T) {
+	config := DbConfig{Name: "sqlite migration status", Driver: SqliteDriver, DB: ":memory:"}
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+	list := migrations.GetMigrationsList(db)
+
+	states, err := MigrationStatus(config)
+	assert.Nil(t, err)
+	assert.Len(t, states, len(list))
+	for i, state := range states {
+		assert.Equal(t, list[i].ID(), state.Migration)
+		assert.False(t, state.Applied)
+		assert.Nil(t, state.AppliedAt)
+	}
+
+	assert.Nil(t, Migrate(config))
+	assert.Nil(t, MigrateRollbackSteps(config, 1))
+
+	states, err = MigrationStatus(config)
+	assert.Nil(t, err)
+	last := len(states) - 1
+	for _, state := range states[:last] {
+		assert.True(t, state.Applied, state.Migration)
+		assert.Equal(t, 1, state.Batch)
+		assert.NotNil(t, state.AppliedAt)
+	}
+	assert.False(t, states[last].Applied, "Rolled back migration should be pending")
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	config := DbConfig{Name: "sqlite migration dry run", Driver: SqliteDriver, DB: ":memory:"}
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	assert.Nil(t, Migrate(config, DryRun(&out)))
+	assert.Contains(t, out.String(), "CREATE TABLE `notifier_migrations`")
+	assert.Contains(t, out.String(), "-- migrate: 000001_create_notifier_tags_table")
+	assert.Contains(t, out.String(), "CREATE TABLE `notifier_tags`")
+	assert.Contains(t, out.String(), "INSERT INTO `notifier_migrations`")
+	assert.Contains(t, out.String(), "BEGIN")
+	assert.Contains(t, out.String(), "COMMIT")
+	assert.False(t, db.Migrator().HasTable("notifier_tags"), "Dry run should not create tables")
+	assert.False(t, db.Migrator().HasTable("notifier_migrations"), "Dry run should not create the history table")
+
+	// Test dry run of rollback keeps the tables and the history
+	assert.Nil(t, Migrate(config))
+	out.Reset()
+	assert.Nil(t, MigrateRollback(config, DryRun(&out)))
+	assert.Contains(t, out.String(), "DROP TABLE IF EXISTS `notifier_tags`")
+	assert.Contains(t, out.String(), "DELETE FROM `notifier_migrations`")
+	assert.True(t, db.Migrator().HasTable("notifier_tags"))
+
+	states, err := MigrationStatus(config)
+	assert.Nil(t, err)
+	for _, state := range states {
+		assert.True(t, state.Applied, state.Migration)
+	}
+}
+
+func TestDomainSchemaConsistency(t *testing.T) {
+	config := DbConfig{Name: "sqlite domain schema", Driver: SqliteDriver, DB: ":memory:"}
+	assert.Nil(t, Migrate(config))
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+
+	models := []interface{}{
+		&NotifierCampaign{},
+		&NotifierEmailCampaignTemplate{},
+		&NotifierEmailService{},
+		&NotifierEmailCampaignStatus{},
+		&NotifierEmailCampaign{},
+		&NotifierEmailCampaignTag{},
+		&NotifierEmailUnsubscribeEvent{},
+		&NotifierEmailSubscriber{},
+		&NotifierEmailSubTag{},
+		&NotifierEmailMessage{},
+		&NotifierEmailAttachment{},
+		&NotifierEmailTemplateVersion{},
+		&NotifierEmailLayout{},
+		&NotifierEmailPartial{},
+		&NotifierMobileDriver{},
+		&NotifierMobileCampaign{},
+		&NotifierMobileCampaignTag{},
+		&NotifierMobileMessage{},
+		&NotifierMobileUnsubscribeEvent{},
+		&NotifierMobileSubscriber{},
+		&NotifierMobileSubTag{},
+		&NotifierNotificationService{},
+		&NotifierNotificationCampaign{},
+		&NotifierNotificationCampaignTag{},
+		&NotifierNotificationMessage{},
+		&NotifierNotificationSubscriber{},
+		&NotifierNotificationSubTag{},
+		&NotifierTag{},
+		&NotifierJob{},
+	}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		assert.Nil(t, stmt.Parse(model))
+		if !assert.True(t, db.Migrator().HasTable(model), "Table %s of %s should exist", stmt.Table, stmt.Schema.Name) {
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			assert.True(t, db.Migrator().HasColumn(model, field.DBName), "Column %s.%s of %s.%s should exist", stmt.Table, field.DBName, stmt.Schema.Name, field.Name)
+		}
+	}
+}
+
+func TestPivotMigrations(t *testing.T) {
+	config := DbConfig{Name: "sqlite pivot migrations", Driver: SqliteDriver, DB: ":memory:"}
+	assert.Nil(t, Migrate(config))
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+
+	// Test a pivot table created by AutoMigrate of older versions is rebuilt and keeps its rows
+	assert.Nil(t, MigrateRollbackSteps(config, rollbackStepsTo(t, db, "000014_create_notifier_email_sub_tags_table")))
+	assert.False(t, db.Migrator().HasTable("notifier_email_sub_tags"))
+	assert.Nil(t, db.Exec("CREATE TABLE notifier_email_sub_tags (email_subscriber_id integer, tag_id integer, "+
+		"PRIMARY KEY (email_subscriber_id, tag_id), "+
+		"FOREIGN KEY (email_subscriber_id) REFERENCES notifier_email_subscribers(id), "+
+		"FOREIGN KEY (tag_id) REFERENCES notifier_tags(id))").Error)
+
+	tag := NewNotifierTag("news")
+	assert.Nil(t, db.Create(tag).Error)
+	other := NewNotifierTag("offers")
+	assert.Nil(t, db.Create(other).Error)
+	subscriber := NewNotifierEmailSubscriber("email@test.com", "first", "last")
+	assert.Nil(t, db.Create(subscriber).Error)
+	assert.Nil(t, db.Create(NewNotifierEmailSubTag(subscriber.ID, tag.ID)).Error)
+	assert.Nil(t, db.Create(NewNotifierEmailSubTag(subscriber.ID, other.ID)).Error)
+
+	// Test a rebuild that failed after dropping the table is resumed from the backup it left
+	mobile := NewNotifierMobileSubscriber("98", "9120000000", "first", "last")
+	assert.Nil(t, db.Create(mobile).Error)
+	assert.Nil(t, db.Exec("CREATE TABLE notifier_mobile_sub_tags_backup (mobile_subscriber_id integer, tag_id integer)").Error)
+	assert.Nil(t, db.Exec("INSERT INTO notifier_mobile_sub_tags_backup VALUES (?, ?)", mobile.ID, other.ID).Error)
+
+	assert.Nil(t, Migrate(config))
+	assert.False(t, db.Migrator().HasTable("notifier_email_sub_tags_backup"))
+	assert.False(t, db.Migrator().HasTable("notifier_mobile_sub_tags_backup"))
+	var subTags []NotifierEmailSubTag
+	assert.Nil(t, db.Order("tag_id").Find(&subTags).Error)
+	assert.Equal(t, []NotifierEmailSubTag{*NewNotifierEmailSubTag(subscriber.ID, tag.ID), *NewNotifierEmailSubTag(subscriber.ID, other.ID)},
+		subTags, "Rows of the pivot table should be copied")
+	var mobileSubTags []NotifierMobileSubTag
+	assert.Nil(t, db.Find(&mobileSubTags).Error)
+	assert.Equal(t, []NotifierMobileSubTag{*NewNotifierMobileSubTag(mobile.ID, other.ID)}, mobileSubTags,
+		"Rows of the backup table should be copied")
+
+	// Test a (subscriber, tag) pair is unique
+	assert.NotNil(t, db.Create(NewNotifierEmailSubTag(subscriber.ID, tag.ID)).Error)
+
+	// Test rows are removed with the subscriber
+	assert.Nil(t, db.Delete(subscriber).Error)
+	var count int64
+	db.Model(&NotifierEmailSubTag{}).Count(&count)
+	assert.Equal(t, int64(0), count, "Rows of the pivot table should be removed with the subscriber")
+}
+
+func TestEmailTemplateVersionMigration(t *testing.T) {
+	config := DbConfig{Name: "sqlite template version migration", Driver: SqliteDriver, DB: ":memory:"}
+	assert.Nil(t, Migrate(config))
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+
+	// Test templates stored before versioning get their content as version 1
+	assert.Nil(t, MigrateRollbackSteps(config, rollbackStepsTo(t, db, "000024_add_layout_and_version_to_notifier_email_campaign_templates_table")))
+	assert.False(t, db.Migrator().HasTable("notifier_email_template_versions"))
+	assert.False(t, db.Migrator().HasColumn(&NotifierEmailCampaignTemplate{}, "LayoutId"))
+	assert.Nil(t, db.Exec("INSERT INTO notifier_email_campaign_templates (name, content, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		"welcome", "Hi {{.FirstName}}", time.Now(), time.Now()).Error)
+
+	assert.Nil(t, Migrate(config))
+	var versions []NotifierEmailTemplateVersion
+	assert.Nil(t, db.Find(&versions).Error)
+	assert.Len(t, versions, 1)
+	assert.Equal(t, uint(1), versions[0].Version)
+	assert.Equal(t, "welcome", versions[0].Name)
+	assert.Equal(t, "Hi {{.FirstName}}", versions[0].Content)
+
+	var template NotifierEmailCampaignTemplate
+	assert.Nil(t, db.First(&template).Error)
+	assert.Equal(t, uint(1), template.Version)
+	assert.Nil(t, template.LayoutId)
+}
+
+func TestChannelCampaignParentMigration(t *testing.T) {
+	config := DbConfig{Name: "sqlite channel campaign parent migration", Driver: SqliteDriver, DB: ":memory:"}
+	assert.Nil(t, Migrate(config))
+	db, err := dbFactory(config)
+	assert.Nil(t, err)
+
+	models := []interface{}{&NotifierEmailCampaign{}, &NotifierMobileCampaign{}, &NotifierNotificationCampaign{}}
+	for _, model := range models {
+		assert.True(t, db.Migrator().HasColumn(model, "CampaignId"))
+	}
+	assert.Nil(t, MigrateRollbackSteps(config, rollbackStepsTo(t, db, "000036_add_campaign_id_to_channel_campaigns_tables")))
+	for _, model := range models {
+		assert.False(t, db.Migrator().HasColumn(model, "CampaignId"))
+	}
+	assert.Nil(t, Migrate(config))
+	assert.True(t, db.Migrator().HasColumn(&NotifierMobileCampaign{}, "CampaignId"))
+}
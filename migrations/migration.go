@@ -2,17 +2,39 @@ package migrations
 
 import (
 	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
-type migrator interface {
+// Migration is a schema change. ID is stored in the history table once the migration is applied,
+// so it must be unique and must not change after release.
+type Migration interface {
+	ID() string
 	Up() error
 	Down() error
 }
 
+// NotifierMigration is a row of the history table, recording an applied migration and its batch.
+type NotifierMigration struct {
+	ID        uint64    `gorm:"primarykey"`
+	Migration string    `gorm:"size:255;not null;index:idx_migration,unique"`
+	Batch     int       `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null;type:timestamp"`
+}
+
+// CreateHistoryTable creates the notifier_migrations table if it doesn't exist.
+func CreateHistoryTable(migr gorm.Migrator) error {
+	if !migr.HasTable(&NotifierMigration{}) {
+		return migr.CreateTable(&NotifierMigration{})
+	}
+	return nil
+}
+
+// ModelGorm holds the common columns of tables. UpdatedAt is maintained by gorm on save,
+// so no dialect specific "on update" clause is needed here.
 type ModelGorm struct {
 	CreatedAt time.Time `gorm:"not null;type:timestamp;default:current_timestamp"`
-	UpdatedAt time.Time `gorm:"not null;type:timestamp;default:current_timestamp ON update current_timestamp"`
+	UpdatedAt time.Time `gorm:"not null;type:timestamp;default:current_timestamp"`
 	ID        uint64    `gorm:"primarykey"`
 }
 
@@ -33,16 +55,19 @@ type createTag struct {
 	mg gorm.Migrator
 }
 
+func (c createTag) ID() string {
+	return "000001_create_notifier_tags_table"
+}
+
 func (c createTag) Up() error {
 	if !c.mg.HasTable(&notifierTag{}) {
-		return c.mg.AutoMigrate(&notifierTag{})
+		return c.mg.CreateTable(&notifierTag{})
 	}
 	return nil
 }
 
 func (c createTag) Down() error {
 	if c.mg.HasTable(&notifierTag{}) {
-		_ = c.mg.DropTable("notifier_email_sub_tags")
 		return c.mg.DropTable(&notifierTag{})
 	}
 	return nil
@@ -57,6 +82,10 @@ type createEmailUnsubscribeEvent struct {
 	mg gorm.Migrator
 }
 
+func (c createEmailUnsubscribeEvent) ID() string {
+	return "000002_create_notifier_email_unsubscribe_events_table"
+}
+
 func (c createEmailUnsubscribeEvent) Up() error {
 	if !c.mg.HasTable(&notifierEmailUnsubscribeEvent{}) {
 		return c.mg.CreateTable(&notifierEmailUnsubscribeEvent{})
@@ -86,9 +115,13 @@ type createEmailSubscriber struct {
 	mg gorm.Migrator
 }
 
+func (c createEmailSubscriber) ID() string {
+	return "000003_create_notifier_email_subscribers_table"
+}
+
 func (c createEmailSubscriber) Up() error {
 	if !c.mg.HasTable(&notifierEmailSubscriber{}) {
-		return c.mg.AutoMigrate(&notifierEmailSubscriber{})
+		return c.mg.CreateTable(&notifierEmailSubscriber{})
 	}
 	return nil
 }
@@ -101,7 +134,7 @@ func (c createEmailSubscriber) Down() error {
 }
 
 type notifierEmailService struct {
-	Payload string `gorm:"type=json"`
+	Payload string
 	Type    string `gorm:"size:255;index:idx_type;not null"`
 	Name    string `gorm:"size:255;not null"`
 	ID      uint64 `gorm:"primarykey"`
@@ -111,6 +144,10 @@ type createEmailService struct {
 	mg gorm.Migrator
 }
 
+func (c createEmailService) ID() string {
+	return "000004_create_notifier_email_services_table"
+}
+
 func (c createEmailService) Up() error {
 	if !c.mg.HasTable(&notifierEmailService{}) {
 		return c.mg.CreateTable(&notifierEmailService{})
@@ -147,6 +184,10 @@ type createEmailMessage struct {
 	mg gorm.Migrator
 }
 
+func (c createEmailMessage) ID() string {
+	return "000005_create_notifier_email_messages_table"
+}
+
 func (c createEmailMessage) Up() error {
 	if !c.mg.HasTable(&notifierEmailMessage{}) {
 		return c.mg.CreateTable(&notifierEmailMessage{})
@@ -163,7 +204,7 @@ func (c createEmailMessage) Down() error {
 
 type notifierEmailCampaignTemplate struct {
 	ModelGorm
-	Content string `gorm:"not null;type=longtext"`
+	Content string `gorm:"not null"`
 	Name    string `gorm:"not null;size:255;"`
 }
 
@@ -171,6 +212,10 @@ type createEmailCampaignTemplate struct {
 	mg gorm.Migrator
 }
 
+func (c createEmailCampaignTemplate) ID() string {
+	return "000006_create_notifier_email_campaign_templates_table"
+}
+
 func (c createEmailCampaignTemplate) Up() error {
 	if !c.mg.HasTable(&notifierEmailCampaignTemplate{}) {
 		return c.mg.CreateTable(&notifierEmailCampaignTemplate{})
@@ -194,6 +239,10 @@ type createEmailCampaignStatus struct {
 	mg gorm.Migrator
 }
 
+func (c createEmailCampaignStatus) ID() string {
+	return "000007_create_notifier_email_campaign_statuses_table"
+}
+
 func (c createEmailCampaignStatus) Up() error {
 	if !c.mg.HasTable(&notifierEmailCampaignStatus{}) {
 		return c.mg.CreateTable(&notifierEmailCampaignStatus{})
@@ -221,7 +270,7 @@ type notifierEmailCampaign struct {
 	FromEmail      string                        `gorm:"not null;size:255;"`
 	FromName       string                        `gorm:"not null;size:255;"`
 	Subject        string                        `gorm:"not null;size:255;"`
-	Content        string                        `gorm:"not null;type=longtext"`
+	Content        string                        `gorm:"not null"`
 	Name           string                        `gorm:"not null;size:255;"`
 }
 
@@ -229,9 +278,13 @@ type createEmailCampaign struct {
 	mg gorm.Migrator
 }
 
+func (c createEmailCampaign) ID() string {
+	return "000008_create_notifier_email_campaigns_table"
+}
+
 func (c createEmailCampaign) Up() error {
 	if !c.mg.HasTable(&notifierEmailCampaign{}) {
-		return c.mg.AutoMigrate(&notifierEmailCampaign{})
+		return c.mg.CreateTable(&notifierEmailCampaign{})
 	}
 	return nil
 }
@@ -254,6 +307,10 @@ type createMobileUnsubscribeEvent struct {
 	mg gorm.Migrator
 }
 
+func (c createMobileUnsubscribeEvent) ID() string {
+	return "000009_create_notifier_mobile_unsubscribe_events_table"
+}
+
 func (c createMobileUnsubscribeEvent) Up() error {
 	if !c.mg.HasTable(&notifierMobileUnsubscribeEvent{}) {
 		return c.mg.CreateTable(&notifierMobileUnsubscribeEvent{})
@@ -284,9 +341,13 @@ type createMobileSubscriber struct {
 	mg gorm.Migrator
 }
 
+func (c createMobileSubscriber) ID() string {
+	return "000010_create_notifier_mobile_subscribers_table"
+}
+
 func (c createMobileSubscriber) Up() error {
 	if !c.mg.HasTable(&notifierMobileSubscriber{}) {
-		return c.mg.AutoMigrate(&notifierMobileSubscriber{})
+		return c.mg.CreateTable(&notifierMobileSubscriber{})
 	}
 	return nil
 }
@@ -309,6 +370,10 @@ type createNotificationDriver struct {
 	mg gorm.Migrator
 }
 
+func (c createNotificationDriver) ID() string {
+	return "000011_create_notifier_notification_drivers_table"
+}
+
 func (c createNotificationDriver) Up() error {
 	if !c.mg.HasTable(&notifierNotificationDriver{}) {
 		return c.mg.CreateTable(&notifierNotificationDriver{})
@@ -330,16 +395,20 @@ type notifierNotificationSubscriber struct {
 	LastName  string                     `gorm:"not null;size:255;"`
 	Driver    notifierNotificationDriver `gorm:"foreignKey:DriverId;"`
 	DriverId  uint64                     `gorm:"not null;"`
-	Token     string                     `gorm:"not null;size:144;index:mobile_index"`
+	Token     string                     `gorm:"not null;size:144;index:token_index"`
 }
 
 type createNotificationSubscriber struct {
 	mg gorm.Migrator
 }
 
+func (c createNotificationSubscriber) ID() string {
+	return "000012_create_notifier_notification_subscribers_table"
+}
+
 func (c createNotificationSubscriber) Up() error {
 	if !c.mg.HasTable(&notifierNotificationSubscriber{}) {
-		return c.mg.AutoMigrate(&notifierNotificationSubscriber{})
+		return c.mg.CreateTable(&notifierNotificationSubscriber{})
 	}
 	return nil
 }
@@ -351,20 +420,1211 @@ func (c createNotificationSubscriber) Down() error {
 	return nil
 }
 
-func GetMigrationsList(migr gorm.Migrator) []migrator {
-	var migrations = make([]migrator, 12)
-	migrations[0] = createTag{migr}
-	migrations[1] = createEmailUnsubscribeEvent{migr}
-	migrations[2] = createEmailSubscriber{migr}
-	migrations[3] = createEmailService{migr}
-	migrations[4] = createEmailMessage{migr}
-	migrations[5] = createEmailCampaignTemplate{migr}
-	migrations[6] = createEmailCampaignStatus{migr}
-	migrations[7] = createEmailCampaign{migr}
-	migrations[8] = createMobileUnsubscribeEvent{migr}
-	migrations[9] = createMobileSubscriber{migr}
-	migrations[10] = createNotificationDriver{migr}
-	migrations[11] = createNotificationSubscriber{migr}
+// notifierNotificationDriverService holds the columns added to notifier_notification_drivers to store the
+// driver type and its settings, like notifierEmailService does for email services.
+type notifierNotificationDriverService struct {
+	Payload string
+	Type    string `gorm:"size:255;not null;default:''"`
+}
+
+func (notifierNotificationDriverService) TableName() string {
+	return "notifier_notification_drivers"
+}
+
+type addNotificationDriverService struct {
+	mg gorm.Migrator
+}
+
+func (c addNotificationDriverService) ID() string {
+	return "000013_add_type_and_payload_to_notifier_notification_drivers_table"
+}
+
+func (c addNotificationDriverService) Up() error {
+	for _, column := range []string{"Type", "Payload"} {
+		if c.mg.HasColumn(&notifierNotificationDriverService{}, column) {
+			continue
+		}
+		err := c.mg.AddColumn(&notifierNotificationDriverService{}, column)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c addNotificationDriverService) Down() error {
+	for _, column := range []string{"Payload", "Type"} {
+		if !c.mg.HasColumn(&notifierNotificationDriverService{}, column) {
+			continue
+		}
+		err := c.mg.DropColumn(&notifierNotificationDriverService{}, column)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pivot tables. The primary key is the (subscriber, tag) pair, so a tag is attached to a subscriber once,
+// and the rows are removed with the subscriber or the tag.
+
+type notifierEmailSubTag struct {
+	EmailSubscriber   notifierEmailSubscriber `gorm:"foreignKey:EmailSubscriberId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	EmailSubscriberId uint64                  `gorm:"primaryKey;autoIncrement:false"`
+	Tag               notifierTag             `gorm:"foreignKey:TagId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TagId             uint64                  `gorm:"primaryKey;autoIncrement:false;index:idx_email_sub_tags_tag_id"`
+}
+
+type createEmailSubTag struct {
+	db *gorm.DB
+}
+
+func (c createEmailSubTag) ID() string {
+	return "000014_create_notifier_email_sub_tags_table"
+}
+
+func (c createEmailSubTag) Up() error {
+	return createPivot(c.db, &notifierEmailSubTag{}, "notifier_email_sub_tags", "email_subscriber_id", "tag_id")
+}
+
+func (c createEmailSubTag) Down() error {
+	return dropPivot(c.db, &notifierEmailSubTag{})
+}
+
+type notifierEmailCampaignTag struct {
+	Campaign   notifierEmailCampaign `gorm:"foreignKey:CampaignId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CampaignId uint64                `gorm:"primaryKey;autoIncrement:false"`
+	Tag        notifierTag           `gorm:"foreignKey:TagId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TagId      uint64                `gorm:"primaryKey;autoIncrement:false;index:idx_email_campaign_tags_tag_id"`
+}
+
+type createEmailCampaignTag struct {
+	db *gorm.DB
+}
+
+func (c createEmailCampaignTag) ID() string {
+	return "000015_create_notifier_email_campaign_tags_table"
+}
+
+func (c createEmailCampaignTag) Up() error {
+	return createPivot(c.db, &notifierEmailCampaignTag{}, "notifier_email_campaign_tags", "campaign_id", "tag_id")
+}
+
+func (c createEmailCampaignTag) Down() error {
+	return dropPivot(c.db, &notifierEmailCampaignTag{})
+}
+
+type notifierMobileSubTag struct {
+	MobileSubscriber   notifierMobileSubscriber `gorm:"foreignKey:MobileSubscriberId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	MobileSubscriberId uint64                   `gorm:"primaryKey;autoIncrement:false"`
+	Tag                notifierTag              `gorm:"foreignKey:TagId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TagId              uint64                   `gorm:"primaryKey;autoIncrement:false;index:idx_mobile_sub_tags_tag_id"`
+}
+
+type createMobileSubTag struct {
+	db *gorm.DB
+}
+
+func (c createMobileSubTag) ID() string {
+	return "000016_create_notifier_mobile_sub_tags_table"
+}
+
+func (c createMobileSubTag) Up() error {
+	return createPivot(c.db, &notifierMobileSubTag{}, "notifier_mobile_sub_tags", "mobile_subscriber_id", "tag_id")
+}
+
+func (c createMobileSubTag) Down() error {
+	return dropPivot(c.db, &notifierMobileSubTag{})
+}
+
+type notifierNotificationSubTag struct {
+	NotificationSubscriber   notifierNotificationSubscriber `gorm:"foreignKey:NotificationSubscriberId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	NotificationSubscriberId uint64                         `gorm:"primaryKey;autoIncrement:false"`
+	Tag                      notifierTag                    `gorm:"foreignKey:TagId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TagId                    uint64                         `gorm:"primaryKey;autoIncrement:false;index:idx_notification_sub_tags_tag_id"`
+}
+
+type createNotificationSubTag struct {
+	db *gorm.DB
+}
+
+func (c createNotificationSubTag) ID() string {
+	return "000017_create_notifier_notification_sub_tags_table"
+}
+
+func (c createNotificationSubTag) Up() error {
+	return createPivot(c.db, &notifierNotificationSubTag{}, "notifier_notification_sub_tags", "notification_subscriber_id", "tag_id")
+}
+
+func (c createNotificationSubTag) Down() error {
+	return dropPivot(c.db, &notifierNotificationSubTag{})
+}
+
+type notifierMobileDriver struct {
+	Payload string
+	Type    string `gorm:"size:255;not null"`
+	Name    string `gorm:"size:255;not null"`
+	ID      uint64 `gorm:"primarykey"`
+}
+
+type createMobileDriver struct {
+	mg gorm.Migrator
+}
+
+func (c createMobileDriver) ID() string {
+	return "000018_create_notifier_mobile_drivers_table"
+}
+
+func (c createMobileDriver) Up() error {
+	if !c.mg.HasTable(&notifierMobileDriver{}) {
+		return c.mg.CreateTable(&notifierMobileDriver{})
+	}
+	return nil
+}
+
+func (c createMobileDriver) Down() error {
+	if c.mg.HasTable(&notifierMobileDriver{}) {
+		return c.mg.DropTable(&notifierMobileDriver{})
+	}
+	return nil
+}
+
+type notifierEmailAttachment struct {
+	ModelGorm
+	Campaign    *notifierEmailCampaign         `gorm:"foreignKey:CampaignId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CampaignId  *uint64                        `gorm:"index:idx_email_attachments_campaign_id"`
+	Template    *notifierEmailCampaignTemplate `gorm:"foreignKey:TemplateId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TemplateId  *uint64                        `gorm:"index:idx_email_attachments_template_id"`
+	Message     *notifierEmailMessage          `gorm:"foreignKey:MessageId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	MessageId   *uint64                        `gorm:"index:idx_email_attachments_message_id"`
+	Name        string                         `gorm:"size:255;not null"`
+	ContentType string                         `gorm:"size:255;not null"`
+	Content     []byte
+	Path        string `gorm:"size:2048;not null"`
+	ContentId   string `gorm:"size:255;not null"`
+	Inline      bool   `gorm:"not null"`
+}
+
+type createEmailAttachment struct {
+	mg gorm.Migrator
+}
+
+func (c createEmailAttachment) ID() string {
+	return "000019_create_notifier_email_attachments_table"
+}
+
+func (c createEmailAttachment) Up() error {
+	if !c.mg.HasTable(&notifierEmailAttachment{}) {
+		return c.mg.CreateTable(&notifierEmailAttachment{})
+	}
+	return nil
+}
+
+func (c createEmailAttachment) Down() error {
+	if c.mg.HasTable(&notifierEmailAttachment{}) {
+		return c.mg.DropTable(&notifierEmailAttachment{})
+	}
+	return nil
+}
+
+type notifierEmailMessageProviderId struct {
+	ProviderMessageId string `gorm:"size:255;not null;default:'';index:idx_email_messages_provider_message_id"`
+}
+
+func (notifierEmailMessageProviderId) TableName() string {
+	return "notifier_email_messages"
+}
+
+type addEmailMessageProviderId struct {
+	mg gorm.Migrator
+}
+
+func (c addEmailMessageProviderId) ID() string {
+	return "000020_add_provider_message_id_to_notifier_email_messages_table"
+}
+
+func (c addEmailMessageProviderId) Up() error {
+	if !c.mg.HasColumn(&notifierEmailMessageProviderId{}, "ProviderMessageId") {
+		err := c.mg.AddColumn(&notifierEmailMessageProviderId{}, "ProviderMessageId")
+		if err != nil {
+			return err
+		}
+	}
+	if !c.mg.HasIndex(&notifierEmailMessageProviderId{}, "idx_email_messages_provider_message_id") {
+		return c.mg.CreateIndex(&notifierEmailMessageProviderId{}, "idx_email_messages_provider_message_id")
+	}
+	return nil
+}
+
+func (c addEmailMessageProviderId) Down() error {
+	if c.mg.HasIndex(&notifierEmailMessageProviderId{}, "idx_email_messages_provider_message_id") {
+		err := c.mg.DropIndex(&notifierEmailMessageProviderId{}, "idx_email_messages_provider_message_id")
+		if err != nil {
+			return err
+		}
+	}
+	if c.mg.HasColumn(&notifierEmailMessageProviderId{}, "ProviderMessageId") {
+		return c.mg.DropColumn(&notifierEmailMessageProviderId{}, "ProviderMessageId")
+	}
+	return nil
+}
+
+type notifierEmailCampaignVariables struct {
+	Variables string `gorm:"type:text"`
+}
+
+func (notifierEmailCampaignVariables) TableName() string {
+	return "notifier_email_campaigns"
+}
+
+type addEmailCampaignVariables struct {
+	mg gorm.Migrator
+}
+
+func (c addEmailCampaignVariables) ID() string {
+	return "000021_add_variables_to_notifier_email_campaigns_table"
+}
+
+func (c addEmailCampaignVariables) Up() error {
+	if !c.mg.HasColumn(&notifierEmailCampaignVariables{}, "Variables") {
+		return c.mg.AddColumn(&notifierEmailCampaignVariables{}, "Variables")
+	}
+	return nil
+}
+
+func (c addEmailCampaignVariables) Down() error {
+	if c.mg.HasColumn(&notifierEmailCampaignVariables{}, "Variables") {
+		return c.mg.DropColumn(&notifierEmailCampaignVariables{}, "Variables")
+	}
+	return nil
+}
+
+type notifierEmailLayout struct {
+	ModelGorm
+	Name    string `gorm:"not null;size:255;uniqueIndex:idx_email_layouts_name"`
+	Content string `gorm:"not null"`
+}
+
+type createEmailLayout struct {
+	mg gorm.Migrator
+}
+
+func (c createEmailLayout) ID() string {
+	return "000022_create_notifier_email_layouts_table"
+}
+
+func (c createEmailLayout) Up() error {
+	if !c.mg.HasTable(&notifierEmailLayout{}) {
+		return c.mg.CreateTable(&notifierEmailLayout{})
+	}
+	return nil
+}
+
+func (c createEmailLayout) Down() error {
+	if c.mg.HasTable(&notifierEmailLayout{}) {
+		return c.mg.DropTable(&notifierEmailLayout{})
+	}
+	return nil
+}
+
+type notifierEmailPartial struct {
+	ModelGorm
+	Name    string `gorm:"not null;size:255;uniqueIndex:idx_email_partials_name"`
+	Content string `gorm:"not null"`
+}
+
+type createEmailPartial struct {
+	mg gorm.Migrator
+}
+
+func (c createEmailPartial) ID() string {
+	return "000023_create_notifier_email_partials_table"
+}
+
+func (c createEmailPartial) Up() error {
+	if !c.mg.HasTable(&notifierEmailPartial{}) {
+		return c.mg.CreateTable(&notifierEmailPartial{})
+	}
+	return nil
+}
+
+func (c createEmailPartial) Down() error {
+	if c.mg.HasTable(&notifierEmailPartial{}) {
+		return c.mg.DropTable(&notifierEmailPartial{})
+	}
+	return nil
+}
+
+type notifierEmailTemplateLayout struct {
+	Layout   *notifierEmailLayout `gorm:"foreignKey:LayoutId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	LayoutId *uint64
+	Version  uint `gorm:"not null;default:1"`
+}
+
+func (notifierEmailTemplateLayout) TableName() string {
+	return "notifier_email_campaign_templates"
+}
+
+// addEmailTemplateLayout adds the layout of templates. SQLite can't add a constraint to a table, but it can add
+// a column with a reference, so the reference is a part of the column there.
+type addEmailTemplateLayout struct {
+	db *gorm.DB
+}
+
+func (c addEmailTemplateLayout) ID() string {
+	return "000024_add_layout_and_version_to_notifier_email_campaign_templates_table"
+}
+
+func (c addEmailTemplateLayout) Up() error {
+	mg := c.db.Migrator()
+	if !mg.HasColumn(&notifierEmailTemplateLayout{}, "LayoutId") {
+		var err error
+		if c.db.Dialector.Name() == "sqlite" {
+			err = c.db.Exec("ALTER TABLE notifier_email_campaign_templates ADD COLUMN `layout_id` integer " +
+				"REFERENCES notifier_email_layouts(id) ON UPDATE CASCADE ON DELETE SET NULL").Error
+		} else {
+			err = mg.AddColumn(&notifierEmailTemplateLayout{}, "LayoutId")
+			if err == nil {
+				err = mg.CreateConstraint(&notifierEmailTemplateLayout{}, "Layout")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !mg.HasColumn(&notifierEmailTemplateLayout{}, "Version") {
+		return mg.AddColumn(&notifierEmailTemplateLayout{}, "Version")
+	}
+	return nil
+}
+
+func (c addEmailTemplateLayout) Down() error {
+	mg := c.db.Migrator()
+	if c.db.Dialector.Name() != "sqlite" && mg.HasConstraint(&notifierEmailTemplateLayout{}, "Layout") {
+		err := mg.DropConstraint(&notifierEmailTemplateLayout{}, "Layout")
+		if err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"Version", "LayoutId"} {
+		if !mg.HasColumn(&notifierEmailTemplateLayout{}, column) {
+			continue
+		}
+		err := mg.DropColumn(&notifierEmailTemplateLayout{}, column)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type notifierEmailTemplateVersion struct {
+	ID         uint64                        `gorm:"primarykey"`
+	CreatedAt  time.Time                     `gorm:"not null;type:timestamp;default:current_timestamp"`
+	Template   notifierEmailCampaignTemplate `gorm:"foreignKey:TemplateId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TemplateId uint64                        `gorm:"not null;uniqueIndex:idx_email_template_versions_version"`
+	Version    uint                          `gorm:"not null;uniqueIndex:idx_email_template_versions_version"`
+	Layout     *notifierEmailLayout          `gorm:"foreignKey:LayoutId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	LayoutId   *uint64
+	Name       string `gorm:"not null;size:255;"`
+	Content    string `gorm:"not null"`
+}
+
+// createEmailTemplateVersion creates the versions table with the first version of every existing template.
+type createEmailTemplateVersion struct {
+	db *gorm.DB
+}
+
+func (c createEmailTemplateVersion) ID() string {
+	return "000025_create_notifier_email_template_versions_table"
+}
+
+func (c createEmailTemplateVersion) Up() error {
+	mg := c.db.Migrator()
+	if mg.HasTable(&notifierEmailTemplateVersion{}) {
+		return nil
+	}
+	err := mg.CreateTable(&notifierEmailTemplateVersion{})
+	if err != nil {
+		return err
+	}
+	return c.db.Exec("INSERT INTO notifier_email_template_versions (template_id, version, layout_id, name, content, created_at) " +
+		"SELECT id, version, layout_id, name, content, updated_at FROM notifier_email_campaign_templates").Error
+}
+
+func (c createEmailTemplateVersion) Down() error {
+	return dropPivot(c.db, &notifierEmailTemplateVersion{})
+}
+
+type notifierEmailCampaignTemplateVersion struct {
+	TemplateVersion uint `gorm:"not null;default:0"`
+}
+
+func (notifierEmailCampaignTemplateVersion) TableName() string {
+	return "notifier_email_campaigns"
+}
+
+type addEmailCampaignTemplateVersion struct {
+	mg gorm.Migrator
+}
+
+func (c addEmailCampaignTemplateVersion) ID() string {
+	return "000026_add_template_version_to_notifier_email_campaigns_table"
+}
+
+func (c addEmailCampaignTemplateVersion) Up() error {
+	if !c.mg.HasColumn(&notifierEmailCampaignTemplateVersion{}, "TemplateVersion") {
+		return c.mg.AddColumn(&notifierEmailCampaignTemplateVersion{}, "TemplateVersion")
+	}
+	return nil
+}
+
+func (c addEmailCampaignTemplateVersion) Down() error {
+	if c.mg.HasColumn(&notifierEmailCampaignTemplateVersion{}, "TemplateVersion") {
+		return c.mg.DropColumn(&notifierEmailCampaignTemplateVersion{}, "TemplateVersion")
+	}
+	return nil
+}
+
+type notifierMobileCampaign struct {
+	ModelGorm
+	Driver      notifierMobileDriver        `gorm:"foreignKey:DriverId"`
+	DriverId    uint64                      `gorm:"not null"`
+	ScheduledAt *time.Time                  `gorm:"type:timestamp"`
+	Status      notifierEmailCampaignStatus `gorm:"foreignKey:StatusId"`
+	StatusId    uint64                      `gorm:"not null"`
+	Sender      string                      `gorm:"not null;size:255;"`
+	Message     string                      `gorm:"not null"`
+	Name        string                      `gorm:"not null;size:255;"`
+	Variables   string                      `gorm:"type:text"`
+}
+
+type createMobileCampaign struct {
+	mg gorm.Migrator
+}
+
+func (c createMobileCampaign) ID() string {
+	return "000027_create_notifier_mobile_campaigns_table"
+}
+
+func (c createMobileCampaign) Up() error {
+	if !c.mg.HasTable(&notifierMobileCampaign{}) {
+		return c.mg.CreateTable(&notifierMobileCampaign{})
+	}
+	return nil
+}
+
+func (c createMobileCampaign) Down() error {
+	if c.mg.HasTable(&notifierMobileCampaign{}) {
+		return c.mg.DropTable(&notifierMobileCampaign{})
+	}
+	return nil
+}
+
+type notifierMobileCampaignTag struct {
+	Campaign   notifierMobileCampaign `gorm:"foreignKey:CampaignId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CampaignId uint64                 `gorm:"primaryKey;autoIncrement:false"`
+	Tag        notifierTag            `gorm:"foreignKey:TagId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TagId      uint64                 `gorm:"primaryKey;autoIncrement:false;index:idx_mobile_campaign_tags_tag_id"`
+}
+
+type createMobileCampaignTag struct {
+	db *gorm.DB
+}
 
-	return migrations
+func (c createMobileCampaignTag) ID() string {
+	return "000028_create_notifier_mobile_campaign_tags_table"
+}
+
+func (c createMobileCampaignTag) Up() error {
+	return createPivot(c.db, &notifierMobileCampaignTag{}, "notifier_mobile_campaign_tags", "campaign_id", "tag_id")
+}
+
+func (c createMobileCampaignTag) Down() error {
+	return dropPivot(c.db, &notifierMobileCampaignTag{})
+}
+
+type notifierMobileMessage struct {
+	ModelGorm
+	Receptor          string                   `gorm:"not null;size:100;"`
+	Driver            notifierMobileDriver     `gorm:"foreignKey:DriverId"`
+	DriverId          uint64                   `gorm:"not null;"`
+	Subscriber        notifierMobileSubscriber `gorm:"foreignKey:SubscriberId"`
+	SubscriberId      uint64                   `gorm:"not null;"`
+	SourceType        string                   `gorm:"not null;size:255;"`
+	SourceId          *uint64
+	Sender            string     `gorm:"not null;size:255;"`
+	Message           string     `gorm:"not null;"`
+	QueuedAt          *time.Time `gorm:"type:timestamp"`
+	FailedAt          *time.Time `gorm:"type:timestamp"`
+	SentAt            *time.Time `gorm:"type:timestamp"`
+	ProviderMessageId string     `gorm:"size:255;not null;default:'';index:idx_mobile_messages_provider_message_id"`
+}
+
+type createMobileMessage struct {
+	mg gorm.Migrator
+}
+
+func (c createMobileMessage) ID() string {
+	return "000029_create_notifier_mobile_messages_table"
+}
+
+func (c createMobileMessage) Up() error {
+	if !c.mg.HasTable(&notifierMobileMessage{}) {
+		return c.mg.CreateTable(&notifierMobileMessage{})
+	}
+	return nil
+}
+
+func (c createMobileMessage) Down() error {
+	if c.mg.HasTable(&notifierMobileMessage{}) {
+		return c.mg.DropTable(&notifierMobileMessage{})
+	}
+	return nil
+}
+
+type notifierNotificationCampaign struct {
+	ModelGorm
+	Driver      notifierNotificationDriver  `gorm:"foreignKey:DriverId"`
+	DriverId    uint64                      `gorm:"not null"`
+	ScheduledAt *time.Time                  `gorm:"type:timestamp"`
+	Status      notifierEmailCampaignStatus `gorm:"foreignKey:StatusId"`
+	StatusId    uint64                      `gorm:"not null"`
+	Title       string                      `gorm:"not null;size:255;"`
+	Body        string                      `gorm:"not null"`
+	Image       string                      `gorm:"not null;size:2048;default:''"`
+	Data        string                      `gorm:"type:text"`
+	Name        string                      `gorm:"not null;size:255;"`
+}
+
+type createNotificationCampaign struct {
+	mg gorm.Migrator
+}
+
+func (c createNotificationCampaign) ID() string {
+	return "000030_create_notifier_notification_campaigns_table"
+}
+
+func (c createNotificationCampaign) Up() error {
+	if !c.mg.HasTable(&notifierNotificationCampaign{}) {
+		return c.mg.CreateTable(&notifierNotificationCampaign{})
+	}
+	return nil
+}
+
+func (c createNotificationCampaign) Down() error {
+	if c.mg.HasTable(&notifierNotificationCampaign{}) {
+		return c.mg.DropTable(&notifierNotificationCampaign{})
+	}
+	return nil
+}
+
+type notifierNotificationCampaignTag struct {
+	Campaign   notifierNotificationCampaign `gorm:"foreignKey:CampaignId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CampaignId uint64                       `gorm:"primaryKey;autoIncrement:false"`
+	Tag        notifierTag                  `gorm:"foreignKey:TagId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	TagId      uint64                       `gorm:"primaryKey;autoIncrement:false;index:idx_notification_campaign_tags_tag_id"`
+}
+
+type createNotificationCampaignTag struct {
+	db *gorm.DB
+}
+
+func (c createNotificationCampaignTag) ID() string {
+	return "000031_create_notifier_notification_campaign_tags_table"
+}
+
+func (c createNotificationCampaignTag) Up() error {
+	return createPivot(c.db, &notifierNotificationCampaignTag{}, "notifier_notification_campaign_tags", "campaign_id", "tag_id")
+}
+
+func (c createNotificationCampaignTag) Down() error {
+	return dropPivot(c.db, &notifierNotificationCampaignTag{})
+}
+
+type notifierNotificationMessage struct {
+	ModelGorm
+	Token             string                         `gorm:"not null;size:768;"`
+	Driver            notifierNotificationDriver     `gorm:"foreignKey:DriverId"`
+	DriverId          uint64                         `gorm:"not null;"`
+	Subscriber        notifierNotificationSubscriber `gorm:"foreignKey:SubscriberId"`
+	SubscriberId      uint64                         `gorm:"not null;"`
+	SourceType        string                         `gorm:"not null;size:255;"`
+	SourceId          *uint64
+	Title             string     `gorm:"not null;size:255;"`
+	Body              string     `gorm:"not null;"`
+	Image             string     `gorm:"not null;size:2048;default:''"`
+	Data              string     `gorm:"type:text"`
+	QueuedAt          *time.Time `gorm:"type:timestamp"`
+	FailedAt          *time.Time `gorm:"type:timestamp"`
+	SentAt            *time.Time `gorm:"type:timestamp"`
+	ProviderMessageId string     `gorm:"size:255;not null;default:'';index:idx_notification_messages_provider_message_id"`
+}
+
+type createNotificationMessage struct {
+	mg gorm.Migrator
+}
+
+func (c createNotificationMessage) ID() string {
+	return "000032_create_notifier_notification_messages_table"
+}
+
+func (c createNotificationMessage) Up() error {
+	if !c.mg.HasTable(&notifierNotificationMessage{}) {
+		return c.mg.CreateTable(&notifierNotificationMessage{})
+	}
+	return nil
+}
+
+func (c createNotificationMessage) Down() error {
+	if c.mg.HasTable(&notifierNotificationMessage{}) {
+		return c.mg.DropTable(&notifierNotificationMessage{})
+	}
+	return nil
+}
+
+// notifierNotificationSubscriberToken is the token column of notification subscribers, widened to hold the
+// JSON of a Web Push subscription.
+type notifierNotificationSubscriberToken struct {
+	Token string `gorm:"not null;size:768;index:token_index"`
+}
+
+func (notifierNotificationSubscriberToken) TableName() string {
+	return "notifier_notification_subscribers"
+}
+
+type notifierNotificationSubscriberShortToken struct {
+	Token string `gorm:"not null;size:144;index:token_index"`
+}
+
+func (notifierNotificationSubscriberShortToken) TableName() string {
+	return "notifier_notification_subscribers"
+}
+
+// widenNotificationSubscriberToken is a no-op on SQLite, which doesn't enforce the size of text columns.
+type widenNotificationSubscriberToken struct {
+	db *gorm.DB
+}
+
+func (c widenNotificationSubscriberToken) ID() string {
+	return "000033_widen_token_of_notifier_notification_subscribers_table"
+}
+
+func (c widenNotificationSubscriberToken) Up() error {
+	if c.db.Dialector.Name() == "sqlite" {
+		return nil
+	}
+	return c.db.Migrator().AlterColumn(&notifierNotificationSubscriberToken{}, "Token")
+}
+
+func (c widenNotificationSubscriberToken) Down() error {
+	if c.db.Dialector.Name() == "sqlite" {
+		return nil
+	}
+	return c.db.Migrator().AlterColumn(&notifierNotificationSubscriberShortToken{}, "Token")
+}
+
+// notifierNotificationSubscriberStatus holds the columns added to notifier_notification_subscribers to disable
+// the tokens providers report as invalid. UpdatedAt is indexed for pruning tokens that aren't refreshed.
+type notifierNotificationSubscriberStatus struct {
+	UpdatedAt      time.Time  `gorm:"index:idx_notification_subscribers_updated_at"`
+	DisabledAt     *time.Time `gorm:"type:timestamp"`
+	DisabledReason string     `gorm:"size:255;not null;default:''"`
+}
+
+func (notifierNotificationSubscriberStatus) TableName() string {
+	return "notifier_notification_subscribers"
+}
+
+type addNotificationSubscriberStatus struct {
+	mg gorm.Migrator
+}
+
+func (c addNotificationSubscriberStatus) ID() string {
+	return "000034_add_disabled_at_to_notifier_notification_subscribers_table"
+}
+
+func (c addNotificationSubscriberStatus) Up() error {
+	for _, column := range []string{"DisabledAt", "DisabledReason"} {
+		if c.mg.HasColumn(&notifierNotificationSubscriberStatus{}, column) {
+			continue
+		}
+		err := c.mg.AddColumn(&notifierNotificationSubscriberStatus{}, column)
+		if err != nil {
+			return err
+		}
+	}
+	if !c.mg.HasIndex(&notifierNotificationSubscriberStatus{}, "idx_notification_subscribers_updated_at") {
+		return c.mg.CreateIndex(&notifierNotificationSubscriberStatus{}, "idx_notification_subscribers_updated_at")
+	}
+	return nil
+}
+
+func (c addNotificationSubscriberStatus) Down() error {
+	if c.mg.HasIndex(&notifierNotificationSubscriberStatus{}, "idx_notification_subscribers_updated_at") {
+		err := c.mg.DropIndex(&notifierNotificationSubscriberStatus{}, "idx_notification_subscribers_updated_at")
+		if err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"DisabledReason", "DisabledAt"} {
+		if !c.mg.HasColumn(&notifierNotificationSubscriberStatus{}, column) {
+			continue
+		}
+		err := c.mg.DropColumn(&notifierNotificationSubscriberStatus{}, column)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type notifierCampaign struct {
+	ModelGorm
+	ScheduledAt *time.Time                  `gorm:"type:timestamp"`
+	Status      notifierEmailCampaignStatus `gorm:"foreignKey:StatusId"`
+	StatusId    uint64                      `gorm:"not null"`
+	Name        string                      `gorm:"not null;size:255;"`
+}
+
+type createCampaign struct {
+	mg gorm.Migrator
+}
+
+func (c createCampaign) ID() string {
+	return "000035_create_notifier_campaigns_table"
+}
+
+func (c createCampaign) Up() error {
+	if !c.mg.HasTable(&notifierCampaign{}) {
+		return c.mg.CreateTable(&notifierCampaign{})
+	}
+	return nil
+}
+
+func (c createCampaign) Down() error {
+	if c.mg.HasTable(&notifierCampaign{}) {
+		return c.mg.DropTable(&notifierCampaign{})
+	}
+	return nil
+}
+
+// The campaign_id columns link the campaigns of every channel to their multi-channel campaign.
+
+type notifierEmailCampaignParent struct {
+	Campaign   *notifierCampaign `gorm:"foreignKey:CampaignId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	CampaignId *uint64           `gorm:"index:idx_email_campaigns_campaign_id"`
+}
+
+func (notifierEmailCampaignParent) TableName() string {
+	return "notifier_email_campaigns"
+}
+
+type notifierMobileCampaignParent struct {
+	Campaign   *notifierCampaign `gorm:"foreignKey:CampaignId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	CampaignId *uint64           `gorm:"index:idx_mobile_campaigns_campaign_id"`
+}
+
+func (notifierMobileCampaignParent) TableName() string {
+	return "notifier_mobile_campaigns"
+}
+
+type notifierNotificationCampaignParent struct {
+	Campaign   *notifierCampaign `gorm:"foreignKey:CampaignId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	CampaignId *uint64           `gorm:"index:idx_notification_campaigns_campaign_id"`
+}
+
+func (notifierNotificationCampaignParent) TableName() string {
+	return "notifier_notification_campaigns"
+}
+
+type addChannelCampaignParent struct {
+	db *gorm.DB
+}
+
+func (c addChannelCampaignParent) ID() string {
+	return "000036_add_campaign_id_to_channel_campaigns_tables"
+}
+
+func (c addChannelCampaignParent) models() []interface{} {
+	return []interface{}{&notifierEmailCampaignParent{}, &notifierMobileCampaignParent{}, &notifierNotificationCampaignParent{}}
+}
+
+func (c addChannelCampaignParent) Up() error {
+	mg := c.db.Migrator()
+	for _, model := range c.models() {
+		if mg.HasColumn(model, "CampaignId") {
+			continue
+		}
+		var err error
+		if c.db.Dialector.Name() == "sqlite" {
+			stmt := &gorm.Statement{DB: c.db}
+			err = stmt.Parse(model)
+			if err == nil {
+				err = c.db.Exec("ALTER TABLE " + stmt.Table + " ADD COLUMN `campaign_id` integer " +
+					"REFERENCES notifier_campaigns(id) ON UPDATE CASCADE ON DELETE SET NULL").Error
+			}
+		} else {
+			err = mg.AddColumn(model, "CampaignId")
+			if err == nil {
+				err = mg.CreateConstraint(model, "Campaign")
+			}
+		}
+		if err == nil {
+			err = mg.CreateIndex(model, "CampaignId")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c addChannelCampaignParent) Down() error {
+	mg := c.db.Migrator()
+	for _, model := range c.models() {
+		if !mg.HasColumn(model, "CampaignId") {
+			continue
+		}
+		if c.db.Dialector.Name() != "sqlite" && mg.HasConstraint(model, "Campaign") {
+			err := mg.DropConstraint(model, "Campaign")
+			if err != nil {
+				return err
+			}
+		}
+		if mg.HasIndex(model, "CampaignId") {
+			err := mg.DropIndex(model, "CampaignId")
+			if err != nil {
+				return err
+			}
+		}
+		err := mg.DropColumn(model, "CampaignId")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPivot creates the pivot table of the model. Older versions created pivot tables as a side effect of
+// AutoMigrate, without cascade rules, so an existing table is rebuilt from the model and its rows are copied back.
+// The rows are kept in a plain backup table meanwhile, so the names of the constraints don't clash. MySQL commits
+// every DDL statement, so a rebuild that failed halfway leaves the backup with every row behind; the next run
+// rebuilds the table from it instead of backing up the table again.
+func createPivot(db *gorm.DB, model interface{}, table string, columns ...string) error {
+	mg := db.Migrator()
+	backup := table + "_backup"
+	list := strings.Join(columns, ", ")
+	if !mg.HasTable(backup) {
+		if !mg.HasTable(table) {
+			return mg.CreateTable(model)
+		}
+		err := db.Exec("CREATE TABLE " + backup + " AS SELECT DISTINCT " + list + " FROM " + table).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if mg.HasTable(table) {
+		err := mg.DropTable(table)
+		if err != nil {
+			return err
+		}
+	}
+	err := mg.CreateTable(model)
+	if err != nil {
+		return err
+	}
+	err = db.Exec("INSERT INTO " + table + " (" + list + ") SELECT " + list + " FROM " + backup).Error
+	if err != nil {
+		return err
+	}
+	return mg.DropTable(backup)
+}
+
+func dropPivot(db *gorm.DB, model interface{}) error {
+	mg := db.Migrator()
+	if mg.HasTable(model) {
+		return mg.DropTable(model)
+	}
+	return nil
+}
+
+type notifierJob struct {
+	ModelGorm
+	AvailableAt time.Time  `gorm:"not null;type:timestamp;index:idx_notifier_jobs_claim,priority:2"`
+	LockedUntil *time.Time `gorm:"type:timestamp"`
+	FinishedAt  *time.Time `gorm:"type:timestamp"`
+	UniqueKey   *string    `gorm:"size:255;uniqueIndex"`
+	Type        string     `gorm:"not null;size:100"`
+	Payload     string     `gorm:"type:text"`
+	Batch       string     `gorm:"size:100;index"`
+	State       string     `gorm:"not null;size:20;index:idx_notifier_jobs_claim,priority:1"`
+	LockedBy    string     `gorm:"size:255"`
+	Attempts    uint       `gorm:"not null;default:0"`
+}
+
+type createJob struct {
+	mg gorm.Migrator
+}
+
+func (c createJob) ID() string {
+	return "000037_create_notifier_jobs_table"
+}
+
+func (c createJob) Up() error {
+	if !c.mg.HasTable(&notifierJob{}) {
+		return c.mg.CreateTable(&notifierJob{})
+	}
+	return nil
+}
+
+func (c createJob) Down() error {
+	if c.mg.HasTable(&notifierJob{}) {
+		return c.mg.DropTable(&notifierJob{})
+	}
+	return nil
+}
+
+type notifierEmailServiceRateLimit struct {
+	RateLimitPerSecond uint `gorm:"not null;default:0"`
+	RateLimitPerDay    uint `gorm:"not null;default:0"`
+}
+
+func (notifierEmailServiceRateLimit) TableName() string {
+	return "notifier_email_services"
+}
+
+// The sent mails of a service in the last day are counted for its daily rate limit.
+
+type notifierEmailMessageServiceSentAt struct {
+	EmailServiceId uint64     `gorm:"index:idx_email_messages_service_sent_at,priority:1"`
+	SentAt         *time.Time `gorm:"index:idx_email_messages_service_sent_at,priority:2"`
+}
+
+func (notifierEmailMessageServiceSentAt) TableName() string {
+	return "notifier_email_messages"
+}
+
+type addEmailServiceRateLimit struct {
+	mg gorm.Migrator
+}
+
+func (c addEmailServiceRateLimit) ID() string {
+	return "000038_add_rate_limits_to_notifier_email_services_table"
+}
+
+func (c addEmailServiceRateLimit) Up() error {
+	for _, column := range []string{"RateLimitPerSecond", "RateLimitPerDay"} {
+		if !c.mg.HasColumn(&notifierEmailServiceRateLimit{}, column) {
+			err := c.mg.AddColumn(&notifierEmailServiceRateLimit{}, column)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !c.mg.HasIndex(&notifierEmailMessageServiceSentAt{}, "idx_email_messages_service_sent_at") {
+		return c.mg.CreateIndex(&notifierEmailMessageServiceSentAt{}, "idx_email_messages_service_sent_at")
+	}
+	return nil
+}
+
+func (c addEmailServiceRateLimit) Down() error {
+	if c.mg.HasIndex(&notifierEmailMessageServiceSentAt{}, "idx_email_messages_service_sent_at") {
+		err := c.mg.DropIndex(&notifierEmailMessageServiceSentAt{}, "idx_email_messages_service_sent_at")
+		if err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"RateLimitPerDay", "RateLimitPerSecond"} {
+		if c.mg.HasColumn(&notifierEmailServiceRateLimit{}, column) {
+			err := c.mg.DropColumn(&notifierEmailServiceRateLimit{}, column)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type notifierEmailMessageRetry struct {
+	Attempts    uint       `gorm:"not null;default:0"`
+	LastError   string     `gorm:"type:text"`
+	DiscardedAt *time.Time `gorm:"type:timestamp"`
+}
+
+func (notifierEmailMessageRetry) TableName() string {
+	return "notifier_email_messages"
+}
+
+type addEmailMessageRetry struct {
+	mg gorm.Migrator
+}
+
+func (c addEmailMessageRetry) ID() string {
+	return "000039_add_attempts_to_notifier_email_messages_table"
+}
+
+func (c addEmailMessageRetry) Up() error {
+	for _, column := range []string{"Attempts", "LastError", "DiscardedAt"} {
+		if !c.mg.HasColumn(&notifierEmailMessageRetry{}, column) {
+			err := c.mg.AddColumn(&notifierEmailMessageRetry{}, column)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c addEmailMessageRetry) Down() error {
+	for _, column := range []string{"DiscardedAt", "LastError", "Attempts"} {
+		if c.mg.HasColumn(&notifierEmailMessageRetry{}, column) {
+			err := c.mg.DropColumn(&notifierEmailMessageRetry{}, column)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type notifierJobMaxAttempts struct {
+	MaxAttempts uint `gorm:"not null;default:25"`
+}
+
+func (notifierJobMaxAttempts) TableName() string {
+	return "notifier_jobs"
+}
+
+type addJobMaxAttempts struct {
+	mg gorm.Migrator
+}
+
+func (c addJobMaxAttempts) ID() string {
+	return "000040_add_max_attempts_to_notifier_jobs_table"
+}
+
+func (c addJobMaxAttempts) Up() error {
+	if !c.mg.HasColumn(&notifierJobMaxAttempts{}, "MaxAttempts") {
+		return c.mg.AddColumn(&notifierJobMaxAttempts{}, "MaxAttempts")
+	}
+	return nil
+}
+
+func (c addJobMaxAttempts) Down() error {
+	if c.mg.HasColumn(&notifierJobMaxAttempts{}, "MaxAttempts") {
+		return c.mg.DropColumn(&notifierJobMaxAttempts{}, "MaxAttempts")
+	}
+	return nil
+}
+
+type notifierEmailMessageEnvelope struct {
+	Envelope string `gorm:"type:text"`
+}
+
+func (notifierEmailMessageEnvelope) TableName() string {
+	return "notifier_email_messages"
+}
+
+type addEmailMessageEnvelope struct {
+	mg gorm.Migrator
+}
+
+func (c addEmailMessageEnvelope) ID() string {
+	return "000041_add_envelope_to_notifier_email_messages_table"
+}
+
+func (c addEmailMessageEnvelope) Up() error {
+	if !c.mg.HasColumn(&notifierEmailMessageEnvelope{}, "Envelope") {
+		return c.mg.AddColumn(&notifierEmailMessageEnvelope{}, "Envelope")
+	}
+	return nil
+}
+
+func (c addEmailMessageEnvelope) Down() error {
+	if c.mg.HasColumn(&notifierEmailMessageEnvelope{}, "Envelope") {
+		return c.mg.DropColumn(&notifierEmailMessageEnvelope{}, "Envelope")
+	}
+	return nil
+}
+
+type notifierJobLastError struct {
+	LastError string `gorm:"type:text"`
+}
+
+func (notifierJobLastError) TableName() string {
+	return "notifier_jobs"
+}
+
+type addJobLastError struct {
+	mg gorm.Migrator
+}
+
+func (c addJobLastError) ID() string {
+	return "000042_add_last_error_to_notifier_jobs_table"
+}
+
+func (c addJobLastError) Up() error {
+	if !c.mg.HasColumn(&notifierJobLastError{}, "LastError") {
+		return c.mg.AddColumn(&notifierJobLastError{}, "LastError")
+	}
+	return nil
+}
+
+func (c addJobLastError) Down() error {
+	if c.mg.HasColumn(&notifierJobLastError{}, "LastError") {
+		return c.mg.DropColumn(&notifierJobLastError{}, "LastError")
+	}
+	return nil
+}
+
+// GetMigrationsList returns every migration in the order they must be applied.
+// New migrations are appended to the end of the list with a new unique id, applied migrations must never change.
+func GetMigrationsList(db *gorm.DB) []Migration {
+	migr := db.Migrator()
+	return []Migration{
+		createTag{migr},
+		createEmailUnsubscribeEvent{migr},
+		createEmailSubscriber{migr},
+		createEmailService{migr},
+		createEmailMessage{migr},
+		createEmailCampaignTemplate{migr},
+		createEmailCampaignStatus{migr},
+		createEmailCampaign{migr},
+		createMobileUnsubscribeEvent{migr},
+		createMobileSubscriber{migr},
+		createNotificationDriver{migr},
+		createNotificationSubscriber{migr},
+		addNotificationDriverService{migr},
+		createEmailSubTag{db},
+		createEmailCampaignTag{db},
+		createMobileSubTag{db},
+		createNotificationSubTag{db},
+		createMobileDriver{migr},
+		createEmailAttachment{migr},
+		addEmailMessageProviderId{migr},
+		addEmailCampaignVariables{migr},
+		createEmailLayout{migr},
+		createEmailPartial{migr},
+		addEmailTemplateLayout{db},
+		createEmailTemplateVersion{db},
+		addEmailCampaignTemplateVersion{migr},
+		createMobileCampaign{migr},
+		createMobileCampaignTag{db},
+		createMobileMessage{migr},
+		createNotificationCampaign{migr},
+		createNotificationCampaignTag{db},
+		createNotificationMessage{migr},
+		widenNotificationSubscriberToken{db},
+		addNotificationSubscriberStatus{migr},
+		createCampaign{migr},
+		addChannelCampaignParent{db},
+		createJob{migr},
+		addEmailServiceRateLimit{migr},
+		addEmailMessageRetry{migr},
+		addJobMaxAttempts{migr},
+		addEmailMessageEnvelope{migr},
+		addJobLastError{migr},
+	}
 }
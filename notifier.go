@@ -0,0 +1,547 @@
+package go_notifier_core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// Notifier is a client for one notifier database. It owns the database connection, repositories and mailers,
+// so a process can talk to several notifier databases by creating several instances with New.
+type Notifier struct {
+	db  *gorm.DB
+	ctx context.Context
+
+	tagRepo ITagRepository
+
+	emailUnSubEventRepo IEmailUnSubEventRepository
+	emailSubTagRepo     IEmailSubTagRepository
+	emailSubscriberRepo IEmailSubscriberRepository
+
+	mobileUnSubEventRepo IMobileUnSubEventRepository
+	mobileSubTagRepo     IMobileSubTagRepository
+	mobileSubscriberRepo IMobileSubscriberRepository
+	mobileDriverRepo     IMobileDriverRepository
+	mobileCampaignRepo   IMobileCampaignRepository
+	mobileMessageRepo    IMobileMessageRepository
+
+	notificationDriverRepo     INotifierNotificationDriverRepository
+	notificationSubTagRepo     INotificationSubTagRepository
+	notificationSubscriberRepo INotificationSubscriberRepository
+	notificationCampaignRepo   INotificationCampaignRepository
+	notificationMessageRepo    INotificationMessageRepository
+
+	emailTemplateRepo IEmailTemplateRepository
+	emailServiceRepo  IEmailServiceRepository
+	emailStatusRepo   IEmailStatusRepository
+	emailCampaignRepo IEmailCampaignRepository
+	campaignRepo      ICampaignRepository
+	emailMessageRepo  IEmailMessageRepository
+
+	emailAttachmentRepo IEmailAttachmentRepository
+
+	emailTemplateVersionRepo IEmailTemplateVersionRepository
+	emailLayoutRepo          IEmailLayoutRepository
+	emailPartialRepo         IEmailPartialRepository
+
+	jobRepo IJobRepository
+	queue   QueueBackend
+
+	mailers     map[string]func() Mailer
+	smsSenders  map[string]func() SmsSender
+	pushSenders map[string]func() PushSender
+
+	jobHandlers map[string]JobHandler
+
+	retryPolicy RetryPolicy
+
+	emailLimiters   *emailServiceLimiters
+	attachmentCache *campaignAttachmentCache
+
+	invalidPushTokenHandler InvalidPushTokenHandler
+}
+
+// Option customizes a Notifier created by New, e.g. to replace a repository with a fake in unit tests.
+type Option func(n *Notifier)
+
+// New connects to the database of the config and returns a Notifier with gorm repositories and the registered mailers.
+// Repositories and mailers passed by options replace the defaults.
+func New(config DbConfig, opts ...Option) (*Notifier, error) {
+	db, err := dbFactory(config)
+	if err != nil {
+		return nil, err
+	}
+	return newNotifier(db, opts...), nil
+}
+
+// NewWithDB returns a Notifier that shares a *gorm.DB managed by the caller, with its pool, logger and plugins.
+func NewWithDB(db *gorm.DB, opts ...Option) (*Notifier, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+	return newNotifier(db, opts...), nil
+}
+
+// NewWithSqlDB returns a Notifier that shares a *sql.DB managed by the caller.
+// The driver is one of MysqlDriver, PostgresDriver or SqliteDriver and must match the connection. Foreign keys
+// of SQLite are enabled on the connection; a pool of several SQLite connections must be opened with
+// _foreign_keys=1 in its DSN, so every connection has them.
+func NewWithSqlDB(sqlDB *sql.DB, driver int, opts ...Option) (*Notifier, error) {
+	if sqlDB == nil {
+		return nil, errors.New("db is nil")
+	}
+	db, err := openSqlDB(sqlDB, driver)
+	if err != nil {
+		return nil, err
+	}
+	return newNotifier(db, opts...), nil
+}
+
+func newNotifier(db *gorm.DB, opts ...Option) *Notifier {
+	n := &Notifier{
+		db: db,
+
+		tagRepo: NewGormTagRepository(db),
+
+		emailUnSubEventRepo: NewGormEmailUnSubEventRepository(db),
+		emailSubTagRepo:     NewGormEmailSubTagRepository(db),
+		emailSubscriberRepo: NewGormEmailSubscriberRepository(db),
+
+		mobileUnSubEventRepo: NewGormMobileUnSubEventRepository(db),
+		mobileSubTagRepo:     NewGormMobileSubTagRepository(db),
+		mobileSubscriberRepo: NewGormMobileSubscriberRepository(db),
+		mobileDriverRepo:     NewGormMobileDriverRepository(db),
+		mobileCampaignRepo:   NewGormMobileCampaignRepository(db),
+		mobileMessageRepo:    NewGormMobileMessageRepository(db),
+
+		notificationDriverRepo:     NewGormNotifierNotificationDriverRepository(db),
+		notificationSubTagRepo:     NewGormNotificationSubTagRepository(db),
+		notificationSubscriberRepo: NewGormNotificationSubscriberRepository(db),
+		notificationCampaignRepo:   NewGormNotificationCampaignRepository(db),
+		notificationMessageRepo:    NewGormNotificationMessageRepository(db),
+
+		emailTemplateRepo: NewGormEmailTemplateRepository(db),
+		emailServiceRepo:  NewGormEmailServiceRepository(db),
+		emailStatusRepo:   NewGormEmailStatusRepository(db),
+		emailCampaignRepo: NewGormEmailCampaignRepository(db),
+		campaignRepo:      NewGormCampaignRepository(db),
+		emailMessageRepo:  NewGormEmailMessageRepository(db),
+
+		emailAttachmentRepo: NewGormEmailAttachmentRepository(db),
+
+		emailTemplateVersionRepo: NewGormEmailTemplateVersionRepository(db),
+		emailLayoutRepo:          NewGormEmailLayoutRepository(db),
+		emailPartialRepo:         NewGormEmailPartialRepository(db),
+
+		jobRepo: NewGormJobRepository(db),
+
+		mailers:     map[string]func() Mailer{},
+		smsSenders:  map[string]func() SmsSender{},
+		pushSenders: map[string]func() PushSender{},
+
+		jobHandlers: map[string]JobHandler{},
+
+		retryPolicy: DefaultRetryPolicy,
+
+		emailLimiters:   newEmailServiceLimiters(),
+		attachmentCache: newCampaignAttachmentCache(),
+
+		invalidPushTokenHandler: DisableInvalidPushToken,
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// WithContext returns a Notifier that runs the queries of its repositories and the sends of its mailers by ctx,
+// e.g. to stop them when the request that started them is canceled. It shares the connection, the mailers
+// and the rate limits of n. Repositories passed by options that aren't gorm repositories are kept as they are.
+func (n *Notifier) WithContext(ctx context.Context) *Notifier {
+	if ctx == nil {
+		panic("nil context")
+	}
+	c := *n
+	c.ctx = ctx
+	c.db = n.db.WithContext(ctx)
+
+	c.tagRepo = repositoryWithContext(ctx, n.tagRepo)
+
+	c.emailUnSubEventRepo = repositoryWithContext(ctx, n.emailUnSubEventRepo)
+	c.emailSubTagRepo = repositoryWithContext(ctx, n.emailSubTagRepo)
+	c.emailSubscriberRepo = repositoryWithContext(ctx, n.emailSubscriberRepo)
+
+	c.mobileUnSubEventRepo = repositoryWithContext(ctx, n.mobileUnSubEventRepo)
+	c.mobileSubTagRepo = repositoryWithContext(ctx, n.mobileSubTagRepo)
+	c.mobileSubscriberRepo = repositoryWithContext(ctx, n.mobileSubscriberRepo)
+	c.mobileDriverRepo = repositoryWithContext(ctx, n.mobileDriverRepo)
+	c.mobileCampaignRepo = repositoryWithContext(ctx, n.mobileCampaignRepo)
+	c.mobileMessageRepo = repositoryWithContext(ctx, n.mobileMessageRepo)
+
+	c.notificationDriverRepo = repositoryWithContext(ctx, n.notificationDriverRepo)
+	c.notificationSubTagRepo = repositoryWithContext(ctx, n.notificationSubTagRepo)
+	c.notificationSubscriberRepo = repositoryWithContext(ctx, n.notificationSubscriberRepo)
+	c.notificationCampaignRepo = repositoryWithContext(ctx, n.notificationCampaignRepo)
+	c.notificationMessageRepo = repositoryWithContext(ctx, n.notificationMessageRepo)
+
+	c.emailTemplateRepo = repositoryWithContext(ctx, n.emailTemplateRepo)
+	c.emailServiceRepo = repositoryWithContext(ctx, n.emailServiceRepo)
+	c.emailStatusRepo = repositoryWithContext(ctx, n.emailStatusRepo)
+	c.emailCampaignRepo = repositoryWithContext(ctx, n.emailCampaignRepo)
+	c.campaignRepo = repositoryWithContext(ctx, n.campaignRepo)
+	c.emailMessageRepo = repositoryWithContext(ctx, n.emailMessageRepo)
+
+	c.emailAttachmentRepo = repositoryWithContext(ctx, n.emailAttachmentRepo)
+
+	c.emailTemplateVersionRepo = repositoryWithContext(ctx, n.emailTemplateVersionRepo)
+	c.emailLayoutRepo = repositoryWithContext(ctx, n.emailLayoutRepo)
+	c.emailPartialRepo = repositoryWithContext(ctx, n.emailPartialRepo)
+
+	c.jobRepo = repositoryWithContext(ctx, n.jobRepo)
+	return &c
+}
+
+// Context returns the context of the Notifier, see WithContext. It's context.Background by default.
+func (n *Notifier) Context() context.Context {
+	if n.ctx != nil {
+		return n.ctx
+	}
+	return context.Background()
+}
+
+// DB returns the database connection of the notifier.
+func (n *Notifier) DB() *gorm.DB {
+	return n.db
+}
+
+// mailer returns a new mailer for the email service type. Mailers passed by WithMailer take precedence
+// over the ones registered by RegisterMailer.
+func (n *Notifier) mailer(serviceType string) (Mailer, error) {
+	factory, ok := n.mailers[serviceType]
+	if !ok {
+		factory, ok = registeredMailer(serviceType)
+	}
+	if !ok {
+		return nil, errors.New("no mailer registered for email service type '" + serviceType + "'")
+	}
+	return factory(), nil
+}
+
+// WithMailer registers a mailer factory for an email service type on this notifier only.
+// A new mailer is created for every mail.
+func WithMailer(serviceType string, factory func() Mailer) Option {
+	return func(n *Notifier) {
+		n.mailers[serviceType] = factory
+	}
+}
+
+// smsSender returns a new SMS sender for the mobile driver type. Senders passed by WithSmsSender take precedence
+// over the ones registered by RegisterSmsSender.
+func (n *Notifier) smsSender(driverType string) (SmsSender, error) {
+	factory, ok := n.smsSenders[driverType]
+	if !ok {
+		factory, ok = registeredSmsSender(driverType)
+	}
+	if !ok {
+		return nil, errors.New("no sms sender registered for mobile driver type '" + driverType + "'")
+	}
+	return factory(), nil
+}
+
+// WithSmsSender registers an SMS sender factory for a mobile driver type on this notifier only.
+// A new sender is created for every message.
+func WithSmsSender(driverType string, factory func() SmsSender) Option {
+	return func(n *Notifier) {
+		n.smsSenders[driverType] = factory
+	}
+}
+
+// pushSender returns a new push sender for the notification driver type. Senders passed by WithPushSender take
+// precedence over the ones registered by RegisterPushSender.
+func (n *Notifier) pushSender(driverType string) (PushSender, error) {
+	factory, ok := n.pushSenders[driverType]
+	if !ok {
+		factory, ok = registeredPushSender(driverType)
+	}
+	if !ok {
+		return nil, errors.New("no push sender registered for notification driver type '" + driverType + "'")
+	}
+	return factory(), nil
+}
+
+// WithPushSender registers a push sender factory for a notification driver type on this notifier only.
+// A new sender is created for every notification.
+func WithPushSender(driverType string, factory func() PushSender) Option {
+	return func(n *Notifier) {
+		n.pushSenders[driverType] = factory
+	}
+}
+
+// jobQueue returns the queue backend of the jobs, the jobs table when no backend is set by WithQueueBackend.
+func (n *Notifier) jobQueue() QueueBackend {
+	if n.queue != nil {
+		return n.queue
+	}
+	return NewDatabaseQueue(n.jobRepo)
+}
+
+// WithQueueBackend replaces the jobs table as the queue backend of the jobs, e.g. by NewQueue for an in-memory queue.
+func WithQueueBackend(queue QueueBackend) Option {
+	return func(n *Notifier) {
+		n.queue = queue
+	}
+}
+
+func (n *Notifier) jobHandler(jobType string) (JobHandler, error) {
+	handler, ok := n.jobHandlers[jobType]
+	if !ok {
+		handler, ok = registeredJobHandler(jobType)
+	}
+	if !ok {
+		return nil, errors.New("no job handler registered for job type '" + jobType + "'")
+	}
+	return handler, nil
+}
+
+// WithJobHandler registers the handler of a job type on this notifier only.
+func WithJobHandler(jobType string, handler JobHandler) Option {
+	return func(n *Notifier) {
+		n.jobHandlers[jobType] = handler
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy as the policy of the mails that fail by a transient error.
+// A policy of one attempt doesn't retry.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(n *Notifier) {
+		n.retryPolicy = policy
+	}
+}
+
+// WithInvalidPushTokenHandler replaces how tokens reported as invalid are handled, e.g. by RemoveInvalidPushToken.
+// A nil handler keeps the tokens as they are.
+func WithInvalidPushTokenHandler(handler InvalidPushTokenHandler) Option {
+	return func(n *Notifier) {
+		n.invalidPushTokenHandler = handler
+	}
+}
+
+func WithTagRepository(repo ITagRepository) Option {
+	return func(n *Notifier) {
+		n.tagRepo = repo
+	}
+}
+
+func WithEmailUnSubEventRepository(repo IEmailUnSubEventRepository) Option {
+	return func(n *Notifier) {
+		n.emailUnSubEventRepo = repo
+	}
+}
+
+func WithEmailSubTagRepository(repo IEmailSubTagRepository) Option {
+	return func(n *Notifier) {
+		n.emailSubTagRepo = repo
+	}
+}
+
+func WithEmailSubscriberRepository(repo IEmailSubscriberRepository) Option {
+	return func(n *Notifier) {
+		n.emailSubscriberRepo = repo
+	}
+}
+
+func WithMobileUnSubEventRepository(repo IMobileUnSubEventRepository) Option {
+	return func(n *Notifier) {
+		n.mobileUnSubEventRepo = repo
+	}
+}
+
+func WithMobileSubTagRepository(repo IMobileSubTagRepository) Option {
+	return func(n *Notifier) {
+		n.mobileSubTagRepo = repo
+	}
+}
+
+func WithMobileSubscriberRepository(repo IMobileSubscriberRepository) Option {
+	return func(n *Notifier) {
+		n.mobileSubscriberRepo = repo
+	}
+}
+
+func WithMobileDriverRepository(repo IMobileDriverRepository) Option {
+	return func(n *Notifier) {
+		n.mobileDriverRepo = repo
+	}
+}
+
+func WithMobileCampaignRepository(repo IMobileCampaignRepository) Option {
+	return func(n *Notifier) {
+		n.mobileCampaignRepo = repo
+	}
+}
+
+func WithMobileMessageRepository(repo IMobileMessageRepository) Option {
+	return func(n *Notifier) {
+		n.mobileMessageRepo = repo
+	}
+}
+
+func WithNotificationDriverRepository(repo INotifierNotificationDriverRepository) Option {
+	return func(n *Notifier) {
+		n.notificationDriverRepo = repo
+	}
+}
+
+func WithNotificationSubTagRepository(repo INotificationSubTagRepository) Option {
+	return func(n *Notifier) {
+		n.notificationSubTagRepo = repo
+	}
+}
+
+func WithNotificationSubscriberRepository(repo INotificationSubscriberRepository) Option {
+	return func(n *Notifier) {
+		n.notificationSubscriberRepo = repo
+	}
+}
+
+func WithNotificationCampaignRepository(repo INotificationCampaignRepository) Option {
+	return func(n *Notifier) {
+		n.notificationCampaignRepo = repo
+	}
+}
+
+func WithNotificationMessageRepository(repo INotificationMessageRepository) Option {
+	return func(n *Notifier) {
+		n.notificationMessageRepo = repo
+	}
+}
+
+func WithEmailTemplateRepository(repo IEmailTemplateRepository) Option {
+	return func(n *Notifier) {
+		n.emailTemplateRepo = repo
+	}
+}
+
+func WithEmailServiceRepository(repo IEmailServiceRepository) Option {
+	return func(n *Notifier) {
+		n.emailServiceRepo = repo
+	}
+}
+
+func WithEmailStatusRepository(repo IEmailStatusRepository) Option {
+	return func(n *Notifier) {
+		n.emailStatusRepo = repo
+	}
+}
+
+func WithEmailCampaignRepository(repo IEmailCampaignRepository) Option {
+	return func(n *Notifier) {
+		n.emailCampaignRepo = repo
+	}
+}
+
+func WithCampaignRepository(repo ICampaignRepository) Option {
+	return func(n *Notifier) {
+		n.campaignRepo = repo
+	}
+}
+
+func WithJobRepository(repo IJobRepository) Option {
+	return func(n *Notifier) {
+		n.jobRepo = repo
+	}
+}
+
+func WithEmailMessageRepository(repo IEmailMessageRepository) Option {
+	return func(n *Notifier) {
+		n.emailMessageRepo = repo
+	}
+}
+
+func WithEmailAttachmentRepository(repo IEmailAttachmentRepository) Option {
+	return func(n *Notifier) {
+		n.emailAttachmentRepo = repo
+	}
+}
+
+func WithEmailTemplateVersionRepository(repo IEmailTemplateVersionRepository) Option {
+	return func(n *Notifier) {
+		n.emailTemplateVersionRepo = repo
+	}
+}
+
+func WithEmailLayoutRepository(repo IEmailLayoutRepository) Option {
+	return func(n *Notifier) {
+		n.emailLayoutRepo = repo
+	}
+}
+
+func WithEmailPartialRepository(repo IEmailPartialRepository) Option {
+	return func(n *Notifier) {
+		n.emailPartialRepo = repo
+	}
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultNotifier *Notifier
+)
+
+// Initialize creates the default Notifier used by the package level functions and workers.
+// It returns a ConnectionError or InvalidDriverError when the database can't be used, so the caller can retry later.
+func Initialize(config DbConfig, opts ...Option) error {
+	n, err := New(config, opts...)
+	if err != nil {
+		return err
+	}
+	setDefault(n)
+	return nil
+}
+
+// InitializeWithDB creates the default Notifier on a *gorm.DB managed by the caller.
+func InitializeWithDB(db *gorm.DB, opts ...Option) error {
+	n, err := NewWithDB(db, opts...)
+	if err != nil {
+		return err
+	}
+	setDefault(n)
+	return nil
+}
+
+// InitializeWithSqlDB creates the default Notifier on a *sql.DB managed by the caller.
+func InitializeWithSqlDB(sqlDB *sql.DB, driver int, opts ...Option) error {
+	n, err := NewWithSqlDB(sqlDB, driver, opts...)
+	if err != nil {
+		return err
+	}
+	setDefault(n)
+	return nil
+}
+
+func setDefault(n *Notifier) {
+	defaultMu.Lock()
+	defaultNotifier = n
+	defaultMu.Unlock()
+}
+
+// Default returns the Notifier created by Initialize, or NotInitializedError when Initialize isn't called yet.
+func Default() (*Notifier, error) {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	if defaultNotifier == nil {
+		return nil, NotInitializedError{}
+	}
+	return defaultNotifier, nil
+}
+
+func dbFactory(config DbConfig) (*gorm.DB, error) {
+	db, err := getDriver(config)
+	if err != nil {
+		return nil, err
+	}
+	return db.(*gorm.DB), nil
+}
@@ -0,0 +1,141 @@
+package go_notifier_core
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// fakeTagRepository keeps tags in memory.
+type fakeTagRepository struct {
+	gormRepository[NotifierTag]
+	tags []NotifierTag
+}
+
+func (f *fakeTagRepository) Create(tag *NotifierTag) error {
+	tag.ID = uint64(len(f.tags) + 1)
+	f.tags = append(f.tags, *tag)
+	return nil
+}
+
+func (f *fakeTagRepository) GetByName(name string) (*NotifierTag, error) {
+	for _, tag := range f.tags {
+		if tag.Name == name {
+			return &tag, nil
+		}
+	}
+	return nil, NotFoundError{}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	repo := &fakeTagRepository{}
+	n, err := New(DbConfig{Name: "sqlite options test", Driver: SqliteDriver, DB: ":memory:"}, WithTagRepository(repo))
+	assert.Nil(t, err)
+
+	tag, err := n.CreateTag("Fake")
+	assert.Nil(t, err)
+	assert.Equal(t, "fake", tag.Name)
+	assert.Len(t, repo.tags, 1, "Tag should be stored in the injected repository")
+
+	// Test unknown email service types
+	_, err = n.mailer("Unknown")
+	assert.NotNil(t, err)
+
+	mailer, err := n.mailer(NotifierEmailServiceSMTPType)
+	assert.Nil(t, err)
+	assert.IsType(t, &SmtpMailer{}, mailer)
+}
+
+func TestNotifiersAreIsolated(t *testing.T) {
+	first := newSqliteTestNotifier(t, "sqlite first tenant")
+	second := newSqliteTestNotifier(t, "sqlite second tenant")
+
+	_, err := first.CreateTag("tenant")
+	assert.Nil(t, err)
+
+	_, err = first.GetTagByName("tenant")
+	assert.Nil(t, err)
+	_, err = second.GetTagByName("tenant")
+	assert.ErrorAs(t, err, &NotFoundError{}, "Tags of a notifier should not be visible to another one")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(DbConfig{Name: "invalid notifier", Driver: 999})
+	assert.ErrorAs(t, err, &InvalidDriverError{})
+}
+
+func TestNewWithDB(t *testing.T) {
+	db, err := sqliteDriver(DbConfig{Name: "sqlite caller db", DB: ":memory:"})
+	assert.Nil(t, err)
+	sqlDB, err := db.DB()
+	assert.Nil(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	n, err := NewWithDB(db)
+	assert.Nil(t, err)
+	assert.Same(t, db, n.DB())
+	assert.Nil(t, n.Migrate())
+
+	_, err = n.CreateTag("shared")
+	assert.Nil(t, err)
+
+	// Test the notifier tables are visible on the caller's connection
+	var count int64
+	db.Model(&NotifierTag{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	_, err = NewWithDB(nil)
+	assert.NotNil(t, err)
+}
+
+func TestNewWithSqlDB(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+
+	n, err := NewWithSqlDB(sqlDB, SqliteDriver)
+	assert.Nil(t, err)
+	assert.Nil(t, n.Migrate())
+	assert.Nil(t, n.Seed())
+
+	// Test foreign keys are enabled, so the rows of a subscriber are removed with it
+	var foreignKeys int
+	assert.Nil(t, n.db.Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error)
+	assert.Equal(t, 1, foreignKeys)
+	subscriber, err := n.SubscribeEmail("sqldb@test.com", "first", "last", []string{"sqldb"}, true)
+	assert.Nil(t, err)
+	var count int64
+	n.db.Model(&NotifierEmailSubTag{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+	assert.Nil(t, n.db.Delete(subscriber).Error)
+	n.db.Model(&NotifierEmailSubTag{}).Count(&count)
+	assert.Equal(t, int64(0), count, "Rows of the pivot table should be removed with the subscriber")
+
+	_, err = NewWithSqlDB(nil, SqliteDriver)
+	assert.NotNil(t, err)
+}
+
+func TestNotifierWithContext(t *testing.T) {
+	n := newSqliteTestNotifier(t, "sqlite context notifier")
+	ctx, cancel := context.WithCancel(context.Background())
+	scoped := n.WithContext(ctx)
+	assert.Same(t, ctx, scoped.Context())
+	assert.Equal(t, context.Background(), n.Context())
+
+	_, err := scoped.CreateTag("before cancel")
+	assert.Nil(t, err)
+
+	// Test the queries of a canceled notifier fail, and the notifier it's made from isn't canceled
+	cancel()
+	_, err = scoped.CreateTag("after cancel")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = n.CreateTag("after cancel")
+	assert.Nil(t, err)
+
+	// Test repositories passed by options that aren't gorm repositories are kept
+	repo := &struct{ ITagRepository }{NewGormTagRepository(n.DB())}
+	n = newSqliteTestNotifier(t, "sqlite context own repository", WithTagRepository(repo))
+	assert.Same(t, repo, n.WithContext(ctx).tagRepo)
+}
@@ -0,0 +1,210 @@
+package go_notifier_core
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// PushNotification is a push message. Data is delivered to the app as is, and Image is the URL of a picture
+	// shown by the clients that support it.
+	PushNotification struct {
+		Title string
+		Body  string
+		Image string
+		Data  map[string]string
+	}
+
+	// PushSender sends push notifications by a notification driver. The Payload of the driver is passed to
+	// SetConfig of a new sender for every message. The message ID of the result is the ID the provider gave
+	// the notification.
+	PushSender interface {
+		Send(token string, notification *PushNotification) (*SendResult, error)
+		SetConfig(config []byte)
+	}
+
+	// ContextPushSender is a PushSender that stops a send when its context is done, e.g. when a worker is
+	// stopped. The built-in senders implement it, the sends of other senders aren't stopped.
+	ContextPushSender interface {
+		PushSender
+		SendContext(ctx context.Context, token string, notification *PushNotification) (*SendResult, error)
+	}
+)
+
+// InvalidPushTokenHandler handles a token the provider reported as invalid when a notification was sent to it,
+// see WithInvalidPushTokenHandler.
+type InvalidPushTokenHandler func(n *Notifier, token, reason string) error
+
+// DisableInvalidPushToken disables the token with the reason, so campaigns skip it but the subscriber and its
+// message log are kept until the token is refreshed or pruned. It's the default InvalidPushTokenHandler.
+func DisableInvalidPushToken(n *Notifier, token, reason string) error {
+	return n.DisableToken(token, reason)
+}
+
+// RemoveInvalidPushToken removes the token and its message log.
+func RemoveInvalidPushToken(n *Notifier, token, reason string) error {
+	return n.RemoveToken(token)
+}
+
+var (
+	pushSenderFactoriesMu sync.RWMutex
+	pushSenderFactories   = map[string]func() PushSender{
+		NotifierNotificationServiceFirebaseType: func() PushSender { return new(FcmSender) },
+		NotifierNotificationServiceAPNsType:     func() PushSender { return new(ApnsSender) },
+		NotifierNotificationServiceWebPushType:  func() PushSender { return new(WebPushSender) },
+	}
+)
+
+// httpPushClient sends the requests of the push senders.
+var httpPushClient = &http.Client{Timeout: 30 * time.Second}
+
+// errPushSenderNotConfigured is returned by Send when SetConfig got an invalid payload.
+var errPushSenderNotConfigured = errors.New("push sender isn't configured, check the payload of the notification driver")
+
+// RegisterPushSender registers a push sender factory for a notification driver type, for every notifier of
+// the process. It replaces the sender of a built-in type.
+func RegisterPushSender(driverType string, factory func() PushSender) {
+	pushSenderFactoriesMu.Lock()
+	pushSenderFactories[driverType] = factory
+	pushSenderFactoriesMu.Unlock()
+}
+
+func registeredPushSender(driverType string) (func() PushSender, bool) {
+	pushSenderFactoriesMu.RLock()
+	defer pushSenderFactoriesMu.RUnlock()
+	factory, ok := pushSenderFactories[driverType]
+	return factory, ok
+}
+
+// sendPushContext sends the notification by ctx when the sender is a ContextPushSender.
+func sendPushContext(ctx context.Context, sender PushSender, token string, notification *PushNotification) (*SendResult, error) {
+	if contextSender, ok := sender.(ContextPushSender); ok {
+		return contextSender.SendContext(ctx, token, notification)
+	}
+	return sender.Send(token, notification)
+}
+
+// doPushRequest sends the request and returns the body and the headers of a 2xx response.
+// Any other status is returned as a PushSenderError with the body of the response.
+func doPushRequest(sender string, req *http.Request) ([]byte, http.Header, error) {
+	res, err := httpPushClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, nil, PushSenderError{Sender: sender, StatusCode: res.StatusCode, Body: string(body)}
+	}
+	return body, res.Header, nil
+}
+
+// tokenCache keeps signed JWTs and access tokens until shortly before they expire, because senders are
+// created for every message and providers limit how often tokens are issued.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+var pushTokens = &tokenCache{tokens: map[string]cachedToken{}}
+
+// get returns the cached token of the key, or a new one of fetch cached until its expiry.
+func (c *tokenCache) get(key string, fetch func() (string, time.Time, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.tokens[key]; ok && time.Now().Before(cached.expires) {
+		return cached.token, nil
+	}
+	token, expires, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	c.tokens[key] = cachedToken{token: token, expires: expires}
+	return token, nil
+}
+
+// signJWT returns the JWT of the claims signed by RS256 for an RSA key or ES256 for an ECDSA P-256 key.
+func signJWT(key crypto.Signer, keyID string, claims map[string]interface{}) (string, error) {
+	header := map[string]string{"typ": "JWT"}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		header["alg"] = "RS256"
+	case *ecdsa.PrivateKey:
+		header["alg"] = "ES256"
+	default:
+		return "", errors.New("unsupported JWT key type")
+	}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		// JWS uses the fixed size r || s form, not the ASN.1 one
+		r, s, er := ecdsa.Sign(rand.Reader, k, digest[:])
+		err = er
+		if err == nil {
+			signature = make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey parses a PEM private key in the PKCS#8, PKCS#1 or SEC 1 form.
+func parsePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("private key isn't PEM encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
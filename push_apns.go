@@ -0,0 +1,151 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type (
+	// ApnsConfig is the payload of an APNs notification driver. PrivateKey is the PEM content of the .p8
+	// signing key of KeyID, and Topic is the bundle ID of the app.
+	ApnsConfig struct {
+		TeamID     string
+		KeyID      string
+		PrivateKey string
+		Topic      string
+		Production bool   // Sends to the production environment instead of the sandbox one.
+		BaseURL    string // Overrides the URL of the environment.
+	}
+
+	// ApnsSender sends push notifications by the HTTP/2 provider API of the Apple Push Notification service,
+	// authenticated by a provider token.
+	ApnsSender struct {
+		config *ApnsConfig
+	}
+
+	apnsAlert struct {
+		Title string `json:"title,omitempty"`
+		Body  string `json:"body,omitempty"`
+	}
+
+	apnsErrorResponse struct {
+		Reason string `json:"reason"`
+	}
+
+	apnsAps struct {
+		Alert          apnsAlert `json:"alert"`
+		MutableContent int       `json:"mutable-content,omitempty"`
+	}
+)
+
+// apnsTokenLifetime is how long a provider token is reused. APNs rejects tokens older than an hour and
+// refreshing them more often than every 20 minutes.
+const apnsTokenLifetime = 50 * time.Minute
+
+func (a *ApnsSender) Send(token string, notification *PushNotification) (*SendResult, error) {
+	return a.SendContext(context.Background(), token, notification)
+}
+
+// SendContext sends the notification to the device token. The data is sent as custom keys of the payload, and
+// the image as the "image" key with mutable-content set, for a notification service extension to download it.
+// The message ID is the apns-id of the response.
+func (a *ApnsSender) SendContext(ctx context.Context, token string, notification *PushNotification) (*SendResult, error) {
+	if a.config == nil {
+		return nil, errPushSenderNotConfigured
+	}
+	if a.config.Topic == "" {
+		return nil, errors.New("apns push sender needs the Topic")
+	}
+	providerToken, err := a.providerToken()
+	if err != nil {
+		return nil, err
+	}
+
+	payload := map[string]interface{}{}
+	for key, value := range notification.Data {
+		payload[key] = value
+	}
+	aps := apnsAps{Alert: apnsAlert{Title: notification.Title, Body: notification.Body}}
+	if notification.Image != "" {
+		aps.MutableContent = 1
+		payload["image"] = notification.Image
+	}
+	payload["aps"] = aps
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := "https://api.sandbox.push.apple.com"
+	if a.config.Production {
+		baseURL = "https://api.push.apple.com"
+	}
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		mailerURL(a.config.BaseURL, baseURL, "/3/device/"+url.PathEscape(token)),
+		bytes.NewReader(data),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", a.config.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	_, header, err := doPushRequest(NotifierNotificationServiceAPNsType, req)
+	if err != nil {
+		return nil, apnsInvalidToken(err)
+	}
+	return &SendResult{MessageID: header.Get("apns-id")}, nil
+}
+
+// apnsInvalidToken returns an InvalidPushTokenError when APNs rejected the device token, otherwise the error itself.
+func apnsInvalidToken(err error) error {
+	var senderErr PushSenderError
+	if !errors.As(err, &senderErr) {
+		return err
+	}
+	var res apnsErrorResponse
+	_ = json.Unmarshal([]byte(senderErr.Body), &res)
+	switch {
+	case senderErr.StatusCode == http.StatusGone,
+		res.Reason == "BadDeviceToken",
+		res.Reason == "DeviceTokenNotForTopic",
+		res.Reason == "Unregistered":
+		reason := res.Reason
+		if reason == "" {
+			reason = "Unregistered"
+		}
+		return InvalidPushTokenError{Sender: NotifierNotificationServiceAPNsType, Reason: reason, Err: err}
+	}
+	return err
+}
+
+func (a *ApnsSender) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &a.config)
+	if err != nil {
+		return
+	}
+}
+
+// providerToken returns the ES256 JWT of the team and the key, cached for apnsTokenLifetime.
+func (a *ApnsSender) providerToken() (string, error) {
+	return pushTokens.get("apns:"+a.config.TeamID+":"+a.config.KeyID, func() (string, time.Time, error) {
+		key, err := parsePrivateKey(a.config.PrivateKey)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		now := time.Now()
+		token, err := signJWT(key, a.config.KeyID, map[string]interface{}{
+			"iss": a.config.TeamID,
+			"iat": now.Unix(),
+		})
+		return token, now.Add(apnsTokenLifetime), err
+	})
+}
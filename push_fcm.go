@@ -0,0 +1,239 @@
+package go_notifier_core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	// FcmConfig is the payload of a Firebase notification driver. ServiceAccount is the JSON key of a Google
+	// service account, it's exchanged for an access token of the FCM scope. A static AccessToken is used as is
+	// instead, e.g. when the token is issued by another service.
+	FcmConfig struct {
+		ProjectID      string          // Defaults to the project_id of the service account.
+		ServiceAccount json.RawMessage // The key file as a JSON object or a string.
+		AccessToken    string
+		BaseURL        string // Defaults to https://fcm.googleapis.com
+		TokenURL       string // Defaults to the token_uri of the service account.
+	}
+
+	// FcmSender sends push notifications by the HTTP v1 API of Firebase Cloud Messaging.
+	FcmSender struct {
+		config *FcmConfig
+	}
+
+	fcmServiceAccount struct {
+		ProjectID    string `json:"project_id"`
+		PrivateKeyID string `json:"private_key_id"`
+		PrivateKey   string `json:"private_key"`
+		ClientEmail  string `json:"client_email"`
+		TokenURI     string `json:"token_uri"`
+	}
+
+	fcmNotification struct {
+		Title string `json:"title,omitempty"`
+		Body  string `json:"body,omitempty"`
+		Image string `json:"image,omitempty"`
+	}
+
+	fcmMessage struct {
+		Token        string            `json:"token"`
+		Notification fcmNotification   `json:"notification"`
+		Data         map[string]string `json:"data,omitempty"`
+	}
+
+	fcmRequest struct {
+		Message fcmMessage `json:"message"`
+	}
+
+	fcmResponse struct {
+		Name string `json:"name"`
+	}
+
+	fcmErrorResponse struct {
+		Error struct {
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+
+	fcmTokenResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+func (f *FcmSender) Send(token string, notification *PushNotification) (*SendResult, error) {
+	return f.SendContext(context.Background(), token, notification)
+}
+
+// SendContext sends the notification to the registration token. The message ID is the name of the message, e.g.
+// "projects/my-project/messages/0:1500415314455276%31bd1c9631bd1c96".
+func (f *FcmSender) SendContext(ctx context.Context, token string, notification *PushNotification) (*SendResult, error) {
+	if f.config == nil {
+		return nil, errPushSenderNotConfigured
+	}
+	account, err := f.serviceAccount()
+	if err != nil {
+		return nil, err
+	}
+	projectID := f.config.ProjectID
+	if projectID == "" && account != nil {
+		projectID = account.ProjectID
+	}
+	if projectID == "" {
+		return nil, errors.New("firebase push sender needs the ProjectID")
+	}
+	accessToken, err := f.accessToken(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	req, _, err := newJSONRequest(
+		ctx,
+		mailerURL(f.config.BaseURL, "https://fcm.googleapis.com", "/v1/projects/"+url.PathEscape(projectID)+"/messages:send"),
+		fcmRequest{Message: fcmMessage{
+			Token:        token,
+			Notification: fcmNotification{Title: notification.Title, Body: notification.Body, Image: notification.Image},
+			Data:         notification.Data,
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	body, _, err := doPushRequest(NotifierNotificationServiceFirebaseType, req)
+	if err != nil {
+		return nil, fcmInvalidToken(err)
+	}
+
+	var res fcmResponse
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: res.Name}, nil
+}
+
+// fcmInvalidToken returns an InvalidPushTokenError when FCM rejected the token as unregistered, of another
+// project or malformed, otherwise the error itself.
+func fcmInvalidToken(err error) error {
+	var senderErr PushSenderError
+	if !errors.As(err, &senderErr) {
+		return err
+	}
+	var res fcmErrorResponse
+	if json.Unmarshal([]byte(senderErr.Body), &res) != nil {
+		return err
+	}
+	for _, detail := range res.Error.Details {
+		switch detail.ErrorCode {
+		case "UNREGISTERED", "SENDER_ID_MISMATCH":
+			return InvalidPushTokenError{Sender: NotifierNotificationServiceFirebaseType, Reason: detail.ErrorCode, Err: err}
+		case "INVALID_ARGUMENT":
+			// Invalid messages have the same code, only the message tells the token is the invalid argument
+			if strings.Contains(res.Error.Message, "registration token") {
+				return InvalidPushTokenError{Sender: NotifierNotificationServiceFirebaseType, Reason: detail.ErrorCode, Err: err}
+			}
+		}
+	}
+	return err
+}
+
+func (f *FcmSender) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &f.config)
+	if err != nil {
+		return
+	}
+}
+
+func (f *FcmSender) serviceAccount() (*fcmServiceAccount, error) {
+	data := []byte(f.config.ServiceAccount)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	// The key file may be embedded as an object or as a string of its content
+	if data[0] == '"' {
+		var content string
+		err := json.Unmarshal(data, &content)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(content)
+	}
+	var account fcmServiceAccount
+	err := json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// accessToken returns the static access token, or exchanges a JWT signed by the service account for an
+// OAuth2 access token. Access tokens are cached per service account until a minute before they expire.
+func (f *FcmSender) accessToken(ctx context.Context, account *fcmServiceAccount) (string, error) {
+	if f.config.AccessToken != "" {
+		return f.config.AccessToken, nil
+	}
+	if account == nil {
+		return "", errors.New("firebase push sender needs a ServiceAccount or an AccessToken")
+	}
+	tokenURL := f.config.TokenURL
+	if tokenURL == "" {
+		tokenURL = account.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = "https://oauth2.googleapis.com/token"
+	}
+
+	return pushTokens.get("fcm:"+account.ClientEmail+":"+tokenURL, func() (string, time.Time, error) {
+		key, err := parsePrivateKey(account.PrivateKey)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		now := time.Now()
+		assertion, err := signJWT(key, account.PrivateKeyID, map[string]interface{}{
+			"iss":   account.ClientEmail,
+			"scope": fcmScope,
+			"aud":   tokenURL,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		form := url.Values{}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		body, _, err := doPushRequest(NotifierNotificationServiceFirebaseType, req)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		var res fcmTokenResponse
+		err = json.Unmarshal(body, &res)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if res.AccessToken == "" {
+			return "", time.Time{}, errors.New("firebase token endpoint returned no access token")
+		}
+		return res.AccessToken, now.Add(time.Duration(res.ExpiresIn)*time.Second - time.Minute), nil
+	})
+}
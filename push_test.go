@@ -0,0 +1,369 @@
+package go_notifier_core
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakePush struct {
+	token        string
+	notification PushNotification
+}
+
+// fakePushSender records the notifications it sends. Tokens of invalid are rejected with their reason.
+type fakePushSender struct {
+	mu      sync.Mutex
+	sent    []fakePush
+	invalid map[string]string
+}
+
+func (f *fakePushSender) Send(token string, notification *PushNotification) (*SendResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, fakePush{token, *notification})
+	if reason, ok := f.invalid[token]; ok {
+		return nil, InvalidPushTokenError{Sender: fakePushSenderType, Reason: reason, Err: errors.New("token is invalid")}
+	}
+	return &SendResult{MessageID: "msg-" + token}, nil
+}
+
+func (f *fakePushSender) SetConfig(config []byte) {
+}
+
+func (f *fakePushSender) Sent() []fakePush {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakePush(nil), f.sent...)
+}
+
+const fakePushSenderType = "fake"
+
+func pemPrivateKey(t *testing.T, key crypto.Signer) string {
+	data, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data}))
+}
+
+// verifyJWT checks the signature of the JWT by the key and returns its header and claims.
+func verifyJWT(t *testing.T, token string, key crypto.PublicKey) (map[string]interface{}, map[string]interface{}) {
+	parts := strings.Split(token, ".")
+	if !assert.Len(t, parts, 3) {
+		return nil, nil
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.Nil(t, err)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		assert.Nil(t, rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature))
+	case *ecdsa.PublicKey:
+		assert.Len(t, signature, 64)
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		assert.True(t, ecdsa.Verify(k, digest[:], r, s), "JWT signature should be valid")
+	}
+	var header, claims map[string]interface{}
+	data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	assert.Nil(t, json.Unmarshal(data, &header))
+	data, _ = base64.RawURLEncoding.DecodeString(parts[1])
+	assert.Nil(t, json.Unmarshal(data, &claims))
+	return header, claims
+}
+
+func TestFcmSenderSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusOK, `{"name":"projects/notifier/messages/0:1500415314455276"}`)
+	sender := &FcmSender{}
+	sender.SetConfig(stub.config(t, map[string]string{"ProjectID": "notifier", "AccessToken": "static"}))
+
+	result, err := sender.Send("device-token", &PushNotification{
+		Title: "Title",
+		Body:  "Body",
+		Image: "https://example.com/image.png",
+		Data:  map[string]string{"order": "12"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "projects/notifier/messages/0:1500415314455276", result.MessageID)
+	assert.Equal(t, "/v1/projects/notifier/messages:send", stub.req.URL.Path)
+	assert.Equal(t, "Bearer static", stub.req.Header.Get("Authorization"))
+	assert.JSONEq(t, `{"message":{"token":"device-token","notification":{"title":"Title","body":"Body","image":"https://example.com/image.png"},"data":{"order":"12"}}}`, string(stub.body))
+
+	// Test an error of the API
+	stub = newProviderStub(t, http.StatusNotFound, `{"error":{"status":"NOT_FOUND","message":"Requested entity was not found."}}`)
+	sender.SetConfig(stub.config(t, map[string]string{"ProjectID": "notifier", "AccessToken": "static"}))
+	_, err = sender.Send("device-token", &PushNotification{Title: "Title"})
+	var senderErr PushSenderError
+	assert.ErrorAs(t, err, &senderErr)
+	assert.Equal(t, http.StatusNotFound, senderErr.StatusCode)
+	assert.Contains(t, senderErr.Body, "NOT_FOUND")
+
+	// Test an invalid payload
+	sender = &FcmSender{}
+	sender.SetConfig([]byte("this is not valid JSON"))
+	_, err = sender.Send("device-token", &PushNotification{})
+	assert.ErrorIs(t, err, errPushSenderNotConfigured)
+}
+
+func TestFcmSenderServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	tokenStub := newProviderStub(t, http.StatusOK, `{"access_token":"issued","expires_in":3600,"token_type":"Bearer"}`)
+	account, err := json.Marshal(map[string]string{
+		"project_id":     "notifier",
+		"private_key_id": "key-id",
+		"private_key":    pemPrivateKey(t, key),
+		"client_email":   "sender@notifier.iam.gserviceaccount.com",
+		"token_uri":      tokenStub.server.URL + "/token",
+	})
+	assert.Nil(t, err)
+	stub := newProviderStub(t, http.StatusOK, `{"name":"projects/notifier/messages/1"}`)
+	sender := &FcmSender{}
+	// The key file is stored as a string of its content
+	sender.SetConfig(stub.config(t, map[string]string{"ServiceAccount": string(account)}))
+
+	_, err = sender.Send("device-token", &PushNotification{Title: "Title"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1/projects/notifier/messages:send", stub.req.URL.Path)
+	assert.Equal(t, "Bearer issued", stub.req.Header.Get("Authorization"))
+
+	assert.Equal(t, "/token", tokenStub.req.URL.Path)
+	form, err := url.ParseQuery(string(tokenStub.body))
+	assert.Nil(t, err)
+	assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", form.Get("grant_type"))
+	header, claims := verifyJWT(t, form.Get("assertion"), &key.PublicKey)
+	assert.Equal(t, "RS256", header["alg"])
+	assert.Equal(t, "key-id", header["kid"])
+	assert.Equal(t, "sender@notifier.iam.gserviceaccount.com", claims["iss"])
+	assert.Equal(t, fcmScope, claims["scope"])
+	assert.Equal(t, tokenStub.server.URL+"/token", claims["aud"])
+
+	// Test the access token is cached
+	tokenStub.req = nil
+	_, err = sender.Send("device-token", &PushNotification{Title: "Title"})
+	assert.Nil(t, err)
+	assert.Nil(t, tokenStub.req, "Access token should be reused")
+}
+
+func TestApnsSenderSend(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	stub := newProviderStub(t, http.StatusOK, "")
+	stub.header.Set("apns-id", "EC1BF194-B3B2-424A-89A9-5A918A6E4B37")
+	sender := &ApnsSender{}
+	config := map[string]string{"TeamID": "TEAM123456", "KeyID": "KEY1234567", "PrivateKey": pemPrivateKey(t, key), "Topic": "com.example.app"}
+	sender.SetConfig(stub.config(t, config))
+
+	result, err := sender.Send("a1b2c3", &PushNotification{
+		Title: "Title",
+		Body:  "Body",
+		Image: "https://example.com/image.png",
+		Data:  map[string]string{"order": "12"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "EC1BF194-B3B2-424A-89A9-5A918A6E4B37", result.MessageID)
+	assert.Equal(t, "/3/device/a1b2c3", stub.req.URL.Path)
+	assert.Equal(t, "com.example.app", stub.req.Header.Get("apns-topic"))
+	assert.Equal(t, "alert", stub.req.Header.Get("apns-push-type"))
+	assert.JSONEq(t, `{"aps":{"alert":{"title":"Title","body":"Body"},"mutable-content":1},"image":"https://example.com/image.png","order":"12"}`, string(stub.body))
+
+	authorization := stub.req.Header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(authorization, "bearer "))
+	header, claims := verifyJWT(t, strings.TrimPrefix(authorization, "bearer "), &key.PublicKey)
+	assert.Equal(t, "ES256", header["alg"])
+	assert.Equal(t, "KEY1234567", header["kid"])
+	assert.Equal(t, "TEAM123456", claims["iss"])
+
+	// Test the reason of a rejected token
+	stub = newProviderStub(t, http.StatusBadRequest, `{"reason":"BadDeviceToken"}`)
+	sender.SetConfig(stub.config(t, config))
+	_, err = sender.Send("a1b2c3", &PushNotification{Title: "Title"})
+	var senderErr PushSenderError
+	assert.ErrorAs(t, err, &senderErr)
+	assert.Equal(t, NotifierNotificationServiceAPNsType, senderErr.Sender)
+	assert.Contains(t, senderErr.Body, "BadDeviceToken")
+}
+
+func TestWebPushSenderSend(t *testing.T) {
+	// The keys of the browser
+	userKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	authSecret := make([]byte, 16)
+	_, _ = rand.Read(authSecret)
+	vapidKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	stub := newProviderStub(t, http.StatusCreated, "")
+	stub.header.Set("Location", "https://push.example.com/message/1")
+	subscription, err := json.Marshal(map[string]interface{}{
+		"endpoint": stub.server.URL + "/push/abc",
+		"keys": map[string]string{
+			"p256dh": base64.RawURLEncoding.EncodeToString(userKey.PublicKey().Bytes()),
+			"auth":   base64.URLEncoding.EncodeToString(authSecret),
+		},
+	})
+	assert.Nil(t, err)
+	sender := &WebPushSender{}
+	sender.SetConfig([]byte(`{"VAPIDPrivateKey":"` + base64.RawURLEncoding.EncodeToString(vapidKey.D.FillBytes(make([]byte, 32))) + `","Subject":"mailto:admin@example.com","TTL":60}`))
+
+	notification := &PushNotification{Title: "Title", Body: "Body", Data: map[string]string{"order": "12"}}
+	result, err := sender.Send(string(subscription), notification)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://push.example.com/message/1", result.MessageID)
+	assert.Equal(t, "/push/abc", stub.req.URL.Path)
+	assert.Equal(t, "aes128gcm", stub.req.Header.Get("Content-Encoding"))
+	assert.Equal(t, "60", stub.req.Header.Get("TTL"))
+
+	authorization := stub.req.Header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(authorization, "vapid t="))
+	parts := strings.SplitN(strings.TrimPrefix(authorization, "vapid t="), ", k=", 2)
+	assert.Len(t, parts, 2)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), vapidKey.X, vapidKey.Y)), parts[1])
+	_, claims := verifyJWT(t, parts[0], &vapidKey.PublicKey)
+	assert.Equal(t, stub.server.URL, claims["aud"])
+	assert.Equal(t, "mailto:admin@example.com", claims["sub"])
+
+	// Test the browser decrypts the message
+	body := stub.body
+	assert.Equal(t, uint32(webPushRecordSize), binary.BigEndian.Uint32(body[16:20]))
+	keyLength := int(body[20])
+	serverPublic := body[21 : 21+keyLength]
+	serverKey, err := ecdh.P256().NewPublicKey(serverPublic)
+	assert.Nil(t, err)
+	sharedSecret, err := userKey.ECDH(serverKey)
+	assert.Nil(t, err)
+	cek, nonce, err := webPushKeys(sharedSecret, authSecret, body[:16], userKey.PublicKey().Bytes(), serverPublic)
+	assert.Nil(t, err)
+	block, err := aes.NewCipher(cek)
+	assert.Nil(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.Nil(t, err)
+	plain, err := gcm.Open(nil, nonce, body[21+keyLength:], nil)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(2), plain[len(plain)-1], "Record should end with the last record delimiter")
+	assert.JSONEq(t, `{"title":"Title","body":"Body","data":{"order":"12"}}`, string(plain[:len(plain)-1]))
+
+	// Test an expired subscription
+	stub = newProviderStub(t, http.StatusGone, "push subscription has unsubscribed or expired")
+	_, err = sender.Send(`{"endpoint":"`+stub.server.URL+`/push/abc","keys":{"p256dh":"`+base64.RawURLEncoding.EncodeToString(userKey.PublicKey().Bytes())+`","auth":"`+base64.RawURLEncoding.EncodeToString(authSecret)+`"}}`, notification)
+	var senderErr PushSenderError
+	assert.ErrorAs(t, err, &senderErr)
+	assert.Equal(t, http.StatusGone, senderErr.StatusCode)
+
+	// Test an invalid token
+	_, err = sender.Send("device-token", notification)
+	assert.NotNil(t, err)
+}
+
+func TestPushSendersInvalidToken(t *testing.T) {
+	var invalid InvalidPushTokenError
+
+	// FCM
+	stub := newProviderStub(t, http.StatusNotFound, `{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND",`+
+		`"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+	fcm := &FcmSender{}
+	fcm.SetConfig(stub.config(t, map[string]string{"ProjectID": "notifier", "AccessToken": "static"}))
+	_, err := fcm.Send("device-token", &PushNotification{Title: "Title"})
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "UNREGISTERED", invalid.Reason)
+	assert.ErrorAs(t, err, &PushSenderError{})
+
+	stub = newProviderStub(t, http.StatusBadRequest, `{"error":{"code":400,"message":"The registration token is not a valid FCM registration token",`+
+		`"status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`)
+	fcm.SetConfig(stub.config(t, map[string]string{"ProjectID": "notifier", "AccessToken": "static"}))
+	_, err = fcm.Send("device-token", &PushNotification{Title: "Title"})
+	assert.ErrorAs(t, err, &invalid)
+
+	// Test an invalid message doesn't invalidate the token
+	stub = newProviderStub(t, http.StatusBadRequest, `{"error":{"code":400,"message":"Invalid value at 'message.data'",`+
+		`"status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`)
+	fcm.SetConfig(stub.config(t, map[string]string{"ProjectID": "notifier", "AccessToken": "static"}))
+	_, err = fcm.Send("device-token", &PushNotification{Title: "Title"})
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &invalid))
+
+	// APNs
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	apnsConfig := map[string]string{"TeamID": "TEAM123456", "KeyID": "KEY1234567", "PrivateKey": pemPrivateKey(t, key), "Topic": "com.example.app"}
+	stub = newProviderStub(t, http.StatusGone, `{"reason":"Unregistered","timestamp":1700000000000}`)
+	apns := &ApnsSender{}
+	apns.SetConfig(stub.config(t, apnsConfig))
+	_, err = apns.Send("a1b2c3", &PushNotification{Title: "Title"})
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "Unregistered", invalid.Reason)
+
+	stub = newProviderStub(t, http.StatusBadRequest, `{"reason":"PayloadEmpty"}`)
+	apns.SetConfig(stub.config(t, apnsConfig))
+	_, err = apns.Send("a1b2c3", &PushNotification{Title: "Title"})
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &invalid))
+
+	// Web Push
+	vapidKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	userKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	stub = newProviderStub(t, http.StatusGone, "")
+	webPush := &WebPushSender{}
+	webPush.SetConfig([]byte(`{"VAPIDPrivateKey":"` + base64.RawURLEncoding.EncodeToString(vapidKey.D.FillBytes(make([]byte, 32))) + `"}`))
+	_, err = webPush.Send(`{"endpoint":"`+stub.server.URL+`/push/abc","keys":{"p256dh":"`+
+		base64.RawURLEncoding.EncodeToString(userKey.PublicKey().Bytes())+`","auth":"c2VjcmV0c2VjcmV0c2VjcmV0"}}`, &PushNotification{Title: "Title"})
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "Gone", invalid.Reason)
+
+	_, err = webPush.Send("device-token", &PushNotification{Title: "Title"})
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "InvalidSubscription", invalid.Reason)
+}
+
+func TestRegisterPushSender(t *testing.T) {
+	n := newNotifier(nil)
+	for driverType, senderType := range map[string]PushSender{
+		NotifierNotificationServiceFirebaseType: &FcmSender{},
+		NotifierNotificationServiceAPNsType:     &ApnsSender{},
+		NotifierNotificationServiceWebPushType:  &WebPushSender{},
+	} {
+		sender, err := n.pushSender(driverType)
+		assert.Nil(t, err)
+		assert.IsType(t, senderType, sender)
+	}
+
+	_, err := n.pushSender("Gateway")
+	assert.NotNil(t, err)
+
+	gateway := &fakePushSender{}
+	RegisterPushSender("Gateway", func() PushSender { return gateway })
+	defer func() {
+		pushSenderFactoriesMu.Lock()
+		delete(pushSenderFactories, "Gateway")
+		pushSenderFactoriesMu.Unlock()
+	}()
+	sender, err := n.pushSender("Gateway")
+	assert.Nil(t, err)
+	assert.Same(t, gateway, sender)
+
+	// Test senders of the notifier take precedence
+	own := &fakePushSender{}
+	n = newNotifier(nil, WithPushSender("Gateway", func() PushSender { return own }))
+	sender, err = n.pushSender("Gateway")
+	assert.Nil(t, err)
+	assert.Same(t, own, sender)
+}
@@ -0,0 +1,265 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+type (
+	// WebPushConfig is the payload of a WebPush notification driver. VAPIDPrivateKey is the base64url
+	// encoded P-256 private key of the application server, the public key is derived from it. Subject is
+	// a mailto: or https: URL push services can contact the sender by.
+	WebPushConfig struct {
+		VAPIDPrivateKey string
+		Subject         string
+		TTL             int // Seconds the push service keeps an undelivered message, defaults to 4 weeks.
+	}
+
+	// WebPushSender sends push notifications to browsers by the Web Push protocol. The token of a subscriber
+	// is the JSON of the PushSubscription of the browser, e.g.
+	// {"endpoint":"https://...","keys":{"p256dh":"...","auth":"..."}}.
+	//
+	// The service worker gets the notification as a JSON message with title, body, image and data fields.
+	WebPushSender struct {
+		config *WebPushConfig
+	}
+
+	webPushSubscription struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+
+	webPushMessage struct {
+		Title string            `json:"title,omitempty"`
+		Body  string            `json:"body,omitempty"`
+		Image string            `json:"image,omitempty"`
+		Data  map[string]string `json:"data,omitempty"`
+	}
+)
+
+const (
+	webPushDefaultTTL = 4 * 7 * 24 * 60 * 60
+	webPushRecordSize = 4096
+	// vapidTokenLifetime is how long a VAPID JWT is reused, the JWT itself expires an hour later.
+	vapidTokenLifetime = 11 * time.Hour
+)
+
+func (w *WebPushSender) Send(token string, notification *PushNotification) (*SendResult, error) {
+	return w.SendContext(context.Background(), token, notification)
+}
+
+// SendContext encrypts the notification for the subscription by the aes128gcm content encoding (RFC 8291) and
+// posts it to the endpoint with a VAPID authorization (RFC 8292). The message ID is the Location of the response.
+func (w *WebPushSender) SendContext(ctx context.Context, token string, notification *PushNotification) (*SendResult, error) {
+	if w.config == nil {
+		return nil, errPushSenderNotConfigured
+	}
+	var subscription webPushSubscription
+	err := json.Unmarshal([]byte(token), &subscription)
+	if err != nil {
+		return nil, invalidWebPushSubscription(errors.New("web push token isn't a push subscription : " + err.Error()))
+	}
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, invalidWebPushSubscription(errors.New("web push subscription has an invalid endpoint"))
+	}
+
+	message, err := json.Marshal(webPushMessage{
+		Title: notification.Title,
+		Body:  notification.Body,
+		Image: notification.Image,
+		Data:  notification.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	body, err := encryptWebPush(message, subscription)
+	if err != nil {
+		return nil, err
+	}
+	authorization, err := w.vapidAuthorization(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := w.config.TTL
+	if ttl <= 0 {
+		ttl = webPushDefaultTTL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(ttl))
+	req.Header.Set("Authorization", authorization)
+	_, header, err := doPushRequest(NotifierNotificationServiceWebPushType, req)
+	var senderErr PushSenderError
+	if errors.As(err, &senderErr) && (senderErr.StatusCode == http.StatusNotFound || senderErr.StatusCode == http.StatusGone) {
+		// The subscription expired or the browser unsubscribed
+		return nil, InvalidPushTokenError{Sender: NotifierNotificationServiceWebPushType, Reason: http.StatusText(senderErr.StatusCode), Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: header.Get("Location")}, nil
+}
+
+func (w *WebPushSender) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &w.config)
+	if err != nil {
+		return
+	}
+}
+
+func invalidWebPushSubscription(err error) error {
+	return InvalidPushTokenError{Sender: NotifierNotificationServiceWebPushType, Reason: "InvalidSubscription", Err: err}
+}
+
+// vapidAuthorization returns the "vapid" Authorization header of the audience, with a JWT cached for
+// vapidTokenLifetime.
+func (w *WebPushSender) vapidAuthorization(audience string) (string, error) {
+	key, err := vapidKey(w.config.VAPIDPrivateKey)
+	if err != nil {
+		return "", err
+	}
+	publicKey := base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y))
+	token, err := pushTokens.get("vapid:"+publicKey+":"+audience, func() (string, time.Time, error) {
+		now := time.Now()
+		claims := map[string]interface{}{
+			"aud": audience,
+			"exp": now.Add(vapidTokenLifetime + time.Hour).Unix(),
+		}
+		if w.config.Subject != "" {
+			claims["sub"] = w.config.Subject
+		}
+		token, err := signJWT(key, "", claims)
+		return token, now.Add(vapidTokenLifetime), err
+	})
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + token + ", k=" + publicKey, nil
+}
+
+// vapidKey decodes a base64url P-256 private key.
+func vapidKey(privateKey string) (*ecdsa.PrivateKey, error) {
+	d, err := decodeBase64URL(privateKey)
+	if err != nil {
+		return nil, errors.New("invalid VAPID private key : " + err.Error())
+	}
+	key, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, errors.New("invalid VAPID private key : " + err.Error())
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), key.PublicKey().Bytes())
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		D:         new(big.Int).SetBytes(d),
+	}, nil
+}
+
+// encryptWebPush encrypts the message as a single aes128gcm record for the keys of the subscription.
+func encryptWebPush(message []byte, subscription webPushSubscription) ([]byte, error) {
+	userPublic, err := decodeBase64URL(subscription.Keys.P256dh)
+	if err != nil {
+		return nil, errors.New("invalid p256dh key of the web push subscription : " + err.Error())
+	}
+	authSecret, err := decodeBase64URL(subscription.Keys.Auth)
+	if err != nil {
+		return nil, errors.New("invalid auth secret of the web push subscription : " + err.Error())
+	}
+	userKey, err := ecdh.P256().NewPublicKey(userPublic)
+	if err != nil {
+		return nil, errors.New("invalid p256dh key of the web push subscription : " + err.Error())
+	}
+	serverKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := serverKey.ECDH(userKey)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	serverPublic := serverKey.PublicKey().Bytes()
+
+	cek, nonce, err := webPushKeys(sharedSecret, authSecret, salt, userPublic, serverPublic)
+	if err != nil {
+		return nil, err
+	}
+	// The record is the message followed by the delimiter of the last record, with no padding
+	if len(message)+1+16 > webPushRecordSize {
+		return nil, errors.New("web push message is too large")
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 21+len(serverPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(serverPublic)))
+	header = append(header, serverPublic...)
+	return gcm.Seal(header, nonce, append(message, 2), nil), nil
+}
+
+// webPushKeys derives the content encryption key and the nonce of a message (RFC 8291 section 3.4).
+func webPushKeys(sharedSecret, authSecret, salt, userPublic, serverPublic []byte) ([]byte, []byte, error) {
+	keyInfo := append(append([]byte("WebPush: info\x00"), userPublic...), serverPublic...)
+	ikm := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, authSecret, keyInfo), ikm)
+	if err != nil {
+		return nil, nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, 12)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cek, nonce, nil
+}
+
+// decodeBase64URL decodes base64url with or without padding, as browsers and key generators use both.
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
@@ -0,0 +1,289 @@
+package go_notifier_core
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueueBackend is the transport of jobs. JobWorker reserves messages as a consumer, runs them by the JobHandler of
+// their type, then acks or nacks them. A reserved message that isn't acked, nacked or delayed before its lease
+// expires is reserved again by any consumer.
+//
+// Queue keeps messages in memory and DatabaseQueue in the jobs table, the default. An adapter for a stream, e.g.
+// Redis streams or NATS JetStream, maps Enqueue to XADD or Publish, Reserve to XREADGROUP (and XAUTOCLAIM of expired
+// leases) or Fetch of a consumer with the lease as ack wait, Ack to XACK or Ack, Nack to XACK or Term, and Delay to
+// a sorted set of delayed messages or NakWithDelay. The Receipt of a reserved message holds what's needed to ack it,
+// e.g. the stream ID. The adapter keeps a counter of unfinished messages by batch for Pending, skips enqueued
+// unique keys, e.g. by SETNX or the Nats-Msg-Id header, and drops messages delivered MaxAttempts times, e.g. by
+// the delivery count of XPENDING or MaxDeliver.
+type QueueBackend interface {
+	// Enqueue adds the messages, skipping the ones whose UniqueKey is already enqueued.
+	Enqueue(messages ...QueueMessage) error
+	// Reserve leases at most limit available messages to consumer for lease, and returns them with their Receipt.
+	// A message reserved MaxAttempts times isn't reserved again but finished as dead.
+	Reserve(consumer string, limit int, lease time.Duration) ([]QueueMessage, error)
+	// Ack finishes a reserved message as done.
+	Ack(message QueueMessage) error
+	// Nack finishes a reserved message as failed by reason.
+	Nack(message QueueMessage, reason error) error
+	// Delay releases a reserved message, to be reserved again after delay.
+	Delay(message QueueMessage, delay time.Duration) error
+	// Pending counts the messages of the batch that aren't finished.
+	Pending(batch string) (int64, error)
+}
+
+// QueueMessage is a job of a QueueBackend: the type name, which selects the JobHandler, and the JSON payload
+// of the job. Batch groups the jobs of a campaign, and a message of an enqueued UniqueKey is skipped.
+// A message is available from AvailableAt, or right away when it's zero, and is reserved at most MaxAttempts
+// times, 25 when it's zero. ID, Attempts and Receipt are set by the backend.
+type QueueMessage struct {
+	ID          string          `json:"id,omitempty"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Batch       string          `json:"batch,omitempty"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	AvailableAt time.Time       `json:"available_at,omitempty"`
+	Attempts    uint            `json:"attempts,omitempty"`
+	MaxAttempts uint            `json:"max_attempts,omitempty"`
+	Receipt     string          `json:"-"`
+}
+
+// NewQueueMessage returns a message of the job type with the JSON of payload.
+func NewQueueMessage(jobType string, payload any) (*QueueMessage, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueMessage{Type: jobType, Payload: data}, nil
+}
+
+// Queue is the in-memory QueueBackend. Its messages are lost when the process stops, so it suits tests and
+// single process setups that don't need to resume campaigns. The UniqueKey of a message is released when it's
+// acked or nacked, so the memory of a long running process doesn't grow by the finished messages.
+type Queue struct {
+	mu       sync.Mutex
+	lastId   uint64
+	messages []*queueEntry
+	keys     map[string]bool
+}
+
+type queueEntry struct {
+	message     QueueMessage
+	availableAt time.Time
+	lockedUntil time.Time
+	reserved    bool
+}
+
+func NewQueue() *Queue {
+	return &Queue{keys: map[string]bool{}}
+}
+
+func (q *Queue) Enqueue(messages ...QueueMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, message := range messages {
+		if message.UniqueKey != "" {
+			if q.keys[message.UniqueKey] {
+				continue
+			}
+			q.keys[message.UniqueKey] = true
+		}
+		q.lastId++
+		message.ID = strconv.FormatUint(q.lastId, 10)
+		message.Attempts = 0
+		message.Receipt = ""
+		if message.AvailableAt.IsZero() {
+			message.AvailableAt = time.Now()
+		}
+		if message.MaxAttempts == 0 {
+			message.MaxAttempts = defaultJobMaxAttempts
+		}
+		q.messages = append(q.messages, &queueEntry{message: message, availableAt: message.AvailableAt})
+	}
+	return nil
+}
+
+func (q *Queue) Reserve(consumer string, limit int, lease time.Duration) ([]QueueMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	receipt := newJobClaim(consumer)
+	var messages []QueueMessage
+	for i := 0; i < len(q.messages) && len(messages) < limit; i++ {
+		entry := q.messages[i]
+		if entry.reserved && !entry.lockedUntil.Before(now) || !entry.reserved && entry.availableAt.After(now) {
+			continue
+		}
+		if entry.message.Attempts >= entry.message.MaxAttempts {
+			log.Printf("Job %s (%s) is dead : %s", entry.message.ID, entry.message.Type, errJobOutOfAttempts)
+			q.remove(i)
+			i--
+			continue
+		}
+		entry.reserved = true
+		entry.lockedUntil = now.Add(lease)
+		entry.message.Attempts++
+		entry.message.Receipt = receipt
+		messages = append(messages, entry.message)
+	}
+	return messages, nil
+}
+
+// reserved returns the index of the entry of a reserved message, or NotFoundError when its lease is expired
+// and it's reserved again.
+func (q *Queue) reserved(message QueueMessage) (int, error) {
+	for i, entry := range q.messages {
+		if entry.message.ID == message.ID && entry.reserved && entry.message.Receipt == message.Receipt {
+			return i, nil
+		}
+	}
+	return 0, NotFoundError{}
+}
+
+func (q *Queue) Ack(message QueueMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.reserved(message)
+	if err != nil {
+		return err
+	}
+	q.remove(i)
+	return nil
+}
+
+// remove removes the entry of index i and releases its unique key.
+func (q *Queue) remove(i int) {
+	if key := q.messages[i].message.UniqueKey; key != "" {
+		delete(q.keys, key)
+	}
+	q.messages = append(q.messages[:i], q.messages[i+1:]...)
+}
+
+// Nack removes the message like Ack, and logs it as failed by reason as the queue doesn't keep failed messages.
+func (q *Queue) Nack(message QueueMessage, reason error) error {
+	err := q.Ack(message)
+	if err != nil {
+		return err
+	}
+	log.Printf("Job %s (%s) is failed : %s", message.ID, message.Type, reason)
+	return nil
+}
+
+func (q *Queue) Delay(message QueueMessage, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.reserved(message)
+	if err != nil {
+		return err
+	}
+	entry := q.messages[i]
+	entry.reserved = false
+	entry.availableAt = time.Now().Add(delay)
+	entry.message.AvailableAt = entry.availableAt
+	entry.message.Receipt = ""
+	return nil
+}
+
+func (q *Queue) Pending(batch string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var count int64
+	for _, entry := range q.messages {
+		if entry.message.Batch == batch {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DatabaseQueue is the QueueBackend of the jobs table, the default of a Notifier. Messages are claimed by
+// SELECT ... FOR UPDATE SKIP LOCKED, see IJobRepository, so consumers on several hosts share them.
+type DatabaseQueue struct {
+	repo IJobRepository
+}
+
+func NewDatabaseQueue(repo IJobRepository) *DatabaseQueue {
+	return &DatabaseQueue{repo: repo}
+}
+
+func (d *DatabaseQueue) Enqueue(messages ...QueueMessage) error {
+	jobs := make([]NotifierJob, len(messages))
+	for i, message := range messages {
+		jobs[i] = *NewNotifierJob(message.Type, string(message.Payload), message.Batch)
+		if !message.AvailableAt.IsZero() {
+			jobs[i].AvailableAt = message.AvailableAt
+		}
+		if message.MaxAttempts != 0 {
+			jobs[i].MaxAttempts = message.MaxAttempts
+		}
+		if message.UniqueKey != "" {
+			key := message.UniqueKey
+			jobs[i].UniqueKey = &key
+		}
+	}
+	return d.repo.Enqueue(jobs)
+}
+
+func (d *DatabaseQueue) Reserve(consumer string, limit int, lease time.Duration) ([]QueueMessage, error) {
+	jobs, err := d.repo.Claim(newJobClaim(consumer), limit, lease)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]QueueMessage, len(jobs))
+	for i, job := range jobs {
+		messages[i] = QueueMessage{
+			ID:          strconv.FormatUint(job.ID, 10),
+			Type:        job.Type,
+			Payload:     json.RawMessage(job.Payload),
+			Batch:       job.Batch,
+			AvailableAt: job.AvailableAt,
+			Attempts:    job.Attempts,
+			MaxAttempts: job.MaxAttempts,
+			Receipt:     job.LockedBy,
+		}
+		if job.UniqueKey != nil {
+			messages[i].UniqueKey = *job.UniqueKey
+		}
+	}
+	return messages, nil
+}
+
+// job returns the claimed job of a reserved message.
+func (d *DatabaseQueue) job(message QueueMessage) (*NotifierJob, error) {
+	id, err := strconv.ParseUint(message.ID, 10, 64)
+	if err != nil {
+		return nil, NotFoundError{}
+	}
+	return &NotifierJob{ID: id, LockedBy: message.Receipt, State: NotifierJobStateRunning}, nil
+}
+
+func (d *DatabaseQueue) Ack(message QueueMessage) error {
+	job, err := d.job(message)
+	if err != nil {
+		return err
+	}
+	return d.repo.Finish(job, NotifierJobStateDone, nil)
+}
+
+func (d *DatabaseQueue) Nack(message QueueMessage, reason error) error {
+	job, err := d.job(message)
+	if err != nil {
+		return err
+	}
+	return d.repo.Finish(job, NotifierJobStateFailed, reason)
+}
+
+func (d *DatabaseQueue) Delay(message QueueMessage, delay time.Duration) error {
+	job, err := d.job(message)
+	if err != nil {
+		return err
+	}
+	return d.repo.Release(job, time.Now().Add(delay))
+}
+
+func (d *DatabaseQueue) Pending(batch string) (int64, error) {
+	return d.repo.CountUnfinished(batch)
+}
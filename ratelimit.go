@@ -0,0 +1,156 @@
+package go_notifier_core
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRateLimitWait is the longest a send waits for the per second rate limit of its service. A send that must
+	// wait longer returns RateLimitedError, so its job is delayed instead of holding a worker.
+	maxRateLimitWait = 10 * time.Second
+	// dailyRateLimitRefresh is how often the sent mails of a service are counted again for its daily rate limit,
+	// to count the mails of other processes.
+	dailyRateLimitRefresh = time.Minute
+	// dailyRateLimitRetry is how long the jobs of a service that reached its daily rate limit are delayed.
+	dailyRateLimitRetry = 30 * time.Minute
+)
+
+// tokenBucket allows rate events per second, in bursts of up to rate events.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate uint) tokenBucket {
+	return tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take takes a token and returns how long to wait until it's available. Tokens taken ahead are waited for
+// in turn, so concurrent senders share the rate.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// giveBack returns a token taken by take.
+func (b *tokenBucket) giveBack() {
+	b.tokens++
+}
+
+// emailServiceLimiter limits the sends of an email service of a Notifier. The per second limit is kept in the
+// process, and the daily limit counts the mails sent in the last 24 hours by every process.
+type emailServiceLimiter struct {
+	mu        sync.Mutex
+	perSecond uint
+	perDay    uint
+	bucket    tokenBucket
+	sent      int64
+	countedAt time.Time
+}
+
+// emailServiceLimiters are the limiters of the email services of a Notifier, shared by its copies of WithContext.
+type emailServiceLimiters struct {
+	mu       sync.Mutex
+	limiters map[uint64]*emailServiceLimiter
+}
+
+func newEmailServiceLimiters() *emailServiceLimiters {
+	return &emailServiceLimiters{limiters: map[uint64]*emailServiceLimiter{}}
+}
+
+// emailServiceLimiter returns the limiter of the service, a new one when its limits are changed.
+func (n *Notifier) emailServiceLimiter(service *NotifierEmailService) *emailServiceLimiter {
+	n.emailLimiters.mu.Lock()
+	defer n.emailLimiters.mu.Unlock()
+	limiter, ok := n.emailLimiters.limiters[service.ID]
+	if !ok || limiter.perSecond != service.RateLimitPerSecond || limiter.perDay != service.RateLimitPerDay {
+		limiter = &emailServiceLimiter{
+			perSecond: service.RateLimitPerSecond,
+			perDay:    service.RateLimitPerDay,
+			bucket:    newTokenBucket(service.RateLimitPerSecond),
+		}
+		n.emailLimiters.limiters[service.ID] = limiter
+	}
+	return limiter
+}
+
+// dailyLimitReached reports whether the service sent RateLimitPerDay mails in the last 24 hours.
+// The caller holds the lock of the limiter.
+func (l *emailServiceLimiter) dailyLimitReached(n *Notifier, service *NotifierEmailService) bool {
+	if l.perDay == 0 {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(l.countedAt) >= dailyRateLimitRefresh {
+		sent, err := n.emailMessageRepo.CountSentByService(service.ID, now.Add(-24*time.Hour))
+		if err != nil {
+			log.Printf("Error during count sent mails of email service %d : %s", service.ID, err)
+		} else {
+			l.sent = sent
+			l.countedAt = now
+		}
+	}
+	return l.sent >= int64(l.perDay)
+}
+
+// waitEmailRateLimit waits until the service can send a mail by its rate limits. It returns RateLimitedError
+// without waiting when the service reached its daily limit, or when it would wait longer than maxRateLimitWait,
+// and the error of the context of n when it's done while waiting.
+func (n *Notifier) waitEmailRateLimit(service *NotifierEmailService) error {
+	if service.RateLimitPerSecond == 0 && service.RateLimitPerDay == 0 {
+		return nil
+	}
+	limiter := n.emailServiceLimiter(service)
+	limiter.mu.Lock()
+	if limiter.dailyLimitReached(n, service) {
+		limiter.mu.Unlock()
+		return RateLimitedError{Service: service.Name, Limit: "daily", RetryAfter: dailyRateLimitRetry}
+	}
+	var wait time.Duration
+	if limiter.perSecond > 0 {
+		wait = limiter.bucket.take(time.Now())
+		if wait > maxRateLimitWait {
+			limiter.bucket.giveBack()
+			limiter.mu.Unlock()
+			return RateLimitedError{Service: service.Name, Limit: "per second", RetryAfter: wait}
+		}
+	}
+	limiter.sent++
+	limiter.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-n.Context().Done():
+		return n.Context().Err()
+	}
+}
+
+// emailServiceExhausted reports whether the service reached its daily rate limit, so its campaigns wait.
+func (n *Notifier) emailServiceExhausted(service *NotifierEmailService) bool {
+	if service.RateLimitPerDay == 0 {
+		return false
+	}
+	limiter := n.emailServiceLimiter(service)
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.dailyLimitReached(n, service)
+}
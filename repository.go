@@ -1,8 +1,10 @@
 package go_notifier_core
 
 import (
+	"context"
 	"errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -23,6 +25,20 @@ type IRepository[Model interface{}] interface {
 	All(data *[]Model)
 }
 
+// contextRepository is a repository that runs its queries by a context, see Notifier.WithContext.
+// The gorm repositories implement it, other repositories are used as they are.
+type contextRepository[r any] interface {
+	withContext(ctx context.Context) r
+}
+
+// repositoryWithContext returns the repository running its queries by ctx, when it's a contextRepository.
+func repositoryWithContext[r any](ctx context.Context, repo r) r {
+	if c, ok := any(repo).(contextRepository[r]); ok {
+		return c.withContext(ctx)
+	}
+	return repo
+}
+
 type gormRepository[m interface{}] struct {
 	db *gorm.DB
 }
@@ -58,6 +74,55 @@ func (g gormRepository[m]) All(data *[]m) {
 
 //Campaign repositories
 
+type ICampaignRepository interface {
+	IRepository[NotifierCampaign]
+	GetChannels(cmpId uint64, channels *CampaignChannels) error
+	GetChannelStatuses(cmpId uint64) ([]uint64, error)
+}
+
+type gormCampaignRepository struct {
+	gormRepository[NotifierCampaign]
+	db *gorm.DB
+}
+
+func (g gormCampaignRepository) GetChannels(cmpId uint64, channels *CampaignChannels) error {
+	err := g.db.Where("campaign_id = ?", cmpId).Order("id").Find(&channels.Email).Error
+	if err != nil {
+		return err
+	}
+	err = g.db.Where("campaign_id = ?", cmpId).Order("id").Find(&channels.Sms).Error
+	if err != nil {
+		return err
+	}
+	return g.db.Where("campaign_id = ?", cmpId).Order("id").Find(&channels.Push).Error
+}
+
+func (g gormCampaignRepository) GetChannelStatuses(cmpId uint64) ([]uint64, error) {
+	var statuses []uint64
+	for _, model := range []interface{}{&NotifierEmailCampaign{}, &NotifierMobileCampaign{}, &NotifierNotificationCampaign{}} {
+		var tmp []uint64
+		err := g.db.Model(model).Where("campaign_id = ?", cmpId).Pluck("status_id", &tmp).Error
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, tmp...)
+	}
+	return statuses, nil
+}
+
+func NewGormCampaignRepository(db *gorm.DB) ICampaignRepository {
+	return &gormCampaignRepository{
+		gormRepository: gormRepository[NotifierCampaign]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormCampaignRepository) withContext(ctx context.Context) ICampaignRepository {
+	return NewGormCampaignRepository(g.db.WithContext(ctx))
+}
+
 type IEmailTemplateRepository interface {
 	IRepository[NotifierEmailCampaignTemplate]
 }
@@ -76,6 +141,96 @@ func NewGormEmailTemplateRepository(db *gorm.DB) IEmailTemplateRepository {
 	}
 }
 
+func (g gormEmailTemplateRepository) withContext(ctx context.Context) IEmailTemplateRepository {
+	return NewGormEmailTemplateRepository(g.db.WithContext(ctx))
+}
+
+type IEmailTemplateVersionRepository interface {
+	IRepository[NotifierEmailTemplateVersion]
+	GetVersions(templateId uint64) []NotifierEmailTemplateVersion
+	GetVersion(templateId uint64, version uint) (*NotifierEmailTemplateVersion, error)
+}
+
+type gormEmailTemplateVersionRepository struct {
+	gormRepository[NotifierEmailTemplateVersion]
+	db *gorm.DB
+}
+
+func (g gormEmailTemplateVersionRepository) GetVersions(templateId uint64) []NotifierEmailTemplateVersion {
+	var data []NotifierEmailTemplateVersion
+	g.db.Where("template_id = ?", templateId).Order("version").Find(&data)
+	return data
+}
+
+func (g gormEmailTemplateVersionRepository) GetVersion(templateId uint64, version uint) (*NotifierEmailTemplateVersion, error) {
+	var tmp NotifierEmailTemplateVersion
+	res := g.db.Where("template_id = ? AND version = ?", templateId, version).First(&tmp)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, NotFoundError{}
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
+func NewGormEmailTemplateVersionRepository(db *gorm.DB) IEmailTemplateVersionRepository {
+	return &gormEmailTemplateVersionRepository{
+		gormRepository: gormRepository[NotifierEmailTemplateVersion]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormEmailTemplateVersionRepository) withContext(ctx context.Context) IEmailTemplateVersionRepository {
+	return NewGormEmailTemplateVersionRepository(g.db.WithContext(ctx))
+}
+
+type IEmailLayoutRepository interface {
+	IRepository[NotifierEmailLayout]
+}
+
+type gormEmailLayoutRepository struct {
+	gormRepository[NotifierEmailLayout]
+	db *gorm.DB
+}
+
+func NewGormEmailLayoutRepository(db *gorm.DB) IEmailLayoutRepository {
+	return &gormEmailLayoutRepository{
+		gormRepository: gormRepository[NotifierEmailLayout]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormEmailLayoutRepository) withContext(ctx context.Context) IEmailLayoutRepository {
+	return NewGormEmailLayoutRepository(g.db.WithContext(ctx))
+}
+
+type IEmailPartialRepository interface {
+	IRepository[NotifierEmailPartial]
+}
+
+type gormEmailPartialRepository struct {
+	gormRepository[NotifierEmailPartial]
+	db *gorm.DB
+}
+
+func NewGormEmailPartialRepository(db *gorm.DB) IEmailPartialRepository {
+	return &gormEmailPartialRepository{
+		gormRepository: gormRepository[NotifierEmailPartial]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormEmailPartialRepository) withContext(ctx context.Context) IEmailPartialRepository {
+	return NewGormEmailPartialRepository(g.db.WithContext(ctx))
+}
+
 type IEmailServiceRepository interface {
 	IRepository[NotifierEmailService]
 }
@@ -94,6 +249,10 @@ func NewGormEmailServiceRepository(db *gorm.DB) IEmailServiceRepository {
 	}
 }
 
+func (g gormEmailServiceRepository) withContext(ctx context.Context) IEmailServiceRepository {
+	return NewGormEmailServiceRepository(g.db.WithContext(ctx))
+}
+
 type IEmailStatusRepository interface {
 	IRepository[NotifierEmailCampaignStatus]
 	FirstOrCreate(status *NotifierEmailCampaignStatus) error
@@ -117,11 +276,17 @@ func NewGormEmailStatusRepository(db *gorm.DB) IEmailStatusRepository {
 	}
 }
 
+func (g gormEmailStatusRepository) withContext(ctx context.Context) IEmailStatusRepository {
+	return NewGormEmailStatusRepository(g.db.WithContext(ctx))
+}
+
 type IEmailCampaignRepository interface {
 	IRepository[NotifierEmailCampaign]
 	AssignTagsToCampaign(cmpId uint64, tagsId []uint64) error
 	DeleteAllTagsForCampaign(cmpId uint64) error
-	GetLatestCampaign() (*NotifierEmailCampaign, error)
+	// GetLatestCampaign returns the first draft campaign to send, skipping the campaigns of skipServiceIds.
+	GetLatestCampaign(skipServiceIds ...uint64) (*NotifierEmailCampaign, error)
+	GetCampaignsByStatus(statusId uint64) []NotifierEmailCampaign
 	GetCampaignTags(cmpId uint64) []NotifierTag
 }
 
@@ -155,12 +320,14 @@ func (g gormEmailCampaignRepository) DeleteAllTagsForCampaign(cmpId uint64) erro
 	return nil
 }
 
-func (g gormEmailCampaignRepository) GetLatestCampaign() (*NotifierEmailCampaign, error) {
+func (g gormEmailCampaignRepository) GetLatestCampaign(skipServiceIds ...uint64) (*NotifierEmailCampaign, error) {
 	var tmp NotifierEmailCampaign
-	res := g.db.Where("status_id = ?", NotifierEmailStatusDraft).
-		Where("scheduled_at <= ? or scheduled_at IS NULL", time.Now()).
-		Order("ID asc").
-		First(&tmp)
+	query := g.db.Where("status_id = ?", NotifierEmailStatusDraft).
+		Where("scheduled_at <= ? or scheduled_at IS NULL", time.Now())
+	if len(skipServiceIds) > 0 {
+		query = query.Where("email_service_id NOT IN ?", skipServiceIds)
+	}
+	res := query.Order("ID asc").First(&tmp)
 
 	if res.Error != nil {
 		return nil, res.Error
@@ -168,6 +335,12 @@ func (g gormEmailCampaignRepository) GetLatestCampaign() (*NotifierEmailCampaign
 	return &tmp, nil
 }
 
+func (g gormEmailCampaignRepository) GetCampaignsByStatus(statusId uint64) []NotifierEmailCampaign {
+	var data []NotifierEmailCampaign
+	g.db.Where("status_id = ?", statusId).Order("id").Find(&data)
+	return data
+}
+
 func (g gormEmailCampaignRepository) GetCampaignTags(cmpId uint64) []NotifierTag {
 	var cmpTags []NotifierEmailCampaignTag
 	res := g.db.Where("campaign_id = ?", cmpId).Find(&cmpTags)
@@ -199,6 +372,10 @@ func NewGormEmailCampaignRepository(db *gorm.DB) IEmailCampaignRepository {
 	}
 }
 
+func (g gormEmailCampaignRepository) withContext(ctx context.Context) IEmailCampaignRepository {
+	return NewGormEmailCampaignRepository(g.db.WithContext(ctx))
+}
+
 // Email repositories
 
 type IEmailSubscriberRepository interface {
@@ -210,6 +387,7 @@ type IEmailSubscriberRepository interface {
 	GetUnSubscribed(data *[]NotifierEmailSubscriber)
 	GetUsersByTagId(tags []NotifierTag, data *[]NotifierEmailSubscriber)
 	GetByEmailWithTags(email string) (*NotifierEmailSubscriber, error)
+	GetWithTags(id uint64) (*NotifierEmailSubscriber, error)
 }
 
 type gormEmailSubscriberRepository struct {
@@ -225,6 +403,15 @@ func (g gormEmailSubscriberRepository) GetByEmail(email string) (*NotifierEmailS
 	}
 	return &tmp, nil
 }
+func (g gormEmailSubscriberRepository) GetWithTags(id uint64) (*NotifierEmailSubscriber, error) {
+	var tmp NotifierEmailSubscriber
+	res := g.db.Preload("Tags").Where("id = ?", id).First(&tmp)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
 func (g gormEmailSubscriberRepository) GetByEmailWithTags(email string) (*NotifierEmailSubscriber, error) {
 	var tmp NotifierEmailSubscriber
 	res := g.db.Preload("Tags").Where("email = ?", email).First(&tmp)
@@ -240,6 +427,7 @@ func (g gormEmailSubscriberRepository) GetUsersByTagId(tags []NotifierTag, data
 		ids[i] = tags[i].ID
 	}
 	_ = g.db.
+		Preload("Tags").
 		Table("notifier_email_subscribers AS subs").
 		Select("DISTINCT subs.*").
 		Where("subs.unsubscribed_event_id IS NULL AND subs.unsubscribed_at IS NULL").
@@ -300,6 +488,10 @@ func NewGormEmailSubscriberRepository(db *gorm.DB) IEmailSubscriberRepository {
 	}
 }
 
+func (g gormEmailSubscriberRepository) withContext(ctx context.Context) IEmailSubscriberRepository {
+	return NewGormEmailSubscriberRepository(g.db.WithContext(ctx))
+}
+
 type IEmailSubTagRepository interface {
 	IRepository[NotifierEmailSubTag]
 }
@@ -318,6 +510,10 @@ func NewGormEmailSubTagRepository(db *gorm.DB) IEmailSubTagRepository {
 	}
 }
 
+func (g gormEmailSubTagRepository) withContext(ctx context.Context) IEmailSubTagRepository {
+	return NewGormEmailSubTagRepository(g.db.WithContext(ctx))
+}
+
 type IEmailUnSubEventRepository interface {
 	IRepository[NotifierEmailUnsubscribeEvent]
 	FirstOrCreate(status *NotifierEmailUnsubscribeEvent) error
@@ -341,9 +537,16 @@ func NewGormEmailUnSubEventRepository(db *gorm.DB) IEmailUnSubEventRepository {
 	}
 }
 
+func (g gormEmailUnSubEventRepository) withContext(ctx context.Context) IEmailUnSubEventRepository {
+	return NewGormEmailUnSubEventRepository(g.db.WithContext(ctx))
+}
+
 type IEmailMessageRepository interface {
 	IRepository[NotifierEmailMessage]
 	CheckMessageExists(message *NotifierEmailMessage) error
+	GetByProviderMessageId(providerMessageId string) (*NotifierEmailMessage, error)
+	CountSentByService(serviceId uint64, since time.Time) (int64, error)
+	GetDeadMessages() ([]NotifierEmailMessage, error)
 }
 
 type gormEmailMessageRepository struct {
@@ -351,11 +554,40 @@ type gormEmailMessageRepository struct {
 	db *gorm.DB
 }
 
+func (g gormEmailMessageRepository) CountSentByService(serviceId uint64, since time.Time) (int64, error) {
+	var count int64
+	err := g.db.Model(&NotifierEmailMessage{}).
+		Where("email_service_id = ? AND sent_at >= ?", serviceId, since).
+		Count(&count).Error
+	return count, err
+}
+
+func (g gormEmailMessageRepository) GetDeadMessages() ([]NotifierEmailMessage, error) {
+	var messages []NotifierEmailMessage
+	err := g.db.Where("failed_at IS NOT NULL AND discarded_at IS NULL").Order("id").Find(&messages).Error
+	return messages, err
+}
+
 func (g gormEmailMessageRepository) CheckMessageExists(message *NotifierEmailMessage) error {
 	err := g.db.Where("subscriber_id = ? AND source_id = ? AND source_type like ?", message.SubscriberId, message.SourceId, "%"+message.SourceType+"%").First(message)
 	return err.Error
 }
 
+func (g gormEmailMessageRepository) GetByProviderMessageId(providerMessageId string) (*NotifierEmailMessage, error) {
+	if providerMessageId == "" {
+		return nil, NotFoundError{}
+	}
+	var tmp NotifierEmailMessage
+	res := g.db.Where("provider_message_id = ?", providerMessageId).First(&tmp)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, NotFoundError{}
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
 func NewGormEmailMessageRepository(db *gorm.DB) IEmailMessageRepository {
 	return &gormEmailMessageRepository{
 		gormRepository: gormRepository[NotifierEmailMessage]{
@@ -365,6 +597,53 @@ func NewGormEmailMessageRepository(db *gorm.DB) IEmailMessageRepository {
 	}
 }
 
+func (g gormEmailMessageRepository) withContext(ctx context.Context) IEmailMessageRepository {
+	return NewGormEmailMessageRepository(g.db.WithContext(ctx))
+}
+
+type IEmailAttachmentRepository interface {
+	IRepository[NotifierEmailAttachment]
+	GetByCampaignId(campaignId uint64) []NotifierEmailAttachment
+	GetByTemplateId(templateId uint64) []NotifierEmailAttachment
+	GetByMessageId(messageId uint64) []NotifierEmailAttachment
+}
+
+type gormEmailAttachmentRepository struct {
+	gormRepository[NotifierEmailAttachment]
+	db *gorm.DB
+}
+
+func (g gormEmailAttachmentRepository) GetByCampaignId(campaignId uint64) []NotifierEmailAttachment {
+	var data []NotifierEmailAttachment
+	g.db.Where("campaign_id = ?", campaignId).Order("id").Find(&data)
+	return data
+}
+
+func (g gormEmailAttachmentRepository) GetByTemplateId(templateId uint64) []NotifierEmailAttachment {
+	var data []NotifierEmailAttachment
+	g.db.Where("template_id = ?", templateId).Order("id").Find(&data)
+	return data
+}
+
+func (g gormEmailAttachmentRepository) GetByMessageId(messageId uint64) []NotifierEmailAttachment {
+	var data []NotifierEmailAttachment
+	g.db.Where("message_id = ?", messageId).Order("id").Find(&data)
+	return data
+}
+
+func NewGormEmailAttachmentRepository(db *gorm.DB) IEmailAttachmentRepository {
+	return &gormEmailAttachmentRepository{
+		gormRepository: gormRepository[NotifierEmailAttachment]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormEmailAttachmentRepository) withContext(ctx context.Context) IEmailAttachmentRepository {
+	return NewGormEmailAttachmentRepository(g.db.WithContext(ctx))
+}
+
 // Mobile repositories
 
 type IMobileSubscriberRepository interface {
@@ -374,6 +653,8 @@ type IMobileSubscriberRepository interface {
 	RemoveTagsFromUser(id uint64, entity []uint64) error
 	GetSubscribersForTag(tagId uint64, data []NotifierMobileSubscriber)
 	GetUnSubscribed(data []NotifierMobileSubscriber)
+	GetUsersByTagId(tags []NotifierTag, data *[]NotifierMobileSubscriber)
+	GetWithTags(id uint64) (*NotifierMobileSubscriber, error)
 }
 
 type gormMobileSubscriberRepository struct {
@@ -424,6 +705,15 @@ func (g gormMobileSubscriberRepository) RemoveTagsFromUser(userId uint64, tagsId
 	return nil
 }
 
+func (g gormMobileSubscriberRepository) GetWithTags(id uint64) (*NotifierMobileSubscriber, error) {
+	var tmp NotifierMobileSubscriber
+	res := g.db.Preload("Tags").Where("id = ?", id).First(&tmp)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
 func (g gormMobileSubscriberRepository) GetSubscribersForTag(tagId uint64, data []NotifierMobileSubscriber) {
 	_ = g.db.Scopes(exceptUnsubscribedScope, tagIdScope(tagId)).Find(data)
 }
@@ -432,6 +722,20 @@ func (g gormMobileSubscriberRepository) GetUnSubscribed(data []NotifierMobileSub
 	_ = g.db.Scopes(unsubscribedScope).Find(data)
 }
 
+func (g gormMobileSubscriberRepository) GetUsersByTagId(tags []NotifierTag, data *[]NotifierMobileSubscriber) {
+	ids := make([]uint64, len(tags))
+	for i := 0; i < len(tags); i++ {
+		ids[i] = tags[i].ID
+	}
+	_ = g.db.
+		Preload("Tags").
+		Table("notifier_mobile_subscribers AS subs").
+		Select("DISTINCT subs.*").
+		Where("subs.unsubscribed_event_id IS NULL AND subs.unsubscribed_at IS NULL").
+		Joins("INNER JOIN notifier_mobile_sub_tags AS sub_tags ON subs.id = sub_tags.mobile_subscriber_id AND sub_tags.tag_id IN ?", ids).
+		Find(data)
+}
+
 func NewGormMobileSubscriberRepository(db *gorm.DB) IMobileSubscriberRepository {
 	return &gormMobileSubscriberRepository{
 		gormRepository: gormRepository[NotifierMobileSubscriber]{
@@ -441,6 +745,10 @@ func NewGormMobileSubscriberRepository(db *gorm.DB) IMobileSubscriberRepository
 	}
 }
 
+func (g gormMobileSubscriberRepository) withContext(ctx context.Context) IMobileSubscriberRepository {
+	return NewGormMobileSubscriberRepository(g.db.WithContext(ctx))
+}
+
 type IMobileSubTagRepository interface {
 	IRepository[NotifierMobileSubTag]
 }
@@ -459,6 +767,10 @@ func NewGormMobileSubTagRepository(db *gorm.DB) IMobileSubTagRepository {
 	}
 }
 
+func (g gormMobileSubTagRepository) withContext(ctx context.Context) IMobileSubTagRepository {
+	return NewGormMobileSubTagRepository(g.db.WithContext(ctx))
+}
+
 type IMobileUnSubEventRepository interface {
 	IRepository[NotifierMobileUnsubscribeEvent]
 }
@@ -477,6 +789,157 @@ func NewGormMobileUnSubEventRepository(db *gorm.DB) IMobileUnSubEventRepository
 	}
 }
 
+func (g gormMobileUnSubEventRepository) withContext(ctx context.Context) IMobileUnSubEventRepository {
+	return NewGormMobileUnSubEventRepository(g.db.WithContext(ctx))
+}
+
+type IMobileDriverRepository interface {
+	IRepository[NotifierMobileDriver]
+}
+
+type gormMobileDriverRepository struct {
+	gormRepository[NotifierMobileDriver]
+	db *gorm.DB
+}
+
+func NewGormMobileDriverRepository(db *gorm.DB) IMobileDriverRepository {
+	return &gormMobileDriverRepository{
+		gormRepository: gormRepository[NotifierMobileDriver]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormMobileDriverRepository) withContext(ctx context.Context) IMobileDriverRepository {
+	return NewGormMobileDriverRepository(g.db.WithContext(ctx))
+}
+
+type IMobileCampaignRepository interface {
+	IRepository[NotifierMobileCampaign]
+	AssignTagsToCampaign(cmpId uint64, tagsId []uint64) error
+	DeleteAllTagsForCampaign(cmpId uint64) error
+	GetLatestCampaign() (*NotifierMobileCampaign, error)
+	GetCampaignsByStatus(statusId uint64) []NotifierMobileCampaign
+	GetCampaignTags(cmpId uint64) []NotifierTag
+}
+
+type gormMobileCampaignRepository struct {
+	gormRepository[NotifierMobileCampaign]
+	db *gorm.DB
+}
+
+func (g gormMobileCampaignRepository) AssignTagsToCampaign(cmpId uint64, tagsId []uint64) error {
+	if len(tagsId) == 0 {
+		return errors.New("tags id is empty")
+	}
+	tmp := make([]NotifierMobileCampaignTag, len(tagsId))
+	for i, tagId := range tagsId {
+		t := NewNotifierMobileCampaignTag(cmpId, tagId)
+		tmp[i] = *t
+	}
+
+	res := g.db.Create(tmp)
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+func (g gormMobileCampaignRepository) DeleteAllTagsForCampaign(cmpId uint64) error {
+	res := g.db.Where("campaign_id = ?", cmpId).Delete(&NotifierMobileCampaignTag{})
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+func (g gormMobileCampaignRepository) GetLatestCampaign() (*NotifierMobileCampaign, error) {
+	var tmp NotifierMobileCampaign
+	res := g.db.Where("status_id = ?", NotifierEmailStatusDraft).
+		Where("scheduled_at <= ? or scheduled_at IS NULL", time.Now()).
+		Order("ID asc").
+		First(&tmp)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
+func (g gormMobileCampaignRepository) GetCampaignsByStatus(statusId uint64) []NotifierMobileCampaign {
+	var data []NotifierMobileCampaign
+	g.db.Where("status_id = ?", statusId).Order("id").Find(&data)
+	return data
+}
+
+func (g gormMobileCampaignRepository) GetCampaignTags(cmpId uint64) []NotifierTag {
+	var tags []NotifierTag
+	res := g.db.Where("id IN (SELECT tag_id FROM notifier_mobile_campaign_tags WHERE campaign_id = ?)", cmpId).
+		Find(&tags)
+	if res.Error != nil {
+		return []NotifierTag{}
+	}
+	return tags
+}
+
+func NewGormMobileCampaignRepository(db *gorm.DB) IMobileCampaignRepository {
+	return &gormMobileCampaignRepository{
+		gormRepository: gormRepository[NotifierMobileCampaign]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormMobileCampaignRepository) withContext(ctx context.Context) IMobileCampaignRepository {
+	return NewGormMobileCampaignRepository(g.db.WithContext(ctx))
+}
+
+type IMobileMessageRepository interface {
+	IRepository[NotifierMobileMessage]
+	CheckMessageExists(message *NotifierMobileMessage) error
+	GetByProviderMessageId(providerMessageId string) (*NotifierMobileMessage, error)
+}
+
+type gormMobileMessageRepository struct {
+	gormRepository[NotifierMobileMessage]
+	db *gorm.DB
+}
+
+func (g gormMobileMessageRepository) CheckMessageExists(message *NotifierMobileMessage) error {
+	err := g.db.Where("subscriber_id = ? AND source_id = ? AND source_type = ?", message.SubscriberId, message.SourceId, message.SourceType).First(message)
+	return err.Error
+}
+
+func (g gormMobileMessageRepository) GetByProviderMessageId(providerMessageId string) (*NotifierMobileMessage, error) {
+	if providerMessageId == "" {
+		return nil, NotFoundError{}
+	}
+	var tmp NotifierMobileMessage
+	res := g.db.Where("provider_message_id = ?", providerMessageId).First(&tmp)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, NotFoundError{}
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
+func NewGormMobileMessageRepository(db *gorm.DB) IMobileMessageRepository {
+	return &gormMobileMessageRepository{
+		gormRepository: gormRepository[NotifierMobileMessage]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormMobileMessageRepository) withContext(ctx context.Context) IMobileMessageRepository {
+	return NewGormMobileMessageRepository(g.db.WithContext(ctx))
+}
+
 // Notification repositories
 
 type INotificationSubscriberRepository interface {
@@ -484,8 +947,9 @@ type INotificationSubscriberRepository interface {
 	GetByNotification(token string) (*NotifierNotificationSubscriber, error)
 	AssignTagToUser(userId uint64, tagsId []uint64) error
 	RemoveTagsFromUser(id uint64, entity []uint64) error
-	GetSubscribersForTag(tagId uint64, data []NotifierNotificationSubscriber)
-	GetSubscribersForTagAndDriver(tagId, driverId uint64, data []NotifierNotificationSubscriber)
+	GetSubscribersForTag(tagId uint64, data *[]NotifierNotificationSubscriber)
+	GetSubscribersForTagAndDriver(tagId, driverId uint64, data *[]NotifierNotificationSubscriber)
+	DeleteNotRefreshedSince(before time.Time) (int64, error)
 }
 
 type gormNotificationSubscriberRepository struct {
@@ -536,12 +1000,52 @@ func (g gormNotificationSubscriberRepository) RemoveTagsFromUser(userId uint64,
 	return nil
 }
 
-func (g gormNotificationSubscriberRepository) GetSubscribersForTag(tagId uint64, data []NotifierNotificationSubscriber) {
-	_ = g.db.Scopes(tagIdScope(tagId)).Find(data)
+// GetSubscribersForTag finds the active tokens of the tag.
+func (g gormNotificationSubscriberRepository) GetSubscribersForTag(tagId uint64, data *[]NotifierNotificationSubscriber) {
+	_ = g.db.Scopes(activeTokenScope, notificationTagScope(tagId)).Find(data)
 }
 
-func (g gormNotificationSubscriberRepository) GetSubscribersForTagAndDriver(tagId, driverId uint64, data []NotifierNotificationSubscriber) {
-	_ = g.db.Scopes(tagIdScope(tagId), driverIdScope(driverId)).Find(data)
+// GetSubscribersForTagAndDriver finds the active tokens of the tag that belong to the driver.
+func (g gormNotificationSubscriberRepository) GetSubscribersForTagAndDriver(tagId, driverId uint64, data *[]NotifierNotificationSubscriber) {
+	_ = g.db.Scopes(activeTokenScope, notificationTagScope(tagId), driverIdScope(driverId)).Find(data)
+}
+
+// Delete deletes the subscriber with its message log, which references the subscriber.
+func (g gormNotificationSubscriberRepository) Delete(subscriber *NotifierNotificationSubscriber) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("subscriber_id = ?", subscriber.ID).Delete(&NotifierNotificationMessage{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(subscriber).Error
+	})
+}
+
+// DeleteNotRefreshedSince deletes the subscribers updated before the time with their message logs, and returns
+// how many subscribers are deleted.
+func (g gormNotificationSubscriberRepository) DeleteNotRefreshedSince(before time.Time) (int64, error) {
+	var deleted int64
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		stale := tx.Model(&NotifierNotificationSubscriber{}).Select("id").Where("updated_at < ?", before)
+		err := tx.Where("subscriber_id IN (?)", stale).Delete(&NotifierNotificationMessage{}).Error
+		if err != nil {
+			return err
+		}
+		res := tx.Where("updated_at < ?", before).Delete(&NotifierNotificationSubscriber{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	return deleted, err
+}
+
+func activeTokenScope(db *gorm.DB) *gorm.DB {
+	return db.Where("disabled_at IS NULL")
+}
+
+func notificationTagScope(tagId uint64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN (SELECT notification_subscriber_id FROM notifier_notification_sub_tags WHERE tag_id = ?)", tagId)
+	}
 }
 
 func driverIdScope(driverId uint64) func(db *gorm.DB) *gorm.DB {
@@ -559,6 +1063,10 @@ func NewGormNotificationSubscriberRepository(db *gorm.DB) INotificationSubscribe
 	}
 }
 
+func (g gormNotificationSubscriberRepository) withContext(ctx context.Context) INotificationSubscriberRepository {
+	return NewGormNotificationSubscriberRepository(g.db.WithContext(ctx))
+}
+
 type INotificationSubTagRepository interface {
 	IRepository[NotifierNotificationSubTag]
 }
@@ -577,6 +1085,10 @@ func NewGormNotificationSubTagRepository(db *gorm.DB) INotificationSubTagReposit
 	}
 }
 
+func (g gormNotificationSubTagRepository) withContext(ctx context.Context) INotificationSubTagRepository {
+	return NewGormNotificationSubTagRepository(g.db.WithContext(ctx))
+}
+
 type INotifierNotificationDriverRepository interface {
 	IRepository[NotifierNotificationService]
 }
@@ -595,6 +1107,136 @@ func NewGormNotifierNotificationDriverRepository(db *gorm.DB) INotifierNotificat
 	}
 }
 
+func (g gormNotifierNotificationDriverRepository) withContext(ctx context.Context) INotifierNotificationDriverRepository {
+	return NewGormNotifierNotificationDriverRepository(g.db.WithContext(ctx))
+}
+
+type INotificationCampaignRepository interface {
+	IRepository[NotifierNotificationCampaign]
+	AssignTagsToCampaign(cmpId uint64, tagsId []uint64) error
+	DeleteAllTagsForCampaign(cmpId uint64) error
+	GetLatestCampaign() (*NotifierNotificationCampaign, error)
+	GetCampaignsByStatus(statusId uint64) []NotifierNotificationCampaign
+	GetCampaignTags(cmpId uint64) []NotifierTag
+}
+
+type gormNotificationCampaignRepository struct {
+	gormRepository[NotifierNotificationCampaign]
+	db *gorm.DB
+}
+
+func (g gormNotificationCampaignRepository) AssignTagsToCampaign(cmpId uint64, tagsId []uint64) error {
+	if len(tagsId) == 0 {
+		return errors.New("tags id is empty")
+	}
+	tmp := make([]NotifierNotificationCampaignTag, len(tagsId))
+	for i, tagId := range tagsId {
+		t := NewNotifierNotificationCampaignTag(cmpId, tagId)
+		tmp[i] = *t
+	}
+
+	res := g.db.Create(tmp)
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+func (g gormNotificationCampaignRepository) DeleteAllTagsForCampaign(cmpId uint64) error {
+	res := g.db.Where("campaign_id = ?", cmpId).Delete(&NotifierNotificationCampaignTag{})
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+func (g gormNotificationCampaignRepository) GetLatestCampaign() (*NotifierNotificationCampaign, error) {
+	var tmp NotifierNotificationCampaign
+	res := g.db.Where("status_id = ?", NotifierEmailStatusDraft).
+		Where("scheduled_at <= ? or scheduled_at IS NULL", time.Now()).
+		Order("ID asc").
+		First(&tmp)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
+func (g gormNotificationCampaignRepository) GetCampaignsByStatus(statusId uint64) []NotifierNotificationCampaign {
+	var data []NotifierNotificationCampaign
+	g.db.Where("status_id = ?", statusId).Order("id").Find(&data)
+	return data
+}
+
+func (g gormNotificationCampaignRepository) GetCampaignTags(cmpId uint64) []NotifierTag {
+	var tags []NotifierTag
+	res := g.db.Where("id IN (SELECT tag_id FROM notifier_notification_campaign_tags WHERE campaign_id = ?)", cmpId).
+		Find(&tags)
+	if res.Error != nil {
+		return []NotifierTag{}
+	}
+	return tags
+}
+
+func NewGormNotificationCampaignRepository(db *gorm.DB) INotificationCampaignRepository {
+	return &gormNotificationCampaignRepository{
+		gormRepository: gormRepository[NotifierNotificationCampaign]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormNotificationCampaignRepository) withContext(ctx context.Context) INotificationCampaignRepository {
+	return NewGormNotificationCampaignRepository(g.db.WithContext(ctx))
+}
+
+type INotificationMessageRepository interface {
+	IRepository[NotifierNotificationMessage]
+	CheckMessageExists(message *NotifierNotificationMessage) error
+	GetByProviderMessageId(providerMessageId string) (*NotifierNotificationMessage, error)
+}
+
+type gormNotificationMessageRepository struct {
+	gormRepository[NotifierNotificationMessage]
+	db *gorm.DB
+}
+
+// CheckMessageExists finds the message of the same token, as a subscriber gets a notification on every token.
+func (g gormNotificationMessageRepository) CheckMessageExists(message *NotifierNotificationMessage) error {
+	err := g.db.Where("subscriber_id = ? AND source_id = ? AND source_type = ? AND token = ?", message.SubscriberId, message.SourceId, message.SourceType, message.Token).First(message)
+	return err.Error
+}
+
+func (g gormNotificationMessageRepository) GetByProviderMessageId(providerMessageId string) (*NotifierNotificationMessage, error) {
+	if providerMessageId == "" {
+		return nil, NotFoundError{}
+	}
+	var tmp NotifierNotificationMessage
+	res := g.db.Where("provider_message_id = ?", providerMessageId).First(&tmp)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, NotFoundError{}
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &tmp, nil
+}
+
+func NewGormNotificationMessageRepository(db *gorm.DB) INotificationMessageRepository {
+	return &gormNotificationMessageRepository{
+		gormRepository: gormRepository[NotifierNotificationMessage]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormNotificationMessageRepository) withContext(ctx context.Context) INotificationMessageRepository {
+	return NewGormNotificationMessageRepository(g.db.WithContext(ctx))
+}
+
 //Tag repositories
 
 type ITagRepository interface {
@@ -626,6 +1268,10 @@ func NewGormTagRepository(db *gorm.DB) ITagRepository {
 	}
 }
 
+func (g gormTagRepository) withContext(ctx context.Context) ITagRepository {
+	return NewGormTagRepository(g.db.WithContext(ctx))
+}
+
 func exceptUnsubscribedScope(db *gorm.DB) *gorm.DB {
 	return db.Where("unsubscribed_event_id is null and unsubscribed_at is null")
 }
@@ -638,3 +1284,178 @@ func tagIdScope(tagId uint64) func(db *gorm.DB) *gorm.DB {
 		return db.Where("tag_id = ?", tagId)
 	}
 }
+
+type IJobRepository interface {
+	IRepository[NotifierJob]
+	Enqueue(jobs []NotifierJob) error
+	Claim(owner string, limit int, lease time.Duration) ([]NotifierJob, error)
+	Finish(job *NotifierJob, state string, reason error) error
+	Release(job *NotifierJob, availableAt time.Time) error
+	CountUnfinished(batch string) (int64, error)
+	DeleteFinishedBefore(before time.Time) (int64, error)
+}
+
+type gormJobRepository struct {
+	gormRepository[NotifierJob]
+	db *gorm.DB
+}
+
+// Enqueue creates the jobs, skipping the ones whose UniqueKey is already enqueued.
+// They're inserted 50 at a time, below the bound variables limit of old SQLite versions.
+func (g gormJobRepository) Enqueue(jobs []NotifierJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	return g.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&jobs, 50).Error
+}
+
+// claimable selects the pending jobs that are available and the running jobs whose lease is expired.
+func (g gormJobRepository) claimable(tx *gorm.DB, now time.Time) *gorm.DB {
+	return tx.Model(&NotifierJob{}).
+		Where("(state = ? AND available_at <= ?) OR (state = ? AND locked_until < ?)",
+			NotifierJobStatePending, now, NotifierJobStateRunning, now)
+}
+
+// skipLocked locks the rows of the query by FOR UPDATE SKIP LOCKED, so a claim skips the rows of other claims
+// instead of waiting for them. SQLite locks the whole database on write, so its queries are kept as they are.
+func (g gormJobRepository) skipLocked(query *gorm.DB) *gorm.DB {
+	if query.Dialector.Name() == "sqlite" {
+		return query
+	}
+	return query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+}
+
+// Claim leases at most limit jobs to owner and returns them. The jobs are locked by SELECT ... FOR UPDATE
+// SKIP LOCKED, so workers on several hosts claim different jobs; SQLite, which locks the whole database on write,
+// claims them by a conditional update. At most limit claimable jobs that are claimed MaxAttempts times are
+// locked the same way and set as dead first.
+func (g gormJobRepository) Claim(owner string, limit int, lease time.Duration) ([]NotifierJob, error) {
+	now := time.Now()
+	var ids []uint64
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		var deadIds []uint64
+		exhausted := g.claimable(tx, now).Where("max_attempts > 0 AND attempts >= max_attempts").Order("available_at, id").Limit(limit)
+		err := g.skipLocked(exhausted).Pluck("id", &deadIds).Error
+		if err != nil {
+			return err
+		}
+		if len(deadIds) > 0 {
+			err = g.claimable(tx, now).Where("id IN ?", deadIds).Updates(map[string]interface{}{
+				"state":        NotifierJobStateDead,
+				"last_error":   errJobOutOfAttempts.Error(),
+				"locked_by":    "",
+				"locked_until": nil,
+				"finished_at":  now,
+				"updated_at":   now,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		query := g.skipLocked(g.claimable(tx, now).Order("available_at, id").Limit(limit))
+		err = query.Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		return g.claimable(tx, now).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"state":        NotifierJobStateRunning,
+			"locked_by":    owner,
+			"locked_until": now.Add(lease),
+			"attempts":     gorm.Expr("attempts + 1"),
+			"updated_at":   now,
+		}).Error
+	})
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	var jobs []NotifierJob
+	err = g.db.Where("id IN ? AND state = ? AND locked_by = ?", ids, NotifierJobStateRunning, owner).
+		Order("available_at, id").
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// updateClaimed updates a claimed job and releases its lease. It fails with NotFoundError when the lease is
+// expired and the job is claimed again.
+func (g gormJobRepository) updateClaimed(job *NotifierJob, values map[string]interface{}) error {
+	values["locked_by"] = ""
+	values["locked_until"] = nil
+	values["updated_at"] = time.Now()
+	res := g.db.Model(&NotifierJob{}).
+		Where("id = ? AND state = ? AND locked_by = ?", job.ID, NotifierJobStateRunning, job.LockedBy).
+		Updates(values)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return NotFoundError{}
+	}
+	job.LockedBy = ""
+	job.LockedUntil = nil
+	return nil
+}
+
+// Finish sets the state of a claimed job, done or failed, with the reason of a failed one as its LastError,
+// and releases its lease.
+func (g gormJobRepository) Finish(job *NotifierJob, state string, reason error) error {
+	now := time.Now()
+	values := map[string]interface{}{"state": state, "finished_at": now}
+	if reason != nil {
+		values["last_error"] = reason.Error()
+	}
+	err := g.updateClaimed(job, values)
+	if err != nil {
+		return err
+	}
+	job.State = state
+	job.FinishedAt = &now
+	if reason != nil {
+		job.LastError = reason.Error()
+	}
+	return nil
+}
+
+// Release sets a claimed job as pending from availableAt and releases its lease.
+func (g gormJobRepository) Release(job *NotifierJob, availableAt time.Time) error {
+	err := g.updateClaimed(job, map[string]interface{}{"state": NotifierJobStatePending, "available_at": availableAt})
+	if err != nil {
+		return err
+	}
+	job.State = NotifierJobStatePending
+	job.AvailableAt = availableAt
+	return nil
+}
+
+// CountUnfinished counts the pending and running jobs of the batch.
+func (g gormJobRepository) CountUnfinished(batch string) (int64, error) {
+	var count int64
+	err := g.db.Model(&NotifierJob{}).
+		Where("batch = ? AND state IN ?", batch, []string{NotifierJobStatePending, NotifierJobStateRunning}).
+		Count(&count).Error
+	return count, err
+}
+
+// DeleteFinishedBefore deletes the done, failed and dead jobs finished before the time, and returns how many
+// jobs are deleted.
+func (g gormJobRepository) DeleteFinishedBefore(before time.Time) (int64, error) {
+	res := g.db.
+		Where("state IN ? AND finished_at < ?",
+			[]string{NotifierJobStateDone, NotifierJobStateFailed, NotifierJobStateDead}, before).
+		Delete(&NotifierJob{})
+	return res.RowsAffected, res.Error
+}
+
+func NewGormJobRepository(db *gorm.DB) IJobRepository {
+	return &gormJobRepository{
+		gormRepository: gormRepository[NotifierJob]{
+			db: db,
+		},
+		db: db,
+	}
+}
+
+func (g gormJobRepository) withContext(ctx context.Context) IJobRepository {
+	return NewGormJobRepository(g.db.WithContext(ctx))
+}
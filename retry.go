@@ -0,0 +1,89 @@
+package go_notifier_core
+
+import (
+	"errors"
+	"math/rand"
+	"net/textproto"
+	"time"
+)
+
+// RetryPolicy retries the sends that failed by a transient error. The delay before the next attempt doubles after
+// every failed one, from BaseDelay up to MaxDelay, and up to Jitter (a fraction from 0 to 1) of it is taken off at
+// random, so messages that failed together aren't retried together. A message that failed MaxAttempts times
+// fails for good.
+type RetryPolicy struct {
+	MaxAttempts uint
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// DefaultRetryPolicy retries a send 4 times, from 30 seconds up to an hour after the failed one.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   30 * time.Second,
+	MaxDelay:    time.Hour,
+	Jitter:      0.2,
+}
+
+// Delay returns how long to wait after the attempt-th failed attempt.
+func (p RetryPolicy) Delay(attempt uint) time.Duration {
+	delay := p.BaseDelay
+	for i := uint(1); i < attempt && (p.MaxDelay == 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// retries reports whether a message that failed attempts times by a transient error is sent again.
+func (p RetryPolicy) retries(attempts uint) bool {
+	return attempts < p.MaxAttempts
+}
+
+// MailErrorClassifier is a Mailer that tells the errors that fail the same way on every attempt, e.g. a rejected
+// address, from transient ones. The errors of other mailers are classified by IsPermanentMailError.
+type MailErrorClassifier interface {
+	IsPermanentError(err error) bool
+}
+
+// permanentMailErrors fail every attempt until the mailer or the service is changed.
+var permanentMailErrors = []error{errMailerNotConfigured, errMailerEnvelopeNotSupported, errMailerAttachmentsNotSupported}
+
+// IsPermanentMailError reports whether err fails the same way on every attempt: an SMTP reply of 5xx, e.g. an
+// unknown mailbox, an API rejecting the mail but by 408, 429 or 5xx, or a mailer that can't send the mail.
+// Timeouts, network errors, 4xx SMTP replies and 408, 429 and 5xx API responses are transient.
+func IsPermanentMailError(err error) bool {
+	for _, permanent := range permanentMailErrors {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 500
+	}
+	var mailerError MailerError
+	if errors.As(err, &mailerError) {
+		code := mailerError.StatusCode
+		return code < 500 && code != 408 && code != 429
+	}
+	return false
+}
+
+// permanentMailError classifies a send error of the service by its mailer, when it's a MailErrorClassifier.
+func (n *Notifier) permanentMailError(service *NotifierEmailService, err error) bool {
+	mailer, er := n.mailer(service.Type)
+	if er == nil {
+		if classifier, ok := mailer.(MailErrorClassifier); ok {
+			return classifier.IsPermanentError(err)
+		}
+	}
+	return IsPermanentMailError(err)
+}
@@ -1,49 +1,60 @@
 package go_notifier_core
 
 import (
-	"errors"
-	"github.com/golobby/container/v3"
 	"log"
 )
 
+// Seed stores the default records of the default Notifier created by Initialize.
 func Seed() error {
-	if !initialized {
-		return errors.New("dependencies not initialized")
+	n, err := Default()
+	if err != nil {
+		return err
 	}
+	return n.Seed()
+}
 
-	emailUnsubReasonSeeder()
-	emailUCampaignStatusSeeder()
+// Seed stores the default records like unsubscribe reasons and campaign statuses.
+func (n *Notifier) Seed() error {
+	err := emailUnsubReasonSeeder(n.emailUnSubEventRepo)
+	if err != nil {
+		return err
+	}
+	err = emailUCampaignStatusSeeder(n.emailStatusRepo)
+	if err != nil {
+		return err
+	}
 	log.Println("Seeder runs successfully")
 	return nil
 }
 
-func emailUnsubReasonSeeder() {
-	var repo IEmailUnSubEventRepository
-	err := container.Resolve(&repo)
-	if err != nil {
-		log.Fatalf("Error during resolve DI : %s", err)
-		return
+func emailUnsubReasonSeeder(repo IEmailUnSubEventRepository) error {
+	events := []*NotifierEmailUnsubscribeEvent{
+		NewNotifierEmailUnsubscribeEvent("Bounce", NotifierEmailUnsubBounce),
+		NewNotifierEmailUnsubscribeEvent("Complaint", NotifierEmailUnsubComplaint),
+		NewNotifierEmailUnsubscribeEvent("Manual by Admin", NotifierEmailUnsubManualByAdmin),
+		NewNotifierEmailUnsubscribeEvent("Manual by Subscriber", NotifierEmailUnsubManualBySubscriber),
 	}
-
-	repo.FirstOrCreate(NewNotifierEmailUnsubscribeEvent("Bounce", NotifierEmailUnsubBounce))
-	repo.FirstOrCreate(NewNotifierEmailUnsubscribeEvent("Complaint", NotifierEmailUnsubComplaint))
-	repo.FirstOrCreate(NewNotifierEmailUnsubscribeEvent("Manual by Admin", NotifierEmailUnsubManualByAdmin))
-	repo.FirstOrCreate(NewNotifierEmailUnsubscribeEvent("Manual by Subscriber", NotifierEmailUnsubManualBySubscriber))
-
+	for _, event := range events {
+		if err := repo.FirstOrCreate(event); err != nil {
+			return SeedError{Seeder: "email unsubscribe reason", Err: err}
+		}
+	}
+	return nil
 }
 
-func emailUCampaignStatusSeeder() {
-	var repo IEmailStatusRepository
-	err := container.Resolve(&repo)
-	if err != nil {
-		log.Fatalf("Error during resolve DI : %s", err)
-		return
+func emailUCampaignStatusSeeder(repo IEmailStatusRepository) error {
+	statuses := []*NotifierEmailCampaignStatus{
+		NewNotifierEmailStatus("Draft", NotifierEmailStatusDraft),
+		NewNotifierEmailStatus("Queued", NotifierEmailStatusQueued),
+		NewNotifierEmailStatus("Sending", NotifierEmailStatusSending),
+		NewNotifierEmailStatus("Sent", NotifierEmailStatusSent),
+		NewNotifierEmailStatus("Canceled", NotifierEmailStatusCanceled),
+		NewNotifierEmailStatus("Failed", NotifierEmailStatusFailed),
 	}
-
-	repo.FirstOrCreate(NewNotifierEmailStatus("Draft", NotifierEmailStatusDraft))
-	repo.FirstOrCreate(NewNotifierEmailStatus("Queued", NotifierEmailStatusQueued))
-	repo.FirstOrCreate(NewNotifierEmailStatus("Sending", NotifierEmailStatusSending))
-	repo.FirstOrCreate(NewNotifierEmailStatus("Sent", NotifierEmailStatusSent))
-	repo.FirstOrCreate(NewNotifierEmailStatus("Canceled", NotifierEmailStatusCanceled))
-	repo.FirstOrCreate(NewNotifierEmailStatus("Failed", NotifierEmailStatusFailed))
+	for _, status := range statuses {
+		if err := repo.FirstOrCreate(status); err != nil {
+			return SeedError{Seeder: "email campaign status", Err: err}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,79 @@
+package go_notifier_core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SmsSender sends SMS by a mobile driver. The Payload of the driver is passed to SetConfig of a new sender
+// for every message. The message ID of the result is the ID the provider reports delivery by.
+type SmsSender interface {
+	Send(sender, receptor, message string) (*SendResult, error)
+	SetConfig(config []byte)
+}
+
+// ContextSmsSender is an SmsSender that stops a send when its context is done, e.g. when a worker is stopped.
+// The built-in senders implement it, the sends of other senders aren't stopped.
+type ContextSmsSender interface {
+	SmsSender
+	SendContext(ctx context.Context, sender, receptor, message string) (*SendResult, error)
+}
+
+var (
+	smsSenderFactoriesMu sync.RWMutex
+	smsSenderFactories   = map[string]func() SmsSender{
+		NotifierMobileServiceKavehNegarType: func() SmsSender { return new(KavehNegarSender) },
+	}
+)
+
+// httpSmsClient sends the requests of the SMS senders of HTTP API providers.
+var httpSmsClient = &http.Client{Timeout: 30 * time.Second}
+
+// errSmsSenderNotConfigured is returned by Send when SetConfig got an invalid payload.
+var errSmsSenderNotConfigured = errors.New("sms sender isn't configured, check the payload of the mobile driver")
+
+// RegisterSmsSender registers an SMS sender factory for a mobile driver type, for every notifier of the process.
+// It replaces the sender of a built-in type.
+func RegisterSmsSender(driverType string, factory func() SmsSender) {
+	smsSenderFactoriesMu.Lock()
+	smsSenderFactories[driverType] = factory
+	smsSenderFactoriesMu.Unlock()
+}
+
+func registeredSmsSender(driverType string) (func() SmsSender, bool) {
+	smsSenderFactoriesMu.RLock()
+	defer smsSenderFactoriesMu.RUnlock()
+	factory, ok := smsSenderFactories[driverType]
+	return factory, ok
+}
+
+// sendSmsContext sends the message by ctx when the sender is a ContextSmsSender.
+func sendSmsContext(ctx context.Context, smsSender SmsSender, sender, receptor, message string) (*SendResult, error) {
+	if contextSender, ok := smsSender.(ContextSmsSender); ok {
+		return contextSender.SendContext(ctx, sender, receptor, message)
+	}
+	return smsSender.Send(sender, receptor, message)
+}
+
+// doSmsRequest sends the request and returns the body of a 2xx response.
+// Any other status is returned as a SmsSenderError with the body of the response.
+func doSmsRequest(sender string, req *http.Request) ([]byte, error) {
+	res, err := httpSmsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, SmsSenderError{Sender: sender, StatusCode: res.StatusCode, Body: string(body)}
+	}
+	return body, nil
+}
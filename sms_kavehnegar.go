@@ -0,0 +1,91 @@
+package go_notifier_core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type (
+	// KavehNegarConfig is the payload of a KavehNegar mobile driver.
+	KavehNegarConfig struct {
+		APIKey  string
+		Sender  string // The default line number, used when a campaign or a message has no sender.
+		BaseURL string // Defaults to https://api.kavenegar.com
+	}
+
+	// KavehNegarSender sends SMS by the send API of KavehNegar.
+	KavehNegarSender struct {
+		config *KavehNegarConfig
+	}
+
+	kavehNegarResponse struct {
+		Return struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"return"`
+		Entries []struct {
+			MessageID json.Number `json:"messageid"`
+		} `json:"entries"`
+	}
+)
+
+func (k *KavehNegarSender) Send(sender, receptor, message string) (*SendResult, error) {
+	return k.SendContext(context.Background(), sender, receptor, message)
+}
+
+// SendContext sends the message by the sms/send API. KavehNegar answers some errors with a 200 status and
+// an error status in the body, so both are checked.
+func (k *KavehNegarSender) SendContext(ctx context.Context, sender, receptor, message string) (*SendResult, error) {
+	if k.config == nil {
+		return nil, errSmsSenderNotConfigured
+	}
+	if sender == "" {
+		sender = k.config.Sender
+	}
+	form := url.Values{}
+	form.Set("receptor", receptor)
+	form.Set("message", message)
+	if sender != "" {
+		form.Set("sender", sender)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		mailerURL(k.config.BaseURL, "https://api.kavenegar.com", "/v1/"+url.PathEscape(k.config.APIKey)+"/sms/send.json"),
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	body, err := doSmsRequest(NotifierMobileServiceKavehNegarType, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var res kavehNegarResponse
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Return.Status != http.StatusOK {
+		return nil, SmsSenderError{Sender: NotifierMobileServiceKavehNegarType, StatusCode: res.Return.Status, Body: string(body)}
+	}
+	result := &SendResult{}
+	if len(res.Entries) > 0 {
+		result.MessageID = res.Entries[0].MessageID.String()
+	}
+	return result, nil
+}
+
+func (k *KavehNegarSender) SetConfig(config []byte) {
+	err := json.Unmarshal(config, &k.config)
+	if err != nil {
+		return
+	}
+}
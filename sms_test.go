@@ -0,0 +1,124 @@
+package go_notifier_core
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+type fakeSms struct {
+	sender, receptor, message string
+}
+
+// fakeSmsSender records the messages it sends.
+type fakeSmsSender struct {
+	mu   sync.Mutex
+	sent []fakeSms
+}
+
+func (f *fakeSmsSender) Send(sender, receptor, message string) (*SendResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, fakeSms{sender, receptor, message})
+	return &SendResult{MessageID: receptor}, nil
+}
+
+func (f *fakeSmsSender) SetConfig(config []byte) {
+}
+
+func (f *fakeSmsSender) Sent() []fakeSms {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeSms(nil), f.sent...)
+}
+
+const fakeSmsSenderType = "fake"
+
+func TestKavehNegarSenderSend(t *testing.T) {
+	stub := newProviderStub(t, http.StatusOK, `{"return":{"status":200,"message":"تایید شد"},"entries":[{"messageid":8792343,"status":1,"receptor":"989121234567"}]}`)
+	sender := &KavehNegarSender{}
+	sender.SetConfig(stub.config(t, map[string]string{"APIKey": "key", "Sender": "10004346"}))
+
+	result, err := sender.Send("", "989121234567", "سلام Ali")
+	assert.Nil(t, err)
+	assert.Equal(t, "8792343", result.MessageID)
+	assert.Equal(t, "/v1/key/sms/send.json", stub.req.URL.Path)
+	form, err := url.ParseQuery(string(stub.body))
+	assert.Nil(t, err)
+	assert.Equal(t, "989121234567", form.Get("receptor"))
+	assert.Equal(t, "سلام Ali", form.Get("message"))
+	assert.Equal(t, "10004346", form.Get("sender"), "The default line should be used")
+
+	_, err = sender.Send("20004346", "989121234567", "Hello")
+	assert.Nil(t, err)
+	form, _ = url.ParseQuery(string(stub.body))
+	assert.Equal(t, "20004346", form.Get("sender"))
+
+	// Test a send of a canceled context isn't sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = sender.SendContext(ctx, "", "989121234567", "Hello")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestKavehNegarSenderErrors(t *testing.T) {
+	stub := newProviderStub(t, http.StatusForbidden, `{"return":{"status":403,"message":"invalid api key"},"entries":null}`)
+	sender := &KavehNegarSender{}
+	sender.SetConfig(stub.config(t, map[string]string{"APIKey": "wrong"}))
+
+	_, err := sender.Send("", "989121234567", "Hello")
+	var senderErr SmsSenderError
+	assert.ErrorAs(t, err, &senderErr)
+	assert.Equal(t, http.StatusForbidden, senderErr.StatusCode)
+	assert.Contains(t, senderErr.Body, "invalid api key")
+
+	// Test an error status in the body of a 200 response
+	stub = newProviderStub(t, http.StatusOK, `{"return":{"status":418,"message":"credit is not enough"},"entries":null}`)
+	sender.SetConfig(stub.config(t, map[string]string{"APIKey": "key"}))
+	_, err = sender.Send("", "989121234567", "Hello")
+	assert.ErrorAs(t, err, &senderErr)
+	assert.Equal(t, 418, senderErr.StatusCode)
+
+	// Test an invalid payload
+	sender = &KavehNegarSender{}
+	sender.SetConfig([]byte("this is not valid JSON"))
+	_, err = sender.Send("", "989121234567", "Hello")
+	assert.ErrorIs(t, err, errSmsSenderNotConfigured)
+}
+
+func TestRegisterSmsSender(t *testing.T) {
+	n := newNotifier(nil)
+	sender, err := n.smsSender(NotifierMobileServiceKavehNegarType)
+	assert.Nil(t, err)
+	assert.IsType(t, &KavehNegarSender{}, sender)
+
+	_, err = n.smsSender("Gateway")
+	assert.NotNil(t, err)
+
+	gateway := &fakeSmsSender{}
+	RegisterSmsSender("Gateway", func() SmsSender { return gateway })
+	defer func() {
+		smsSenderFactoriesMu.Lock()
+		delete(smsSenderFactories, "Gateway")
+		smsSenderFactoriesMu.Unlock()
+	}()
+	sender, err = n.smsSender("Gateway")
+	assert.Nil(t, err)
+	assert.Same(t, gateway, sender)
+
+	// Test senders of the notifier take precedence
+	own := &fakeSmsSender{}
+	n = newNotifier(nil, WithSmsSender("Gateway", func() SmsSender { return own }))
+	sender, err = n.smsSender("Gateway")
+	assert.Nil(t, err)
+	assert.Same(t, own, sender)
+}
+
+func TestMobileSubscriberReceptor(t *testing.T) {
+	assert.Equal(t, "989121234567", NewNotifierMobileSubscriber("+98", "09121234567", "", "").Receptor())
+	assert.Equal(t, "989121234567", NewNotifierMobileSubscriber("98", "9121234567", "", "").Receptor())
+	assert.Equal(t, "09121234567", NewNotifierMobileSubscriber("", "09121234567", "", "").Receptor())
+}
@@ -0,0 +1,275 @@
+package go_notifier_core
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// emailLayoutBody is the name a layout renders the template by. The template itself is "content".
+const emailLayoutBody = "body"
+
+var emailPartialName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// EmailTemplateData is the data a campaign subject and content are rendered with for a subscriber.
+// Templates use the Go template syntax, e.g. "Hello {{.FirstName | default "friend"}}" or "{{.Vars.coupon}}".
+// A missing variable of Vars renders as an empty string.
+type EmailTemplateData struct {
+	FirstName string
+	LastName  string
+	Email     string
+	Tags      []string          // Names of the tags of the subscriber.
+	Vars      map[string]string // Variables of the campaign.
+}
+
+// SmsTemplateData is the data an SMS campaign message is rendered with for a subscriber, like EmailTemplateData.
+type SmsTemplateData struct {
+	FirstName string
+	LastName  string
+	Mobile    string
+	Tags      []string          // Names of the tags of the subscriber.
+	Vars      map[string]string // Variables of the campaign.
+}
+
+// emailTemplateFuncs are the functions templates can call besides the built-in ones.
+var emailTemplateFuncs = map[string]interface{}{
+	"default": templateDefault,
+}
+
+// NewEmailTemplateData returns the data of the subscriber with the variables of a campaign.
+func NewEmailTemplateData(subscriber *NotifierEmailSubscriber, vars map[string]string) EmailTemplateData {
+	data := EmailTemplateData{
+		FirstName: subscriber.FirstName,
+		LastName:  subscriber.LastName,
+		Email:     subscriber.Email,
+		Vars:      vars,
+	}
+	for _, tag := range subscriber.Tags {
+		data.Tags = append(data.Tags, tag.Name)
+	}
+	return data
+}
+
+// NewSmsTemplateData returns the data of the subscriber with the variables of a campaign.
+func NewSmsTemplateData(subscriber *NotifierMobileSubscriber, vars map[string]string) SmsTemplateData {
+	data := SmsTemplateData{
+		FirstName: subscriber.FirstName,
+		LastName:  subscriber.LastName,
+		Mobile:    subscriber.Mobile,
+		Vars:      vars,
+	}
+	for _, tag := range subscriber.Tags {
+		data.Tags = append(data.Tags, tag.Name)
+	}
+	return data
+}
+
+// RenderEmailContent renders an email body. HTML is rendered by html/template, so the values are escaped for
+// the place they're used in, and any other body by text/template.
+func RenderEmailContent(content string, data EmailTemplateData) (string, error) {
+	return renderTemplate("content", content, looksLikeHTML(content), data)
+}
+
+// RenderEmailSubject renders a subject by text/template.
+func RenderEmailSubject(subject string, data EmailTemplateData) (string, error) {
+	return renderTemplate("subject", subject, false, data)
+}
+
+// RenderSmsMessage renders an SMS message by text/template.
+func RenderSmsMessage(message string, data SmsTemplateData) (string, error) {
+	return renderTemplate("message", message, false, data)
+}
+
+// ValidateEmailContent returns a TemplateError when the content can't be parsed, or can't be rendered for
+// a subscriber without a name, tags or variables, e.g. because it uses an unknown field.
+func ValidateEmailContent(content string) error {
+	_, err := RenderEmailContent(content, EmailTemplateData{})
+	return err
+}
+
+// ValidateEmailSubject is ValidateEmailContent for a subject.
+func ValidateEmailSubject(subject string) error {
+	_, err := RenderEmailSubject(subject, EmailTemplateData{})
+	return err
+}
+
+// ValidateSmsMessage is ValidateEmailContent for an SMS message.
+func ValidateSmsMessage(message string) error {
+	_, err := RenderSmsMessage(message, SmsTemplateData{})
+	return err
+}
+
+// ComposeEmailTemplate returns the content of a template in its layout, with the partials defined, as one
+// template. Without a layout the content is the root template.
+func ComposeEmailTemplate(content string, layout *NotifierEmailLayout, partials []NotifierEmailPartial) string {
+	var buf strings.Builder
+	if layout == nil {
+		buf.WriteString(content)
+	} else {
+		buf.WriteString(layout.Content)
+		buf.WriteString(`{{define "` + emailLayoutBody + `"}}`)
+		buf.WriteString(content)
+		buf.WriteString("{{end}}")
+	}
+	for _, partial := range partials {
+		buf.WriteString(`{{define "` + partial.Name + `"}}`)
+		buf.WriteString(partial.Content)
+		buf.WriteString("{{end}}")
+	}
+	return buf.String()
+}
+
+// ValidateEmailLayout returns a TemplateError when the layout can't be parsed or doesn't render
+// {{template "body" .}}.
+func ValidateEmailLayout(content string) error {
+	tmpl, err := texttemplate.New("layout").Funcs(emailTemplateFuncs).Parse(content)
+	if err != nil {
+		return TemplateError{Template: "layout", Err: err}
+	}
+	if tmpl.Tree == nil || !rendersTemplate(tmpl.Tree.Root, emailLayoutBody) {
+		return TemplateError{Template: "layout", Err: errors.New(`layout doesn't render {{template "body" .}}`)}
+	}
+	return nil
+}
+
+// ValidateEmailPartialName returns a TemplateError when the name can't be used by {{template "<name>" .}}.
+func ValidateEmailPartialName(name string) error {
+	if name == emailLayoutBody || name == "content" || !emailPartialName.MatchString(name) {
+		return TemplateError{Template: "partial", Err: errors.New("invalid partial name " + name)}
+	}
+	return nil
+}
+
+// rendersTemplate reports whether the node, or any node in it, is a {{template}} of the name.
+func rendersTemplate(node parse.Node, name string) bool {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return false
+		}
+		for _, n := range node.Nodes {
+			if rendersTemplate(n, name) {
+				return true
+			}
+		}
+	case *parse.TemplateNode:
+		return node.Name == name
+	case *parse.IfNode:
+		return rendersTemplate(node.List, name) || rendersTemplate(node.ElseList, name)
+	case *parse.RangeNode:
+		return rendersTemplate(node.List, name) || rendersTemplate(node.ElseList, name)
+	case *parse.WithNode:
+		return rendersTemplate(node.List, name) || rendersTemplate(node.ElseList, name)
+	}
+	return false
+}
+
+func renderTemplate(name, content string, html bool, data interface{}) (string, error) {
+	var buf strings.Builder
+	var err error
+	if html {
+		var tmpl *htmltemplate.Template
+		tmpl, err = htmltemplate.New(name).Funcs(emailTemplateFuncs).Option("missingkey=zero").Parse(content)
+		if err == nil {
+			err = tmpl.Execute(&buf, data)
+		}
+	} else {
+		var tmpl *texttemplate.Template
+		tmpl, err = texttemplate.New(name).Funcs(emailTemplateFuncs).Option("missingkey=zero").Parse(content)
+		if err == nil {
+			err = tmpl.Execute(&buf, data)
+		}
+	}
+	if err != nil {
+		return "", TemplateError{Template: name, Err: err}
+	}
+	return buf.String(), nil
+}
+
+// templateDefault returns the value, or the fallback when the value is empty. The value is the last argument,
+// so it's used in pipelines: {{.FirstName | default "friend"}}.
+func templateDefault(fallback, value string) string {
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	return value
+}
+
+// TemplateDiffLine is a line of a TemplateDiff. Op is " " for an unchanged line, "-" for a removed line
+// and "+" for an added line.
+type TemplateDiffLine struct {
+	Op   string
+	Text string
+}
+
+// TemplateDiff is the line diff of the contents of two template versions.
+type TemplateDiff []TemplateDiffLine
+
+// Changed reports whether any line is added or removed.
+func (d TemplateDiff) Changed() bool {
+	for _, line := range d {
+		if line.Op != " " {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the diff in the unified format without hunk headers.
+func (d TemplateDiff) String() string {
+	var buf strings.Builder
+	for _, line := range d {
+		buf.WriteString(line.Op)
+		buf.WriteString(line.Text)
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// DiffTemplates returns the line diff from one content to another by their longest common subsequence.
+func DiffTemplates(from, to string) TemplateDiff {
+	a, b := strings.Split(from, "\n"), strings.Split(to, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff TemplateDiff
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, TemplateDiffLine{Op: " ", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, TemplateDiffLine{Op: "-", Text: a[i]})
+			i++
+		default:
+			diff = append(diff, TemplateDiffLine{Op: "+", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, TemplateDiffLine{Op: "-", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, TemplateDiffLine{Op: "+", Text: b[j]})
+	}
+	return diff
+}
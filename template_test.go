@@ -0,0 +1,103 @@
+package go_notifier_core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRenderEmailContent(t *testing.T) {
+	subscriber := &NotifierEmailSubscriber{
+		FirstName: "Ali",
+		LastName:  "<Rezaei>",
+		Email:     "ali@example.com",
+		Tags:      []NotifierTag{{Name: "news"}, {Name: "vip"}},
+	}
+	data := NewEmailTemplateData(subscriber, map[string]string{"coupon": "SUMMER"})
+	assert.Equal(t, []string{"news", "vip"}, data.Tags)
+
+	html, err := RenderEmailContent(`<p>Hi {{.FirstName}} {{.LastName}}, use {{.Vars.coupon}}{{range .Tags}} #{{.}}{{end}}</p>`, data)
+	assert.Nil(t, err)
+	assert.Equal(t, `<p>Hi Ali &lt;Rezaei&gt;, use SUMMER #news #vip</p>`, html)
+
+	// Test plain text isn't HTML escaped
+	text, err := RenderEmailContent(`Hi {{.LastName}}, it's for {{.Email}}`, data)
+	assert.Nil(t, err)
+	assert.Equal(t, `Hi <Rezaei>, it's for ali@example.com`, text)
+
+	subject, err := RenderEmailSubject(`{{.FirstName}}'s & {{.Vars.coupon}}`, data)
+	assert.Nil(t, err)
+	assert.Equal(t, `Ali's & SUMMER`, subject)
+
+	// Test missing values render empty or fall back to the default
+	empty := NewEmailTemplateData(&NotifierEmailSubscriber{Email: "anonymous@example.com"}, nil)
+	html, err = RenderEmailContent(`<p>Hi {{.FirstName | default "friend"}}, [{{.Vars.coupon}}] {{default "no code" .Vars.coupon}}</p>`, empty)
+	assert.Nil(t, err)
+	assert.Equal(t, `<p>Hi friend, [] no code</p>`, html)
+}
+
+func TestValidateEmailContent(t *testing.T) {
+	assert.Nil(t, ValidateEmailContent(`<p>Hi {{.FirstName}} {{.Vars.anything}}</p>`))
+	assert.Nil(t, ValidateEmailContent(`Hello, no template at all.`))
+
+	var templateErr TemplateError
+	assert.ErrorAs(t, ValidateEmailContent(`<p>Hi {{.FirstName</p>`), &templateErr)
+	assert.Equal(t, "content", templateErr.Template)
+	assert.ErrorAs(t, ValidateEmailContent(`<p>Hi {{.Phone}}</p>`), &templateErr)
+	assert.ErrorAs(t, ValidateEmailContent(`Hi {{unknown .FirstName}}`), &templateErr)
+	assert.ErrorAs(t, ValidateEmailSubject(`Hi {{end}}`), &templateErr)
+	assert.Equal(t, "subject", templateErr.Template)
+}
+
+func TestComposeEmailTemplate(t *testing.T) {
+	layout := &NotifierEmailLayout{Content: `<html><body>{{template "header" .}}{{template "body" .}}</body></html>`}
+	partials := []NotifierEmailPartial{
+		{Name: "header", Content: `<h1>Hi {{.FirstName}}</h1>`},
+		{Name: "footer", Content: `<small>{{.Email}}</small>`},
+	}
+	content := ComposeEmailTemplate(`<p>News</p>{{template "footer" .}}`, layout, partials)
+	html, err := RenderEmailContent(content, EmailTemplateData{FirstName: "<Ali>", Email: "ali@example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, `<html><body><h1>Hi &lt;Ali&gt;</h1><p>News</p><small>ali@example.com</small></body></html>`, html)
+
+	// Test a template without a layout is the root template
+	text, err := RenderEmailContent(ComposeEmailTemplate(`Hi {{template "name" .}}`, nil, []NotifierEmailPartial{
+		{Name: "name", Content: `{{.FirstName | default "friend"}}`},
+	}), EmailTemplateData{})
+	assert.Nil(t, err)
+	assert.Equal(t, `Hi friend`, text)
+
+	// Test a missing partial is invalid
+	var templateErr TemplateError
+	assert.ErrorAs(t, ValidateEmailContent(ComposeEmailTemplate(`{{template "missing" .}}`, nil, partials)), &templateErr)
+}
+
+func TestValidateEmailLayout(t *testing.T) {
+	assert.Nil(t, ValidateEmailLayout(`<div>{{template "body" .}}</div>`))
+	assert.Nil(t, ValidateEmailLayout(`{{if .FirstName}}{{template "body" .}}{{else}}-{{end}}`))
+
+	var templateErr TemplateError
+	assert.ErrorAs(t, ValidateEmailLayout(`<div>{{template "header" .}}</div>`), &templateErr)
+	assert.Equal(t, "layout", templateErr.Template)
+	assert.ErrorAs(t, ValidateEmailLayout(`<div>{{template "body" .</div>`), &templateErr)
+
+	assert.Nil(t, ValidateEmailPartialName("footer-v2.en"))
+	assert.ErrorAs(t, ValidateEmailPartialName("body"), &templateErr)
+	assert.ErrorAs(t, ValidateEmailPartialName("content"), &templateErr)
+	assert.ErrorAs(t, ValidateEmailPartialName(`foot"er`), &templateErr)
+	assert.ErrorAs(t, ValidateEmailPartialName(""), &templateErr)
+}
+
+func TestDiffTemplates(t *testing.T) {
+	diff := DiffTemplates("<p>\nHello\n</p>", "<p>\nHi\nthere\n</p>")
+	assert.True(t, diff.Changed())
+	assert.Equal(t, TemplateDiff{
+		{Op: " ", Text: "<p>"},
+		{Op: "-", Text: "Hello"},
+		{Op: "+", Text: "Hi"},
+		{Op: "+", Text: "there"},
+		{Op: " ", Text: "</p>"},
+	}, diff)
+	assert.Equal(t, " <p>\n-Hello\n+Hi\n+there\n </p>\n", diff.String())
+
+	assert.False(t, DiffTemplates("same\ncontent", "same\ncontent").Changed())
+}
@@ -1,17 +1,24 @@
 package go_notifier_core
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/golobby/container/v3"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
 type (
-	//IWorker used for cronjob structs
+	//IWorker used for cronjob structs. Run stops taking new work when ctx is done, see WorkerStart.
 	IWorker interface {
-		Run()
+		Run(ctx context.Context)
 	}
 
 	WorkerConfig struct {
@@ -22,101 +29,204 @@ type (
 
 	WorkersList []WorkerConfig
 
+	// EmailWorker enqueues the campaigns of its Notifier, or of the default Notifier when Notifier is nil, to be
+	// sent by a JobWorker. It sets the sending campaigns whose jobs are finished as sent.
 	EmailWorker struct {
+		Notifier *Notifier
 	}
 
+	// MobileWorker enqueues the SMS campaigns of its Notifier, or of the default Notifier when Notifier is nil,
+	// to be sent by a JobWorker. It sets the sending campaigns whose jobs are finished as sent.
 	MobileWorker struct {
+		Notifier *Notifier
 	}
 
+	// NotificationWorker enqueues the push campaigns of its Notifier, or of the default Notifier when Notifier
+	// is nil, to be sent by a JobWorker. It sets the sending campaigns whose jobs are finished as sent.
 	NotificationWorker struct {
+		Notifier *Notifier
+	}
+
+	// JobWorker runs the jobs of its Notifier, or of the default Notifier when Notifier is nil, e.g. the messages
+	// of campaigns. It reserves Batch jobs at a time (100 by default) from the QueueBackend of the Notifier and runs
+	// them until no job is available.
+	// Reserved jobs are leased to the worker for Lease (5 minutes by default), then a job that isn't finished is run
+	// again by any worker, so Lease must be longer than a job. Name identifies the worker in the jobs, the host
+	// name and the process ID by default.
+	JobWorker struct {
+		Notifier *Notifier
+		Name     string
+		Batch    int
+		Lease    time.Duration
+		// Concurrency is how many jobs run at a time, 1 by default. Sends wait for the rate limits of their
+		// service, so a pool larger than the limits allow only waits more.
+		Concurrency int
+	}
+
+	// PushTokenPruneWorker removes the push tokens of its Notifier that aren't refreshed in Days days, see
+	// PruneStaleTokens. The default Notifier is used when Notifier is nil.
+	PushTokenPruneWorker struct {
+		Notifier *Notifier
+		Days     int
+	}
+
+	// JobPruneWorker removes the jobs of its Notifier finished more than Days days ago, see PruneFinishedJobs.
+	// The default Notifier is used when Notifier is nil.
+	JobPruneWorker struct {
+		Notifier *Notifier
+		Days     int
 	}
 )
 
-func (e EmailWorker) Run() {
+func (e EmailWorker) Run(ctx context.Context) {
+	n := e.Notifier
+	if n == nil {
+		var err error
+		n, err = Default()
+		if err != nil {
+			log.Printf("error during run email worker : %s", err)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	n = n.WithContext(ctx)
+
+	n.finishEmailCampaigns()
 
-	campaign, err := GetLatestCampaignForRun()
+	campaign, err := n.nextEmailCampaign()
 	if err != nil {
 		log.Printf("error during run email worker : %s", err)
 		return
 	}
 
 	campaign.StatusId = NotifierEmailStatusDraft
-	_ = UpdateEmailCampaign(campaign)
+	_ = n.UpdateEmailCampaign(campaign)
 
-	tags := GetEmailCampaignTags(campaign.ID)
+	tags := n.GetEmailCampaignTags(campaign.ID)
 	if len(tags) == 0 {
 		log.Printf("There is no tag saved for campagin = %d", campaign.ID)
 		campaign.StatusId = NotifierEmailStatusFailed
-		err := UpdateEmailCampaign(campaign)
+		err := n.UpdateEmailCampaign(campaign)
 		if err != nil {
 			log.Printf("Error during update campaign : %s", err)
 		}
 		return
 	}
 
-	queue := NewQueue("Email Queue")
-	queue.StartListening()
-	defer queue.CloseWorker()
-
-	campaign.StatusId = NotifierEmailStatusSending
-	_ = UpdateEmailCampaign(campaign)
-	subscribers, err := GetEmailSubscribersWithTags(tags)
+	err = n.enqueueCampaign(emailCampaignJob, campaign.ID)
 	if err != nil {
-		log.Printf("error during get subs for tags email : %s", err)
+		log.Printf("Error during enqueue campaign %d : %s", campaign.ID, err)
+		return
 	}
+	campaign.StatusId = NotifierEmailStatusSending
+	_ = n.UpdateEmailCampaign(campaign)
+}
 
-	for _, subscriber := range subscribers {
-		log.Println("Subscriber id is : ", subscriber.ID)
-		queue.Send(NewQueueMessage(sendEmail, NewNotifierEmailMessage(
-			subscriber.Email,
-			subscriber.ID,
-			"campaign",
-			campaign.FromEmail,
-			campaign.ID,
-			campaign.FromName,
-			campaign.Subject,
-			campaign.EmailServiceId,
-			campaign.Content,
-		)))
+// nextEmailCampaign returns the first draft campaign to send whose email service hasn't reached its daily rate
+// limit. The campaigns of an exhausted service wait, the ones of other services are sent.
+func (n *Notifier) nextEmailCampaign() (*NotifierEmailCampaign, error) {
+	var exhausted []uint64
+	for {
+		campaign, err := n.emailCampaignRepo.GetLatestCampaign(exhausted...)
+		if err != nil {
+			return nil, err
+		}
+		service, err := n.GetEmailServiceById(campaign.EmailServiceId)
+		if err != nil || !n.emailServiceExhausted(service) {
+			return campaign, nil
+		}
+		log.Printf("Email service %d reached its daily rate limit, campaign %d waits", service.ID, campaign.ID)
+		exhausted = append(exhausted, service.ID)
 	}
+}
 
-	campaign.StatusId = NotifierEmailStatusSent
-	_ = UpdateEmailCampaign(campaign)
+// finishEmailCampaigns sets the sending email campaigns whose jobs are finished as sent.
+func (n *Notifier) finishEmailCampaigns() {
+	for _, campaign := range n.emailCampaignRepo.GetCampaignsByStatus(NotifierEmailStatusSending) {
+		if !n.campaignJobsFinished(emailCampaignJob, campaign.ID) {
+			continue
+		}
+		campaign.StatusId = NotifierEmailStatusSent
+		err := n.UpdateEmailCampaign(&campaign)
+		if err != nil {
+			log.Printf("Error during update campaign : %s", err)
+		}
+	}
 }
 
-func sendEmail(data any) error {
+func (n *Notifier) sendEmail(data any) error {
 	message, ok := data.(*NotifierEmailMessage)
 	if !ok {
 		return errors.New("invalid data message to send email")
 	}
-	err := CheckEmailMessageExists(message)
+	err := n.CheckEmailMessageExists(message)
+	if errors.Is(err, ErrMessageExists) {
+		// The message of a job that stopped before the message is sent is sent by the next run of the job.
+		if message.SentAt != nil || message.FailedAt != nil {
+			return nil
+		}
+		return n.deliverEmail(message, nil)
+	}
 	if err != nil {
-		return nil
+		return err
 	}
 
-	err = CreateEmailMessage(message)
+	err = n.CreateEmailMessage(message)
 	if err != nil {
 		return err
 	}
+	return n.deliverEmail(message, nil)
+}
 
-	service, err := GetEmailServiceById(message.EmailServiceId)
+// deliverEmail sends a created message by its email service and records when it's sent or failed, with
+// the message ID of the provider. The Cc, Bcc, Reply-To, headers and tags of the envelope are sent too.
+// A send that failed by a transient error returns RetryError while the retry policy has attempts left.
+func (n *Notifier) deliverEmail(message *NotifierEmailMessage, envelope *EmailEnvelope) error {
+	service, err := n.GetEmailServiceById(message.EmailServiceId)
 	if err != nil {
+		// A send stopped by the context of n isn't failed, its job runs it again.
+		if n.Context().Err() != nil {
+			return n.Context().Err()
+		}
 		log.Printf("Error during send mail (get service): %s", err)
 		t := time.Now()
 		message.FailedAt = &t
-		er := UpdateEmailMessage(message)
+		er := n.UpdateEmailMessage(message)
 		if er != nil {
 			log.Printf("Error during update failed at : %s\n", er)
 		}
 		return err
 	}
 
-	err = handleMail(service, message)
+	// A rate limited message isn't failed, it's sent by the next run of its job.
+	err = n.waitEmailRateLimit(service)
 	if err != nil {
+		return err
+	}
+
+	result, err := n.handleMail(service, message, envelope)
+	if err != nil {
+		// A send stopped by the context of n isn't failed, its job runs it again.
+		if n.Context().Err() != nil {
+			return n.Context().Err()
+		}
 		log.Printf("Error during send mail : %s\n", err)
+		message.Attempts++
+		message.LastError = err.Error()
+		// A transient error is retried by the retry policy, the message fails when it's permanent or
+		// it's out of attempts.
+		if !n.permanentMailError(service, err) && n.retryPolicy.retries(message.Attempts) {
+			er := n.UpdateEmailMessage(message)
+			if er != nil {
+				log.Printf("Error during update attempts : %s\n", er)
+			}
+			return RetryError{Attempt: message.Attempts, RetryAfter: n.retryPolicy.Delay(message.Attempts), Err: err}
+		}
 		t := time.Now()
 		message.FailedAt = &t
-		er := UpdateEmailMessage(message)
+		er := n.UpdateEmailMessage(message)
 		if er != nil {
 			log.Printf("Error during update failed at : %s\n", er)
 		}
@@ -125,112 +235,605 @@ func sendEmail(data any) error {
 
 	t := time.Now()
 	message.SentAt = &t
-	err = UpdateEmailMessage(message)
+	message.ProviderMessageId = result.MessageID
+	err = n.UpdateEmailMessage(message)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func handleMail(service *NotifierEmailService, message *NotifierEmailMessage) error {
-	var mailer Mailer
-	err := container.NamedResolve(&mailer, service.Type)
+func (n *Notifier) handleMail(service *NotifierEmailService, message *NotifierEmailMessage, envelope *EmailEnvelope) (*SendResult, error) {
+	mailer, err := n.mailer(service.Type)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	envelopeMailer := AdaptMailer(mailer)
+	envelopeMailer.SetConfig([]byte(service.Payload))
+
+	attachments, err := n.mailAttachments(message)
+	if err != nil {
+		return nil, err
+	}
+	mail := EmailEnvelope{}
+	if envelope != nil {
+		mail = *envelope
+	}
+	mail.FromName = message.FromName
+	mail.FromEmail = message.FromEmail
+	mail.To = message.RecipientEmail
+	mail.Subject = message.Subject
+	mail.Message = message.Message
+	mail.Attachments = attachments
+	result, err := sendEnvelope(n.Context(), envelopeMailer, &mail)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &SendResult{}
 	}
-	mailer.SetConfig([]byte(service.Payload))
-	return mailer.Send(message.FromName, message.FromEmail, message.RecipientEmail, message.Subject, message.Message)
+	return result, nil
 }
 
-func (m MobileWorker) Run() {
-	panic("TODO implement")
+// mailAttachments returns the attachments of the message: the ones of its campaign and of the campaign template,
+// then its own ones.
+func (n *Notifier) mailAttachments(message *NotifierEmailMessage) ([]MailAttachment, error) {
+	var attachments []MailAttachment
+	if message.SourceType == NotifierEmailMessageSourceCampaign {
+		var err error
+		attachments, err = n.campaignMailAttachments(message.SourceId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if message.ID != 0 {
+		own, err := n.loadMailAttachments(n.emailAttachmentRepo.GetByMessageId(message.ID))
+		if err != nil {
+			return nil, err
+		}
+		// The cached attachments of the campaign are copied, not appended to.
+		attachments = append(attachments[:len(attachments):len(attachments)], own...)
+	}
+	return attachments, nil
 }
 
-func (n NotificationWorker) Run() {
-	panic("TODO implement")
+// campaignAttachmentsTTL is how long the attachments of a campaign are kept to send to its subscribers.
+const campaignAttachmentsTTL = 10 * time.Minute
+
+// campaignAttachmentCache keeps the loaded attachments of the campaigns of a Notifier, so a campaign and its
+// template attachments are read and downloaded once for its subscribers, not once per message.
+type campaignAttachmentCache struct {
+	mu        sync.Mutex
+	campaigns map[uint64]*cachedCampaignAttachments
 }
 
-// WorkerStart starts cronjob workers
-func WorkerStart(config WorkersList) {
-	for _, workerConfig := range config {
-		go func(c WorkerConfig) {
-			fmt.Printf("Worker %s starts work\n", c.Name)
-			cron := time.NewTicker(c.Duration)
-			for range cron.C {
-				c.Worker.Run()
-			}
-			fmt.Printf("Worker %s stops\n", c.Name)
-		}(workerConfig)
+type cachedCampaignAttachments struct {
+	mu          sync.Mutex
+	attachments []MailAttachment
+	loadedAt    time.Time
+}
+
+func newCampaignAttachmentCache() *campaignAttachmentCache {
+	return &campaignAttachmentCache{campaigns: map[uint64]*cachedCampaignAttachments{}}
+}
+
+// campaignMailAttachments returns the attachments of the campaign and of its template, loaded once for
+// campaignAttachmentsTTL. A failed load isn't kept, so the next message loads them again.
+func (n *Notifier) campaignMailAttachments(cmpId uint64) ([]MailAttachment, error) {
+	cache := n.attachmentCache
+	now := time.Now()
+	cache.mu.Lock()
+	for id, cached := range cache.campaigns {
+		if !cached.loadedAt.IsZero() && now.Sub(cached.loadedAt) > campaignAttachmentsTTL {
+			delete(cache.campaigns, id)
+		}
+	}
+	cached, ok := cache.campaigns[cmpId]
+	if !ok {
+		cached = &cachedCampaignAttachments{}
+		cache.campaigns[cmpId] = cached
 	}
+	cache.mu.Unlock()
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	if !cached.loadedAt.IsZero() {
+		return cached.attachments, nil
+	}
+	campaign, err := n.emailCampaignRepo.Get(cmpId)
+	if err != nil {
+		return nil, err
+	}
+	records := n.emailAttachmentRepo.GetByTemplateId(campaign.TemplateId)
+	records = append(records, n.emailAttachmentRepo.GetByCampaignId(campaign.ID)...)
+	attachments, err := n.loadMailAttachments(records)
+	if err != nil {
+		return nil, err
+	}
+	cached.attachments = attachments
+	cached.loadedAt = time.Now()
+	return attachments, nil
 }
 
-type Queue struct {
-	name string
-	recv chan *QueueMessage
-	quit chan bool
+// loadMailAttachments loads the content of the attachments by the context of n.
+func (n *Notifier) loadMailAttachments(records []NotifierEmailAttachment) ([]MailAttachment, error) {
+	attachments := make([]MailAttachment, 0, len(records))
+	for _, record := range records {
+		attachment, err := loadMailAttachment(n.Context(), record)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
 }
 
-func NewQueue(name string) *Queue {
-	return &Queue{
-		name: name,
+// loadMailAttachment returns the attachment with its content, read from the file or downloaded by ctx from
+// the URL of Path when it isn't stored.
+func loadMailAttachment(ctx context.Context, record NotifierEmailAttachment) (MailAttachment, error) {
+	attachment := MailAttachment{
+		Name:        record.Name,
+		ContentType: record.ContentType,
+		Content:     record.Content,
+		Inline:      record.Inline,
+		ContentID:   record.ContentId,
+	}
+	if len(attachment.Content) > 0 || record.Path == "" {
+		return attachment, nil
 	}
+
+	if strings.HasPrefix(record.Path, "http://") || strings.HasPrefix(record.Path, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, record.Path, nil)
+		if err != nil {
+			return attachment, err
+		}
+		res, err := httpMailerClient.Do(req)
+		if err != nil {
+			return attachment, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return attachment, fmt.Errorf("download attachment %s: %s", record.Path, res.Status)
+		}
+		attachment.Content, err = io.ReadAll(res.Body)
+		if err != nil {
+			return attachment, err
+		}
+		if attachment.ContentType == "" {
+			attachment.ContentType = res.Header.Get("Content-Type")
+		}
+		if attachment.Name == "" {
+			attachment.Name = path.Base(res.Request.URL.Path)
+		}
+		return attachment, nil
+	}
+
+	var err error
+	attachment.Content, err = os.ReadFile(record.Path)
+	if attachment.Name == "" {
+		attachment.Name = filepath.Base(record.Path)
+	}
+	return attachment, err
 }
 
-func (q *Queue) StartListening() {
-	log.Printf("Initializing %s's queue...\n", q.name)
-	q.recv = make(chan *QueueMessage)
-	q.quit = make(chan bool)
-	go q.listen()
+func (m MobileWorker) Run(ctx context.Context) {
+	n := m.Notifier
+	if n == nil {
+		var err error
+		n, err = Default()
+		if err != nil {
+			log.Printf("error during run mobile worker : %s", err)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	n = n.WithContext(ctx)
+
+	n.finishMobileCampaigns()
+
+	campaign, err := n.GetLatestMobileCampaignForRun()
+	if err != nil {
+		log.Printf("error during run mobile worker : %s", err)
+		return
+	}
+
+	campaign.StatusId = NotifierEmailStatusDraft
+	_ = n.UpdateMobileCampaign(campaign)
+
+	tags := n.GetMobileCampaignTags(campaign.ID)
+	if len(tags) == 0 {
+		log.Printf("There is no tag saved for mobile campagin = %d", campaign.ID)
+		campaign.StatusId = NotifierEmailStatusFailed
+		err := n.UpdateMobileCampaign(campaign)
+		if err != nil {
+			log.Printf("Error during update mobile campaign : %s", err)
+		}
+		return
+	}
+
+	err = n.enqueueCampaign(mobileCampaignJob, campaign.ID)
+	if err != nil {
+		log.Printf("Error during enqueue mobile campaign %d : %s", campaign.ID, err)
+		return
+	}
+	campaign.StatusId = NotifierEmailStatusSending
+	_ = n.UpdateMobileCampaign(campaign)
 }
 
-func (q *Queue) Send(data *QueueMessage) {
-	q.recv <- data
+// finishMobileCampaigns sets the sending SMS campaigns whose jobs are finished as sent.
+func (n *Notifier) finishMobileCampaigns() {
+	for _, campaign := range n.mobileCampaignRepo.GetCampaignsByStatus(NotifierEmailStatusSending) {
+		if !n.campaignJobsFinished(mobileCampaignJob, campaign.ID) {
+			continue
+		}
+		campaign.StatusId = NotifierEmailStatusSent
+		err := n.UpdateMobileCampaign(&campaign)
+		if err != nil {
+			log.Printf("Error during update mobile campaign : %s", err)
+		}
+	}
 }
 
-func (q *Queue) CloseWorker() {
-	log.Printf("Closing %s's queue listening. Please wait...\n", q.name)
-	q.quit <- true
-	close(q.quit)
-	close(q.recv)
+func (n *Notifier) sendSms(data any) error {
+	message, ok := data.(*NotifierMobileMessage)
+	if !ok {
+		return errors.New("invalid data message to send sms")
+	}
+	err := n.CheckMobileMessageExists(message)
+	if errors.Is(err, ErrMessageExists) {
+		// The message of a job that stopped before the message is sent is sent by the next run of the job.
+		if message.SentAt != nil || message.FailedAt != nil {
+			return nil
+		}
+		return n.deliverSms(message)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = n.CreateMobileMessage(message)
+	if err != nil {
+		return err
+	}
+	return n.deliverSms(message)
 }
 
-func (q *Queue) listen() {
-	log.Println("Queue starts ...")
-	for {
-		select {
-		case tmp := <-q.recv:
-			if tmp.handle() != true {
-				log.Println("There is an error during handle message")
-				if tmp.err != "" {
-					log.Printf("Report : %s\n", tmp.err)
-				}
-			} else {
-				log.Println("Queue handled successfully")
-			}
-		case _, ok := <-q.quit:
-			if ok {
-				log.Println("Queue stops.")
+// deliverSms sends a created message by its mobile driver and records when it's sent or failed, with
+// the message ID of the provider.
+func (n *Notifier) deliverSms(message *NotifierMobileMessage) error {
+	result, err := n.handleSms(message)
+	if err != nil {
+		// A send stopped by the context of n isn't failed, its job runs it again.
+		if n.Context().Err() != nil {
+			return n.Context().Err()
+		}
+		log.Printf("Error during send sms : %s\n", err)
+		t := time.Now()
+		message.FailedAt = &t
+		er := n.UpdateMobileMessage(message)
+		if er != nil {
+			log.Printf("Error during update failed at : %s\n", er)
+		}
+		return err
+	}
+
+	t := time.Now()
+	message.SentAt = &t
+	message.ProviderMessageId = result.MessageID
+	return n.UpdateMobileMessage(message)
+}
+
+func (n *Notifier) handleSms(message *NotifierMobileMessage) (*SendResult, error) {
+	driver, err := n.GetMobileDriverById(message.DriverId)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := n.smsSender(driver.Type)
+	if err != nil {
+		return nil, err
+	}
+	sender.SetConfig([]byte(driver.Payload))
+
+	result, err := sendSmsContext(n.Context(), sender, message.Sender, message.Receptor, message.Message)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &SendResult{}
+	}
+	return result, nil
+}
+
+func (w NotificationWorker) Run(ctx context.Context) {
+	n := w.Notifier
+	if n == nil {
+		var err error
+		n, err = Default()
+		if err != nil {
+			log.Printf("error during run notification worker : %s", err)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	n = n.WithContext(ctx)
+
+	n.finishNotificationCampaigns()
+
+	campaign, err := n.GetLatestNotificationCampaignForRun()
+	if err != nil {
+		log.Printf("error during run notification worker : %s", err)
+		return
+	}
+
+	campaign.StatusId = NotifierEmailStatusDraft
+	_ = n.UpdateNotificationCampaign(campaign)
+
+	tags := n.GetNotificationCampaignTags(campaign.ID)
+	if len(tags) == 0 {
+		log.Printf("There is no tag saved for notification campagin = %d", campaign.ID)
+		campaign.StatusId = NotifierEmailStatusFailed
+		err := n.UpdateNotificationCampaign(campaign)
+		if err != nil {
+			log.Printf("Error during update notification campaign : %s", err)
+		}
+		return
+	}
+
+	err = n.enqueueCampaign(notificationCampaignJob, campaign.ID)
+	if err != nil {
+		log.Printf("Error during enqueue notification campaign %d : %s", campaign.ID, err)
+		return
+	}
+	campaign.StatusId = NotifierEmailStatusSending
+	_ = n.UpdateNotificationCampaign(campaign)
+}
+
+// finishNotificationCampaigns sets the sending push campaigns whose jobs are finished as sent.
+func (n *Notifier) finishNotificationCampaigns() {
+	for _, campaign := range n.notificationCampaignRepo.GetCampaignsByStatus(NotifierEmailStatusSending) {
+		if !n.campaignJobsFinished(notificationCampaignJob, campaign.ID) {
+			continue
+		}
+		campaign.StatusId = NotifierEmailStatusSent
+		err := n.UpdateNotificationCampaign(&campaign)
+		if err != nil {
+			log.Printf("Error during update notification campaign : %s", err)
+		}
+	}
+}
+
+func (p PushTokenPruneWorker) Run(ctx context.Context) {
+	n := p.Notifier
+	if n == nil {
+		var err error
+		n, err = Default()
+		if err != nil {
+			log.Printf("error during run push token prune worker : %s", err)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	n = n.WithContext(ctx)
+
+	pruned, err := n.PruneStaleTokens(p.Days)
+	if err != nil {
+		log.Printf("error during run push token prune worker : %s", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("%d stale push tokens are pruned", pruned)
+	}
+}
+
+func (p JobPruneWorker) Run(ctx context.Context) {
+	n := p.Notifier
+	if n == nil {
+		var err error
+		n, err = Default()
+		if err != nil {
+			log.Printf("error during run job prune worker : %s", err)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	n = n.WithContext(ctx)
+
+	pruned, err := n.PruneFinishedJobs(p.Days)
+	if err != nil {
+		log.Printf("error during run job prune worker : %s", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("%d finished jobs are pruned", pruned)
+	}
+}
+
+func (n *Notifier) sendPush(data any) error {
+	message, ok := data.(*NotifierNotificationMessage)
+	if !ok {
+		return errors.New("invalid data message to send push notification")
+	}
+	err := n.CheckNotificationMessageExists(message)
+	if errors.Is(err, ErrMessageExists) {
+		// The message of a job that stopped before the message is sent is sent by the next run of the job.
+		if message.SentAt != nil || message.FailedAt != nil {
+			return nil
+		}
+		return n.deliverPush(message)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = n.CreateNotificationMessage(message)
+	if err != nil {
+		return err
+	}
+	return n.deliverPush(message)
+}
+
+// deliverPush sends a created message by its notification driver and records when it's sent or failed, with
+// the message ID of the provider.
+func (n *Notifier) deliverPush(message *NotifierNotificationMessage) error {
+	result, err := n.handlePush(message)
+	if err != nil {
+		// A send stopped by the context of n isn't failed, its job runs it again.
+		if n.Context().Err() != nil {
+			return n.Context().Err()
+		}
+		log.Printf("Error during send push notification : %s\n", err)
+		t := time.Now()
+		message.FailedAt = &t
+		er := n.UpdateNotificationMessage(message)
+		if er != nil {
+			log.Printf("Error during update failed at : %s\n", er)
+		}
+		var invalid InvalidPushTokenError
+		if errors.As(err, &invalid) && n.invalidPushTokenHandler != nil {
+			er = n.invalidPushTokenHandler(n, message.Token, invalid.Reason)
+			if er != nil {
+				log.Printf("Error during handle invalid push token : %s\n", er)
 			}
+		}
+		return err
+	}
+
+	t := time.Now()
+	message.SentAt = &t
+	message.ProviderMessageId = result.MessageID
+	return n.UpdateNotificationMessage(message)
+}
+
+func (n *Notifier) handlePush(message *NotifierNotificationMessage) (*SendResult, error) {
+	driver, err := n.GetNotificationDriverById(message.DriverId)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := n.pushSender(driver.Type)
+	if err != nil {
+		return nil, err
+	}
+	sender.SetConfig([]byte(driver.Payload))
+
+	result, err := sendPushContext(n.Context(), sender, message.Token, message.Notification())
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &SendResult{}
+	}
+	return result, nil
+}
+
+func (j JobWorker) Run(ctx context.Context) {
+	n := j.Notifier
+	if n == nil {
+		var err error
+		n, err = Default()
+		if err != nil {
+			log.Printf("error during run job worker : %s", err)
 			return
 		}
 	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	name := j.Name
+	if name == "" {
+		name = defaultJobOwner()
+	}
+	batch := j.Batch
+	if batch <= 0 {
+		batch = defaultJobBatch
+	}
+	lease := j.Lease
+	if lease <= 0 {
+		lease = defaultJobLease
+	}
+
+	concurrency := j.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	_, err := n.runJobs(ctx, name, batch, concurrency, lease)
+	if err != nil {
+		log.Printf("error during run job worker : %s", err)
+	}
 }
 
-type QueueMessage struct {
-	handler func(data interface{}) error
-	data    any
-	err     string
+// sendContextKey is the key of the context of the sends of a worker run by WorkerStart.
+type sendContextKey struct{}
+
+// sendContext returns the context of the sends in flight of a worker run by ctx. The sends of workers started
+// by WorkerStart are stopped when Stop gives up waiting for them, the sends of other runs when ctx is done.
+func sendContext(ctx context.Context) context.Context {
+	if sendCtx, ok := ctx.Value(sendContextKey{}).(context.Context); ok {
+		return sendCtx
+	}
+	return ctx
+}
+
+// Workers are the workers started by WorkerStart.
+type Workers struct {
+	stop  context.CancelFunc
+	abort context.CancelFunc
+	wg    sync.WaitGroup
 }
 
-func (qm QueueMessage) handle() bool {
-	if err := qm.handler(qm.data); err != nil {
-		qm.err = err.Error()
-		return false
-	} else {
-		return true
+// WorkerStart starts cronjob workers, each running every Duration of its config until the returned Workers are
+// stopped or ctx is done. Canceling ctx stops the sends in flight too, Stop waits for them.
+func WorkerStart(ctx context.Context, config WorkersList) *Workers {
+	sendCtx, abort := context.WithCancel(ctx)
+	runCtx, stop := context.WithCancel(context.WithValue(sendCtx, sendContextKey{}, sendCtx))
+	w := &Workers{stop: stop, abort: abort}
+	for _, workerConfig := range config {
+		w.wg.Add(1)
+		go func(c WorkerConfig) {
+			defer w.wg.Done()
+			fmt.Printf("Worker %s starts work\n", c.Name)
+			cron := time.NewTicker(c.Duration)
+			defer cron.Stop()
+			for {
+				select {
+				case <-runCtx.Done():
+					fmt.Printf("Worker %s stops\n", c.Name)
+					return
+				case <-cron.C:
+					c.Worker.Run(runCtx)
+				}
+			}
+		}(workerConfig)
 	}
+	return w
 }
 
-func NewQueueMessage(handle func(data any) error, data interface{}) *QueueMessage {
-	return &QueueMessage{handler: handle, data: data, err: ""}
+// Stop stops the workers from taking new work and waits for the runs in flight, e.g. the sends of the reserved
+// jobs, to finish. When ctx is done first, the sends in flight are canceled and the error of ctx is returned;
+// their jobs are run again when their lease expires.
+func (w *Workers) Stop(ctx context.Context) error {
+	w.stop()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w.abort()
+		return nil
+	case <-ctx.Done():
+		w.abort()
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,1737 @@
+package go_notifier_core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const fakeMailerType = "Fake"
+
+type fakeMail struct {
+	fromName, fromMail, to, subject, message string
+	attachments                              []MailAttachment
+	envelope                                 EmailEnvelope
+}
+
+// fakeMailer records the sent mails instead of delivering them.
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []fakeMail
+}
+
+func (f *fakeMailer) Send(fromName, fromMail, to, subject, message string) error {
+	return f.SendWithAttachments(fromName, fromMail, to, subject, message, nil)
+}
+
+func (f *fakeMailer) SendWithAttachments(fromName, fromMail, to, subject, message string, attachments []MailAttachment) error {
+	_, err := f.SendEnvelope(newEnvelope(fromName, fromMail, to, subject, message, attachments))
+	return err
+}
+
+func (f *fakeMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, fakeMail{envelope.FromName, envelope.FromEmail, envelope.To, envelope.Subject, envelope.Message, envelope.Attachments, *envelope})
+	return &SendResult{MessageID: fmt.Sprintf("fake-%d", len(f.sent))}, nil
+}
+
+func (f *fakeMailer) SetConfig(config []byte) {
+}
+
+func (f *fakeMailer) Sent() []fakeMail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeMail(nil), f.sent...)
+}
+
+// newSqliteTestNotifier returns a migrated and seeded Notifier on its own in-memory SQLite database.
+func newSqliteTestNotifier(t *testing.T, name string, opts ...Option) *Notifier {
+	t.Helper()
+	n, err := New(DbConfig{Name: name, Driver: SqliteDriver, DB: ":memory:"}, opts...)
+	if err != nil {
+		t.Fatalf("Error during create notifier : %s", err)
+	}
+	if err = n.Migrate(); err != nil {
+		t.Fatalf("Error during migrate : %s", err)
+	}
+	if err = n.Seed(); err != nil {
+		t.Fatalf("Error during seed : %s", err)
+	}
+	return n
+}
+
+// runCampaignWorker runs the campaign worker, then the jobs it enqueued, then the worker again to set the sent
+// campaign.
+func runCampaignWorker(n *Notifier, worker IWorker) {
+	worker.Run(context.Background())
+	JobWorker{Notifier: n}.Run(context.Background())
+	worker.Run(context.Background())
+}
+
+func TestEmailWorkerRun(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite worker test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("worker tag")
+	assert.Nil(t, err)
+
+	_, err = n.SubscribeEmail("worker1@test.com", "first", "last", []string{"worker tag"}, false)
+	assert.Nil(t, err)
+	_, err = n.SubscribeEmail("worker2@test.com", "first", "last", []string{"worker tag"}, false)
+	assert.Nil(t, err)
+
+	service, err := n.CreateEmailService("worker service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	template, err := n.CreateEmailTemplate("worker template", "<p>Worker content</p>")
+	assert.Nil(t, err)
+
+	campaign, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "Worker subject",
+		Name:           "worker campaign",
+		Tags:           []uint64{tag.ID},
+	})
+	assert.Nil(t, err)
+
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+
+	sent := mailer.Sent()
+	assert.Len(t, sent, 2)
+	for _, mail := range sent {
+		assert.Equal(t, "Worker subject", mail.subject)
+		assert.Equal(t, "<p>Worker content</p>", mail.message)
+	}
+
+	stored, err := n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+
+	// Test messages are not sent twice for the same campaign
+	stored.StatusId = NotifierEmailStatusDraft
+	assert.Nil(t, n.UpdateEmailCampaign(stored))
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+	assert.Len(t, mailer.Sent(), 2)
+}
+
+func TestEmailWorkerAttachments(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite worker attachments test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("attachment tag")
+	assert.Nil(t, err)
+	subscriber, err := n.SubscribeEmail("attachment@test.com", "first", "last", []string{"attachment tag"}, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("attachment service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("attachment template", `<p><img src="cid:logo"></p>`)
+	assert.Nil(t, err)
+	campaign, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "Attachment subject",
+		Name:           "attachment campaign",
+		Tags:           []uint64{tag.ID},
+	})
+	assert.Nil(t, err)
+
+	file := filepath.Join(t.TempDir(), "report.txt")
+	assert.Nil(t, os.WriteFile(file, []byte("report"), 0o600))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		_, _ = w.Write([]byte("%PDF-1.4"))
+	}))
+	defer server.Close()
+
+	assert.Nil(t, n.AttachToEmailTemplate(template.ID, NewNotifierEmailInlineAttachment("logo.png", "image/png", "logo", []byte("png"))))
+	assert.Nil(t, n.AttachToEmailCampaign(campaign.ID, NewNotifierEmailAttachmentReference("", "", file)))
+	assert.Nil(t, n.AttachToEmailCampaign(campaign.ID, NewNotifierEmailAttachmentReference("", "", server.URL+"/files/terms.pdf")))
+	assert.NotNil(t, n.AttachToEmailCampaign(campaign.ID+100, NewNotifierEmailAttachment("missing.txt", "", nil)))
+
+	attachments, err := n.GetEmailCampaignAttachments(campaign.ID)
+	assert.Nil(t, err)
+	assert.Len(t, attachments, 2)
+
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+
+	sent := mailer.Sent()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, []MailAttachment{
+			{Name: "logo.png", ContentType: "image/png", Content: []byte("png"), Inline: true, ContentID: "logo"},
+			{Name: "report.txt", Content: []byte("report")},
+			{Name: "terms.pdf", ContentType: "application/pdf", Content: []byte("%PDF-1.4")},
+		}, sent[0].attachments)
+	}
+
+	// Test a transactional mail has its own attachments and is sent every time
+	for i := 0; i < 2; i++ {
+		message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Invoice", service.ID, "Your invoice")
+		assert.Nil(t, n.SendEmailMessage(message, NewNotifierEmailAttachment("invoice.txt", "text/plain", []byte("invoice"))))
+		assert.Equal(t, NotifierEmailMessageSourceTransactional, message.SourceType)
+		assert.NotNil(t, message.SentAt)
+	}
+	sent = mailer.Sent()
+	if assert.Len(t, sent, 3) {
+		assert.Equal(t, []MailAttachment{{Name: "invoice.txt", ContentType: "text/plain", Content: []byte("invoice")}}, sent[2].attachments)
+	}
+
+	// Test a mailer without attachments support fails the mail
+	n.mailers[fakeMailerType] = func() Mailer { return struct{ Mailer }{mailer} }
+	message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Invoice", service.ID, "Your invoice")
+	assert.NotNil(t, n.SendEmailMessage(message, NewNotifierEmailAttachment("invoice.txt", "text/plain", []byte("invoice"))))
+	assert.NotNil(t, message.FailedAt)
+
+	// Test deleting attachments
+	for _, attachment := range attachments {
+		assert.Nil(t, n.DeleteEmailAttachment(attachment.ID))
+	}
+	attachments, err = n.GetEmailCampaignAttachments(campaign.ID)
+	assert.Nil(t, err)
+	assert.Len(t, attachments, 0)
+}
+
+func TestSendEmailEnvelope(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite send envelope test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	subscriber, err := n.SubscribeEmail("envelope@test.com", "first", "last", nil, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("envelope service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Welcome", service.ID, "Hello")
+	err = n.SendEmailEnvelope(message, &EmailEnvelope{
+		To:      "ignored@test.com",
+		ReplyTo: "support@test.com",
+		Headers: map[string]string{"List-Unsubscribe": "<https://test.com/unsubscribe>"},
+		Tags:    []string{"welcome"},
+	})
+	assert.Nil(t, err)
+	if sent := mailer.Sent(); assert.Len(t, sent, 1) {
+		assert.Equal(t, "envelope@test.com", sent[0].to)
+		assert.Equal(t, "support@test.com", sent[0].envelope.ReplyTo)
+		assert.Equal(t, []string{"welcome"}, sent[0].envelope.Tags)
+	}
+
+	// Test the message ID of the provider is stored and finds the message
+	assert.Equal(t, "fake-1", message.ProviderMessageId)
+	stored, err := n.GetEmailMessageByProviderMessageId("fake-1")
+	assert.Nil(t, err)
+	assert.Equal(t, message.ID, stored.ID)
+	_, err = n.GetEmailMessageByProviderMessageId("unknown")
+	assert.ErrorAs(t, err, &NotFoundError{})
+
+	// Test an old mailer gets the mail by the adapter, without a message ID
+	old := &fakeMailer{}
+	n.mailers[fakeMailerType] = func() Mailer { return struct{ Mailer }{old} }
+	message = NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Welcome", service.ID, "Hello")
+	assert.Nil(t, n.SendEmailMessage(message))
+	assert.Len(t, old.Sent(), 1)
+	assert.Equal(t, "", message.ProviderMessageId)
+	assert.NotNil(t, message.SentAt)
+}
+
+func TestEmailWorkerPersonalization(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite worker personalization test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("personal")
+	assert.Nil(t, err)
+	_, err = n.SubscribeEmail("ali@test.com", "Ali", "Rezaei", []string{"personal"}, false)
+	assert.Nil(t, err)
+	_, err = n.SubscribeEmail("anonymous@test.com", "", "", []string{"personal"}, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("personal service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("personal template",
+		`<p>Hi {{.FirstName | default "friend"}}, use {{.Vars.coupon}}{{range .Tags}} #{{.}}{{end}}</p>`)
+	assert.Nil(t, err)
+	_, err = n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        `{{.FirstName | default "Hey"}}, your {{.Vars.coupon}} code`,
+		Name:           "personal campaign",
+		Tags:           []uint64{tag.ID},
+		Variables:      map[string]string{"coupon": "SUMMER"},
+	})
+	assert.Nil(t, err)
+
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+
+	mails := map[string]fakeMail{}
+	for _, mail := range mailer.Sent() {
+		mails[mail.to] = mail
+	}
+	assert.Len(t, mails, 2)
+	assert.Equal(t, "Ali, your SUMMER code", mails["ali@test.com"].subject)
+	assert.Equal(t, "<p>Hi Ali, use SUMMER #personal</p>", mails["ali@test.com"].message)
+	assert.Equal(t, "Hey, your SUMMER code", mails["anonymous@test.com"].subject)
+	assert.Equal(t, "<p>Hi friend, use SUMMER #personal</p>", mails["anonymous@test.com"].message)
+}
+
+func TestSendTestEmailCampaign(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite test campaign test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("preview")
+	assert.Nil(t, err)
+	_, err = n.SubscribeEmail("ali@test.com", "Ali", "Rezaei", []string{"preview"}, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("preview service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("preview template",
+		`<p>Hi {{.FirstName | default "friend"}}{{range .Tags}} #{{.}}{{end}} {{.Vars.coupon}}</p>`)
+	assert.Nil(t, err)
+	assert.Nil(t, n.AttachToEmailTemplate(template.ID, NewNotifierEmailAttachment("terms.txt", "text/plain", []byte("terms"))))
+
+	// Test previews for a subscriber and for sample data
+	preview, err := n.PreviewEmailTemplate(template.ID, "ali@test.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "<p>Hi Ali #preview </p>", preview)
+	preview, err = n.PreviewEmailTemplateWithData(template.ID, EmailTemplateData{FirstName: "Sample", Vars: map[string]string{"coupon": "X"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "<p>Hi Sample X</p>", preview)
+	_, err = n.PreviewEmailTemplate(template.ID, "unknown@test.com")
+	assert.ErrorAs(t, err, &NotFoundError{})
+
+	campaign, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        `{{.FirstName | default "Hey"}}, your {{.Vars.coupon}} code`,
+		Name:           "preview campaign",
+		Tags:           []uint64{tag.ID},
+		Variables:      map[string]string{"coupon": "SUMMER"},
+	})
+	assert.Nil(t, err)
+
+	assert.NotNil(t, n.SendTestEmailCampaign(campaign.ID, nil))
+	assert.Nil(t, n.SendTestEmailCampaign(campaign.ID, []string{"ali@test.com", "qa@test.com"}))
+
+	mails := mailer.Sent()
+	assert.Len(t, mails, 2)
+	assert.Equal(t, "ali@test.com", mails[0].to)
+	assert.Equal(t, "Ali, your SUMMER code", mails[0].subject)
+	assert.Equal(t, "<p>Hi Ali #preview SUMMER</p>", mails[0].message)
+	assert.Len(t, mails[0].attachments, 1)
+	assert.Equal(t, "qa@test.com", mails[1].to)
+	assert.Equal(t, "Hey, your SUMMER code", mails[1].subject)
+
+	// Test no message is stored and the campaign isn't changed
+	var count int64
+	n.db.Model(&NotifierEmailMessage{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+	stored, err := n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusDraft), stored.StatusId)
+}
+
+func TestMobileWorkerRun(t *testing.T) {
+	sender := &fakeSmsSender{}
+	n := newSqliteTestNotifier(t, "sqlite mobile worker test", WithSmsSender(fakeSmsSenderType, func() SmsSender {
+		return sender
+	}))
+
+	tag, err := n.CreateTag("sms tag")
+	assert.Nil(t, err)
+	_, err = n.SubscribeMobile("+98", "09121234567", "Ali", "Rezaei", []string{"sms tag"}, false)
+	assert.Nil(t, err)
+	_, err = n.SubscribeMobile("+98", "09351234567", "", "", []string{"sms tag"}, false)
+	assert.Nil(t, err)
+	unsubscribed, err := n.SubscribeMobile("+98", "09191234567", "Gone", "", []string{"sms tag"}, false)
+	assert.Nil(t, err)
+	now := time.Now()
+	unsubscribed.UnsubscribedAt = &now
+	assert.Nil(t, n.mobileSubscriberRepo.Update(unsubscribed))
+
+	driver, err := n.CreateMobileDriver("sms driver", fakeSmsSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+	_, err = n.AddMobileCampaign(&MobileCampaignCreateData{DriverId: driver.ID, Message: "Hi {{.FirstName", Tags: []uint64{tag.ID}})
+	assert.ErrorAs(t, err, &TemplateError{})
+	campaign, err := n.AddMobileCampaign(&MobileCampaignCreateData{
+		DriverId:  driver.ID,
+		StatusId:  NotifierEmailStatusDraft,
+		Sender:    "10004346",
+		Message:   `Hi {{.FirstName | default "friend"}}, use {{.Vars.coupon}}`,
+		Name:      "sms campaign",
+		Tags:      []uint64{tag.ID},
+		Variables: map[string]string{"coupon": "SUMMER"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, n.GetMobileCampaignTags(campaign.ID), 1)
+
+	runCampaignWorker(n, MobileWorker{Notifier: n})
+
+	messages := map[string]fakeSms{}
+	for _, sms := range sender.Sent() {
+		messages[sms.receptor] = sms
+	}
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "Hi Ali, use SUMMER", messages["989121234567"].message)
+	assert.Equal(t, "10004346", messages["989121234567"].sender)
+	assert.Equal(t, "Hi friend, use SUMMER", messages["989351234567"].message)
+
+	stored, err := n.mobileCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+
+	var logs []NotifierMobileMessage
+	n.db.Order("id").Find(&logs)
+	assert.Len(t, logs, 2)
+	for _, logged := range logs {
+		assert.NotNil(t, logged.SentAt)
+		assert.Nil(t, logged.FailedAt)
+		assert.Equal(t, logged.Receptor, logged.ProviderMessageId)
+		assert.Equal(t, NotifierMobileMessageSourceCampaign, logged.SourceType)
+		assert.Equal(t, campaign.ID, logged.SourceId)
+	}
+	message, err := n.GetMobileMessageByProviderMessageId("989121234567")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hi Ali, use SUMMER", message.Message)
+
+	// Test a transactional message
+	assert.Nil(t, n.SendMobileMessage(NewNotifierMobileMessage("989121234567", logs[0].SubscriberId, "", 0, "", driver.ID, "Your code is 1234")))
+	assert.Len(t, sender.Sent(), 3)
+
+	// Test a message of an unknown driver type fails
+	unknown, err := n.CreateMobileDriver("unknown driver", "Unknown", []byte(`{}`))
+	assert.Nil(t, err)
+	failed := NewNotifierMobileMessage("989121234567", logs[0].SubscriberId, "", 0, "", unknown.ID, "Hello")
+	assert.NotNil(t, n.SendMobileMessage(failed))
+	assert.NotNil(t, failed.FailedAt)
+}
+
+func TestNotificationWorkerRun(t *testing.T) {
+	sender := &fakePushSender{}
+	n := newSqliteTestNotifier(t, "sqlite notification worker test", WithPushSender(fakePushSenderType, func() PushSender {
+		return sender
+	}))
+
+	news, err := n.CreateTag("push news")
+	assert.Nil(t, err)
+	offers, err := n.CreateTag("push offers")
+	assert.Nil(t, err)
+	driver, err := n.CreateNotificationDriver("push driver", fakePushSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+	other, err := n.CreateNotificationDriver("other driver", fakePushSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	_, err = n.AddNewToken("token-1", "Ali", "", driver.ID, []string{"push news", "push offers"}, false)
+	assert.Nil(t, err)
+	_, err = n.AddNewToken("token-2", "Sara", "", driver.ID, []string{"push offers"}, false)
+	assert.Nil(t, err)
+	_, err = n.AddNewToken("token-3", "Other", "", other.ID, []string{"push news"}, false)
+	assert.Nil(t, err)
+
+	subscribers, err := n.GetTagAndDriverTokenSubscribers("push news", driver.ID)
+	assert.Nil(t, err)
+	assert.Len(t, subscribers, 1)
+	subscribers, err = n.GetTagTokenSubscribers("push news")
+	assert.Nil(t, err)
+	assert.Len(t, subscribers, 2)
+
+	campaign, err := n.AddNotificationCampaign(&NotificationCampaignCreateData{
+		DriverId: driver.ID,
+		StatusId: NotifierEmailStatusDraft,
+		Title:    "Sale",
+		Body:     "Everything is 20% off",
+		Image:    "https://example.com/sale.png",
+		Data:     map[string]string{"screen": "offers"},
+		Name:     "push campaign",
+		Tags:     []uint64{news.ID, offers.ID},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, n.GetNotificationCampaignTags(campaign.ID), 2)
+
+	runCampaignWorker(n, NotificationWorker{Notifier: n})
+
+	sent := map[string]PushNotification{}
+	for _, push := range sender.Sent() {
+		sent[push.token] = push.notification
+	}
+	assert.Len(t, sender.Sent(), 2, "A subscriber of both tags should get the notification once")
+	assert.Contains(t, sent, "token-1")
+	assert.Contains(t, sent, "token-2")
+	assert.Equal(t, PushNotification{Title: "Sale", Body: "Everything is 20% off", Image: "https://example.com/sale.png", Data: map[string]string{"screen": "offers"}}, sent["token-1"])
+
+	stored, err := n.notificationCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+	assert.Equal(t, map[string]string{"screen": "offers"}, stored.Data)
+
+	var logs []NotifierNotificationMessage
+	n.db.Order("id").Find(&logs)
+	assert.Len(t, logs, 2)
+	for _, logged := range logs {
+		assert.NotNil(t, logged.SentAt)
+		assert.Nil(t, logged.FailedAt)
+		assert.Equal(t, "msg-"+logged.Token, logged.ProviderMessageId)
+		assert.Equal(t, NotifierNotificationMessageSourceCampaign, logged.SourceType)
+		assert.Equal(t, campaign.ID, logged.SourceId)
+	}
+	message, err := n.GetNotificationMessageByProviderMessageId("msg-token-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Sale", message.Title)
+
+	// Test a transactional message
+	assert.Nil(t, n.SendNotificationMessage(NewNotifierNotificationMessage("token-1", logs[0].SubscriberId, "", 0, driver.ID, &PushNotification{Title: "Your order is shipped"})))
+	assert.Len(t, sender.Sent(), 3)
+
+	// Test a message of an unknown driver type fails
+	unknown, err := n.CreateNotificationDriver("unknown driver", "Unknown", []byte(`{}`))
+	assert.Nil(t, err)
+	failed := NewNotifierNotificationMessage("token-1", logs[0].SubscriberId, "", 0, unknown.ID, &PushNotification{Title: "Hello"})
+	assert.NotNil(t, n.SendNotificationMessage(failed))
+	assert.NotNil(t, failed.FailedAt)
+}
+
+func TestInvalidPushTokenCleanup(t *testing.T) {
+	sender := &fakePushSender{invalid: map[string]string{"dead-token": "UNREGISTERED"}}
+	n := newSqliteTestNotifier(t, "sqlite invalid push token test", WithPushSender(fakePushSenderType, func() PushSender {
+		return sender
+	}))
+
+	tag, err := n.CreateTag("push tag")
+	assert.Nil(t, err)
+	driver, err := n.CreateNotificationDriver("push driver", fakePushSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+	_, err = n.AddNewToken("live-token", "", "", driver.ID, []string{"push tag"}, false)
+	assert.Nil(t, err)
+	_, err = n.AddNewToken("dead-token", "", "", driver.ID, []string{"push tag"}, false)
+	assert.Nil(t, err)
+
+	data := &NotificationCampaignCreateData{DriverId: driver.ID, StatusId: NotifierEmailStatusDraft, Title: "Title", Tags: []uint64{tag.ID}}
+	_, err = n.AddNotificationCampaign(data)
+	assert.Nil(t, err)
+	runCampaignWorker(n, NotificationWorker{Notifier: n})
+	assert.Len(t, sender.Sent(), 2)
+
+	// Test the token is disabled with the reason and skipped by the next campaign
+	dead, err := n.notificationSubscriberRepo.GetByNotification("dead-token")
+	assert.Nil(t, err)
+	assert.False(t, dead.Active())
+	assert.Equal(t, "UNREGISTERED", dead.DisabledReason)
+	subscribers, err := n.GetTagAndDriverTokenSubscribers("push tag", driver.ID)
+	assert.Nil(t, err)
+	assert.Len(t, subscribers, 1)
+
+	_, err = n.AddNotificationCampaign(data)
+	assert.Nil(t, err)
+	runCampaignWorker(n, NotificationWorker{Notifier: n})
+	assert.Len(t, sender.Sent(), 3)
+
+	// Test a refreshed token is enabled
+	assert.Nil(t, n.RefreshToken("dead-token"))
+	dead, err = n.notificationSubscriberRepo.GetByNotification("dead-token")
+	assert.Nil(t, err)
+	assert.True(t, dead.Active())
+	assert.Equal(t, "", dead.DisabledReason)
+
+	// Test tokens are removed by RemoveInvalidPushToken, with their message log
+	WithInvalidPushTokenHandler(RemoveInvalidPushToken)(n)
+	assert.NotNil(t, n.SendNotificationMessage(NewNotifierNotificationMessage("dead-token", dead.ID, "", 0, driver.ID, &PushNotification{Title: "Hello"})))
+	_, err = n.notificationSubscriberRepo.GetByNotification("dead-token")
+	assert.NotNil(t, err)
+	var count int64
+	n.db.Model(&NotifierNotificationMessage{}).Where("subscriber_id = ?", dead.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestPruneStaleTokens(t *testing.T) {
+	n := newSqliteTestNotifier(t, "sqlite prune push tokens test", WithPushSender(fakePushSenderType, func() PushSender {
+		return &fakePushSender{}
+	}))
+
+	driver, err := n.CreateNotificationDriver("push driver", fakePushSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+	fresh, err := n.AddNewToken("fresh-token", "", "", driver.ID, nil, false)
+	assert.Nil(t, err)
+	stale, err := n.AddNewToken("stale-token", "", "", driver.ID, nil, false)
+	assert.Nil(t, err)
+	assert.Nil(t, n.SendNotificationMessage(NewNotifierNotificationMessage("stale-token", stale.ID, "", 0, driver.ID, &PushNotification{Title: "Hello"})))
+	assert.Nil(t, n.db.Model(stale).UpdateColumn("updated_at", time.Now().AddDate(0, 0, -31)).Error)
+
+	_, err = n.PruneStaleTokens(0)
+	assert.NotNil(t, err)
+
+	PushTokenPruneWorker{Notifier: n, Days: 30}.Run(context.Background())
+	_, err = n.notificationSubscriberRepo.Get(stale.ID)
+	assert.ErrorAs(t, err, &NotFoundError{})
+	_, err = n.notificationSubscriberRepo.Get(fresh.ID)
+	assert.Nil(t, err)
+
+	pruned, err := n.PruneStaleTokens(30)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), pruned)
+}
+
+func TestMultiChannelCampaign(t *testing.T) {
+	mailer := &fakeMailer{}
+	smsSender := &fakeSmsSender{}
+	pushSender := &fakePushSender{}
+	n := newSqliteTestNotifier(t, "sqlite multi-channel campaign test",
+		WithMailer(fakeMailerType, func() Mailer { return mailer }),
+		WithSmsSender(fakeSmsSenderType, func() SmsSender { return smsSender }),
+		WithPushSender(fakePushSenderType, func() PushSender { return pushSender }),
+	)
+
+	tag, err := n.CreateTag("launch")
+	assert.Nil(t, err)
+	_, err = n.SubscribeEmail("launch@test.com", "Ali", "", []string{"launch"}, false)
+	assert.Nil(t, err)
+	_, err = n.SubscribeMobile("+98", "09121234567", "Ali", "", []string{"launch"}, false)
+	assert.Nil(t, err)
+	pushDriver, err := n.CreateNotificationDriver("push driver", fakePushSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+	_, err = n.AddNewToken("launch-token", "Ali", "", pushDriver.ID, []string{"launch"}, false)
+	assert.Nil(t, err)
+
+	service, err := n.CreateEmailService("launch service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("launch template", "<p>Hi {{.FirstName}}, it's here</p>")
+	assert.Nil(t, err)
+	smsDriver, err := n.CreateMobileDriver("sms driver", fakeSmsSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	data := &CampaignCreateData{
+		Name: "launch",
+		Tags: []uint64{tag.ID},
+		Email: &CampaignEmailData{
+			EmailServiceId: service.ID,
+			TemplateId:     template.ID,
+			FromEmail:      "from@test.com",
+			Subject:        "It's here",
+		},
+		Sms:  &CampaignSmsData{DriverId: smsDriver.ID, Message: "Hi {{.FirstName}}, it's here"},
+		Push: &CampaignPushData{DriverId: pushDriver.ID, Title: "It's here"},
+	}
+
+	// Test an invalid channel stores nothing
+	data.Sms.Message = "Hi {{.FirstName"
+	_, err = n.AddCampaign(data)
+	assert.ErrorAs(t, err, &TemplateError{})
+	campaigns, err := n.CampaignList()
+	assert.Nil(t, err)
+	assert.Len(t, campaigns, 0)
+	var count int64
+	n.db.Model(&NotifierEmailCampaign{}).Count(&count)
+	assert.Equal(t, int64(0), count, "Email channel of an invalid campaign should be removed")
+	_, err = n.AddCampaign(&CampaignCreateData{Name: "no channel", Tags: []uint64{tag.ID}})
+	assert.NotNil(t, err)
+
+	data.Sms.Message = "Hi {{.FirstName}}, it's here"
+	campaign, err := n.AddCampaign(data)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusDraft), campaign.StatusId)
+	channels, err := n.GetCampaignChannels(campaign.ID)
+	assert.Nil(t, err)
+	assert.Len(t, channels.Email, 1)
+	assert.Len(t, channels.Sms, 1)
+	assert.Len(t, channels.Push, 1)
+	assert.Equal(t, uint64(NotifierEmailStatusDraft), channels.Sms[0].StatusId)
+	assert.Equal(t, "launch", channels.Push[0].Name)
+
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+	stored, err := n.GetCampaign(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusDraft), stored.StatusId, "Campaign should wait for the other channels")
+
+	runCampaignWorker(n, MobileWorker{Notifier: n})
+	runCampaignWorker(n, NotificationWorker{Notifier: n})
+	stored, err = n.GetCampaign(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+	assert.Len(t, mailer.Sent(), 1)
+	assert.Len(t, smsSender.Sent(), 1)
+	assert.Len(t, pushSender.Sent(), 1)
+
+	// Test canceling a scheduled campaign
+	scheduled := time.Now().Add(time.Hour)
+	data.ScheduledAt = &scheduled
+	campaign, err = n.AddCampaign(data)
+	assert.Nil(t, err)
+	assert.Nil(t, n.SetCampaignStatus(campaign.ID, NotifierEmailStatusCanceled))
+	stored, err = n.GetCampaign(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusCanceled), stored.StatusId)
+	channels, err = n.GetCampaignChannels(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusCanceled), channels.Email[0].StatusId)
+
+	assert.Nil(t, n.DeleteCampaign(campaign.ID))
+	_, err = n.GetCampaign(campaign.ID)
+	assert.ErrorAs(t, err, &NotFoundError{})
+	n.db.Model(&NotifierMobileCampaign{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestQueueBackends(t *testing.T) {
+	n := newSqliteTestNotifier(t, "sqlite queue backends test")
+	backends := map[string]QueueBackend{"memory": NewQueue(), "database": NewDatabaseQueue(n.jobRepo)}
+	for name, queue := range backends {
+		t.Run(name, func(t *testing.T) {
+			testQueueBackend(t, queue)
+		})
+	}
+}
+
+func TestQueueReleasesUniqueKeys(t *testing.T) {
+	queue := NewQueue()
+	for _, finish := range []func(message QueueMessage) error{
+		queue.Ack,
+		func(message QueueMessage) error { return queue.Nack(message, fmt.Errorf("failed")) },
+	} {
+		assert.Nil(t, queue.Enqueue(QueueMessage{Type: "test", Payload: []byte(`1`), UniqueKey: "unique"}))
+		reserved, err := queue.Reserve("consumer", 1, time.Minute)
+		assert.Nil(t, err)
+		if !assert.Len(t, reserved, 1) {
+			return
+		}
+		// Test a delayed message keeps its key
+		assert.Nil(t, queue.Delay(reserved[0], 0))
+		assert.Nil(t, queue.Enqueue(QueueMessage{Type: "test", Payload: []byte(`2`), UniqueKey: "unique"}))
+		reserved, err = queue.Reserve("consumer", 2, time.Minute)
+		assert.Nil(t, err)
+		if !assert.Len(t, reserved, 1) {
+			return
+		}
+
+		assert.Nil(t, finish(reserved[0]))
+		assert.Len(t, queue.keys, 0)
+	}
+	// Test a key of a finished message is enqueued again
+	assert.Nil(t, queue.Enqueue(QueueMessage{Type: "test", Payload: []byte(`3`), UniqueKey: "unique"}))
+	assert.Len(t, queue.messages, 1)
+
+	// Test a nacked message is logged with its reason
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	reserved, err := queue.Reserve("consumer", 1, time.Minute)
+	assert.Nil(t, err)
+	if assert.Len(t, reserved, 1) {
+		assert.Nil(t, queue.Nack(reserved[0], fmt.Errorf("bad payload")))
+		assert.Contains(t, out.String(), "Job "+reserved[0].ID+" (test) is failed : bad payload")
+	}
+}
+
+func testQueueBackend(t *testing.T, queue QueueBackend) {
+	messages := make([]QueueMessage, 3)
+	for i := range messages {
+		message, err := NewQueueMessage("test", i+1)
+		assert.Nil(t, err)
+		message.Batch = "batch"
+		messages[i] = *message
+	}
+	messages[0].UniqueKey = "unique"
+	assert.Nil(t, queue.Enqueue(messages...))
+	// Test a message of an enqueued key is skipped
+	assert.Nil(t, queue.Enqueue(QueueMessage{Type: "test", Payload: []byte(`4`), Batch: "batch", UniqueKey: "unique"}))
+	count, err := queue.Pending("batch")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Test consumers don't reserve the same messages
+	first, err := queue.Reserve("first", 2, time.Minute)
+	assert.Nil(t, err)
+	if !assert.Len(t, first, 2) {
+		return
+	}
+	assert.Equal(t, "test", first[0].Type)
+	assert.Equal(t, `1`, string(first[0].Payload))
+	assert.Equal(t, "unique", first[0].UniqueKey)
+	assert.Equal(t, uint(1), first[0].Attempts)
+	assert.NotEmpty(t, first[0].Receipt)
+	second, err := queue.Reserve("second", 2, time.Minute)
+	assert.Nil(t, err)
+	if !assert.Len(t, second, 1) {
+		return
+	}
+	assert.Equal(t, `3`, string(second[0].Payload))
+	none, err := queue.Reserve("third", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, none, 0)
+
+	assert.Nil(t, queue.Ack(first[0]))
+	assert.ErrorAs(t, queue.Ack(first[0]), &NotFoundError{})
+	assert.Nil(t, queue.Nack(first[1], fmt.Errorf("failed")))
+	count, err = queue.Pending("batch")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// Test a delayed message is reserved again after its delay
+	assert.Nil(t, queue.Delay(second[0], 0))
+	delayed, err := queue.Reserve("third", 2, time.Minute)
+	assert.Nil(t, err)
+	if assert.Len(t, delayed, 1) {
+		assert.Equal(t, second[0].ID, delayed[0].ID)
+		assert.Equal(t, uint(2), delayed[0].Attempts)
+		assert.Nil(t, queue.Delay(delayed[0], time.Hour))
+	}
+	none, err = queue.Reserve("third", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, none, 0)
+
+	// Test a message of an expired lease is reserved again, and its old consumer can't ack it
+	assert.Nil(t, queue.Enqueue(QueueMessage{Type: "test", Payload: []byte(`5`), Batch: "batch"}))
+	expired, err := queue.Reserve("expired", 1, -time.Second)
+	assert.Nil(t, err)
+	if !assert.Len(t, expired, 1) {
+		return
+	}
+	retried, err := queue.Reserve("retried", 1, time.Minute)
+	assert.Nil(t, err)
+	if assert.Len(t, retried, 1) {
+		assert.Equal(t, expired[0].ID, retried[0].ID)
+		assert.Equal(t, uint(2), retried[0].Attempts)
+		assert.ErrorAs(t, queue.Ack(expired[0]), &NotFoundError{})
+		assert.Nil(t, queue.Ack(retried[0]))
+	}
+	count, err = queue.Pending("batch")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// Test a message reserved MaxAttempts times isn't reserved again
+	assert.Nil(t, queue.Enqueue(QueueMessage{Type: "test", Payload: []byte(`6`), Batch: "dead", MaxAttempts: 2}))
+	for attempt := uint(1); attempt <= 2; attempt++ {
+		reserved, err := queue.Reserve("dead", 2, -time.Second)
+		assert.Nil(t, err)
+		if assert.Len(t, reserved, 1) {
+			assert.Equal(t, attempt, reserved[0].Attempts)
+			assert.Equal(t, uint(2), reserved[0].MaxAttempts)
+		}
+	}
+	none, err = queue.Reserve("dead", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, none, 0)
+	count, err = queue.Pending("dead")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestPruneFinishedJobs(t *testing.T) {
+	n := newSqliteTestNotifier(t, "sqlite prune finished jobs test")
+	queue := NewDatabaseQueue(n.jobRepo)
+	for i := 0; i < 4; i++ {
+		message, err := NewQueueMessage("test", i)
+		assert.Nil(t, err)
+		message.MaxAttempts = 1
+		assert.Nil(t, queue.Enqueue(*message))
+	}
+	reserved, err := queue.Reserve("consumer", 3, time.Minute)
+	assert.Nil(t, err)
+	if !assert.Len(t, reserved, 3) {
+		return
+	}
+	assert.Nil(t, queue.Ack(reserved[0]))
+	assert.Nil(t, queue.Nack(reserved[1], fmt.Errorf("failed")))
+	assert.Nil(t, n.db.Model(&NotifierJob{}).Where("id = ?", reserved[2].ID).UpdateColumn("locked_until", time.Now().Add(-time.Second)).Error)
+	reserved, err = queue.Reserve("consumer", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, reserved, 1, "The expired job of its last attempt should be dead")
+
+	var states []string
+	n.db.Model(&NotifierJob{}).Order("id").Pluck("state", &states)
+	assert.Equal(t, []string{NotifierJobStateDone, NotifierJobStateFailed, NotifierJobStateDead, NotifierJobStateRunning}, states)
+	var lastErrors []string
+	n.db.Model(&NotifierJob{}).Order("id").Pluck("last_error", &lastErrors)
+	assert.Equal(t, []string{"", "failed", errJobOutOfAttempts.Error(), ""}, lastErrors, "Failed and dead jobs should keep their error")
+
+	_, err = n.PruneFinishedJobs(0)
+	assert.NotNil(t, err)
+	JobPruneWorker{Notifier: n, Days: 7}.Run(context.Background())
+	var count int64
+	n.db.Model(&NotifierJob{}).Count(&count)
+	assert.Equal(t, int64(4), count, "Recently finished jobs should be kept")
+
+	assert.Nil(t, n.db.Model(&NotifierJob{}).Where("finished_at IS NOT NULL").UpdateColumn("finished_at", time.Now().AddDate(0, 0, -8)).Error)
+	pruned, err := n.PruneFinishedJobs(7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), pruned)
+	n.db.Model(&NotifierJob{}).Pluck("state", &states)
+	assert.Equal(t, []string{NotifierJobStateRunning}, states)
+}
+
+func TestJobHandlers(t *testing.T) {
+	var greeted []string
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite job handlers test",
+		WithQueueBackend(NewQueue()),
+		WithMailer(fakeMailerType, func() Mailer {
+			return mailer
+		}),
+		WithJobHandler("greet", func(n *Notifier, payload []byte) error {
+			var name string
+			err := json.Unmarshal(payload, &name)
+			greeted = append(greeted, name)
+			return err
+		}))
+
+	assert.Nil(t, n.EnqueueJob("greet", "Ali"))
+	assert.Nil(t, n.EnqueueJob("unknown", nil))
+	ran, err := n.RunJobs("test", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ran)
+	assert.Equal(t, []string{"Ali"}, greeted)
+
+	// Test campaigns are sent by the in-memory queue too
+	tag, err := n.CreateTag("memory")
+	assert.Nil(t, err)
+	_, err = n.SubscribeEmail("memory@test.com", "first", "last", []string{"memory"}, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("memory service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("memory template", "<p>Memory</p>")
+	assert.Nil(t, err)
+	campaign, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "Memory subject",
+		Name:           "memory campaign",
+		Tags:           []uint64{tag.ID},
+	})
+	assert.Nil(t, err)
+
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+	assert.Len(t, mailer.Sent(), 1)
+	stored, err := n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+	var count int64
+	n.db.Model(&NotifierJob{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestJobWorkerRecoversCampaign(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite job recovery test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("durable")
+	assert.Nil(t, err)
+	first, err := n.SubscribeEmail("durable1@test.com", "first", "last", []string{"durable"}, false)
+	assert.Nil(t, err)
+	_, err = n.SubscribeEmail("durable2@test.com", "first", "last", []string{"durable"}, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("durable service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("durable template", "<p>Durable</p>")
+	assert.Nil(t, err)
+	campaign, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "Durable subject",
+		Name:           "durable campaign",
+		Tags:           []uint64{tag.ID},
+	})
+	assert.Nil(t, err)
+
+	// Test the campaign is sending until its jobs are run
+	EmailWorker{Notifier: n}.Run(context.Background())
+	stored, err := n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSending), stored.StatusId)
+	assert.Len(t, mailer.Sent(), 0)
+
+	// Test the jobs of a stopped worker are run by another worker when their lease is expired
+	ran, err := n.RunJobs("stopped", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, ran)
+	mailer.sent = nil
+	n.db.Model(&NotifierEmailMessage{}).Where("subscriber_id = ?", first.ID).Update("sent_at", nil)
+	n.db.Model(&NotifierJob{}).Where("type = ?", emailMessageJob).Updates(map[string]interface{}{
+		"state":        NotifierJobStateRunning,
+		"locked_by":    "stopped",
+		"locked_until": time.Now().Add(-time.Second),
+	})
+	EmailWorker{Notifier: n}.Run(context.Background())
+	stored, err = n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSending), stored.StatusId)
+
+	JobWorker{Notifier: n, Name: "recovery"}.Run(context.Background())
+	sent := mailer.Sent()
+	if assert.Len(t, sent, 1, "Only the message that isn't sent should be sent again") {
+		assert.Equal(t, "durable1@test.com", sent[0].to)
+	}
+	var jobs []NotifierJob
+	n.db.Order("id").Find(&jobs)
+	assert.Len(t, jobs, 3)
+	for _, job := range jobs {
+		assert.Equal(t, NotifierJobStateDone, job.State)
+		assert.Equal(t, campaignBatch(emailCampaignJob, campaign.ID), job.Batch)
+		assert.Empty(t, job.LockedBy)
+	}
+	assert.Equal(t, uint(2), jobs[1].Attempts)
+
+	EmailWorker{Notifier: n}.Run(context.Background())
+	stored, err = n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(2)
+	now := bucket.last
+	assert.Equal(t, time.Duration(0), bucket.take(now))
+	assert.Equal(t, time.Duration(0), bucket.take(now))
+	assert.Equal(t, 500*time.Millisecond, bucket.take(now))
+	assert.Equal(t, time.Second, bucket.take(now))
+	// Test tokens are refilled by the rate, up to a second of them
+	assert.Equal(t, 500*time.Millisecond, bucket.take(now.Add(time.Second)))
+	bucket.giveBack()
+	assert.Equal(t, time.Duration(0), bucket.take(now.Add(time.Hour)))
+	assert.Equal(t, time.Duration(0), bucket.take(now.Add(time.Hour)))
+	assert.Equal(t, 500*time.Millisecond, bucket.take(now.Add(time.Hour)))
+}
+
+func TestEmailServiceRateLimit(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite rate limit test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("limited")
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = n.SubscribeEmail(fmt.Sprintf("limited%d@test.com", i), "first", "last", []string{"limited"}, false)
+		assert.Nil(t, err)
+	}
+	service, err := n.CreateEmailService("limited service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	service, err = n.SetEmailServiceRateLimit(service.ID, 1000, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), service.RateLimitPerSecond)
+	template, err := n.CreateEmailTemplate("limited template", "<p>Limited</p>")
+	assert.Nil(t, err)
+	data := &EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "Limited subject",
+		Name:           "limited campaign",
+		Tags:           []uint64{tag.ID},
+	}
+	campaign, err := n.AddEmailCampaign(data)
+	assert.Nil(t, err)
+
+	// Test the jobs over the daily limit are delayed, not failed, and the campaign is sending until they're sent
+	EmailWorker{Notifier: n}.Run(context.Background())
+	JobWorker{Notifier: n, Concurrency: 4}.Run(context.Background())
+	EmailWorker{Notifier: n}.Run(context.Background())
+	assert.Len(t, mailer.Sent(), 6)
+	stored, err := n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSending), stored.StatusId)
+	var delayed []NotifierJob
+	n.db.Where("type = ?", emailMessageJob).Where("state = ?", NotifierJobStatePending).Find(&delayed)
+	assert.Len(t, delayed, 4)
+	for _, job := range delayed {
+		assert.True(t, job.AvailableAt.After(time.Now().Add(dailyRateLimitRetry-time.Minute)))
+	}
+	var failed int64
+	n.db.Model(&NotifierEmailMessage{}).Where("failed_at IS NOT NULL").Count(&failed)
+	assert.Equal(t, int64(0), failed)
+
+	// Test a campaign of an exhausted service waits
+	data.Name = "waiting campaign"
+	waiting, err := n.AddEmailCampaign(data)
+	assert.Nil(t, err)
+	EmailWorker{Notifier: n}.Run(context.Background())
+	stored, err = n.emailCampaignRepo.Get(waiting.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusDraft), stored.StatusId)
+
+	// Test the campaigns of other services aren't blocked by the waiting campaign
+	other, err := n.CreateEmailService("other service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	data.Name = "other campaign"
+	data.EmailServiceId = other.ID
+	otherCampaign, err := n.AddEmailCampaign(data)
+	assert.Nil(t, err)
+	EmailWorker{Notifier: n}.Run(context.Background())
+	stored, err = n.emailCampaignRepo.Get(otherCampaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSending), stored.StatusId)
+	stored, err = n.emailCampaignRepo.Get(waiting.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusDraft), stored.StatusId)
+
+	// Test the delayed jobs and the waiting campaign are sent when the limit allows them
+	_, err = n.SetEmailServiceRateLimit(service.ID, 1000, 0)
+	assert.Nil(t, err)
+	n.db.Model(&NotifierJob{}).Where("state = ?", NotifierJobStatePending).Update("available_at", time.Now())
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+	assert.Len(t, mailer.Sent(), 30)
+	for _, id := range []uint64{campaign.ID, waiting.ID, otherCampaign.ID} {
+		stored, err = n.emailCampaignRepo.Get(id)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+	}
+
+	// Test a send waits for the per second limit
+	_, err = n.SetEmailServiceRateLimit(service.ID, 2, 0)
+	assert.Nil(t, err)
+	subscriber, err := n.emailSubscriberRepo.GetByEmail("limited0@test.com")
+	assert.Nil(t, err)
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Limited", service.ID, "Hello")
+		assert.Nil(t, n.SendEmailMessage(message))
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 400*time.Millisecond)
+}
+
+// failingMailer fails its sends by err until it's cleared, then sends by fakeMailer.
+type failingMailer struct {
+	fakeMailer
+	err error
+}
+
+func (f *failingMailer) SendEnvelope(envelope *EmailEnvelope) (*SendResult, error) {
+	f.mu.Lock()
+	err := f.err
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return f.fakeMailer.SendEnvelope(envelope)
+}
+
+func (f *failingMailer) fail(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 4*time.Second, policy.Delay(3))
+	assert.Equal(t, 5*time.Second, policy.Delay(10))
+	assert.True(t, policy.retries(2))
+	assert.False(t, policy.retries(3))
+
+	// Test the jitter takes up to its fraction off the delay
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		delay := policy.Delay(2)
+		assert.True(t, delay > time.Second && delay <= 2*time.Second, delay)
+	}
+}
+
+func TestRequeueEmailEnvelope(t *testing.T) {
+	mailer := &failingMailer{}
+	n := newSqliteTestNotifier(t, "sqlite requeue envelope test", WithMailer(fakeMailerType, func() Mailer { return mailer }))
+	subscriber, err := n.SubscribeEmail("envelope@test.com", "first", "last", nil, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("envelope service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	mailer.fail(MailerError{Mailer: "fake", StatusCode: http.StatusBadRequest, Body: "rejected"})
+	message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Envelope", service.ID, "Hello")
+	envelope := &EmailEnvelope{
+		Cc:          []string{"cc@test.com"},
+		Bcc:         []string{"bcc@test.com"},
+		ReplyTo:     "reply@test.com",
+		Message:     "Ignored",
+		Headers:     map[string]string{"List-Unsubscribe": "<https://test.com/unsubscribe>"},
+		Tags:        []string{"receipt"},
+		Attachments: []MailAttachment{{Name: "ignored.txt", Content: []byte("ignored")}},
+	}
+	assert.ErrorAs(t, n.SendEmailEnvelope(message, envelope), &MailerError{})
+
+	// Test the envelope is stored without the message and the attachments
+	stored, err := n.emailMessageRepo.Get(message.ID)
+	assert.Nil(t, err)
+	if assert.NotNil(t, stored.Envelope) {
+		assert.Equal(t, []string{"cc@test.com"}, stored.Envelope.Cc)
+		assert.Empty(t, stored.Envelope.Message)
+		assert.Nil(t, stored.Envelope.Attachments)
+	}
+
+	// Test a requeued message is sent with the Cc, Bcc, Reply-To, headers and tags of its envelope
+	mailer.fail(nil)
+	assert.Nil(t, n.RequeueEmailMessage(message.ID))
+	JobWorker{Notifier: n}.Run(context.Background())
+	sent := mailer.Sent()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, "Hello", sent[0].message)
+		assert.Equal(t, []string{"cc@test.com"}, sent[0].envelope.Cc)
+		assert.Equal(t, []string{"bcc@test.com"}, sent[0].envelope.Bcc)
+		assert.Equal(t, "reply@test.com", sent[0].envelope.ReplyTo)
+		assert.Equal(t, envelope.Headers, sent[0].envelope.Headers)
+		assert.Equal(t, envelope.Tags, sent[0].envelope.Tags)
+	}
+}
+
+func TestIsPermanentMailError(t *testing.T) {
+	assert.True(t, IsPermanentMailError(&textproto.Error{Code: 550, Msg: "mailbox unavailable"}))
+	assert.False(t, IsPermanentMailError(&textproto.Error{Code: 421, Msg: "service not available"}))
+	assert.True(t, IsPermanentMailError(MailerError{Mailer: "api", StatusCode: http.StatusUnprocessableEntity}))
+	assert.False(t, IsPermanentMailError(MailerError{Mailer: "api", StatusCode: http.StatusTooManyRequests}))
+	assert.False(t, IsPermanentMailError(MailerError{Mailer: "api", StatusCode: http.StatusBadGateway}))
+	assert.True(t, IsPermanentMailError(fmt.Errorf("send: %w", errMailerAttachmentsNotSupported)))
+	assert.False(t, IsPermanentMailError(os.ErrDeadlineExceeded))
+}
+
+func TestEmailMessageRetry(t *testing.T) {
+	mailer := &failingMailer{}
+	n := newSqliteTestNotifier(t, "sqlite email retry test",
+		WithMailer(fakeMailerType, func() Mailer { return mailer }),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute}),
+	)
+
+	subscriber, err := n.SubscribeEmail("retry@test.com", "first", "last", nil, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("retry service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	// Test a transient error is retried by a delayed job
+	mailer.fail(&textproto.Error{Code: 451, Msg: "try again later"})
+	message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Retry", service.ID, "Hello")
+	err = n.SendEmailMessage(message)
+	var retry RetryError
+	if assert.ErrorAs(t, err, &retry) {
+		assert.Equal(t, uint(1), retry.Attempt)
+		assert.Equal(t, time.Minute, retry.RetryAfter)
+	}
+	assert.Nil(t, message.FailedAt)
+	assert.Equal(t, uint(1), message.Attempts)
+	assert.Contains(t, message.LastError, "try again later")
+	runJobs := func() {
+		n.db.Model(&NotifierJob{}).Where("state = ?", NotifierJobStatePending).Update("available_at", time.Now())
+		JobWorker{Notifier: n}.Run(context.Background())
+	}
+
+	// Test the message is dead when it's out of attempts
+	runJobs()
+	runJobs()
+	stored, err := n.emailMessageRepo.Get(message.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3), stored.Attempts)
+	assert.NotNil(t, stored.FailedAt)
+	dead, err := n.GetDeadEmailMessages()
+	assert.Nil(t, err)
+	if assert.Len(t, dead, 1) {
+		assert.Equal(t, message.ID, dead[0].ID)
+	}
+
+	// Test a requeued message is sent
+	mailer.fail(nil)
+	assert.Nil(t, n.RequeueEmailMessage(message.ID))
+	assert.IsType(t, NotFoundError{}, n.RequeueEmailMessage(message.ID))
+	runJobs()
+	stored, err = n.emailMessageRepo.Get(message.ID)
+	assert.Nil(t, err)
+	assert.NotNil(t, stored.SentAt)
+	assert.Nil(t, stored.FailedAt)
+	assert.Len(t, mailer.Sent(), 1)
+
+	// Test a permanent error fails the message right away and a discarded message isn't dead
+	mailer.fail(MailerError{Mailer: "fake", StatusCode: http.StatusBadRequest, Body: "invalid address"})
+	message = NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Rejected", service.ID, "Hello")
+	err = n.SendEmailMessage(message)
+	assert.ErrorAs(t, err, &MailerError{})
+	assert.NotNil(t, message.FailedAt)
+	assert.Equal(t, uint(1), message.Attempts)
+	assert.Nil(t, n.DiscardEmailMessage(message.ID))
+	dead, err = n.GetDeadEmailMessages()
+	assert.Nil(t, err)
+	assert.Len(t, dead, 0)
+	assert.IsType(t, NotFoundError{}, n.DiscardEmailMessage(message.ID))
+}
+
+// blockingWorker blocks its runs until it's released, or until the context of its sends is canceled.
+type blockingWorker struct {
+	started  chan struct{}
+	release  chan struct{}
+	canceled chan struct{}
+}
+
+func newBlockingWorker() *blockingWorker {
+	return &blockingWorker{started: make(chan struct{}, 1), release: make(chan struct{}), canceled: make(chan struct{}, 1)}
+}
+
+func (b *blockingWorker) Run(ctx context.Context) {
+	select {
+	case b.started <- struct{}{}:
+	default:
+	}
+	select {
+	case <-b.release:
+	case <-sendContext(ctx).Done():
+		b.canceled <- struct{}{}
+	}
+}
+
+func TestWorkerStartStop(t *testing.T) {
+	worker := newBlockingWorker()
+	workers := WorkerStart(context.Background(), WorkersList{{Duration: 10 * time.Millisecond, Worker: worker, Name: "blocking"}})
+	<-worker.started
+
+	// Test Stop waits for the run in flight
+	stopped := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- workers.Stop(ctx)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the run in flight finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(worker.release)
+	assert.Nil(t, <-stopped)
+
+	// Test Stop cancels the run in flight when its context is done first
+	worker = newBlockingWorker()
+	workers = WorkerStart(context.Background(), WorkersList{{Duration: 10 * time.Millisecond, Worker: worker, Name: "blocking"}})
+	<-worker.started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, workers.Stop(ctx), context.DeadlineExceeded)
+	select {
+	case <-worker.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run in flight wasn't canceled")
+	}
+}
+
+func TestJobWorkerStopped(t *testing.T) {
+	queue := NewQueue()
+	ran := 0
+	n := newSqliteTestNotifier(t, "sqlite job worker stopped test",
+		WithQueueBackend(queue),
+		WithJobHandler("count", func(n *Notifier, payload []byte) error {
+			ran++
+			return nil
+		}),
+	)
+	message, err := NewQueueMessage("count", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, queue.Enqueue(*message, *message))
+
+	// Test a stopped worker doesn't take jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	JobWorker{Notifier: n}.Run(ctx)
+	assert.Equal(t, 0, ran)
+	pending, err := queue.Pending("")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), pending)
+
+	// Test reserved jobs aren't started once the worker is stopped, and are released
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	n.jobHandlers["count"] = func(n *Notifier, payload []byte) error {
+		ran++
+		cancel()
+		return nil
+	}
+	JobWorker{Notifier: n}.Run(ctx)
+	assert.Equal(t, 1, ran)
+	messages, err := queue.Reserve("test", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 1)
+}
+
+// checkFailingEmailMessageRepository fails the lookups of existing messages by err.
+type checkFailingEmailMessageRepository struct {
+	IEmailMessageRepository
+	err error
+}
+
+func (c checkFailingEmailMessageRepository) CheckMessageExists(message *NotifierEmailMessage) error {
+	return c.err
+}
+
+func TestSendEmailCheckError(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite send check error test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+	subscriber, err := n.SubscribeEmail("check@test.com", "first", "last", nil, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("check service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, NotifierEmailMessageSourceTransactional, "from@test.com", 1, "from", "Check", service.ID, "Hello")
+	assert.Nil(t, n.sendEmail(message))
+	message = NewNotifierEmailMessage(subscriber.Email, subscriber.ID, NotifierEmailMessageSourceTransactional, "from@test.com", 1, "from", "Check", service.ID, "Hello")
+	assert.ErrorIs(t, n.CheckEmailMessageExists(message), ErrMessageExists)
+
+	// Test a failed lookup fails the send instead of skipping it as sent
+	lookupErr := errors.New("connection reset")
+	n.emailMessageRepo = checkFailingEmailMessageRepository{n.emailMessageRepo, lookupErr}
+	message = NewNotifierEmailMessage(subscriber.Email, subscriber.ID, NotifierEmailMessageSourceTransactional, "from@test.com", 2, "from", "Check", service.ID, "Hello")
+	assert.ErrorIs(t, n.sendEmail(message), lookupErr)
+	assert.Equal(t, uint64(0), message.ID)
+	assert.Len(t, mailer.Sent(), 1)
+}
+
+// contextBlockingMailer blocks its sends until their context is done while block is set, then sends by fakeMailer.
+type contextBlockingMailer struct {
+	fakeMailer
+	block   bool
+	started chan struct{}
+}
+
+func (c *contextBlockingMailer) SendEnvelopeContext(ctx context.Context, envelope *EmailEnvelope) (*SendResult, error) {
+	c.mu.Lock()
+	block := c.block
+	c.mu.Unlock()
+	if block {
+		select {
+		case c.started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return c.fakeMailer.SendEnvelope(envelope)
+}
+
+func TestWorkersStopMidCampaign(t *testing.T) {
+	mailer := &contextBlockingMailer{block: true, started: make(chan struct{}, 1)}
+	n := newSqliteTestNotifier(t, "sqlite workers stop test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("stopping")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = n.SubscribeEmail(fmt.Sprintf("stopping%d@test.com", i), "first", "last", []string{"stopping"}, false)
+		assert.Nil(t, err)
+	}
+	service, err := n.CreateEmailService("stopping service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("stopping template", "<p>Stopping</p>")
+	assert.Nil(t, err)
+	campaign, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "Stopping subject",
+		Name:           "stopping campaign",
+		Tags:           []uint64{tag.ID},
+	})
+	assert.Nil(t, err)
+
+	workers := WorkerStart(context.Background(), WorkersList{
+		{Duration: 10 * time.Millisecond, Worker: EmailWorker{Notifier: n}, Name: "email"},
+		{Duration: 10 * time.Millisecond, Worker: JobWorker{Notifier: n}, Name: "job"},
+	})
+	select {
+	case <-mailer.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the campaign wasn't sent")
+	}
+
+	// Test the canceled send isn't failed, and its job is released instead of finished
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, workers.Stop(ctx), context.DeadlineExceeded)
+	assert.Eventually(t, func() bool {
+		var running int64
+		n.db.Model(&NotifierJob{}).Where("state = ?", NotifierJobStateRunning).Count(&running)
+		return running == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	var failed, finished int64
+	n.db.Model(&NotifierEmailMessage{}).Where("failed_at IS NOT NULL").Count(&failed)
+	assert.Equal(t, int64(0), failed)
+	n.db.Model(&NotifierJob{}).Where("type = ? AND state <> ?", emailMessageJob, NotifierJobStatePending).Count(&finished)
+	assert.Equal(t, int64(0), finished)
+
+	// Test the released jobs are sent by the next worker
+	mailer.mu.Lock()
+	mailer.block = false
+	mailer.mu.Unlock()
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+	assert.Len(t, mailer.Sent(), 3)
+	stored, err := n.emailCampaignRepo.Get(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(NotifierEmailStatusSent), stored.StatusId)
+}
+
+// contextBlockingSmsSender blocks its sends until their context is done while block is set, then sends by
+// fakeSmsSender.
+type contextBlockingSmsSender struct {
+	fakeSmsSender
+	block   bool
+	started chan struct{}
+}
+
+func (c *contextBlockingSmsSender) SendContext(ctx context.Context, sender, receptor, message string) (*SendResult, error) {
+	c.mu.Lock()
+	block := c.block
+	c.mu.Unlock()
+	if block {
+		select {
+		case c.started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return c.fakeSmsSender.Send(sender, receptor, message)
+}
+
+// contextBlockingPushSender blocks its sends until their context is done while block is set, then sends by
+// fakePushSender.
+type contextBlockingPushSender struct {
+	fakePushSender
+	block   bool
+	started chan struct{}
+}
+
+func (c *contextBlockingPushSender) SendContext(ctx context.Context, token string, notification *PushNotification) (*SendResult, error) {
+	c.mu.Lock()
+	block := c.block
+	c.mu.Unlock()
+	if block {
+		select {
+		case c.started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return c.fakePushSender.Send(token, notification)
+}
+
+func TestWorkersStopSmsAndPushSends(t *testing.T) {
+	smsSender := &contextBlockingSmsSender{block: true, started: make(chan struct{}, 1)}
+	pushSender := &contextBlockingPushSender{block: true, started: make(chan struct{}, 1)}
+	n := newSqliteTestNotifier(t, "sqlite workers stop sms and push test",
+		WithSmsSender(fakeSmsSenderType, func() SmsSender { return smsSender }),
+		WithPushSender(fakePushSenderType, func() PushSender { return pushSender }),
+	)
+
+	tag, err := n.CreateTag("stopping")
+	assert.Nil(t, err)
+	_, err = n.SubscribeMobile("+98", "09121234567", "first", "last", []string{"stopping"}, false)
+	assert.Nil(t, err)
+	pushDriver, err := n.CreateNotificationDriver("stopping push driver", fakePushSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+	_, err = n.AddNewToken("stopping-token", "first", "last", pushDriver.ID, []string{"stopping"}, false)
+	assert.Nil(t, err)
+	smsDriver, err := n.CreateMobileDriver("stopping sms driver", fakeSmsSenderType, []byte(`{}`))
+	assert.Nil(t, err)
+
+	for name, channel := range map[string]struct {
+		data        *CampaignCreateData
+		worker      IWorker
+		started     chan struct{}
+		messageJob  string
+		message     interface{}
+		unblockSent func() int
+	}{
+		"sms": {
+			data:       &CampaignCreateData{Name: "stopping sms", Tags: []uint64{tag.ID}, Sms: &CampaignSmsData{DriverId: smsDriver.ID, Message: "Stopping"}},
+			worker:     MobileWorker{Notifier: n},
+			started:    smsSender.started,
+			messageJob: mobileMessageJob,
+			message:    &NotifierMobileMessage{},
+			unblockSent: func() int {
+				smsSender.mu.Lock()
+				smsSender.block = false
+				smsSender.mu.Unlock()
+				runCampaignWorker(n, MobileWorker{Notifier: n})
+				return len(smsSender.Sent())
+			},
+		},
+		"push": {
+			data:       &CampaignCreateData{Name: "stopping push", Tags: []uint64{tag.ID}, Push: &CampaignPushData{DriverId: pushDriver.ID, Title: "Stopping"}},
+			worker:     NotificationWorker{Notifier: n},
+			started:    pushSender.started,
+			messageJob: notificationMessageJob,
+			message:    &NotifierNotificationMessage{},
+			unblockSent: func() int {
+				pushSender.mu.Lock()
+				pushSender.block = false
+				pushSender.mu.Unlock()
+				runCampaignWorker(n, NotificationWorker{Notifier: n})
+				return len(pushSender.Sent())
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := n.AddCampaign(channel.data)
+			assert.Nil(t, err)
+			workers := WorkerStart(context.Background(), WorkersList{
+				{Duration: 10 * time.Millisecond, Worker: channel.worker, Name: name},
+				{Duration: 10 * time.Millisecond, Worker: JobWorker{Notifier: n}, Name: "job"},
+			})
+			select {
+			case <-channel.started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the campaign wasn't sent")
+			}
+
+			// Test the blocked send is canceled by Stop, isn't failed, and its job is released
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			assert.ErrorIs(t, workers.Stop(ctx), context.DeadlineExceeded)
+			assert.Eventually(t, func() bool {
+				var running int64
+				n.db.Model(&NotifierJob{}).Where("state = ?", NotifierJobStateRunning).Count(&running)
+				return running == 0
+			}, 5*time.Second, 10*time.Millisecond)
+			var failed, finished int64
+			n.db.Model(channel.message).Where("failed_at IS NOT NULL").Count(&failed)
+			assert.Equal(t, int64(0), failed)
+			n.db.Model(&NotifierJob{}).Where("type = ? AND state <> ?", channel.messageJob, NotifierJobStatePending).Count(&finished)
+			assert.Equal(t, int64(0), finished)
+
+			// Test the released job is sent by the next worker
+			assert.Equal(t, 1, channel.unblockSent())
+		})
+	}
+}
+
+func TestSendEmailEnvelopeRequeued(t *testing.T) {
+	mailer := &contextBlockingMailer{started: make(chan struct{}, 1)}
+	n := newSqliteTestNotifier(t, "sqlite send requeued test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+	subscriber, err := n.SubscribeEmail("requeued@test.com", "first", "last", nil, false)
+	assert.Nil(t, err)
+	service, err := n.CreateEmailService("requeued service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	_, err = n.SetEmailServiceRateLimit(service.ID, 0, 1)
+	assert.Nil(t, err)
+	deliveries := func() []NotifierJob {
+		var jobs []NotifierJob
+		n.db.Where("type = ?", emailDeliveryJob).Order("id").Find(&jobs)
+		return jobs
+	}
+
+	// Test a rate limited send is enqueued after its limit
+	assert.Nil(t, n.SendEmailMessage(NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "First", service.ID, "Hello")))
+	message := NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Limited", service.ID, "Hello")
+	assert.ErrorAs(t, n.SendEmailMessage(message), &RateLimitedError{})
+	assert.Nil(t, message.FailedAt)
+	if jobs := deliveries(); assert.Len(t, jobs, 1) {
+		assert.True(t, jobs[0].AvailableAt.After(time.Now().Add(dailyRateLimitRetry-time.Minute)))
+	}
+
+	// Test a send stopped by its context is enqueued to be sent again right away
+	_, err = n.SetEmailServiceRateLimit(service.ID, 0, 0)
+	assert.Nil(t, err)
+	mailer.block = true
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	message = NewNotifierEmailMessage(subscriber.Email, subscriber.ID, "", "from@test.com", 0, "from", "Stopped", service.ID, "Hello")
+	assert.ErrorIs(t, n.WithContext(ctx).SendEmailMessage(message), context.DeadlineExceeded)
+	if jobs := deliveries(); assert.Len(t, jobs, 2) {
+		assert.False(t, jobs[1].AvailableAt.After(time.Now()))
+	}
+	mailer.block = false
+	JobWorker{Notifier: n}.Run(context.Background())
+	stored, err := n.emailMessageRepo.Get(message.ID)
+	assert.Nil(t, err)
+	assert.NotNil(t, stored.SentAt)
+	assert.Equal(t, uint(0), stored.Attempts)
+}
+
+func TestCampaignAttachmentsLoadedOnce(t *testing.T) {
+	mailer := &fakeMailer{}
+	n := newSqliteTestNotifier(t, "sqlite campaign attachments once test", WithMailer(fakeMailerType, func() Mailer {
+		return mailer
+	}))
+
+	tag, err := n.CreateTag("once")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = n.SubscribeEmail(fmt.Sprintf("once%d@test.com", i), "first", "last", []string{"once"}, false)
+		assert.Nil(t, err)
+	}
+	service, err := n.CreateEmailService("once service", fakeMailerType, []byte(`{}`))
+	assert.Nil(t, err)
+	template, err := n.CreateEmailTemplate("once template", "<p>Once</p>")
+	assert.Nil(t, err)
+	campaign, err := n.AddEmailCampaign(&EmailCampaignCreateData{
+		EmailServiceId: service.ID,
+		TemplateId:     template.ID,
+		StatusId:       NotifierEmailStatusDraft,
+		FromEmail:      "from@test.com",
+		FromName:       "from",
+		Subject:        "Once subject",
+		Name:           "once campaign",
+		Tags:           []uint64{tag.ID},
+	})
+	assert.Nil(t, err)
+
+	var downloads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		_, _ = w.Write([]byte("%PDF-1.4"))
+	}))
+	defer server.Close()
+	assert.Nil(t, n.AttachToEmailCampaign(campaign.ID, NewNotifierEmailAttachmentReference("terms.pdf", "application/pdf", server.URL+"/terms.pdf")))
+
+	// Test the attachment is downloaded once for the subscribers of the campaign
+	runCampaignWorker(n, EmailWorker{Notifier: n})
+	sent := mailer.Sent()
+	assert.Len(t, sent, 3)
+	for _, mail := range sent {
+		assert.Equal(t, []MailAttachment{{Name: "terms.pdf", ContentType: "application/pdf", Content: []byte("%PDF-1.4")}}, mail.attachments)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	// Test a download is stopped by the context of the send
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = loadMailAttachment(ctx, NotifierEmailAttachment{Path: server.URL + "/terms.pdf"})
+	assert.ErrorIs(t, err, context.Canceled)
+}